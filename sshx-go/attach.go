@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/util"
+)
+
+// attachSession mirrors the shell the session opens for the host on this
+// terminal, and sends it what the host types, until the returned function is
+// called. The terminal is in raw mode meanwhile, so keys such as Ctrl+C
+// reach the shell rather than this process.
+func attachSession(session *sshx.Session) (stop func(), err error) {
+	restore, err := makeRaw(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to put the terminal in raw mode: %w", err)
+	}
+	if err := session.Mirror(sshx.HostShellID, os.Stdout); err != nil {
+		restore()
+		return nil, err
+	}
+
+	// The host's size applies until a viewer resizes the shell
+	resize := func() {
+		rows, cols, err := terminalSize(os.Stdin)
+		if err != nil || rows == 0 || cols == 0 {
+			return
+		}
+		if err := session.Resize(sshx.HostShellID, rows, cols); err != nil {
+			util.Warnf("Failed to resize the shell: %v", err)
+		}
+	}
+	resize()
+
+	signals := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(signals, resizeSignals...)
+	}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				resize()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// Reading blocks until the host types, so this outlives stop until then
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if n > 0 {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if err := session.WriteInput(sshx.HostShellID, append([]byte(nil), buf[:n]...)); err != nil {
+					util.Warnf("Failed to send input to the shell: %v", err)
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+		session.Mirror(sshx.HostShellID, nil)
+		restore()
+		fmt.Println()
+	}, nil
+}
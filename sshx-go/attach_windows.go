@@ -0,0 +1,51 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// resizeSignals report that the host's terminal changed size. Windows has no
+// such signal, so the size is only taken when attaching.
+var resizeSignals []os.Signal
+
+// makeRaw puts the console f in raw mode with virtual terminal input, so
+// every key reaches the shared shell as typed, and enables virtual terminal
+// processing on stdout. It returns a function that restores both modes.
+func makeRaw(f *os.File) (func(), error) {
+	in := windows.Handle(f.Fd())
+	var inMode uint32
+	if err := windows.GetConsoleMode(in, &inMode); err != nil {
+		return nil, err
+	}
+	raw := inMode&^(windows.ENABLE_ECHO_INPUT|windows.ENABLE_LINE_INPUT|windows.ENABLE_PROCESSED_INPUT) | windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(in, raw); err != nil {
+		return nil, err
+	}
+
+	out := windows.Handle(os.Stdout.Fd())
+	var outMode uint32
+	outSet := windows.GetConsoleMode(out, &outMode) == nil &&
+		windows.SetConsoleMode(out, outMode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+
+	return func() {
+		windows.SetConsoleMode(in, inMode)
+		if outSet {
+			windows.SetConsoleMode(out, outMode)
+		}
+	}, nil
+}
+
+// terminalSize returns the size of the visible window of the console the
+// host's output goes to.
+func terminalSize(f *os.File) (rows, cols uint32, err error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	window := info.Window
+	return uint32(window.Bottom - window.Top + 1), uint32(window.Right - window.Left + 1), nil
+}
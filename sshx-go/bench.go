@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"sshx-go/internal/viewer"
+	"sshx-go/pkg/client"
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/terminal"
+	"sshx-go/pkg/transport"
+)
+
+const (
+	// defaultBenchKeystrokes is how many keystrokes sshx bench times.
+	defaultBenchKeystrokes = 100
+	// defaultBenchBytes is how much output sshx bench sends to measure
+	// throughput.
+	defaultBenchBytes = "4M"
+
+	// benchTimeout bounds each step of a benchmark: connecting, a single
+	// keystroke, or the arrival of each window of output.
+	benchTimeout = 15 * time.Second
+	// benchChunk is the size of the pastes the throughput test sends, and
+	// benchWindow how much may be in flight at once, well within what the
+	// host buffers for a shell.
+	benchChunk  = 16 << 10
+	benchWindow = 256 << 10
+)
+
+// benchResult holds the measurements of one server over one transport.
+type benchResult struct {
+	Server    string `json:"server"`
+	Transport string `json:"transport"`
+	// Round trips to the server alone, and of keystrokes echoed by the host
+	Ping      *latencies `json:"ping,omitempty"`
+	Keystroke *latencies `json:"keystroke,omitempty"`
+	// Output echoed per second, in bytes
+	Throughput float64 `json:"throughput,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// latencies summarizes samples of a round trip, in milliseconds in JSON.
+type latencies struct {
+	P50 time.Duration `json:"-"`
+	P90 time.Duration `json:"-"`
+	P99 time.Duration `json:"-"`
+}
+
+func (l latencies) MarshalJSON() ([]byte, error) {
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	return json.Marshal(map[string]float64{"p50": ms(l.P50), "p90": ms(l.P90), "p99": ms(l.P99)})
+}
+
+func (l latencies) String() string {
+	return fmt.Sprintf("%s / %s / %s", roundLatency(l.P50), roundLatency(l.P90), roundLatency(l.P99))
+}
+
+// formatRate formats a rate in bytes per second with a binary unit.
+func formatRate(rate float64) string {
+	switch {
+	case rate >= 1<<20:
+		return fmt.Sprintf("%.1f MiB/s", rate/(1<<20))
+	case rate >= 1<<10:
+		return fmt.Sprintf("%.1f KiB/s", rate/(1<<10))
+	default:
+		return fmt.Sprintf("%.0f B/s", rate)
+	}
+}
+
+// summarize returns the percentiles of samples, by nearest rank.
+func summarize(samples []time.Duration) *latencies {
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := func(p int) time.Duration {
+		return sorted[(len(sorted)*p+99)/100-1]
+	}
+	return &latencies{P50: rank(50), P90: rank(90), P99: rank(99)}
+}
+
+// runBench opens a session with the echo runner on each server, over each
+// transport --transport allows, and measures it as a user would see it: the
+// round trip of keystrokes through the server and back, and the rate at which
+// large output arrives. It fails if no benchmark completes.
+func runBench(opts options, connConfig transport.ConnectionConfig) error {
+	if opts.BenchKeystrokes <= 0 {
+		return fmt.Errorf("invalid --bench-keystrokes %d (must be positive)", opts.BenchKeystrokes)
+	}
+	size, err := terminal.ParseMemorySize(opts.BenchBytes)
+	if err != nil || size <= 0 {
+		return fmt.Errorf("invalid --bench-bytes %q: expected a size such as 512K or 4M", opts.BenchBytes)
+	}
+
+	preferences := []transport.TransportPreference{transport.PreferGrpc, transport.PreferWebSocket}
+	if connConfig.Preference != transport.PreferAuto {
+		preferences = []transport.TransportPreference{connConfig.Preference}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	jsonOutput := opts.Output == "json"
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	var completed int
+	for _, origin := range sshx.SplitServers(opts.Server) {
+		if !jsonOutput {
+			fmt.Printf("%sServer%s %s\n", BoldGreen, Reset, origin)
+			fmt.Printf("  %s%-11s %-26s %-26s %s%s\n", Fixed8, "", "Ping p50 / p90 / p99", "Keystroke p50 / p90 / p99", "Throughput", Reset)
+		}
+		for _, preference := range preferences {
+			result := benchTransport(ctx, opts, connConfig, origin, preference, size)
+			if result.Error == "" {
+				completed++
+			}
+			if jsonOutput {
+				if err := encoder.Encode(result); err != nil {
+					return err
+				}
+				continue
+			}
+			name := "gRPC"
+			if preference == transport.PreferWebSocket {
+				name = "WebSocket"
+			}
+			if result.Error != "" {
+				fmt.Printf("  %s✗%s %-9s %s\n", Red, Reset, name, result.Error)
+			} else {
+				fmt.Printf("  %s✓%s %-9s %-26s %-26s %s\n", Green, Reset, name, result.Ping, result.Keystroke, formatRate(result.Throughput))
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		if !jsonOutput {
+			fmt.Println()
+		}
+	}
+
+	if completed == 0 {
+		return fmt.Errorf("no benchmark completed")
+	}
+	return nil
+}
+
+// benchTransport benchmarks one server over one transport.
+func benchTransport(ctx context.Context, opts options, connConfig transport.ConnectionConfig, origin string, preference transport.TransportPreference, size int64) benchResult {
+	result := benchResult{Server: origin, Transport: preference.String()}
+	fail := func(err error) benchResult {
+		result.Error = err.Error()
+		return result
+	}
+
+	connConfig.Preference = preference
+	name := opts.Name
+	if name == "" {
+		name = sshx.DefaultSessionName()
+	}
+	controller, err := client.NewControllerWithConnection(client.ControllerConfig{
+		Origin: origin,
+		Name:   name,
+		Runner: &client.EchoRunner{},
+	}, connConfig)
+	if err != nil {
+		return fail(err)
+	}
+	defer controller.Close()
+	go controller.Run()
+
+	joinCtx, cancel := context.WithTimeout(ctx, benchTimeout)
+	defer cancel()
+	v, err := viewer.Join(joinCtx, controller.URL(), connConfig)
+	if err != nil {
+		return fail(fmt.Errorf("failed to join the session: %w", err))
+	}
+	defer v.Close()
+	shell, err := v.CreateShell(joinCtx)
+	if err != nil {
+		return fail(err)
+	}
+	if err := v.Subscribe(shell); err != nil {
+		return fail(err)
+	}
+	echo := &echoReader{viewer: v, shell: shell}
+
+	// Round trips to the server, which bound those of keystrokes
+	pings := make([]time.Duration, 0, opts.BenchKeystrokes)
+	for i := 0; i < opts.BenchKeystrokes; i++ {
+		pingCtx, cancel := context.WithTimeout(ctx, benchTimeout)
+		rtt, err := v.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			return fail(fmt.Errorf("ping failed: %w", err))
+		}
+		pings = append(pings, rtt)
+	}
+	result.Ping = summarize(pings)
+
+	// Keystrokes, typed one at a time like a user would
+	keystrokes := make([]time.Duration, 0, opts.BenchKeystrokes)
+	for i := 0; i < opts.BenchKeystrokes; i++ {
+		start := time.Now()
+		if err := v.Input(shell, []byte{'a' + byte(i%26)}); err != nil {
+			return fail(err)
+		}
+		if err := echo.wait(ctx, echo.received+1); err != nil {
+			return fail(fmt.Errorf("keystroke was not echoed: %w", err))
+		}
+		keystrokes = append(keystrokes, time.Since(start))
+	}
+	result.Keystroke = summarize(keystrokes)
+
+	// Large output, as pastes echoed back, keeping a window in flight
+	chunk := make([]byte, benchChunk)
+	for i := range chunk {
+		chunk[i] = 'a' + byte(i%26)
+	}
+	base := echo.received
+	start := time.Now()
+	for sent := int64(0); sent < size; {
+		n := min(int64(len(chunk)), size-sent)
+		if err := echo.wait(ctx, base+sent+n-benchWindow); err != nil {
+			return fail(fmt.Errorf("output stalled: %w", err))
+		}
+		if err := v.Input(shell, chunk[:n]); err != nil {
+			return fail(err)
+		}
+		sent += n
+	}
+	if err := echo.wait(ctx, base+size); err != nil {
+		return fail(fmt.Errorf("output stalled: %w", err))
+	}
+	result.Throughput = float64(size) / time.Since(start).Seconds()
+	return result
+}
+
+// echoReader counts the output of a shell that a viewer receives.
+type echoReader struct {
+	viewer   *viewer.Viewer
+	shell    uint32
+	received int64
+}
+
+// wait reads output until at least n bytes were received in total, failing if
+// none arrives for benchTimeout.
+func (e *echoReader) wait(ctx context.Context, n int64) error {
+	timer := time.NewTimer(benchTimeout)
+	defer timer.Stop()
+	for e.received < n {
+		select {
+		case chunk, ok := <-e.viewer.Output():
+			if !ok {
+				return fmt.Errorf("connection ended: %w", e.viewer.Err())
+			}
+			if chunk.Shell == e.shell {
+				e.received += int64(len(chunk.Data))
+				timer.Reset(benchTimeout)
+			}
+		case <-timer.C:
+			if err := e.viewer.Err(); err != nil {
+				return err
+			}
+			return fmt.Errorf("timed out after %s", benchTimeout)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"sshx-go/pkg/control"
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/util"
+)
+
+// controlSocketFlag implements --control-socket, which may be given alone to
+// use the default socket path, or as --control-socket=PATH.
+type controlSocketFlag struct {
+	path *string
+}
+
+func (f controlSocketFlag) String() string {
+	if f.path == nil {
+		return ""
+	}
+	return *f.path
+}
+
+func (f controlSocketFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f.path = control.DefaultSocketPath()
+	case "false":
+		*f.path = ""
+	default:
+		*f.path = value
+	}
+	return nil
+}
+
+func (f controlSocketFlag) IsBoolFlag() bool { return true }
+
+// controlShell is a shell listed by the shells.list method.
+type controlShell struct {
+	Session string `json:"session"`
+	sshx.ShellInfo
+}
+
+// controlShellStats is a shell listed by the shells.stats method.
+type controlShellStats struct {
+	Session string `json:"session"`
+	sshx.ShellStats
+}
+
+// controlTransport is a connection listed by the transports.list method.
+type controlTransport struct {
+	Session    string `json:"session"`
+	Transport  string `json:"transport"`
+	RTT        string `json:"rtt,omitempty"`
+	AvgRTT     string `json:"avgRtt,omitempty"`
+	RTTSamples int    `json:"rttSamples"`
+	Pings      uint64 `json:"pings"`
+	Switches   int    `json:"switches"`
+}
+
+// controlTarget selects the sessions a method acts on, all when Name is empty.
+type controlTarget struct {
+	Name string `json:"name,omitempty"`
+}
+
+// controlShellTarget selects a shell for the shells.lock and shells.unlock
+// methods. Name may be empty when there is a single session.
+type controlShellTarget struct {
+	Name string `json:"name,omitempty"`
+	ID   uint32 `json:"id"`
+}
+
+// controlDump is the parameters and result of the shells.dump method.
+type controlDump struct {
+	Dir   string   `json:"dir,omitempty"`
+	Files []string `json:"files,omitempty"`
+}
+
+// serveControl serves the control socket for the open sessions. infos are the
+// sessions' details as printed, without any hidden writable links. close asks
+// the process to shut down like an interrupt.
+func serveControl(opts options, sessions []*sshx.Session, infos []sshx.Info, close func()) (*control.Server, error) {
+	server := control.NewServer()
+
+	server.Handle("sessions.list", func(json.RawMessage) (any, error) {
+		records := make([]sessionRecord, len(infos))
+		for i, info := range infos {
+			records[i] = newSessionRecord(info)
+		}
+		return records, nil
+	})
+
+	server.Handle("shells.list", func(json.RawMessage) (any, error) {
+		shells := []controlShell{}
+		for _, session := range sessions {
+			for _, shell := range session.Shells() {
+				shells = append(shells, controlShell{Session: session.Info().Name, ShellInfo: shell})
+			}
+		}
+		return shells, nil
+	})
+
+	server.Handle("shells.stats", func(json.RawMessage) (any, error) {
+		stats := []controlShellStats{}
+		for _, session := range sessions {
+			for _, shell := range session.ShellStats() {
+				stats = append(stats, controlShellStats{Session: session.Info().Name, ShellStats: shell})
+			}
+		}
+		return stats, nil
+	})
+
+	server.Handle("transports.list", func(json.RawMessage) (any, error) {
+		transports := make([]controlTransport, len(sessions))
+		for i, session := range sessions {
+			stats := session.Stats()
+			transports[i] = controlTransport{
+				Session:    session.Info().Name,
+				Transport:  session.Controller().ConnectionMethod().String(),
+				RTTSamples: stats.RTTSamples,
+				Pings:      stats.Pings,
+				Switches:   stats.TransportSwitches,
+			}
+			if stats.RTTSamples > 0 {
+				transports[i].RTT = stats.RTT.String()
+				transports[i].AvgRTT = stats.AvgRTT.String()
+			}
+		}
+		return transports, nil
+	})
+
+	server.Handle("session.reconnect", func(params json.RawMessage) (any, error) {
+		targets, err := controlTargets(sessions, params)
+		if err != nil {
+			return nil, err
+		}
+		for _, session := range targets {
+			util.Infof("Reconnecting %s on request", session.Info().Name)
+			session.Reconnect()
+		}
+		return nil, nil
+	})
+
+	server.Handle("session.close", func(json.RawMessage) (any, error) {
+		util.Infof("Close requested over the control socket")
+		close()
+		return nil, nil
+	})
+
+	for method, locked := range map[string]bool{"shells.lock": true, "shells.unlock": false} {
+		server.Handle(method, func(params json.RawMessage) (any, error) {
+			var target controlShellTarget
+			if err := json.Unmarshal(params, &target); err != nil {
+				return nil, fmt.Errorf("invalid params: %w", err)
+			}
+			targets, err := controlTargets(sessions, params)
+			if err != nil {
+				return nil, err
+			}
+			if len(targets) > 1 {
+				return nil, fmt.Errorf("several sessions are open, give the session name")
+			}
+			if err := targets[0].LockInput(target.ID, locked); err != nil {
+				return nil, err
+			}
+			if locked {
+				util.Infof("Locked input to shell %d of %s", target.ID, targets[0].Info().Name)
+			} else {
+				util.Infof("Unlocked input to shell %d of %s", target.ID, targets[0].Info().Name)
+			}
+			return nil, nil
+		})
+	}
+
+	server.Handle("shells.dump", func(params json.RawMessage) (any, error) {
+		var dump controlDump
+		if params != nil {
+			if err := json.Unmarshal(params, &dump); err != nil {
+				return nil, fmt.Errorf("invalid params: %w", err)
+			}
+		}
+		if dump.Dir == "" {
+			dump.Dir = opts.DumpDir
+		}
+		if dump.Dir == "" {
+			return nil, fmt.Errorf("no dump directory given and --dump-dir is not set")
+		}
+		files, err := dumpSessions(dump.Dir, sessions)
+		if err != nil {
+			return nil, err
+		}
+		return controlDump{Dir: dump.Dir, Files: files}, nil
+	})
+
+	if err := server.Listen(opts.ControlSocket); err != nil {
+		return nil, err
+	}
+	util.DebugLog("control socket listening on %s", opts.ControlSocket)
+	return server, nil
+}
+
+// controlTargets returns the sessions named by params, or all of them.
+func controlTargets(sessions []*sshx.Session, params json.RawMessage) ([]*sshx.Session, error) {
+	var target controlTarget
+	if params != nil {
+		if err := json.Unmarshal(params, &target); err != nil {
+			return nil, fmt.Errorf("invalid params: %w", err)
+		}
+	}
+	if target.Name == "" {
+		return sessions, nil
+	}
+	for _, session := range sessions {
+		if session.Info().Name == target.Name {
+			return []*sshx.Session{session}, nil
+		}
+	}
+	return nil, fmt.Errorf("no session named %q", target.Name)
+}
+
+// ctlUsage describes the commands of "sshx ctl".
+const ctlUsage = `Usage: sshx ctl [--socket PATH] <command>
+
+Administer an sshx process started with --control-socket.
+
+Commands:
+  sessions          List sessions with their links, as JSON
+  urls              Print the link of every session
+  shells            List running shells and their sizes, as JSON
+  stats             List each shell's bytes in and out, output the server has
+                    not acknowledged and last activity, as JSON
+  transports        List each session's transport and latency, as JSON
+  reconnect [NAME]  Reconnect a session, or all of them, to the server
+  lock ID [NAME]    Drop users' input to a shell, so only the host types in it
+  unlock ID [NAME]  Let users type in a locked shell again
+  dump [DIR]        Save each shell's recent output (default --dump-dir)
+  close             Close the sessions and shut sshx down
+
+Flags:
+`
+
+// runCtl implements "sshx ctl", calling a running sshx over its control socket.
+func runCtl(args []string) error {
+	flags := flag.NewFlagSet("ctl", flag.ExitOnError)
+	socket := flags.String("socket", control.DefaultSocketPath(), "Control socket of the sshx process")
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), ctlUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	command, rest := flags.Arg(0), flags.Args()[1:]
+
+	switch command {
+	case "sessions", "shells", "transports":
+		var result json.RawMessage
+		if err := control.Call(*socket, command+".list", nil, &result); err != nil {
+			return err
+		}
+		return printJSON(result)
+
+	case "stats":
+		var result json.RawMessage
+		if err := control.Call(*socket, "shells.stats", nil, &result); err != nil {
+			return err
+		}
+		return printJSON(result)
+
+	case "urls":
+		var records []sessionRecord
+		if err := control.Call(*socket, "sessions.list", nil, &records); err != nil {
+			return err
+		}
+		for _, record := range records {
+			fmt.Println(record.URL)
+			if record.WriteURL != "" {
+				fmt.Println(record.WriteURL)
+			}
+		}
+		return nil
+
+	case "reconnect":
+		var target controlTarget
+		if len(rest) > 0 {
+			target.Name = rest[0]
+		}
+		return control.Call(*socket, "session.reconnect", target, nil)
+
+	case "lock", "unlock":
+		if len(rest) == 0 {
+			return fmt.Errorf("usage: sshx ctl %s ID [NAME]", command)
+		}
+		id, err := strconv.ParseUint(rest[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid shell ID %q", rest[0])
+		}
+		target := controlShellTarget{ID: uint32(id)}
+		if len(rest) > 1 {
+			target.Name = rest[1]
+		}
+		return control.Call(*socket, "shells."+command, target, nil)
+
+	case "dump":
+		var dump controlDump
+		if len(rest) > 0 {
+			// The directory is resolved by the sshx process, which may run elsewhere
+			dir, err := filepath.Abs(rest[0])
+			if err != nil {
+				return err
+			}
+			dump.Dir = dir
+		}
+		if err := control.Call(*socket, "shells.dump", dump, &dump); err != nil {
+			return err
+		}
+		for _, file := range dump.Files {
+			fmt.Println(file)
+		}
+		return nil
+
+	case "close":
+		return control.Call(*socket, "session.close", nil, nil)
+
+	default:
+		return fmt.Errorf("unknown ctl command %q (see sshx ctl --help)", command)
+	}
+}
+
+// printJSON prints a JSON value indented for reading.
+func printJSON(value json.RawMessage) error {
+	out, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/terminal"
+	"sshx-go/pkg/transport"
+)
+
+// doctorTimeout bounds each network check of sshx doctor.
+const doctorTimeout = 10 * time.Second
+
+// maxClockSkew is how far the local clock may be off the server's before sshx
+// doctor warns. Certificates and expiring links are checked against it.
+const maxClockSkew = 30 * time.Second
+
+// certExpiryWarning is how close to expiry a server certificate may be before
+// sshx doctor warns.
+const certExpiryWarning = 14 * 24 * time.Hour
+
+// checkStatus is the outcome of a check of sshx doctor.
+type checkStatus int
+
+const (
+	checkOK checkStatus = iota
+	checkSkipped
+	checkWarn
+	checkFail
+)
+
+// doctorReport prints the outcome of each check as it completes and counts the
+// problems found.
+type doctorReport struct {
+	warnings int
+	failures int
+}
+
+func (r *doctorReport) add(status checkStatus, name, format string, args ...interface{}) {
+	var mark string
+	switch status {
+	case checkOK:
+		mark = Green + "✓" + Reset
+	case checkSkipped:
+		mark = Fixed8 + "-" + Reset
+	case checkWarn:
+		mark = Yellow + "!" + Reset
+		r.warnings++
+	case checkFail:
+		mark = Red + "✗" + Reset
+		r.failures++
+	}
+	fmt.Printf("  %s %-10s %s\n", mark, name, fmt.Sprintf(format, args...))
+}
+
+// runDoctor checks that sshx can reach each server and share a terminal from
+// this host, and prints what it finds. It fails if any check fails.
+func runDoctor(opts options, connConfig transport.ConnectionConfig) error {
+	report := &doctorReport{}
+	for _, origin := range sshx.SplitServers(opts.Server) {
+		fmt.Printf("%sServer%s %s\n", BoldGreen, Reset, origin)
+		checkServer(report, opts, connConfig, origin)
+		fmt.Println()
+	}
+
+	fmt.Printf("%sHost%s\n", BoldGreen, Reset)
+	checkPTY(report, opts)
+	fmt.Println()
+
+	switch {
+	case report.failures > 0:
+		return fmt.Errorf("%d check(s) failed, %d warning(s)", report.failures, report.warnings)
+	case report.warnings > 0:
+		fmt.Printf("%s!%s All checks passed with %d warning(s)\n", Yellow, Reset, report.warnings)
+	default:
+		fmt.Printf("%s✓%s All checks passed\n", Green, Reset)
+	}
+	return nil
+}
+
+// checkServer runs the network checks against one server.
+func checkServer(report *doctorReport, opts options, connConfig transport.ConnectionConfig, origin string) {
+	u, err := url.Parse(origin)
+	if err != nil || u.Hostname() == "" {
+		report.add(checkFail, "URL", "invalid server URL %q", origin)
+		return
+	}
+
+	// Proxy settings decide which names this host has to resolve itself
+	proxy, err := connConfig.ProxyURL(origin)
+	switch {
+	case err != nil:
+		report.add(checkFail, "Proxy", "%v", err)
+		return
+	case opts.SOCKS5 != "":
+		report.add(checkOK, "Proxy", "SOCKS5 proxy %s (--socks5)", opts.SOCKS5)
+	case proxy != nil:
+		report.add(checkOK, "Proxy", "HTTP proxy %s", proxy.Redacted())
+	default:
+		report.add(checkOK, "Proxy", "none, connecting directly")
+	}
+
+	switch {
+	case proxy != nil:
+		checkDNS(report, proxy.Hostname())
+		report.add(checkSkipped, "DNS", "%s is resolved by the proxy", u.Hostname())
+	case opts.SOCKS5 != "":
+		report.add(checkSkipped, "DNS", "%s is resolved by the SOCKS5 proxy", u.Hostname())
+	default:
+		checkDNS(report, u.Hostname())
+		if connConfig.GrpcTarget != "" {
+			if host, _, err := net.SplitHostPort(connConfig.GrpcTarget); err == nil && host != u.Hostname() {
+				checkDNS(report, host)
+			}
+		}
+	}
+
+	checkTLS(report, connConfig, origin, u)
+	if checkTransports(report, connConfig, origin) {
+		checkClock(report, connConfig, origin)
+	} else {
+		report.add(checkSkipped, "Clock", "server unreachable")
+	}
+}
+
+// checkDNS resolves host with the system resolver.
+func checkDNS(report *doctorReport, host string) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		report.add(checkFail, "DNS", "failed to resolve %s: %v", host, err)
+		return
+	}
+	report.add(checkOK, "DNS", "%s resolves to %s", host, strings.Join(addrs, ", "))
+}
+
+// checkTLS verifies the certificate chain of an https server.
+func checkTLS(report *doctorReport, connConfig transport.ConnectionConfig, origin string, u *url.URL) {
+	if u.Scheme != "https" {
+		report.add(checkSkipped, "TLS", "not used by %s:// servers", u.Scheme)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	chain, err := transport.CheckTLS(ctx, origin, connConfig)
+	if err != nil {
+		report.add(checkFail, "TLS", "%v", err)
+		return
+	}
+	leaf := chain[0]
+	issuer := leaf.Issuer.CommonName
+	if issuer == "" {
+		issuer = leaf.Issuer.String()
+	}
+	detail := fmt.Sprintf("issued by %s, %d certificate(s) in chain, expires %s",
+		issuer, len(chain), leaf.NotAfter.Format("2006-01-02"))
+
+	switch {
+	case connConfig.TLSInsecureSkipVerify:
+		report.add(checkWarn, "TLS", "not verified (--tls-insecure); %s", detail)
+	case time.Until(leaf.NotAfter) < certExpiryWarning:
+		report.add(checkWarn, "TLS", "certificate expires soon; %s", detail)
+	default:
+		report.add(checkOK, "TLS", "valid; %s", detail)
+	}
+}
+
+// checkTransports probes the transports --transport allows, and reports
+// whether any of them works. A failing transport is only a warning while the
+// other one works, since sessions fall back to it.
+func checkTransports(report *doctorReport, connConfig transport.ConnectionConfig, origin string) bool {
+	useGrpc := connConfig.Preference != transport.PreferWebSocket
+	useWebSocket := connConfig.Preference != transport.PreferGrpc
+
+	var grpcErr, wsErr error
+	var grpcTime, wsTime time.Duration
+	if useGrpc {
+		start := time.Now()
+		grpcErr = transport.ProbeGrpc(origin, connConfig)
+		grpcTime = time.Since(start)
+	}
+	if useWebSocket {
+		start := time.Now()
+		wsErr = transport.ProbeWebSocket(origin, connConfig)
+		wsTime = time.Since(start)
+	}
+
+	if !useGrpc {
+		report.add(checkSkipped, "gRPC", "not used (--transport %s)", connConfig.Preference)
+	} else if grpcErr == nil {
+		report.add(checkOK, "gRPC", "reachable in %s", roundLatency(grpcTime))
+	} else if useWebSocket && wsErr == nil {
+		report.add(checkWarn, "gRPC", "unreachable, sessions will use WebSocket: %v", grpcErr)
+	} else {
+		report.add(checkFail, "gRPC", "unreachable: %v", grpcErr)
+	}
+
+	if !useWebSocket {
+		report.add(checkSkipped, "WebSocket", "not used (--transport %s)", connConfig.Preference)
+	} else if wsErr == nil {
+		report.add(checkOK, "WebSocket", "reachable in %s", roundLatency(wsTime))
+	} else if useGrpc && grpcErr == nil {
+		report.add(checkWarn, "WebSocket", "unreachable, but not needed while gRPC works: %v", wsErr)
+	} else {
+		report.add(checkFail, "WebSocket", "unreachable: %v", wsErr)
+	}
+	return (useGrpc && grpcErr == nil) || (useWebSocket && wsErr == nil)
+}
+
+// roundLatency rounds d for display, keeping sub-millisecond times visible.
+func roundLatency(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// checkClock compares the local clock with the Date the server reports, which
+// is only accurate to the second.
+func checkClock(report *doctorReport, connConfig transport.ConnectionConfig, origin string) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+
+	start := time.Now()
+	serverTime, err := transport.ServerTime(ctx, origin, connConfig)
+	if err != nil {
+		report.add(checkWarn, "Clock", "could not read the server time: %v", err)
+		return
+	}
+	// The server stamped its response somewhere during the round trip
+	local := start.Add(time.Since(start) / 2)
+	skew := local.Sub(serverTime).Round(time.Second)
+
+	switch {
+	case skew >= maxClockSkew:
+		report.add(checkWarn, "Clock", "local clock is %s ahead of the server", skew)
+	case skew <= -maxClockSkew:
+		report.add(checkWarn, "Clock", "local clock is %s behind the server", -skew)
+	default:
+		report.add(checkOK, "Clock", "in sync with the server")
+	}
+}
+
+// checkPTY opens a terminal running the shell sessions would run, and closes
+// it again.
+func checkPTY(report *doctorReport, opts options) {
+	shell := terminal.GetDefaultShell()
+	if opts.Shell != "" {
+		command, err := terminal.SplitCommand(opts.Shell)
+		if err != nil {
+			report.add(checkFail, "PTY", "invalid --shell command: %v", err)
+			return
+		}
+		shell = command[0]
+	}
+
+	term, err := terminal.New(shell)
+	if err != nil {
+		report.add(checkFail, "PTY", "failed to start %s: %v", shell, err)
+		return
+	}
+	if err := term.Close(); err != nil {
+		report.add(checkWarn, "PTY", "started %s, but failed to close it: %v", shell, err)
+		return
+	}
+	report.add(checkOK, "PTY", "started %s", shell)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/util"
+)
+
+// dumpTimeout bounds how long shells are given to hand over their output.
+const dumpTimeout = 5 * time.Second
+
+// dumpSessions writes the recent output of every shell of every session to
+// its own file in dir, returning the paths written. The files are private
+// since the output may contain anything typed into the session.
+func dumpSessions(dir string, sessions []*sshx.Session) ([]string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dump directory: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dumpTimeout)
+	defer cancel()
+
+	stamp := time.Now().Format("20060102-150405")
+	var paths []string
+	for _, session := range sessions {
+		name := strings.NewReplacer("/", "_", string(os.PathSeparator), "_").Replace(session.Info().Name)
+		snapshot := session.Snapshot(ctx)
+
+		ids := make([]uint32, 0, len(snapshot))
+		for id := range snapshot {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		for _, id := range ids {
+			path := filepath.Join(dir, fmt.Sprintf("%s-shell%d-%s.log", name, id, stamp))
+			if err := os.WriteFile(path, snapshot[id], 0600); err != nil {
+				return paths, fmt.Errorf("failed to write dump: %w", err)
+			}
+			paths = append(paths, path)
+		}
+	}
+	return paths, nil
+}
+
+// watchDumps dumps the sessions to dir whenever a dump signal (SIGUSR1) is
+// received, until the returned function is called.
+func watchDumps(dir string, sessions []*sshx.Session) (stop func()) {
+	if dir == "" || len(dumpSignals) == 0 {
+		return func() {}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, dumpSignals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				paths, err := dumpSessions(dir, sessions)
+				if err != nil {
+					util.Warnf("Scrollback dump failed: %v", err)
+				} else {
+					util.Infof("Dumped %d shells to %s", len(paths), dir)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
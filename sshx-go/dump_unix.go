@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// dumpSignals trigger a scrollback dump to --dump-dir.
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
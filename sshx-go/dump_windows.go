@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// dumpSignals trigger a scrollback dump to --dump-dir. Windows has no
+// user-defined signals, so dumps are not available there.
+var dumpSignals []os.Signal
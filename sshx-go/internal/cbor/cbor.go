@@ -0,0 +1,342 @@
+// Package cbor encodes and decodes the CBOR messages of the server's web
+// protocol, which it exchanges with the browser.
+//
+// Only what those messages use is supported: integers, byte and text strings,
+// arrays, maps with text keys, booleans and null. Values are untyped, as in
+// encoding/json: Rust enums arrive as maps with the variant name as the only
+// key, tuples as arrays.
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// maxDepth bounds the nesting of decoded values.
+const maxDepth = 32
+
+// Major types.
+const (
+	majorUint   = 0
+	majorNegInt = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Marshal encodes v, which may be nil, a bool, an integer, a string, a byte
+// slice, or a []any or map[string]any of these. Map keys are sorted, so equal
+// values encode alike.
+func Marshal(v any) ([]byte, error) {
+	return appendValue(nil, v)
+}
+
+func appendValue(buf []byte, v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return append(buf, 0xf6), nil
+	case bool:
+		if v {
+			return append(buf, 0xf5), nil
+		}
+		return append(buf, 0xf4), nil
+	case int:
+		return appendInt(buf, int64(v)), nil
+	case int32:
+		return appendInt(buf, int64(v)), nil
+	case int64:
+		return appendInt(buf, v), nil
+	case uint:
+		return appendHead(buf, majorUint, uint64(v)), nil
+	case uint16:
+		return appendHead(buf, majorUint, uint64(v)), nil
+	case uint32:
+		return appendHead(buf, majorUint, uint64(v)), nil
+	case uint64:
+		return appendHead(buf, majorUint, v), nil
+	case string:
+		return append(appendHead(buf, majorText, uint64(len(v))), v...), nil
+	case []byte:
+		return append(appendHead(buf, majorBytes, uint64(len(v))), v...), nil
+	case []any:
+		buf = appendHead(buf, majorArray, uint64(len(v)))
+		for _, item := range v {
+			var err error
+			if buf, err = appendValue(buf, item); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		buf = appendHead(buf, majorMap, uint64(len(v)))
+		for _, key := range keys {
+			buf = append(appendHead(buf, majorText, uint64(len(key))), key...)
+			var err error
+			if buf, err = appendValue(buf, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	default:
+		return nil, fmt.Errorf("cbor: unsupported type %T", v)
+	}
+}
+
+func appendInt(buf []byte, n int64) []byte {
+	if n < 0 {
+		return appendHead(buf, majorNegInt, uint64(-1-n))
+	}
+	return appendHead(buf, majorUint, uint64(n))
+}
+
+// appendHead appends the initial byte of an item and its argument, in the
+// shortest form.
+func appendHead(buf []byte, major byte, arg uint64) []byte {
+	major <<= 5
+	switch {
+	case arg < 24:
+		return append(buf, major|byte(arg))
+	case arg <= math.MaxUint8:
+		return append(buf, major|24, byte(arg))
+	case arg <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(arg))
+	case arg <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(arg))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, major|27), arg)
+	}
+}
+
+var errTruncated = errors.New("cbor: unexpected end of data")
+
+// Unmarshal decodes a single value from data. Unsigned integers decode as
+// uint64, negative ones as int64, floats as float64, byte strings as []byte,
+// arrays as []any and maps as map[string]any. Tags are skipped.
+func Unmarshal(data []byte) (any, error) {
+	d := decoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(d.data) {
+		return nil, fmt.Errorf("cbor: %d bytes of trailing data", len(d.data)-d.pos)
+	}
+	return v, nil
+}
+
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads the initial byte of an item and its argument. Indefinite
+// lengths are reported with indefinite set.
+func (d *decoder) head() (major byte, info byte, arg uint64, err error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, 0, errTruncated
+	}
+	b := d.data[d.pos]
+	d.pos++
+	major, info = b>>5, b&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	case info == 31:
+		return major, info, 0, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+	if len(d.data)-d.pos < size {
+		return 0, 0, 0, errTruncated
+	}
+	for _, b := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(b)
+	}
+	d.pos += size
+	return major, info, arg, nil
+}
+
+// length checks that arg can be the length of an item with at least unit
+// bytes per element in what is left of the data.
+func (d *decoder) length(arg uint64, unit int) (int, error) {
+	if arg > uint64(len(d.data)-d.pos)/uint64(unit) {
+		return 0, errTruncated
+	}
+	return int(arg), nil
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, errors.New("cbor: nested too deeply")
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if info == 31 && major != majorBytes && major != majorText && major != majorArray && major != majorMap {
+		return nil, fmt.Errorf("cbor: invalid indefinite length for major type %d", major)
+	}
+
+	switch major {
+	case majorUint:
+		return arg, nil
+
+	case majorNegInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("cbor: negative integer out of range")
+		}
+		return -1 - int64(arg), nil
+
+	case majorBytes, majorText:
+		var s []byte
+		if info == 31 {
+			// Concatenate definite-length chunks until the break
+			for {
+				if d.pos < len(d.data) && d.data[d.pos] == 0xff {
+					d.pos++
+					break
+				}
+				chunkMajor, chunkInfo, chunkArg, err := d.head()
+				if err != nil {
+					return nil, err
+				}
+				if chunkMajor != major || chunkInfo == 31 {
+					return nil, errors.New("cbor: invalid chunk in indefinite-length string")
+				}
+				n, err := d.length(chunkArg, 1)
+				if err != nil {
+					return nil, err
+				}
+				s = append(s, d.data[d.pos:d.pos+n]...)
+				d.pos += n
+			}
+		} else {
+			n, err := d.length(arg, 1)
+			if err != nil {
+				return nil, err
+			}
+			s = append([]byte{}, d.data[d.pos:d.pos+n]...)
+			d.pos += n
+		}
+		if major == majorText {
+			return string(s), nil
+		}
+		return s, nil
+
+	case majorArray:
+		var items []any
+		if info != 31 {
+			n, err := d.length(arg, 1)
+			if err != nil {
+				return nil, err
+			}
+			items = make([]any, 0, n)
+		}
+		for i := 0; info == 31 || i < int(arg); i++ {
+			if info == 31 && d.pos < len(d.data) && d.data[d.pos] == 0xff {
+				d.pos++
+				break
+			}
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		if items == nil {
+			items = []any{}
+		}
+		return items, nil
+
+	case majorMap:
+		m := make(map[string]any)
+		if info != 31 {
+			if _, err := d.length(arg, 2); err != nil {
+				return nil, err
+			}
+		}
+		for i := 0; info == 31 || i < int(arg); i++ {
+			if info == 31 && d.pos < len(d.data) && d.data[d.pos] == 0xff {
+				d.pos++
+				break
+			}
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("cbor: unsupported map key of type %T", key)
+			}
+			if m[name], err = d.value(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+
+	case majorTag:
+		return d.value(depth + 1)
+
+	default: // majorSimple
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			return float16(uint16(arg)), nil
+		case 26:
+			return float64(math.Float32frombits(uint32(arg))), nil
+		case 27:
+			return math.Float64frombits(arg), nil
+		default:
+			return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+		}
+	}
+}
+
+// float16 converts an IEEE 754 half-precision float.
+func float16(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1f
+	frac := float64(bits & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(frac, -24)
+	case 0x1f:
+		if frac == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(frac+1024, exp-25)
+	}
+	if bits&0x8000 != 0 {
+		f = -f
+	}
+	return f
+}
@@ -0,0 +1,166 @@
+package cbor
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// encodings are examples of RFC 8949, appendix A, in the shortest form
+// Marshal produces, and the value they decode to.
+var encodings = []struct {
+	hex   string
+	value any
+}{
+	{"00", uint64(0)},
+	{"17", uint64(23)},
+	{"1818", uint64(24)},
+	{"1903e8", uint64(1000)},
+	{"1a000f4240", uint64(1000000)},
+	{"1b000000e8d4a51000", uint64(1000000000000)},
+	{"1bffffffffffffffff", uint64(math.MaxUint64)},
+	{"20", int64(-1)},
+	{"3863", int64(-100)},
+	{"3903e7", int64(-1000)},
+	{"3b7fffffffffffffff", int64(math.MinInt64)},
+	{"f4", false},
+	{"f5", true},
+	{"f6", nil},
+	{"40", []byte{}},
+	{"4401020304", []byte{1, 2, 3, 4}},
+	{"60", ""},
+	{"6161", "a"},
+	{"6449455446", "IETF"},
+	{"62225c", "\"\\"},
+	{"63e6b0b4", "水"},
+	{"80", []any{}},
+	{"83010203", []any{uint64(1), uint64(2), uint64(3)}},
+	{"8301820203820405", []any{uint64(1), []any{uint64(2), uint64(3)}, []any{uint64(4), uint64(5)}}},
+	{"a0", map[string]any{}},
+	{"a26161016162820203", map[string]any{"a": uint64(1), "b": []any{uint64(2), uint64(3)}}},
+	{"a56161614161626142616361436164614461656145", map[string]any{"a": "A", "b": "B", "c": "C", "d": "D", "e": "E"}},
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tt := range encodings {
+		want, _ := hex.DecodeString(tt.hex)
+		got, err := Marshal(tt.value)
+		if err != nil {
+			t.Errorf("Marshal(%#v): %v", tt.value, err)
+		} else if !bytes.Equal(got, want) {
+			t.Errorf("Marshal(%#v) = %x, want %s", tt.value, got, tt.hex)
+		}
+		value, err := Unmarshal(want)
+		if err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.hex, err)
+		} else if !reflect.DeepEqual(value, tt.value) {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", tt.hex, value, tt.value)
+		}
+	}
+
+	// Go integers of every type encode as the unsigned or negative integers
+	// they hold
+	for _, v := range []any{int(-1000), int32(-1000), int64(-1000)} {
+		if got, _ := Marshal(v); hex.EncodeToString(got) != "3903e7" {
+			t.Errorf("Marshal(%T(-1000)) = %x, want 3903e7", v, got)
+		}
+	}
+	for _, v := range []any{uint(1000), uint16(1000), uint32(1000), uint64(1000), int(1000)} {
+		if got, _ := Marshal(v); hex.EncodeToString(got) != "1903e8" {
+			t.Errorf("Marshal(%T(1000)) = %x, want 1903e8", v, got)
+		}
+	}
+	if _, err := Marshal(1.5); err == nil {
+		t.Error("Marshal of a float succeeded")
+	}
+}
+
+// TestUnmarshal checks encodings the server may send but Marshal never
+// produces: floats, indefinite lengths, tags and longer heads than needed.
+func TestUnmarshal(t *testing.T) {
+	for _, tt := range []struct {
+		hex   string
+		value any
+	}{
+		{"f90000", 0.0},
+		{"f93c00", 1.0},
+		{"f9c400", -4.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"f97c00", math.Inf(1)},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f7", nil},
+		{"1800", uint64(0)},
+		{"c11a514b67b0", uint64(1363896240)},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9f018202039f0405ffff", []any{uint64(1), []any{uint64(2), uint64(3)}, []any{uint64(4), uint64(5)}}},
+		{"9fff", []any{}},
+		{"bf61610161629f0203ffff", map[string]any{"a": uint64(1), "b": []any{uint64(2), uint64(3)}}},
+	} {
+		data, _ := hex.DecodeString(tt.hex)
+		value, err := Unmarshal(data)
+		if err != nil {
+			t.Errorf("Unmarshal(%s): %v", tt.hex, err)
+		} else if !reflect.DeepEqual(value, tt.value) {
+			t.Errorf("Unmarshal(%s) = %#v, want %#v", tt.hex, value, tt.value)
+		}
+	}
+	if value, err := Unmarshal([]byte{0xf9, 0x7e, 0x00}); err != nil || !math.IsNaN(value.(float64)) {
+		t.Errorf("Unmarshal(f97e00) = %v, %v, want NaN", value, err)
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	for _, tt := range []struct {
+		hex  string
+		want string
+	}{
+		{"", "unexpected end"},
+		{"19 03", "unexpected end"},
+		{"44 0102", "unexpected end"},
+		{"9b ffffffffffffffff", "unexpected end"},
+		{"bb ffffffffffffffff", "unexpected end"},
+		{"5f 4101", "unexpected end"},
+		{"5f 6161 ff", "invalid chunk"},
+		{"1c", "invalid additional information"},
+		{"1f", "invalid indefinite length"},
+		{"a1 01 02", "unsupported map key"},
+		{"3b 8000000000000000", "out of range"},
+		{"f8 20", "unsupported simple value"},
+		{"00 00", "trailing data"},
+		{strings.Repeat("81", maxDepth+1) + "00", "nested too deeply"},
+	} {
+		data, _ := hex.DecodeString(strings.ReplaceAll(tt.hex, " ", ""))
+		if _, err := Unmarshal(data); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Unmarshal(%s) = %v, want an error containing %q", tt.hex, err, tt.want)
+		}
+	}
+	if _, err := Unmarshal([]byte{0x19, 0x03}); !errors.Is(err, errTruncated) {
+		t.Errorf("truncated data returned %v, want errTruncated", err)
+	}
+}
+
+// TestNesting checks values nested as deep as allowed decode.
+func TestNesting(t *testing.T) {
+	var value any = uint64(1)
+	for range maxDepth {
+		value = []any{value}
+	}
+	data, err := Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Unmarshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, value) {
+		t.Errorf("Unmarshal(%x) = %v, want %v", data, got, value)
+	}
+}
@@ -0,0 +1,721 @@
+// Package sshxtest runs an in-process mock sshx server for integration tests.
+//
+// The server speaks both the gRPC and the WebSocket protocol on one local
+// port, so the Controller, both transports, fallback between them, reconnects
+// and the shell sync logic can be exercised end-to-end without a live
+// deployment:
+//
+//	server, err := sshxtest.NewServer()
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer server.Close()
+//
+//	controller, err := client.NewController(client.ControllerConfig{
+//		Origin: server.URL,
+//		Name:   "test",
+//		Runner: &client.EchoRunner{},
+//	})
+//	...
+//	session, err := server.Session(ctx, controller.Name())
+//
+// Like the real server, it never sees the encryption key: terminal output is
+// recorded encrypted, and tests decrypt it with the key from the session URL.
+//
+// Users join sessions on the browser endpoint, /api/s/{name}, as with the
+// viewer package; it supports enough of the web protocol to create shells,
+// type into them and read their output.
+package sshxtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"sshx-go/pkg/proto"
+)
+
+// Options configures a mock server.
+type Options struct {
+	// DisableGrpc rejects gRPC requests, so clients must fall back to
+	// WebSocket. Server.SetGrpcDisabled changes it while the server runs.
+	DisableGrpc bool
+	// DisableWebSocket rejects WebSocket upgrades.
+	DisableWebSocket bool
+	// SyncInterval sends each connected session its sequence numbers this
+	// often, like the real server. Zero only syncs on Session.Sync.
+	SyncInterval time.Duration
+}
+
+// Server is a mock sshx server listening on a local port.
+type Server struct {
+	// URL is the origin clients connect to, e.g. "http://127.0.0.1:41234".
+	URL string
+
+	opts       Options
+	listener   net.Listener
+	httpServer *http.Server
+	grpcServer *grpc.Server
+	upgrader   websocket.Upgrader
+
+	grpcDisabled atomic.Bool
+
+	mu       sync.Mutex
+	sessions map[string]*Session
+	changed  chan struct{} // closed and replaced whenever sessions change
+}
+
+// NewServer starts a mock server serving both transports.
+func NewServer() (*Server, error) {
+	return NewServerWithOptions(Options{})
+}
+
+// NewServerWithOptions starts a mock server configured by opts.
+func NewServerWithOptions(opts Options) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s := &Server{
+		URL:        "http://" + listener.Addr().String(),
+		opts:       opts,
+		listener:   listener,
+		grpcServer: grpc.NewServer(),
+		sessions:   make(map[string]*Session),
+		changed:    make(chan struct{}),
+	}
+	s.grpcDisabled.Store(opts.DisableGrpc)
+	proto.RegisterSshxServiceServer(s.grpcServer, &grpcService{server: s})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/cli/", s.serveWebSocket)
+	mux.HandleFunc("/api/s/", s.serveViewer)
+
+	// gRPC arrives as HTTP/2 with prior knowledge, everything else as HTTP/1.1
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			if s.grpcDisabled.Load() {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			s.grpcServer.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+	s.httpServer = &http.Server{Handler: h2c.NewHandler(handler, &http2.Server{})}
+	go s.httpServer.Serve(listener)
+
+	return s, nil
+}
+
+// Close stops the server, dropping every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	for _, session := range s.sessions {
+		session.Drop()
+	}
+	s.mu.Unlock()
+
+	s.grpcServer.Stop()
+	return s.httpServer.Close()
+}
+
+// SetGrpcDisabled rejects gRPC requests from now on, or accepts them again.
+func (s *Server) SetGrpcDisabled(disabled bool) {
+	s.grpcDisabled.Store(disabled)
+}
+
+// Session waits until a session with the given server-assigned name is opened.
+func (s *Server) Session(ctx context.Context, name string) (*Session, error) {
+	for {
+		s.mu.Lock()
+		session, changed := s.sessions[name], s.changed
+		s.mu.Unlock()
+		if session != nil {
+			return session, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("session %q was not opened: %w", name, ctx.Err())
+		}
+	}
+}
+
+// Sessions returns every session opened on the server, including closed ones.
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// open creates a session like the real server: with a random name and token,
+// and a URL on the origin the client asked for.
+func (s *Server) open(req *proto.OpenRequest) (*proto.OpenResponse, error) {
+	if req.Origin == "" {
+		return nil, status.Error(codes.InvalidArgument, "origin is empty")
+	}
+
+	session := &Session{
+		Name:              randomHex(5),
+		Token:             randomHex(16),
+		DisplayName:       req.Name,
+		EncryptedZeros:    req.EncryptedZeros,
+		WritePasswordHash: req.WritePasswordHash,
+		server:            s,
+		updates:           make(chan *proto.ClientUpdate, 1024),
+		output:            make(map[uint32][]byte),
+		changed:           make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.sessions[session.Name] = session
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+
+	resp := &proto.OpenResponse{
+		Name:  session.Name,
+		Token: session.Token,
+		Url:   req.Origin + "/s/" + session.Name,
+	}
+	return resp, nil
+}
+
+// lookup returns the session authenticated by name and token.
+func (s *Server) lookup(name, token string) (*Session, error) {
+	s.mu.Lock()
+	session := s.sessions[name]
+	s.mu.Unlock()
+
+	if session == nil || session.Token != token {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	return session, nil
+}
+
+// close ends the session authenticated by req, dropping its connection.
+func (s *Server) close(req *proto.CloseRequest) error {
+	session, err := s.lookup(req.Name, req.Token)
+	if err != nil {
+		return err
+	}
+
+	session.mu.Lock()
+	session.closed = true
+	session.mu.Unlock()
+	session.notify()
+	session.Drop()
+	return nil
+}
+
+// randomHex returns n random bytes as hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Session is a session opened on the mock server.
+type Session struct {
+	// Name and Token are assigned by the server when the session is opened.
+	Name  string
+	Token string
+	// DisplayName, EncryptedZeros and WritePasswordHash are as sent by the client.
+	DisplayName       string
+	EncryptedZeros    []byte
+	WritePasswordHash []byte
+
+	server  *Server
+	updates chan *proto.ClientUpdate
+
+	mu          sync.Mutex
+	conn        *conn
+	connections int
+	transports  []string          // transport of each connection, in order
+	lossy       bool              // discard terminal data, as if lost in transit
+	lost        int               // bytes of terminal data discarded
+	output      map[uint32][]byte // encrypted output of each shell, from offset 0
+	shells      []uint32          // shells the client created and has not closed
+	lastShell   uint32            // ID of the last shell users asked for
+	lastUser    uint32            // ID of the last user who joined
+	closed      bool
+	changed     chan struct{} // closed and replaced whenever the session changes
+}
+
+// conn is a client's channel connection to a session.
+type conn struct {
+	mu      sync.Mutex
+	send    func(*proto.ServerUpdate) error
+	dropped chan struct{}
+	once    sync.Once
+}
+
+// drop disconnects the client.
+func (c *conn) drop() {
+	c.once.Do(func() { close(c.dropped) })
+}
+
+// Updates returns the messages received from the client, other than Hello.
+// Terminal data is also recorded in Output.
+func (s *Session) Updates() <-chan *proto.ClientUpdate {
+	return s.updates
+}
+
+// Send sends an update to the connected client.
+func (s *Session) Send(update *proto.ServerUpdate) error {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	if c == nil {
+		return errors.New("session is not connected")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.send(update)
+}
+
+// Sync sends the client the sequence number of each shell's output, which
+// makes it resend anything the server has not received.
+func (s *Session) Sync() error {
+	return s.Send(&proto.ServerUpdate{
+		ServerMessage: &proto.ServerUpdate_Sync{Sync: s.sequenceNumbers()},
+	})
+}
+
+// Output returns the encrypted output received for a shell, from offset 0.
+func (s *Session) Output(id uint32) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]byte(nil), s.output[id]...)
+}
+
+// WaitOutput waits until at least n bytes of output were received for a shell.
+func (s *Session) WaitOutput(ctx context.Context, id uint32, n int) ([]byte, error) {
+	return waitFor(ctx, s, func() ([]byte, bool) {
+		output := s.output[id]
+		return append([]byte(nil), output...), len(output) >= n
+	})
+}
+
+// WaitConnections waits until the client has connected n times in total, so
+// tests can observe reconnects.
+func (s *Session) WaitConnections(ctx context.Context, n int) error {
+	_, err := waitFor(ctx, s, func() (struct{}, bool) {
+		return struct{}{}, s.connections >= n && s.conn != nil
+	})
+	return err
+}
+
+// Connections returns how many times the client has connected to the session.
+func (s *Session) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// Transports returns the transport of each connection so far, in order:
+// "grpc" or "websocket".
+func (s *Session) Transports() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.transports...)
+}
+
+// Connected reports whether a client is connected to the session.
+func (s *Session) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// Closed reports whether the client closed the session.
+func (s *Session) Closed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Drop disconnects the client without closing the session, as if the
+// network failed, so it reconnects.
+func (s *Session) Drop() {
+	s.mu.Lock()
+	c := s.conn
+	s.lossy = false
+	s.mu.Unlock()
+	if c != nil {
+		c.drop()
+	}
+}
+
+// Lose discards terminal data received until the next Drop, as if it was
+// lost in transit with a failing connection.
+func (s *Session) Lose() {
+	s.mu.Lock()
+	s.lossy = true
+	s.mu.Unlock()
+}
+
+// WaitLost waits until at least n bytes of terminal data were discarded by Lose.
+func (s *Session) WaitLost(ctx context.Context, n int) error {
+	_, err := waitFor(ctx, s, func() (struct{}, bool) {
+		return struct{}{}, s.lost >= n
+	})
+	return err
+}
+
+// waitFor waits until check, called with the session locked, succeeds.
+func waitFor[T any](ctx context.Context, s *Session, check func() (T, bool)) (T, error) {
+	for {
+		s.mu.Lock()
+		value, ok := check()
+		changed := s.changed
+		s.mu.Unlock()
+		if ok {
+			return value, nil
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return value, ctx.Err()
+		}
+	}
+}
+
+// notify wakes up waitFor.
+func (s *Session) notify() {
+	s.mu.Lock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// sequenceNumbers returns the length of each shell's output.
+func (s *Session) sequenceNumbers() *proto.SequenceNumbers {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seqs := &proto.SequenceNumbers{Map: make(map[uint32]uint64)}
+	for id, output := range s.output {
+		seqs.Map[id] = uint64(len(output))
+	}
+	return seqs
+}
+
+// attach makes send the session's connection over the named transport,
+// replacing any earlier one, and serves periodic syncs until it is dropped.
+func (s *Session) attach(transport string, send func(*proto.ServerUpdate) error) *conn {
+	c := &conn{send: send, dropped: make(chan struct{})}
+
+	s.mu.Lock()
+	old := s.conn
+	s.conn = c
+	s.connections++
+	s.transports = append(s.transports, transport)
+	s.mu.Unlock()
+	if old != nil {
+		old.drop()
+	}
+	s.notify()
+
+	if interval := s.server.opts.SyncInterval; interval > 0 {
+		go func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					update := &proto.ServerUpdate{ServerMessage: &proto.ServerUpdate_Sync{Sync: s.sequenceNumbers()}}
+					c.mu.Lock()
+					c.send(update)
+					c.mu.Unlock()
+				case <-c.dropped:
+					return
+				}
+			}
+		}()
+	}
+	return c
+}
+
+// detach clears the session's connection if it is still c.
+func (s *Session) detach(c *conn) {
+	c.drop()
+	s.mu.Lock()
+	if s.conn == c {
+		s.conn = nil
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// receive records a message from the client. Terminal data is added to the
+// shell's output like the real server does: only the part past what was
+// already received is kept, and data starting beyond it is ignored.
+func (s *Session) receive(update *proto.ClientUpdate) {
+	if created := update.GetCreatedShell(); created != nil {
+		s.mu.Lock()
+		if _, ok := s.output[created.Id]; !ok {
+			s.output[created.Id] = []byte{} // synced from zero until output arrives
+		}
+		s.mu.Unlock()
+	}
+	if data := update.GetData(); data != nil {
+		s.mu.Lock()
+		output := s.output[data.Id]
+		if s.lossy {
+			s.lost += len(data.Data)
+		} else if data.Seq <= uint64(len(output)) && data.Seq+uint64(len(data.Data)) > uint64(len(output)) {
+			s.output[data.Id] = append(output, data.Data[uint64(len(output))-data.Seq:]...)
+		}
+		s.mu.Unlock()
+		s.notify()
+	}
+	if created := update.GetCreatedShell(); created != nil {
+		s.mu.Lock()
+		s.shells = append(s.shells, created.Id)
+		s.mu.Unlock()
+		s.notify()
+	}
+	if closed := update.GetClosedShell(); closed != 0 {
+		s.mu.Lock()
+		delete(s.output, closed)
+		s.shells = slices.DeleteFunc(s.shells, func(id uint32) bool { return id == closed })
+		s.mu.Unlock()
+		s.notify()
+	}
+
+	select {
+	case s.updates <- update:
+	default:
+		// Tests that do not read updates should not stall the client
+	}
+}
+
+// grpcService implements the gRPC protocol.
+type grpcService struct {
+	proto.UnimplementedSshxServiceServer
+	server *Server
+}
+
+func (g *grpcService) Open(ctx context.Context, req *proto.OpenRequest) (*proto.OpenResponse, error) {
+	return g.server.open(req)
+}
+
+func (g *grpcService) Close(ctx context.Context, req *proto.CloseRequest) (*proto.CloseResponse, error) {
+	if err := g.server.close(req); err != nil {
+		return nil, err
+	}
+	return &proto.CloseResponse{}, nil
+}
+
+func (g *grpcService) Channel(stream grpc.BidiStreamingServer[proto.ClientUpdate, proto.ServerUpdate]) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	name, token, ok := strings.Cut(first.GetHello(), ",")
+	if !ok {
+		return status.Error(codes.InvalidArgument, "invalid first message")
+	}
+	session, err := g.server.lookup(name, token)
+	if err != nil {
+		return err
+	}
+
+	c := session.attach("grpc", stream.Send)
+	defer session.detach(c)
+
+	received := make(chan error, 1)
+	go func() {
+		for {
+			update, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+			session.receive(update)
+		}
+	}()
+
+	select {
+	case <-c.dropped:
+		return status.Error(codes.Unavailable, "connection dropped")
+	case <-received:
+		return nil
+	}
+}
+
+// serveWebSocket implements the WebSocket protocol: protobuf CliRequests
+// answered by CliResponses with the same ID, and server updates sent with
+// the ID "server_update".
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	if s.opts.DisableWebSocket {
+		http.NotFound(w, r)
+		return
+	}
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	var writeMu sync.Mutex
+	write := func(resp *proto.CliResponse) error {
+		data, err := protobuf.Marshal(resp)
+		if err != nil {
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return ws.WriteMessage(websocket.BinaryMessage, data)
+	}
+
+	var session *Session
+	var c *conn
+	defer func() {
+		if c != nil {
+			session.detach(c)
+		}
+	}()
+
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var req proto.CliRequest
+		if err := protobuf.Unmarshal(message, &req); err != nil {
+			continue
+		}
+		resp := &proto.CliResponse{Id: req.Id}
+
+		switch msg := req.CliMessage.(type) {
+		case *proto.CliRequest_OpenSession:
+			open, err := s.open(msg.OpenSession)
+			if err != nil {
+				resp.CliResponseMessage = &proto.CliResponse_Error{Error: err.Error()}
+			} else {
+				resp.CliResponseMessage = &proto.CliResponse_OpenSession{OpenSession: open}
+			}
+
+		case *proto.CliRequest_CloseSession:
+			if err := s.close(msg.CloseSession); err != nil {
+				resp.CliResponseMessage = &proto.CliResponse_Error{Error: err.Error()}
+			} else {
+				resp.CliResponseMessage = &proto.CliResponse_CloseSession{CloseSession: &proto.CloseResponse{}}
+			}
+
+		case *proto.CliRequest_StartChannel:
+			found, err := s.lookup(msg.StartChannel.Name, msg.StartChannel.Token)
+			if err != nil {
+				resp.CliResponseMessage = &proto.CliResponse_Error{Error: err.Error()}
+				break
+			}
+			if c != nil {
+				session.detach(c)
+			}
+			session = found
+			c = session.attach("websocket", func(update *proto.ServerUpdate) error {
+				resp, err := serverUpdateToCliResponse(update)
+				if err != nil {
+					return err
+				}
+				return write(resp)
+			})
+			// Closing the socket makes ReadMessage fail, ending this handler
+			dropped := c.dropped
+			go func() {
+				<-dropped
+				ws.Close()
+			}()
+			resp.CliResponseMessage = &proto.CliResponse_StartChannel{StartChannel: &proto.ChannelStartResponse{}}
+
+		default:
+			if session == nil {
+				continue
+			}
+			if update := cliRequestToClientUpdate(&req); update != nil {
+				session.receive(update)
+			}
+			continue // streamed messages get no response
+		}
+
+		if err := write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// cliRequestToClientUpdate converts a streamed WebSocket request to the
+// equivalent gRPC message, or nil if it has none.
+func cliRequestToClientUpdate(req *proto.CliRequest) *proto.ClientUpdate {
+	update := &proto.ClientUpdate{}
+	switch msg := req.CliMessage.(type) {
+	case *proto.CliRequest_TerminalData:
+		update.ClientMessage = &proto.ClientUpdate_Data{Data: msg.TerminalData}
+	case *proto.CliRequest_CreatedShell:
+		update.ClientMessage = &proto.ClientUpdate_CreatedShell{CreatedShell: msg.CreatedShell}
+	case *proto.CliRequest_ClosedShell:
+		update.ClientMessage = &proto.ClientUpdate_ClosedShell{ClosedShell: msg.ClosedShell}
+	case *proto.CliRequest_Pong:
+		update.ClientMessage = &proto.ClientUpdate_Pong{Pong: msg.Pong}
+	case *proto.CliRequest_Error:
+		update.ClientMessage = &proto.ClientUpdate_Error{Error: msg.Error}
+	default:
+		return nil
+	}
+	return update
+}
+
+// serverUpdateToCliResponse converts a gRPC server message to the equivalent
+// streamed WebSocket response.
+func serverUpdateToCliResponse(update *proto.ServerUpdate) (*proto.CliResponse, error) {
+	resp := &proto.CliResponse{Id: "server_update"}
+	switch msg := update.ServerMessage.(type) {
+	case *proto.ServerUpdate_Input:
+		resp.CliResponseMessage = &proto.CliResponse_TerminalInput{TerminalInput: msg.Input}
+	case *proto.ServerUpdate_CreateShell:
+		resp.CliResponseMessage = &proto.CliResponse_CreateShell{CreateShell: msg.CreateShell}
+	case *proto.ServerUpdate_CloseShell:
+		resp.CliResponseMessage = &proto.CliResponse_CloseShell{CloseShell: msg.CloseShell}
+	case *proto.ServerUpdate_Sync:
+		resp.CliResponseMessage = &proto.CliResponse_Sync{Sync: msg.Sync}
+	case *proto.ServerUpdate_Resize:
+		resp.CliResponseMessage = &proto.CliResponse_Resize{Resize: msg.Resize}
+	case *proto.ServerUpdate_Ping:
+		resp.CliResponseMessage = &proto.CliResponse_Ping{Ping: msg.Ping}
+	case *proto.ServerUpdate_Error:
+		resp.CliResponseMessage = &proto.CliResponse_Error{Error: msg.Error}
+	default:
+		return nil, fmt.Errorf("unsupported server message type: %T", msg)
+	}
+	return resp, nil
+}
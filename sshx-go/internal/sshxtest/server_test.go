@@ -0,0 +1,266 @@
+package sshxtest
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+
+	"sshx-go/pkg/proto"
+)
+
+// testTimeout bounds each wait of the tests.
+const testTimeout = 10 * time.Second
+
+// newTestServer starts a mock server until the test ends.
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	server, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// dialGrpc connects a gRPC client to server until the test ends.
+func dialGrpc(t *testing.T, server *Server) proto.SshxServiceClient {
+	t.Helper()
+	conn, err := grpc.NewClient(strings.TrimPrefix(server.URL, "http://"), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return proto.NewSshxServiceClient(conn)
+}
+
+// wsClient speaks the WebSocket protocol to a mock server.
+type wsClient struct {
+	t  *testing.T
+	ws *websocket.Conn
+}
+
+// dialWebSocket connects a WebSocket client to server until the test ends.
+func dialWebSocket(t *testing.T, server *Server) *wsClient {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/cli/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ws.Close() })
+	return &wsClient{t: t, ws: ws}
+}
+
+func (c *wsClient) send(req *proto.CliRequest) {
+	c.t.Helper()
+	data, err := protobuf.Marshal(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsClient) recv() (*proto.CliResponse, error) {
+	c.ws.SetReadDeadline(time.Now().Add(testTimeout))
+	_, message, err := c.ws.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	var resp proto.CliResponse
+	if err := protobuf.Unmarshal(message, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// TestReceiveOrdering checks terminal data is kept like the real server
+// keeps it: retransmitted bytes are skipped, data past a gap is ignored, and
+// sequence numbers acknowledge exactly what was kept.
+func TestReceiveOrdering(t *testing.T) {
+	server := newTestServer(t)
+	open, err := server.open(&proto.OpenRequest{Origin: server.URL, Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := server.Session(context.Background(), open.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := func(seq uint64, s string) *proto.ClientUpdate {
+		return &proto.ClientUpdate{ClientMessage: &proto.ClientUpdate_Data{
+			Data: &proto.TerminalData{Id: 1, Data: []byte(s), Seq: seq},
+		}}
+	}
+	session.receive(&proto.ClientUpdate{ClientMessage: &proto.ClientUpdate_CreatedShell{CreatedShell: &proto.NewShell{Id: 1}}})
+	if seq, ok := session.sequenceNumbers().Map[1]; !ok || seq != 0 {
+		t.Fatalf("new shell synced at %d (listed %v), want 0", seq, ok)
+	}
+
+	session.receive(data(0, "abc"))
+	session.receive(data(1, "bcde")) // Retransmitted from an older sync
+	session.receive(data(8, "xyz"))  // Past the gap left by lost data
+	session.receive(data(2, "c"))    // Already received in full
+	if got := session.Output(1); string(got) != "abcde" {
+		t.Fatalf("output %q, want %q", got, "abcde")
+	}
+	if seq := session.sequenceNumbers().Map[1]; seq != 5 {
+		t.Fatalf("synced at %d, want 5", seq)
+	}
+
+	// Every message reaches Updates in the order it was received
+	var seqs []uint64
+	for len(session.Updates()) > 0 {
+		if d := (<-session.Updates()).GetData(); d != nil {
+			seqs = append(seqs, d.Seq)
+		}
+	}
+	if want := []uint64{0, 1, 8, 2}; !slices.Equal(seqs, want) {
+		t.Fatalf("updates in order %v, want %v", seqs, want)
+	}
+
+	session.receive(&proto.ClientUpdate{ClientMessage: &proto.ClientUpdate_ClosedShell{ClosedShell: 1}})
+	if _, ok := session.sequenceNumbers().Map[1]; ok {
+		t.Fatal("closed shell still synced")
+	}
+}
+
+// TestWebSocketResponses checks requests are answered in order under their
+// own IDs, while streamed and unknown messages get no response at all.
+func TestWebSocketResponses(t *testing.T) {
+	server := newTestServer(t)
+	client := dialWebSocket(t, server)
+
+	client.send(&proto.CliRequest{Id: "1", CliMessage: &proto.CliRequest_OpenSession{
+		OpenSession: &proto.OpenRequest{Origin: server.URL, Name: "test"},
+	}})
+	resp, err := client.recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := resp.GetOpenSession()
+	if resp.Id != "1" || open == nil {
+		t.Fatalf("open answered with %v", resp)
+	}
+
+	client.send(&proto.CliRequest{Id: "2", CliMessage: &proto.CliRequest_StartChannel{
+		StartChannel: &proto.ChannelStartRequest{Name: open.Name, Token: "wrong"},
+	}})
+	client.send(&proto.CliRequest{Id: "3", CliMessage: &proto.CliRequest_StartChannel{
+		StartChannel: &proto.ChannelStartRequest{Name: open.Name, Token: open.Token},
+	}})
+	for _, want := range []string{"2", "3"} {
+		resp, err := client.recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Id != want {
+			t.Fatalf("response %q arrived when %q was due", resp.Id, want)
+		}
+		if rejected := resp.GetError() != ""; rejected != (want == "2") {
+			t.Fatalf("request %s answered with %v", want, resp)
+		}
+	}
+
+	client.send(&proto.CliRequest{Id: "4", CliMessage: &proto.CliRequest_TerminalData{
+		TerminalData: &proto.TerminalData{Id: 1, Data: []byte("hello")},
+	}})
+	client.send(&proto.CliRequest{Id: "5"}) // No message the server knows
+	client.send(&proto.CliRequest{Id: "6", CliMessage: &proto.CliRequest_CloseSession{
+		CloseSession: &proto.CloseRequest{Name: open.Name, Token: open.Token},
+	}})
+
+	resp, err = client.recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Id != "6" || resp.GetCloseSession() == nil {
+		t.Fatalf("close answered with %v", resp)
+	}
+
+	session, err := server.Session(context.Background(), open.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := session.Output(1); !bytes.Equal(got, []byte("hello")) {
+		t.Fatalf("output %q, want %q", got, "hello")
+	}
+	if !session.Closed() {
+		t.Fatal("session not closed")
+	}
+	// Closing drops the channel
+	if resp, err := client.recv(); err == nil {
+		t.Fatalf("channel still open after closing, got %v", resp)
+	}
+}
+
+// TestGrpcClose checks channels need a hello and closing a session needs its
+// token, and that closing drops the channel of the connected client.
+func TestGrpcClose(t *testing.T) {
+	server := newTestServer(t)
+	client := dialGrpc(t, server)
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	open, err := client.Open(ctx, &proto.OpenRequest{Origin: server.URL, Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	invalid, err := client.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	invalid.Send(&proto.ClientUpdate{ClientMessage: &proto.ClientUpdate_Pong{Pong: 1}})
+	if _, err := invalid.Recv(); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("channel without hello: %v, want InvalidArgument", err)
+	}
+
+	channel, err := client.Channel(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel.Send(&proto.ClientUpdate{ClientMessage: &proto.ClientUpdate_Hello{Hello: open.Name + "," + open.Token}})
+	session, err := server.Session(ctx, open.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.WaitConnections(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Close(ctx, &proto.CloseRequest{Name: open.Name, Token: "wrong"})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("close with a wrong token: %v, want Unauthenticated", err)
+	}
+	if session.Closed() {
+		t.Fatal("session closed with a wrong token")
+	}
+
+	if _, err := client.Close(ctx, &proto.CloseRequest{Name: open.Name, Token: open.Token}); err != nil {
+		t.Fatal(err)
+	}
+	if !session.Closed() {
+		t.Fatal("session not closed")
+	}
+	if _, err := channel.Recv(); status.Code(err) != codes.Unavailable {
+		t.Fatalf("channel after closing: %v, want Unavailable", err)
+	}
+	if session.Connected() {
+		t.Fatal("client still connected after closing")
+	}
+	if err := session.Send(&proto.ServerUpdate{ServerMessage: &proto.ServerUpdate_Ping{Ping: 1}}); err == nil {
+		t.Fatal("sent to a closed session")
+	}
+}
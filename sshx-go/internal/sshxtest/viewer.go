@@ -0,0 +1,196 @@
+package sshxtest
+
+import (
+	"bytes"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+
+	"sshx-go/internal/cbor"
+	"sshx-go/pkg/proto"
+)
+
+// serveViewer implements the browser protocol on /api/s/{name}: CBOR messages
+// named after the variants of the real server's WsClient and WsServer enums.
+// Only one user's view of the session is kept, so shell positions, chat and
+// the user list are left out.
+func (s *Server) serveViewer(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/api/s/")
+	s.mu.Lock()
+	session := s.sessions[name]
+	s.mu.Unlock()
+	if session == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	var writeMu sync.Mutex
+	send := func(kind string, fields any) error {
+		data, err := cbor.Marshal(map[string]any{kind: fields})
+		if err != nil {
+			return err
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return ws.WriteMessage(websocket.BinaryMessage, data)
+	}
+	read := func() (map[string]any, error) {
+		for {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				return nil, err
+			}
+			if msg, err := cbor.Unmarshal(data); err == nil {
+				if fields, ok := msg.(map[string]any); ok {
+					return fields, nil
+				}
+			}
+		}
+	}
+
+	session.mu.Lock()
+	session.lastUser++
+	userID := session.lastUser
+	session.mu.Unlock()
+	if err := send("hello", []any{userID, session.DisplayName}); err != nil {
+		return
+	}
+
+	// Authenticate like the real server: the key by its encrypted zeros, and
+	// write access by the write password's
+	msg, err := read()
+	if err != nil {
+		return
+	}
+	auth, _ := msg["authenticate"].([]any)
+	if len(auth) != 2 {
+		send("invalidAuth", []any{})
+		return
+	}
+	zeros, _ := auth[0].([]byte)
+	if !bytes.Equal(zeros, session.EncryptedZeros) {
+		send("invalidAuth", []any{})
+		return
+	}
+	canWrite := session.WritePasswordHash == nil
+	if writeZeros, ok := auth[1].([]byte); ok && session.WritePasswordHash != nil {
+		if !bytes.Equal(writeZeros, session.WritePasswordHash) {
+			send("invalidAuth", []any{})
+			return
+		}
+		canWrite = true
+	}
+	if err := send("users", []any{}); err != nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	var subscribedMu sync.Mutex
+	subscribed := make(map[uint32]uint64) // bytes of output sent of each shell
+	go func() {
+		var shells []uint32
+		for {
+			session.mu.Lock()
+			changed := session.changed
+			var newShells []uint32
+			if !slices.Equal(shells, session.shells) {
+				shells = append([]uint32(nil), session.shells...)
+				newShells = shells
+			}
+			var chunks [][]any
+			subscribedMu.Lock()
+			for id, sent := range subscribed {
+				if output := session.output[id]; uint64(len(output)) > sent {
+					chunk := append([]byte(nil), output[sent:]...)
+					chunks = append(chunks, []any{id, sent, []any{chunk}})
+					subscribed[id] = uint64(len(output))
+				}
+			}
+			subscribedMu.Unlock()
+			session.mu.Unlock()
+
+			if newShells != nil {
+				list := make([]any, len(newShells))
+				for i, id := range newShells {
+					list[i] = []any{id, map[string]any{"x": 0, "y": 0, "rows": 24, "cols": 80}}
+				}
+				if send("shells", list) != nil {
+					return
+				}
+			}
+			for _, chunk := range chunks {
+				if send("chunks", chunk) != nil {
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, err := read()
+		if err != nil {
+			return
+		}
+		var update *proto.ServerUpdate
+		for kind, fields := range msg {
+			args, _ := fields.([]any)
+			switch kind {
+			case "create":
+				session.mu.Lock()
+				session.lastShell++
+				id := session.lastShell
+				session.mu.Unlock()
+				update = &proto.ServerUpdate{ServerMessage: &proto.ServerUpdate_CreateShell{CreateShell: &proto.NewShell{Id: id}}}
+			case "close":
+				id, _ := fields.(uint64)
+				update = &proto.ServerUpdate{ServerMessage: &proto.ServerUpdate_CloseShell{CloseShell: uint32(id)}}
+			case "data":
+				if len(args) == 3 {
+					id, _ := args[0].(uint64)
+					data, _ := args[1].([]byte)
+					offset, _ := args[2].(uint64)
+					update = &proto.ServerUpdate{ServerMessage: &proto.ServerUpdate_Input{Input: &proto.TerminalInput{Id: uint32(id), Data: data, Offset: offset}}}
+				}
+			case "subscribe":
+				if len(args) == 2 {
+					id, _ := args[0].(uint64)
+					subscribedMu.Lock()
+					if _, ok := subscribed[uint32(id)]; !ok {
+						subscribed[uint32(id)] = 0
+					}
+					subscribedMu.Unlock()
+					session.notify()
+				}
+			case "ping":
+				send("pong", fields)
+			}
+		}
+
+		if update == nil {
+			continue
+		}
+		if !canWrite {
+			send("error", "you do not have write permission")
+			continue
+		}
+		if err := session.Send(update); err != nil {
+			send("error", err.Error())
+		}
+	}
+}
@@ -0,0 +1,378 @@
+// Package viewer joins sessions the way the web app does, over the server's
+// browser WebSocket protocol, so that programs can type into shells and read
+// their output like a user.
+package viewer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"sshx-go/internal/cbor"
+	"sshx-go/pkg/encrypt"
+	"sshx-go/pkg/transport"
+)
+
+// Stream numbers of the encrypted streams, as in the web app.
+const (
+	outputStream = 0x100000000
+	inputStream  = 0x200000000
+)
+
+// ErrInvalidAuth is returned by Join when the server refuses the key or write
+// password in the link.
+var ErrInvalidAuth = errors.New("invalid session key or write password")
+
+// Chunk is output of a shell the viewer is subscribed to, decrypted.
+type Chunk struct {
+	Shell uint32
+	Data  []byte
+}
+
+// Viewer is a user connected to a session.
+type Viewer struct {
+	conn    *websocket.Conn
+	encrypt *encrypt.Encrypt
+	userID  uint32
+
+	writeMu sync.Mutex
+	offset  uint64 // encryption offset of the next input
+
+	output    chan Chunk
+	pongs     chan uint64
+	ended     chan struct{} // closed when reading stops
+	closed    chan struct{} // closed by Close
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	shells  []uint32
+	changed chan struct{} // closed and replaced whenever shells change
+	err     error         // why reading stopped, or the last server error
+}
+
+// Join connects to the session of a link, authenticating with its key and,
+// for writable links, its write password.
+func Join(ctx context.Context, link string, config transport.ConnectionConfig) (*Viewer, error) {
+	endpoint, key, writePassword, err := parseLink(link)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := config.DialWebSocket(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	v := &Viewer{
+		conn:    conn,
+		encrypt: encrypt.New(key),
+		output:  make(chan Chunk, 256),
+		pongs:   make(chan uint64, 16),
+		ended:   make(chan struct{}),
+		closed:  make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+
+	if err := v.handshake(ctx, writePassword); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	go v.readLoop()
+	return v, nil
+}
+
+// parseLink returns the WebSocket endpoint of a session link, and the key
+// and write password in its fragment.
+func parseLink(link string) (endpoint, key, writePassword string, err error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid session link: %w", err)
+	}
+	prefix, name, ok := strings.Cut(u.Path, "/s/")
+	if !ok || name == "" || strings.Contains(name, "/") {
+		return "", "", "", fmt.Errorf("invalid session link %q: expected a path ending in /s/<name>", u.Redacted())
+	}
+	key, writePassword, _ = strings.Cut(u.Fragment, ",")
+	if key == "" {
+		return "", "", "", fmt.Errorf("session link has no key; links of sessions with a passphrase cannot be joined")
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	u.Path = prefix + "/api/s/" + name
+	u.RawPath = ""
+	u.Fragment = ""
+	return u.String(), key, writePassword, nil
+}
+
+// handshake waits for the server's greeting and authenticates.
+func (v *Viewer) handshake(ctx context.Context, writePassword string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		v.conn.SetReadDeadline(deadline)
+		defer v.conn.SetReadDeadline(time.Time{})
+	}
+
+	msg, err := v.read()
+	if err != nil {
+		return fmt.Errorf("failed to join session: %w", err)
+	}
+	hello, ok := msg["hello"].([]any)
+	if !ok || len(hello) < 1 {
+		return fmt.Errorf("failed to join session: unexpected greeting")
+	}
+	userID, _ := hello[0].(uint64)
+	v.userID = uint32(userID)
+
+	var writeZeros any
+	if writePassword != "" {
+		writeZeros = encrypt.New(writePassword).Zeros()
+	}
+	if err := v.send("authenticate", []any{v.encrypt.Zeros(), writeZeros}); err != nil {
+		return err
+	}
+
+	// The server lists the users once the key is accepted
+	for {
+		msg, err := v.read()
+		if err != nil {
+			return fmt.Errorf("failed to join session: %w", err)
+		}
+		if _, ok := msg["invalidAuth"]; ok {
+			return ErrInvalidAuth
+		}
+		if _, ok := msg["users"]; ok {
+			return nil
+		}
+	}
+}
+
+// UserID returns the ID the server gave this user.
+func (v *Viewer) UserID() uint32 {
+	return v.userID
+}
+
+// read receives one message, as a map from the message type to its fields.
+func (v *Viewer) read() (map[string]any, error) {
+	for {
+		kind, data, err := v.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if kind != websocket.BinaryMessage {
+			continue
+		}
+		value, err := cbor.Unmarshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid message from server: %w", err)
+		}
+		msg, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("invalid message from server: %T", value)
+		}
+		return msg, nil
+	}
+}
+
+// send sends a message of the given type.
+func (v *Viewer) send(kind string, fields any) error {
+	data, err := cbor.Marshal(map[string]any{kind: fields})
+	if err != nil {
+		return err
+	}
+	v.writeMu.Lock()
+	defer v.writeMu.Unlock()
+	if err := v.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return fmt.Errorf("failed to send %s: %w", kind, err)
+	}
+	return nil
+}
+
+// readLoop dispatches messages from the server until the connection ends.
+func (v *Viewer) readLoop() {
+	defer close(v.output)
+	defer close(v.ended)
+	for {
+		msg, err := v.read()
+		if err != nil {
+			v.mu.Lock()
+			if v.err == nil {
+				v.err = err
+			}
+			v.mu.Unlock()
+			return
+		}
+
+		switch {
+		case msg["shells"] != nil:
+			shells, _ := msg["shells"].([]any)
+			ids := make([]uint32, 0, len(shells))
+			for _, shell := range shells {
+				if fields, ok := shell.([]any); ok && len(fields) > 0 {
+					id, _ := fields[0].(uint64)
+					ids = append(ids, uint32(id))
+				}
+			}
+			v.mu.Lock()
+			v.shells = ids
+			close(v.changed)
+			v.changed = make(chan struct{})
+			v.mu.Unlock()
+
+		case msg["chunks"] != nil:
+			fields, _ := msg["chunks"].([]any)
+			if len(fields) < 3 {
+				continue
+			}
+			id, _ := fields[0].(uint64)
+			seq, _ := fields[1].(uint64)
+			chunks, _ := fields[2].([]any)
+			for _, chunk := range chunks {
+				data, _ := chunk.([]byte)
+				data = v.encrypt.SegmentInto(data, outputStream|id, seq, data)
+				seq += uint64(len(data))
+				select {
+				case v.output <- Chunk{Shell: uint32(id), Data: data}:
+				case <-v.closed:
+					return
+				}
+			}
+
+		case msg["pong"] != nil:
+			ts, _ := msg["pong"].(uint64)
+			select {
+			case v.pongs <- ts:
+			default:
+			}
+
+		case msg["error"] != nil:
+			text, _ := msg["error"].(string)
+			v.mu.Lock()
+			v.err = errors.New(text)
+			v.mu.Unlock()
+		}
+	}
+}
+
+// Output returns the channel that output of subscribed shells arrives on. It
+// is closed when the connection ends.
+func (v *Viewer) Output() <-chan Chunk {
+	return v.output
+}
+
+// Shells returns the IDs of the open shells.
+func (v *Viewer) Shells() []uint32 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]uint32(nil), v.shells...)
+}
+
+// Err returns why the connection ended, or the last error the server
+// reported, such as a refused write on a read-only link.
+func (v *Viewer) Err() error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.err
+}
+
+// CreateShell opens a new shell, as the web app's new terminal button does,
+// and returns its ID once the host has created it.
+func (v *Viewer) CreateShell(ctx context.Context) (uint32, error) {
+	v.mu.Lock()
+	before := append([]uint32(nil), v.shells...)
+	v.mu.Unlock()
+
+	if err := v.send("create", []any{0, 0}); err != nil {
+		return 0, err
+	}
+	for {
+		v.mu.Lock()
+		shells, changed := v.shells, v.changed
+		v.mu.Unlock()
+		for _, id := range shells {
+			if !containsShell(before, id) {
+				return id, nil
+			}
+		}
+
+		select {
+		case <-changed:
+		case <-v.ended:
+			return 0, fmt.Errorf("connection ended before the shell was created: %w", v.Err())
+		case <-ctx.Done():
+			if err := v.Err(); err != nil {
+				return 0, fmt.Errorf("shell was not created: %w", err)
+			}
+			return 0, fmt.Errorf("shell was not created: %w", ctx.Err())
+		}
+	}
+}
+
+func containsShell(shells []uint32, id uint32) bool {
+	for _, shell := range shells {
+		if shell == id {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseShell closes a shell.
+func (v *Viewer) CloseShell(id uint32) error {
+	return v.send("close", id)
+}
+
+// Subscribe starts sending the output of a shell, from its beginning, on the
+// Output channel.
+func (v *Viewer) Subscribe(id uint32) error {
+	return v.send("subscribe", []any{id, uint64(0)})
+}
+
+// Input types data into a shell.
+func (v *Viewer) Input(id uint32, data []byte) error {
+	v.writeMu.Lock()
+	offset := v.offset
+	v.offset += uint64(len(data))
+	v.writeMu.Unlock()
+
+	encrypted := v.encrypt.Segment(inputStream, offset, data)
+	return v.send("data", []any{id, encrypted, offset})
+}
+
+// Ping measures the round trip to the server, not including the host.
+func (v *Viewer) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	ts := uint64(start.UnixNano())
+	if err := v.send("ping", ts); err != nil {
+		return 0, err
+	}
+	for {
+		select {
+		case pong := <-v.pongs:
+			if pong == ts {
+				return time.Since(start), nil
+			}
+		case <-v.ended:
+			return 0, fmt.Errorf("connection ended: %w", v.Err())
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// Close leaves the session.
+func (v *Viewer) Close() error {
+	v.closeOnce.Do(func() { close(v.closed) })
+	v.writeMu.Lock()
+	v.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	v.writeMu.Unlock()
+	return v.conn.Close()
+}
@@ -0,0 +1,178 @@
+package viewer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"sshx-go/internal/sshxtest"
+	"sshx-go/pkg/client"
+	"sshx-go/pkg/transport"
+)
+
+// testTimeout bounds each wait of the tests on the mock server.
+const testTimeout = 30 * time.Second
+
+func TestParseLink(t *testing.T) {
+	for _, tt := range []struct {
+		link, endpoint, key, writePassword string
+	}{
+		{"https://sshx.io/s/abc#key", "wss://sshx.io/api/s/abc", "key", ""},
+		{"https://sshx.io/s/abc#key,password", "wss://sshx.io/api/s/abc", "key", "password"},
+		{"http://localhost:8051/s/abc#key", "ws://localhost:8051/api/s/abc", "key", ""},
+		{"https://example.com/sshx/s/abc?access=t#key", "wss://example.com/sshx/api/s/abc?access=t", "key", ""},
+	} {
+		endpoint, key, writePassword, err := parseLink(tt.link)
+		if err != nil {
+			t.Errorf("parseLink(%q): %v", tt.link, err)
+			continue
+		}
+		if endpoint != tt.endpoint || key != tt.key || writePassword != tt.writePassword {
+			t.Errorf("parseLink(%q) = %q, %q, %q, want %q, %q, %q", tt.link,
+				endpoint, key, writePassword, tt.endpoint, tt.key, tt.writePassword)
+		}
+	}
+
+	for _, link := range []string{"https://sshx.io/s/abc", "https://sshx.io/abc#key", "https://sshx.io/s/#key", "https://sshx.io/s/a/b#key"} {
+		if _, _, _, err := parseLink(link); err == nil {
+			t.Errorf("parseLink(%q) succeeded", link)
+		}
+	}
+}
+
+// startSession opens a session with config on a mock server, echoing what
+// is typed into its shells, and runs it until the test ends.
+func startSession(t *testing.T, config client.ControllerConfig) *client.Controller {
+	t.Helper()
+	server, err := sshxtest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	config.Origin, config.Name, config.Runner = server.URL, "test", &client.EchoRunner{}
+	controller, err := client.NewControllerWithConnection(config, transport.DefaultConnectionConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		controller.Run()
+	}()
+	t.Cleanup(func() {
+		controller.Close()
+		<-done
+	})
+	return controller
+}
+
+// join joins the session of link until the test ends.
+func join(t *testing.T, link string) *Viewer {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	v, err := Join(ctx, link, transport.DefaultConnectionConfig())
+	if err != nil {
+		t.Fatalf("failed to join the session: %v", err)
+	}
+	t.Cleanup(func() { v.Close() })
+	return v
+}
+
+// TestViewer types into a shell the viewer creates and reads back its echo.
+func TestViewer(t *testing.T) {
+	controller := startSession(t, client.ControllerConfig{})
+	echo(t, join(t, controller.URL()))
+}
+
+// TestViewerPassword checks the links of a session encrypted with a chosen
+// passphrase open it like those of a generated key.
+func TestViewerPassword(t *testing.T) {
+	controller := startSession(t, client.ControllerConfig{Password: "correct-horse", EnableReaders: true})
+	if link := controller.URL(); !strings.HasSuffix(link, "#correct-horse") {
+		t.Fatalf("link %q does not carry the passphrase", link)
+	}
+	writeURL := controller.WriteURL()
+	if writeURL == nil || !strings.Contains(*writeURL, "#correct-horse,") {
+		t.Fatalf("write link %v does not carry the passphrase", writeURL)
+	}
+	echo(t, join(t, *writeURL))
+}
+
+// echo types into a shell v creates and reads back its echo.
+func echo(t *testing.T, v *Viewer) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	id, err := v.CreateShell(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shells := v.Shells(); len(shells) != 1 || shells[0] != id {
+		t.Fatalf("shells %v, want [%d]", shells, id)
+	}
+	if err := v.Subscribe(id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inputs continue the encryption offset of the ones before
+	for _, input := range []string{"hello ", "world\r\n"} {
+		if err := v.Input(id, []byte(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var output []byte
+	for !bytes.Contains(output, []byte("hello world")) {
+		select {
+		case chunk, ok := <-v.Output():
+			if !ok {
+				t.Fatalf("connection ended: %v", v.Err())
+			}
+			if chunk.Shell != id {
+				t.Fatalf("output of shell %d, want %d", chunk.Shell, id)
+			}
+			output = append(output, chunk.Data...)
+		case <-ctx.Done():
+			t.Fatalf("got output %q, want the echo of the input", output)
+		}
+	}
+
+	if _, err := v.Ping(ctx); err != nil {
+		t.Fatalf("ping failed: %v", err)
+	}
+}
+
+// TestViewerAuth checks the server's checks of the key and write password
+// in links are honored.
+func TestViewerAuth(t *testing.T) {
+	controller := startSession(t, client.ControllerConfig{EnableReaders: true})
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+
+	base, _, _ := strings.Cut(controller.URL(), "#")
+	if _, err := Join(ctx, base+"#wrongkey", transport.DefaultConnectionConfig()); !errors.Is(err, ErrInvalidAuth) {
+		t.Fatalf("joining with a wrong key returned %v, want ErrInvalidAuth", err)
+	}
+
+	// Read-only links cannot create shells
+	reader := join(t, controller.URL())
+	short, cancelShort := context.WithTimeout(ctx, time.Second)
+	defer cancelShort()
+	if _, err := reader.CreateShell(short); err == nil || reader.Err() == nil {
+		t.Fatalf("read-only viewer created a shell, or no error was reported: %v", err)
+	}
+
+	writeURL := controller.WriteURL()
+	if writeURL == nil {
+		t.Fatal("session has no write link")
+	}
+	writer := join(t, *writeURL)
+	if _, err := writer.CreateShell(ctx); err != nil {
+		t.Fatalf("viewer with the write password could not create a shell: %v", err)
+	}
+}
@@ -1,21 +1,20 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
-	"net/http"
-	"net/url"
+	"net"
 	"os"
-	"os/signal"
-	"os/user"
+	"path/filepath"
 	"strings"
-	"syscall"
+	"time"
 
-	"sshx-go/pkg/client"
+	"sshx-go/pkg/config"
+	"sshx-go/pkg/control"
+	"sshx-go/pkg/dashboard"
 	"sshx-go/pkg/service"
+	"sshx-go/pkg/sshx"
 	"sshx-go/pkg/terminal"
 	"sshx-go/pkg/transport"
 	"sshx-go/pkg/util"
@@ -28,10 +27,264 @@ const (
 	Cyan          = "\033[36m"
 	UnderlineCyan = "\033[4;36m"
 	Fixed8        = "\033[38;5;8m" // Gray color for secondary info
+	Yellow        = "\033[33m"
+	Red           = "\033[31m"
 	Reset         = "\033[0m"
 )
 
+// hiddenFlags are development flags left out of the usage message.
+var hiddenFlags = map[string]bool{"echo": true}
+
+// printFlagDefaults prints the flags like flag.PrintDefaults, except for
+// hiddenFlags.
+func printFlagDefaults() {
+	visible := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	visible.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			visible.Var(f.Value, f.Name, f.Usage)
+			visible.Lookup(f.Name).DefValue = f.DefValue
+		}
+	})
+	visible.PrintDefaults()
+}
+
+// defaultLogMaxFiles is how many rotated log files are kept.
+const defaultLogMaxFiles = 5
+
+// defaultDashboardKeyFile is the default of --dashboard-key-file for this user.
+var defaultDashboardKeyFile string
+
+// options holds the parsed command-line flags.
+type options struct {
+	Server        string
+	Shell         string
+	Quiet         bool
+	QR            bool
+	Name          string
+	EnableReaders bool
+	Service       string
+	Verbose       bool
+	Dashboard     bool
+	Transport     string
+	Proxy         string
+	SOCKS5        string
+	Exec          string
+	AttachTmux    string
+	Docker        string
+	SSH           string
+	SSHKey        string
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	TLSServerName string
+	TLSInsecure   bool
+	GrpcTarget    string
+	GrpcAuthority string
+	WSPath        string
+	Sessions      int
+	Config        string
+	LogFormat     string
+	LogFile       string
+	LogUnsafe     bool
+	LogMaxSize    string
+	LogMaxAge     time.Duration
+	LogMaxFiles   int
+	LogCompress   bool
+
+	ReadersOnly       bool
+	WriteURLFile      string
+	WriteURLStdout    bool
+	DashboardKey      string
+	DashboardKeyFile  string
+	Tags              stringList
+	HeartbeatInterval time.Duration
+	Output            string
+	URLFile           string
+	ExitOnShellClose  sshx.ShellExitPolicy
+	IdleTimeout       time.Duration
+	BatchDelay        time.Duration
+	AllowedShells     stringList
+	RunAsUser         string
+	ServiceUser       string
+	ServiceGroup      string
+	UserService       bool
+	ServiceEnv        stringList
+	Supervise         bool
+	HealthAddr        string
+	Env               stringList
+	Cwd               string
+	Login             bool
+	RestartShells     bool
+	Resume            bool
+	KillExisting      bool
+	DumpDir           string
+	ControlSocket     string
+	WebhookURL        string
+	Version           bool
+	Upgrade           bool
+	Doctor            bool
+	Bench             bool
+	Echo              bool
+	BenchKeystrokes   int
+	BenchBytes        string
+	MaxUploadKbps     int
+	MaxShells         int
+	ShellMemoryMax    string
+	ShellCPUQuota     string
+	ScrollbackBytes   string
+	ChunkBytes        string
+	KillGrace         time.Duration
+	Password          string
+	PromptPassword    bool
+	SanitizeOutput    string
+	InvalidUTF8       string
+	Attach            bool
+	OnStart           string
+	Stream            []string // command broadcast by "sshx stream"
+	Linger            time.Duration
+}
+
+// dashboardFlag implements --dashboard, which may be given alone to create or
+// rejoin a dashboard, or as --dashboard=KEY to join a specific one.
+type dashboardFlag struct {
+	enabled *bool
+	key     *string
+}
+
+func (d dashboardFlag) String() string {
+	if d.key != nil && *d.key != "" {
+		return *d.key
+	}
+	return ""
+}
+
+func (d dashboardFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*d.enabled = true
+	case "false":
+		*d.enabled = false
+	default:
+		*d.enabled = value != ""
+		*d.key = value
+	}
+	return nil
+}
+
+func (d dashboardFlag) IsBoolFlag() bool { return true }
+
+// passwordFlag implements --password, which may be given alone to prompt for
+// the passphrase, or as --password=SECRET to set it directly.
+type passwordFlag struct {
+	prompt   *bool
+	password *string
+}
+
+func (p passwordFlag) String() string {
+	return ""
+}
+
+func (p passwordFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*p.prompt = true
+	case "false":
+		*p.prompt = false
+	default:
+		*p.prompt = false
+		*p.password = value
+	}
+	return nil
+}
+
+func (p passwordFlag) IsBoolFlag() bool { return true }
+
+// shellExitFlag implements --exit-on-shell-close, which may be given alone to
+// exit after the last shell, or as --exit-on-shell-close=first to exit when
+// the first shell opened in the session exits.
+type shellExitFlag struct {
+	policy *sshx.ShellExitPolicy
+}
+
+func (f shellExitFlag) String() string {
+	if f.policy == nil {
+		return ""
+	}
+	switch *f.policy {
+	case sshx.ShellExitLast:
+		return "last"
+	case sshx.ShellExitFirst:
+		return "first"
+	default:
+		return ""
+	}
+}
+
+func (f shellExitFlag) Set(value string) error {
+	switch value {
+	case "true", "last":
+		*f.policy = sshx.ShellExitLast
+	case "first":
+		*f.policy = sshx.ShellExitFirst
+	case "false":
+		*f.policy = sshx.ShellExitNever
+	default:
+		return fmt.Errorf("expected last or first")
+	}
+	return nil
+}
+
+func (f shellExitFlag) IsBoolFlag() bool { return true }
+
+// stringList collects the values of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func main() {
+	// Subcommands; running without one is the same as "sshx run"
+	args := os.Args[1:]
+	var serviceCommand string
+	var stream bool
+	if len(args) > 0 {
+		switch args[0] {
+		case "run":
+			args = args[1:]
+		case "stream":
+			stream, args = true, args[1:]
+		case "upgrade":
+			args = append([]string{"--upgrade"}, args[1:]...)
+		case "doctor":
+			args = append([]string{"--doctor"}, args[1:]...)
+		case "bench":
+			args = append([]string{"--bench"}, args[1:]...)
+		case "service":
+			if len(args) < 2 || strings.HasPrefix(args[1], "-") {
+				fmt.Fprintln(os.Stderr, "Usage: sshx service <install|uninstall|status|start|stop> [flags]")
+				os.Exit(2)
+			}
+			serviceCommand, args = args[1], args[2:]
+		case "ctl":
+			// Administers a running process instead of starting one
+			if err := runCtl(args[1:]); err != nil {
+				fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "version":
+			printVersion()
+			return
+		}
+	}
+
 	// Get default values from environment variables - matches Rust implementation
 	defaultServer := os.Getenv("SSHX_SERVER")
 	if defaultServer == "" {
@@ -40,58 +293,310 @@ func main() {
 
 	defaultVerbose := os.Getenv("SSHX_VERBOSE") != ""
 
-	var (
-		server        = flag.String("server", defaultServer, "Address of the remote sshx server")
-		shell         = flag.String("shell", "", "Local shell command to run in the terminal")
-		quiet         = flag.Bool("quiet", false, "Quiet mode, only prints the URL to stdout")
-		name          = flag.String("name", "", "Session name displayed in the title (defaults to user@hostname)")
-		enableReaders = flag.Bool("enable-readers", false, "Enable read-only access mode - generates separate URLs for viewers and editors")
-		serviceCmd    = flag.String("service", "", "Service management (install|uninstall|status|start|stop)")
-		verbose       = flag.Bool("verbose", defaultVerbose, "Enable verbose output showing connection details and fallback attempts")
-		dashboard     = flag.String("dashboard", "", "Register with dashboard. Optional KEY to join existing dashboard (use empty string for new dashboard)")
-	)
+	defaultLogFormat := os.Getenv("SSHX_LOG_FORMAT")
+	if defaultLogFormat == "" {
+		defaultLogFormat = "text"
+	}
+
+	defaultWebhookURL := os.Getenv("SSHX_WEBHOOK_URL")
+
+	defaultTransport := os.Getenv("SSHX_TRANSPORT")
+	if defaultTransport == "" {
+		defaultTransport = "auto"
+	}
+
+	defaultDashboardKeyFile = config.DefaultDashboardKeyPath()
+
+	var opts options
+	flag.StringVar(&opts.Server, "server", defaultServer, "Address of the remote sshx server, or a comma-separated list of servers of one deployment to fail over between")
+	flag.StringVar(&opts.Shell, "shell", "", "Local shell command to run in the terminal, with optional arguments (e.g. \"/bin/zsh -l\")")
+	flag.BoolVar(&opts.Login, "login", false, "Start the shell as a login shell (-l), so profiles are sourced")
+	flag.BoolVar(&opts.RestartShells, "restart-shells", false, "Restart a shell in the same pane when it exits instead of closing the pane, e.g. for kiosk displays")
+	flag.BoolVar(&opts.Resume, "resume", false, "Keep sessions open when sshx is stopped and reattach to them on the next run with --resume, keeping their links (e.g. across crashes and reboots)")
+	flag.BoolVar(&opts.KillExisting, "kill-existing", false, "Close the sessions an earlier run with --kill-existing left open (e.g. after a crash) before opening new ones")
+	flag.BoolVar(&opts.Attach, "attach", false, "Open a shell right away and use it from this terminal too; sshx exits when it does")
+	flag.BoolVar(&opts.Quiet, "quiet", false, "Quiet mode, only prints the URL to stdout")
+	flag.BoolVar(&opts.QR, "qr", false, "Also show the read-only link as a QR code in the greeting, to open it on a phone")
+	flag.StringVar(&opts.Output, "output", "text", "Output format for session links: text or json (one object per session with name, URLs, dashboard and transport, or per result of sshx bench)")
+	flag.StringVar(&opts.URLFile, "url-file", "", "Also write the session links as JSON to this file, one object per line")
+	flag.StringVar(&opts.Name, "name", "", "Session name displayed in the title (defaults to user@hostname)")
+	flag.BoolVar(&opts.EnableReaders, "enable-readers", false, "Enable read-only access mode - generates separate URLs for viewers and editors")
+	flag.Var(passwordFlag{&opts.PromptPassword, &opts.Password}, "password", "Encrypt sessions with your own passphrase instead of a generated key; give --password alone to be prompted (also SSHX_PASSWORD)")
+	flag.StringVar(&opts.SanitizeOutput, "sanitize-output", "off", "Remove escape sequences from output before viewers' terminals act on them: off, clipboard (OSC 52 clipboard access), or strict (also titles, hyperlinks and device control strings)")
+	flag.StringVar(&opts.InvalidUTF8, "invalid-utf8", "drop", "Handle output that is not valid UTF-8, such as ISO-8859 text or zmodem: drop, replace (with U+FFFD, keeping text aligned), or passthrough")
+	flag.DurationVar(&opts.Linger, "linger", defaultStreamLinger, "With sshx stream, keep showing the output this long after the command exits")
+	flag.BoolVar(&opts.ReadersOnly, "readers-only", false, "Like --enable-readers, but never print the writable link (e.g. when presenting)")
+	flag.StringVar(&opts.WriteURLFile, "write-url-file", "", "Write the writable link to this file instead of printing it (implies --readers-only)")
+	flag.BoolVar(&opts.WriteURLStdout, "write-url-stdout", true, "Print the writable link; with --write-url-stdout=false it only goes to --url-file, the control socket (sshx ctl urls) and webhooks, keeping it out of scrollback and the journal (implies --enable-readers)")
+	flag.StringVar(&opts.Cwd, "cwd", "", "Working directory for spawned shells (defaults to the current directory)")
+	flag.Var(&opts.Env, "env", "Set KEY=VALUE in the environment of every spawned shell (repeatable)")
+	flag.Var(&opts.AllowedShells, "allowed-shell", "Only allow this shell or --exec program to be spawned (repeatable); other sessions fail to start")
+	flag.StringVar(&opts.RunAsUser, "run-as-user", "", "Start shells as this unprivileged user instead of the current one (requires root)")
+	flag.Var(controlSocketFlag{&opts.ControlSocket}, "control-socket", "Serve the local control API used by 'sshx ctl' on "+control.DefaultSocketPath()+", or --control-socket=PATH")
+	flag.IntVar(&opts.MaxUploadKbps, "max-upload-kbps", 0, "Limit terminal output sent to the server to this many kilobits per second, across all sessions (0 disables); excess output is delayed, not dropped")
+	flag.IntVar(&opts.MaxShells, "max-shells", 0, "Refuse to open more than this many shells at once in each session (0 disables), to protect small hosts")
+	flag.StringVar(&opts.ShellMemoryMax, "shell-memory-max", "", "Cap the memory of each shell and its children, e.g. 512M or 2G (uses a systemd scope, else an address space rlimit)")
+	flag.StringVar(&opts.ShellCPUQuota, "shell-cpu-quota", "", "Cap the CPU time of each shell and its children, in percent of one CPU, e.g. 50% (requires systemd)")
+	flag.StringVar(&opts.WebhookURL, "webhook-url", defaultWebhookURL, "POST JSON to this URL when sessions start (with their links), shells open, connections drop and recover, and sessions close, e.g. a Slack or Teams incoming webhook (also SSHX_WEBHOOK_URL)")
+	flag.StringVar(&opts.DumpDir, "dump-dir", "", "On SIGUSR1, write the recent output of every shell to a file in this directory")
+	flag.StringVar(&opts.Service, "service", "", "Service management (install|uninstall|status|start|stop)")
+	flag.BoolVar(&opts.UserService, "user", false, "With --service, manage a per-user systemd service (no sudo needed) instead of the system one")
+	flag.Var(&opts.ServiceEnv, "service-env", "With --service install, set NAME=VALUE, or pass NAME's current value, in the service environment (repeatable)")
+	flag.StringVar(&opts.ServiceUser, "service-user", "", "User the installed service runs as (default root)")
+	flag.StringVar(&opts.ServiceGroup, "service-group", "", "Group the installed service runs as (default the user's primary group)")
+	flag.BoolVar(&opts.Version, "version", false, "Print the version and exit")
+	flag.BoolVar(&opts.Upgrade, "upgrade", false, "Replace this binary with the latest verified release and restart the installed service, then exit")
+	flag.BoolVar(&opts.Doctor, "doctor", false, "Check DNS, proxy, TLS, gRPC and WebSocket connectivity to the server, PTY support and clock skew, then exit")
+	flag.BoolVar(&opts.Bench, "bench", false, "Measure keystroke latency and output throughput to the server over each transport with an echo session, then exit")
+	flag.IntVar(&opts.BenchKeystrokes, "bench-keystrokes", defaultBenchKeystrokes, "Keystrokes, and pings, timed by --bench")
+	flag.BoolVar(&opts.Echo, "echo", false, "Echo input back instead of running shells, to test transports and servers without spawning processes")
+	flag.StringVar(&opts.BenchBytes, "bench-bytes", defaultBenchBytes, "Output echoed by --bench to measure throughput, e.g. 512K or 16M")
+	flag.BoolVar(&opts.Verbose, "verbose", defaultVerbose, "Enable verbose output showing connection details and fallback attempts")
+	flag.Var(dashboardFlag{&opts.Dashboard, &opts.DashboardKey}, "dashboard", "Register with dashboard. Use --dashboard=KEY to join an existing dashboard")
+	flag.StringVar(&opts.DashboardKey, "dashboard-key", "", "Key of an existing dashboard to join (implies --dashboard)")
+	flag.StringVar(&opts.DashboardKeyFile, "dashboard-key-file", defaultDashboardKeyFile, "File that stores the key of a dashboard created by --dashboard, reused on later runs (empty to disable)")
+	flag.DurationVar(&opts.HeartbeatInterval, "dashboard-heartbeat", dashboard.DefaultHeartbeatInterval, "How often to report uptime, shell count and transport to the dashboard, which marks sessions that stop reporting as stale (0 disables)")
+	flag.Var(&opts.Tags, "tag", "Send KEY=VALUE with --dashboard registrations so dashboards can filter and group hosts (repeatable); hostname, os, arch and version are added automatically")
+	flag.StringVar(&opts.Transport, "transport", defaultTransport, "Transport to use: grpc, websocket, or auto (gRPC with WebSocket fallback)")
+	flag.StringVar(&opts.Proxy, "proxy", "", "HTTP(S) proxy URL for server connections, overriding HTTP_PROXY/HTTPS_PROXY (use 'none' to disable)")
+	flag.StringVar(&opts.SOCKS5, "socks5", "", "SOCKS5 proxy address (host:port or user:pass@host:port) for server connections")
+	flag.StringVar(&opts.Exec, "exec", "", "Run a command with arguments in each pane instead of an interactive shell (pane closes when it exits)")
+	flag.StringVar(&opts.Docker, "docker", "", "Run each pane's shell, or --exec command, inside this running Docker container (uses DOCKER_HOST)")
+	flag.StringVar(&opts.SSH, "ssh", "", "Open each pane's shell, or --exec command, on this host over SSH ([user@]host[:port]; uses the SSH agent and known_hosts)")
+	flag.StringVar(&opts.SSHKey, "ssh-key", "", "Private key for --ssh, in addition to the SSH agent (default ~/.ssh/id_ed25519, id_ecdsa or id_rsa)")
+	flag.StringVar(&opts.OnStart, "on-start", "", "Type this command into the first shell once it starts, e.g. 'cd /var/log && tail -f syslog'")
+	flag.StringVar(&opts.AttachTmux, "attach-tmux", "", "Attach each pane to this existing tmux session instead of starting a shell (e.g. work, or work:2 for a window)")
+	flag.StringVar(&opts.TLSCert, "tls-cert", "", "PEM client certificate for servers requiring mutual TLS")
+	flag.StringVar(&opts.TLSKey, "tls-key", "", "PEM private key for --tls-cert")
+	flag.StringVar(&opts.TLSCA, "tls-ca", "", "PEM CA bundle used to verify the server instead of the system roots")
+	flag.StringVar(&opts.TLSServerName, "tls-server-name", "", "Override the TLS server name (SNI) used to verify the server")
+	flag.BoolVar(&opts.TLSInsecure, "tls-insecure", false, "Skip TLS certificate verification (self-signed test servers only)")
+	flag.StringVar(&opts.GrpcTarget, "grpc-target", "", "host:port to dial for gRPC instead of the --server host on port 443 (8051 for localhost)")
+	flag.StringVar(&opts.WSPath, "ws-path-template", "", "Path of the WebSocket endpoint with {name} for the session, e.g. /sshx/api/cli/{name} (default /api/cli/{name} below the --server path)")
+	flag.StringVar(&opts.GrpcAuthority, "grpc-authority", "", "Override the :authority of gRPC requests, also used as the TLS server name unless --tls-server-name is set")
+	flag.IntVar(&opts.Sessions, "sessions", 1, "Number of sessions to open in this process (ignored when the config file lists sessions)")
+	flag.StringVar(&opts.Config, "config", "", "Path to the JSON config file (default ~/.config/sshx/config.json)")
+	flag.Var(shellExitFlag{&opts.ExitOnShellClose}, "exit-on-shell-close", "Close the session and exit once the last shell exits; use --exit-on-shell-close=first to exit when the first shell exits")
+	flag.DurationVar(&opts.IdleTimeout, "idle-timeout", 0, "Close the session and exit after this long without terminal input or output, e.g. 30m (0 disables)")
+	flag.DurationVar(&opts.BatchDelay, "batch-delay", 0, "Hold terminal output back up to this long to send small writes together, e.g. 10ms on high-latency links (0 disables)")
+	flag.StringVar(&opts.ScrollbackBytes, "scrollback-bytes", "", "Recent output each shell keeps to replay to users joining late, e.g. 1M or 64M (default 8M)")
+	flag.StringVar(&opts.ChunkBytes, "chunk-bytes", "", "Most terminal output sent in one message, e.g. 16K (default 64K)")
+	flag.DurationVar(&opts.KillGrace, "kill-grace", terminal.DefaultKillGrace, "Time a closing shell and the programs it started get to exit after each of SIGTERM, SIGHUP and SIGKILL")
+	flag.BoolVar(&opts.Supervise, "supervise", false, "Container mode: reopen sessions after fatal errors with backoff, serve --health-addr, and log JSON by default")
+	flag.StringVar(&opts.HealthAddr, "health-addr", ":8080", "Address serving the /healthz liveness endpoint with --supervise (empty to disable)")
+	flag.StringVar(&opts.LogFormat, "log-format", defaultLogFormat, "Log output format: text or json (one structured record per line)")
+	flag.StringVar(&opts.LogFile, "log-file", "", "Append logs to this file instead of stderr")
+	flag.StringVar(&opts.LogMaxSize, "log-max-size", "", "Rotate --log-file before it grows past this size, e.g. 100M")
+	flag.DurationVar(&opts.LogMaxAge, "log-max-age", 0, "Rotate --log-file after writing to it for this long, e.g. 24h")
+	flag.IntVar(&opts.LogMaxFiles, "log-max-files", defaultLogMaxFiles, "Rotated log files to keep, removing the oldest (0 keeps all)")
+	flag.BoolVar(&opts.LogCompress, "log-compress", false, "Compress rotated log files with gzip")
+	flag.BoolVar(&opts.LogUnsafe, "log-unsafe", false, "Log tokens, keys and terminal data instead of redacting them (only for debugging; never share such logs)")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `A secure web-based, collaborative terminal.
 
+Commands:
+  sshx [run] [flags]   Share a terminal (the default)
+  sshx stream [flags] <command> [args...]
+                       Broadcast a command's output read-only, without input
+  sshx service <install|uninstall|status|start|stop> [flags]
+                       Manage the system service, like --service
+  sshx ctl <command>   Administer a running sshx, see sshx ctl --help
+  sshx upgrade         Upgrade to the latest release, like --upgrade
+  sshx doctor [flags]  Diagnose why sessions fail to connect, like --doctor
+  sshx bench [flags]   Measure latency and throughput to the server, like --bench
+  sshx version         Print the version and exit
+
 Connection:
   Automatically tries gRPC first, then WebSocket fallback for compatibility
-  with proxies and firewalls (e.g., Cloudflare tunnels).
+  with proxies and firewalls (e.g., Cloudflare tunnels). Use --transport to
+  force a single transport and skip the gRPC probe. If sessions fail to
+  connect, "sshx doctor" with the same flags reports what is wrong.
 
-Service Management:
-  --service install    Install and enable systemd service with current configuration
-  --service uninstall  Remove systemd service and binary
+Service Management (or "sshx service install", etc.):
+  --service install    Install and enable the system service (systemd or launchd)
+  --service uninstall  Remove the system service and binary
   --service status     Check service status
   --service start      Start service
   --service stop       Stop service
+  --user               With any of the above, manage a per-user systemd
+                       service in ~/.config/systemd/user instead
+
+Multiple Sessions:
+  --sessions N opens N copies of the session. A config file can instead list
+  sessions with their own name, server, shell, exec, cwd, enableReaders,
+  dashboard and tags settings:
+    {"sessions": [{"name": "web", "shell": "/bin/bash"},
+                  {"name": "logs", "exec": "tail -f /var/log/syslog"}]}
+
+Notifications:
+  --webhook-url posts every event as JSON. The config file can also send
+  messages to Slack and Matrix, showing read-only links only unless
+  includeWriteUrl is set, with an optional Go template (the Matrix token may
+  come from SSHX_MATRIX_TOKEN):
+    {"notifiers": [{"type": "slack", "url": "https://hooks.slack.com/..."},
+                   {"type": "matrix", "homeserver": "https://matrix.org",
+                    "room": "!abc:matrix.org", "template": "{{.Session}}: {{.URL}}"}]}
 
 Examples:
   sshx --server https://your-server.com --dashboard --service install
+                       The new dashboard's key is saved and reused on restart
+  sshx --dashboard --tag env=prod --tag role=db --service install
+                       Group this host with others by environment and role
   sshx --shell /bin/bash --name server1 --service install
+  sshx --service-env HTTPS_PROXY --service-env SSHX_TRANSPORT=websocket --service install
+                       Embed variables in the service; more go in /etc/sshx/env
+  sshx --service-user sshx --service-group sshx --service install
+                       Run the service as an unprivileged account
   sshx --verbose       Show connection method and detailed debugging info
+  sshx --exec "htop -d 5"      Share a program instead of an interactive shell
+  sshx --attach-tmux work      Share the panes of a running tmux session
+  sshx --docker web --cwd /app Share a shell inside a running container
+  sshx --ssh deploy@db1        Share a shell on another host from a bastion
+  sshx --cwd ~/project         Start shells in a project directory
+  sshx --login                 Start login shells that source your profile
+  sshx --shell "/bin/zsh -l"   Pass arguments to the shell
+  sshx --transport websocket   Connect via WebSocket only (e.g., behind Cloudflare)
+  sshx --proxy http://proxy.corp:3128   Connect through an HTTP proxy
+  sshx --socks5 127.0.0.1:1080          Connect through a SOCKS5 proxy
+  sshx --tls-cert client.pem --tls-key client.key --tls-ca ca.pem
+                       Connect to a self-hosted server behind mutual TLS
+  sshx --tls-ca dev-ca.pem --tls-server-name sshx.internal
+                       Trust a private CA for both gRPC and WebSocket
+  sshx --server https://sshx.example.com --grpc-target grpc.example.com:8443
+                       Reach gRPC served on another host or port
+  sshx --server https://proxy.corp/sshx --transport websocket
+                       Connect to a server mounted under a subpath
+  sshx --server https://sshx-a.example.com,https://sshx-b.example.com
+                       Move to the second frontend when the first goes down
+  sshx doctor --proxy http://proxy.corp:3128
+                       Find out why sessions fail to connect through a proxy
+  sshx bench --server https://sshx-a.example.com,https://sshx-b.example.com
+                       Compare the latency and throughput of two servers
+  sshx --max-upload-kbps 256
+                       Keep bursts of output from saturating a metered uplink
+  sshx --max-shells 4  Keep a small host from running out of PTYs
+  sshx --batch-delay 10ms
+                       Send fewer, larger messages over a satellite link
+  sshx --scrollback-bytes 1M
+                       Keep less output per shell on a memory-constrained host
+  sshx --shell-memory-max 1G --shell-cpu-quota 50%%
+                       Keep a collaborative sandbox from exhausting the host
+  sshx --on-start htop --service install
+                       Greet collaborators with a running htop
+  sshx --exec htop --restart-shells
+                       Keep a kiosk pane running htop even if it is quit
+  sshx --resume --service install
+                       Keep the same links after the host reboots
+  sshx --kill-existing --service install
+                       Close the session a crashed run left behind on restart
+  sshx --attach        Work in the shared shell from this terminal while
+                       collaborators join from the browser
+  sshx --enable-readers --qr
+                       Let people in the room scan the read-only link
+  sshx --readers-only --write-url-file ~/.sshx-write-url
+                       Show only the read-only link, e.g. on a projector
+  sshx --write-url-stdout=false --control-socket --service install
+                       Keep the writable link out of the journal; get it
+                       with sudo sshx ctl urls
+  sshx --password      Prompt for a passphrase to use instead of a generated
+                       key; a short one makes shorter links
+  sshx --sanitize-output clipboard
+                       Keep programs in the session from writing viewers' clipboards
+  sshx --invalid-utf8 replace
+                       Show bytes of legacy ISO-8859 output as placeholders instead of dropping them
+  sshx --run-as-user sshx --allowed-shell /bin/bash --service install
+                       Give viewers an unprivileged shell from a root service
+  sshx --env PS1='(shared) \$ ' --env HTTPS_PROXY=http://proxy.corp:3128
+                       Customize the environment of shared shells
+                       Shells also get SSHX_URL, SSHX_WRITE_URL and SSHX_SESSION_NAME
+  sshx --dump-dir ~/sshx-dumps   then   kill -USR1 <pid>
+                       Save what collaborators saw in each shell to files
+  sshx --webhook-url https://hooks.slack.com/services/...
+                       Post each session's link to a chat channel
+  sshx --control-socket   then   sshx ctl shells
+                       Inspect and administer a running process locally
+  sshx stream --linger 1m make test
+                       Broadcast a build's output read-only; exits with its status
+  sshx --exit-on-shell-close --output json
+                       Debug a CI job; the job continues once the shell exits
+  sshx --idle-timeout 30m --service install
+                       Shut forgotten sessions down after 30 idle minutes
+  sshx --output json | jq -r .url
+                       Read session links from a script or CI job
+  sshx --supervise --health-addr :8080
+                       Run in a container: restart on errors, probe /healthz
+  sshx --log-format json --service install
+                       Emit machine-parseable logs for journald or ELK
+  sshx --log-file /var/log/sshx.log --log-max-size 100M --log-compress --service install
+                       Keep the logs of a long-running service from filling the disk
+
+Updates:
+  Interactive runs check GitHub for a newer release and print a notice. Set
+  SSHX_NO_UPDATE_CHECK=1 or "updateCheck": false in the config file to opt out.
 
 Usage:
 `)
-		flag.PrintDefaults()
+		printFlagDefaults()
+	}
+
+	flag.CommandLine.Parse(args)
+	if serviceCommand != "" {
+		opts.Service = serviceCommand
+	}
+	if opts.Version {
+		printVersion()
+		return
 	}
 
-	flag.Parse()
+	// Accept the older "--dashboard KEY" form, then keep parsing flags after it
+	if opts.Dashboard && opts.DashboardKey == "" && flag.NArg() > 0 && !strings.HasPrefix(flag.Arg(0), "-") {
+		opts.DashboardKey = flag.Arg(0)
+		flag.CommandLine.Parse(flag.Args()[1:])
+	}
+	if opts.DashboardKey != "" {
+		opts.Dashboard = true
+	}
+
+	if stream {
+		if flag.NArg() == 0 {
+			fmt.Fprintln(os.Stderr, "Usage: sshx stream [flags] <command> [args...]")
+			os.Exit(2)
+		}
+		// Viewers only ever watch; nobody may type into the command
+		opts.Stream = flag.Args()
+		opts.ReadersOnly = true
+	}
 
-	if err := runSshx(*server, *shell, *quiet, *name, *enableReaders, *serviceCmd, *dashboard, *verbose); err != nil {
+	if opts.WriteURLFile != "" {
+		opts.ReadersOnly = true
+	}
+	if opts.Supervise && !flagSet("log-format") && os.Getenv("SSHX_LOG_FORMAT") == "" {
+		opts.LogFormat = "json"
+	}
+	if opts.ReadersOnly || !opts.WriteURLStdout {
+		opts.EnableReaders = true
+	}
+
+	if err := runSshx(opts); err != nil {
 		// Provide user-friendly error messages - matches Rust implementation
 		errorMsg := err.Error()
-		if strings.Contains(errorMsg, "Both gRPC and WebSocket connections failed") {
+		var exitStatus *exitStatusError
+		if errors.As(err, &exitStatus) {
+			fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+			os.Exit(exitStatus.code)
+		} else if strings.Contains(errorMsg, "Both gRPC and WebSocket connections failed") {
 			fmt.Fprintf(os.Stderr, "❌ Unable to connect to the sshx server.\n")
 			fmt.Fprintf(os.Stderr, "   Please check:\n")
-			fmt.Fprintf(os.Stderr, "   • Server URL is correct: %s\n", *server)
+			fmt.Fprintf(os.Stderr, "   • Server URL is correct: %s\n", opts.Server)
 			fmt.Fprintf(os.Stderr, "   • Network connectivity is available\n")
 			fmt.Fprintf(os.Stderr, "   • Server is running and accessible\n")
-			if !*verbose {
+			if !opts.Verbose {
 				fmt.Fprintf(os.Stderr, "   Use --verbose for detailed connection diagnostics\n")
 			}
 		} else if strings.Contains(errorMsg, "gRPC") && strings.Contains(errorMsg, "WebSocket") {
 			fmt.Fprintf(os.Stderr, "❌ Connection failed: %v\n", err)
-			if !*verbose {
+			if !opts.Verbose {
 				fmt.Fprintf(os.Stderr, "   Try again with --verbose for detailed diagnostics\n")
 			}
 		} else {
@@ -101,251 +606,385 @@ Usage:
 	}
 }
 
-func runSshx(server, shell string, quiet bool, name string, enableReaders bool, serviceCmd string, dashboard string, verbose bool) error {
-	// Initialize logger with verbose mode
-	util.InitLogger(verbose)
+// flagSet reports whether the named flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
 
-	// Handle service commands if present
-	if serviceCmd != "" {
-		return handleServiceCommand(serviceCmd, server, dashboard != "", enableReaders, name, shell)
+// logRotation returns the rotation of --log-file set by the --log-max-*
+// and --log-compress flags.
+func logRotation(opts options) (util.RotateOptions, error) {
+	rotate := util.RotateOptions{
+		MaxAge:   opts.LogMaxAge,
+		MaxFiles: opts.LogMaxFiles,
+		Compress: opts.LogCompress,
+	}
+	if opts.LogMaxSize != "" {
+		size, err := terminal.ParseMemorySize(opts.LogMaxSize)
+		if err != nil {
+			return rotate, fmt.Errorf("invalid --log-max-size %q (expected a size, e.g. 100M)", opts.LogMaxSize)
+		}
+		rotate.MaxSize = size
+	}
+	if opts.LogMaxAge < 0 {
+		return rotate, fmt.Errorf("invalid --log-max-age %s (must not be negative)", opts.LogMaxAge)
+	}
+	if opts.LogMaxFiles < 0 {
+		return rotate, fmt.Errorf("invalid --log-max-files %d (must not be negative)", opts.LogMaxFiles)
+	}
+	// journald rotates its own files, see SystemMaxUse= in journald.conf
+	if opts.LogFile == "" && (rotate.MaxSize > 0 || rotate.MaxAge > 0) {
+		return rotate, fmt.Errorf("--log-max-size and --log-max-age rotate --log-file; logs on stderr or in the journal are rotated by their collector")
+	}
+	return rotate, nil
+}
+
+func runSshx(opts options) error {
+	rotate, err := logRotation(opts)
+	if err != nil {
+		return err
 	}
 
-	// Get shell command
-	shellCmd := shell
-	if shellCmd == "" {
-		shellCmd = terminal.GetDefaultShell()
+	// Initialize logger with verbose mode and output format
+	if err := util.ConfigureLogger(util.LogOptions{
+		Verbose: opts.Verbose,
+		Format:  opts.LogFormat,
+		File:    opts.LogFile,
+		Rotate:  rotate,
+		Unsafe:  opts.LogUnsafe,
+	}); err != nil {
+		return err
 	}
 
-	// Get session name
-	sessionName := name
-	if sessionName == "" {
-		sessionName = getDefaultSessionName()
+	preference, err := transport.ParseTransportPreference(opts.Transport)
+	if err != nil {
+		return err
 	}
 
-	// Create runner
-	runner := &client.ShellRunner{Shell: shellCmd}
+	for _, kv := range opts.Env {
+		if name, _, ok := strings.Cut(kv, "="); !ok || name == "" {
+			return fmt.Errorf("invalid --env %q (expected KEY=VALUE)", kv)
+		}
+	}
 
-	// Create controller config
-	config := client.ControllerConfig{
-		Origin:        server,
-		Name:          sessionName,
-		Runner:        runner,
-		EnableReaders: enableReaders,
+	if opts.Output != "text" && opts.Output != "json" {
+		return fmt.Errorf("invalid output format %q (expected text or json)", opts.Output)
+	}
+	if opts.QR && (opts.Quiet || opts.Output == "json") {
+		return fmt.Errorf("--qr is shown in the greeting and cannot be used with --quiet or --output json")
 	}
 
-	// Create connection configuration
-	connConfig := transport.DefaultConnectionConfig()
-	if verbose {
-		connConfig = transport.VerboseConfig()
+	if _, err := sshx.ParseSanitizePolicy(opts.SanitizeOutput); err != nil {
+		return err
+	}
+	if _, err := sshx.ParseInvalidUTF8Policy(opts.InvalidUTF8); err != nil {
+		return err
 	}
 
-	// Create controller using transport abstraction with automatic fallback
-	controller, err := client.NewControllerWithConnection(config, connConfig)
-	if err != nil {
-		return fmt.Errorf("failed to create controller with transport: %w", err)
+	if opts.Upgrade {
+		return runUpgrade(opts, preference)
 	}
 
-	// Report connection method if verbose
-	if verbose {
-		switch controller.ConnectionMethod() {
-		case transport.MethodGrpc:
-			log.Printf("✓ Connected via gRPC")
-		case transport.MethodWebSocketFallback:
-			log.Printf("✓ Connected via WebSocket fallback")
+	// Handle service commands if present
+	if opts.Service != "" {
+		if opts.Stream != nil || opts.Attach {
+			return fmt.Errorf("sshx stream and --attach cannot be installed as a service")
 		}
+		return handleServiceCommand(opts, preference)
 	}
 
-	// Register with dashboard if requested
-	var dashboardInfo *DashboardInfo
-	if dashboard != "" {
-		// Use provided dashboard key
-		var dashboardKey *string = &dashboard
-		if info, err := registerWithDashboard(server, controller, sessionName, dashboardKey); err != nil {
-			log.Printf("Dashboard registration failed: %v", err)
-		} else {
-			dashboardInfo = info
-		}
+	connConfig, err := connectionConfig(opts, preference)
+	if err != nil {
+		return err
 	}
 
-	// Print greeting or URL
-	if quiet {
-		if writeURL := controller.WriteURL(); writeURL != nil {
-			fmt.Println(*writeURL)
-		} else {
-			fmt.Println(controller.URL())
+	if opts.Doctor {
+		return runDoctor(opts, connConfig)
+	}
+	if opts.Bench {
+		return runBench(opts, connConfig)
+	}
+
+	if opts.Password, err = sessionPassword(opts); err != nil {
+		return err
+	}
+
+	if opts.Attach {
+		if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+			return fmt.Errorf("--attach needs a terminal to attach to")
 		}
+		if opts.Stream != nil || opts.Supervise {
+			return fmt.Errorf("--attach cannot be combined with --supervise or sshx stream")
+		}
+	}
+
+	// Load sessions from the config file, if any
+	var file *config.File
+	if opts.Config != "" {
+		file, err = config.Load(opts.Config)
 	} else {
-		printGreeting(shellCmd, controller, controller.ConnectionMethod(), dashboardInfo)
+		file, err = config.LoadDefault()
+	}
+	if err != nil {
+		return err
 	}
 
-	// Set up signal handling
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	sessionOpts, err := sessionOptions(opts, file, connConfig)
+	if err != nil {
+		return err
+	}
+	targets, err := notifyTargets(opts, file)
+	if err != nil {
+		return err
+	}
+	if !opts.WriteURLStdout && !opts.ReadersOnly && !writeURLDelivered(opts, file) {
+		return fmt.Errorf("--write-url-stdout=false leaves the writable link nowhere; also give --url-file, --control-socket, --webhook-url or a notifier with includeWriteUrl")
+	}
 
-	// Run controller in background
-	done := make(chan error, 1)
-	go func() {
-		done <- controller.Run()
-	}()
+	if opts.Attach && len(sessionOpts) != 1 {
+		return fmt.Errorf("--attach shares a single session; remove --sessions and sessions in the config file")
+	}
 
-	// Wait for completion or signal
-	select {
-	case <-sigChan:
-		log.Println("Received interrupt, shutting down...")
-	case err := <-done:
-		if err != nil {
-			return fmt.Errorf("controller error: %w", err)
+	var streamExit <-chan error
+	if opts.Stream != nil {
+		if opts.Supervise {
+			return fmt.Errorf("sshx stream cannot be combined with --supervise")
+		}
+		if sessionOpts, streamExit, err = streamSessions(opts, sessionOpts); err != nil {
+			return err
 		}
 	}
 
-	// Graceful shutdown
-	return controller.Close()
-}
+	if opts.Supervise {
+		return superviseSessions(opts, sessionOpts, targets)
+	}
 
-func handleServiceCommand(serviceCmd, server string, dashboard, enableReaders bool, name, shell string) error {
-	config := service.ServiceConfig{
-		Server:        server,
-		Dashboard:     dashboard,
-		EnableReaders: enableReaders,
+	// Print a notice about a newer release once the links are shown
+	var onReady func([]sshx.Info)
+	if notices := startUpdateCheck(opts, file, connConfig); notices != nil {
+		onReady = func([]sshx.Info) {
+			go func() {
+				if notice, ok := <-notices; ok {
+					fmt.Println(notice)
+				}
+			}()
+		}
+	}
+	if err := runSessions(opts, sessionOpts, targets, onReady); err != nil {
+		return err
 	}
+	return streamStatus(streamExit)
+}
+
+// connectionConfig builds the transport configuration from the command-line flags.
+func connectionConfig(opts options, preference transport.TransportPreference) (transport.ConnectionConfig, error) {
+	connConfig := transport.DefaultConnectionConfig()
+	connConfig.VerboseErrors = opts.Verbose
+	connConfig.Preference = preference
+	connConfig.ClientVersion = buildVersion()
+	connConfig.Proxy = opts.Proxy
+	connConfig.TLSCertFile = opts.TLSCert
+	connConfig.TLSKeyFile = opts.TLSKey
+	connConfig.TLSCAFile = opts.TLSCA
+	connConfig.TLSServerName = opts.TLSServerName
+	connConfig.TLSInsecureSkipVerify = opts.TLSInsecure
+	connConfig.GrpcAuthority = opts.GrpcAuthority
 
-	if name != "" {
-		config.Name = &name
+	if opts.WSPath != "" {
+		if !strings.HasPrefix(opts.WSPath, "/") || !strings.Contains(opts.WSPath, "{name}") {
+			return connConfig, fmt.Errorf("invalid --ws-path-template %q: expected an absolute path containing {name}", opts.WSPath)
+		}
+		connConfig.WebSocketPathTemplate = opts.WSPath
 	}
 
-	if shell != "" {
-		config.Shell = &shell
+	if opts.GrpcTarget != "" {
+		if _, _, err := net.SplitHostPort(opts.GrpcTarget); err != nil {
+			return connConfig, fmt.Errorf("invalid --grpc-target %q: expected host:port", opts.GrpcTarget)
+		}
+		connConfig.GrpcTarget = opts.GrpcTarget
 	}
 
-	switch serviceCmd {
-	case "install":
-		return service.InstallWithConfig(config)
-	case "uninstall":
-		return service.Uninstall()
-	case "status":
-		return service.Status()
-	case "start":
-		return service.Start()
-	case "stop":
-		return service.Stop()
-	default:
-		return fmt.Errorf("invalid service command: %s", serviceCmd)
+	if opts.SOCKS5 != "" {
+		dialer, err := transport.SOCKS5Dialer(opts.SOCKS5)
+		if err != nil {
+			return connConfig, err
+		}
+		connConfig.Dialer = dialer
 	}
+
+	return connConfig, nil
 }
 
-func getDefaultSessionName() string {
-	sessionName := "unknown"
+func handleServiceCommand(opts options, preference transport.TransportPreference) error {
+	// Arguments end up in the unit file, which is no place for a secret
+	if opts.Password != "" || opts.PromptPassword {
+		return fmt.Errorf("--password cannot be used with --service; set SSHX_PASSWORD in the service environment file instead")
+	}
 
-	if currentUser, err := user.Current(); err == nil {
-		sessionName = currentUser.Username
+	config := service.ServiceConfig{
+		Server:        opts.Server,
+		Dashboard:     opts.Dashboard,
+		EnableReaders: opts.EnableReaders,
+		Proxy:         opts.Proxy,
+		SOCKS5:        opts.SOCKS5,
+		TLSCert:       opts.TLSCert,
+		TLSKey:        opts.TLSKey,
+		TLSCA:         opts.TLSCA,
+		TLSServerName: opts.TLSServerName,
+		TLSInsecure:   opts.TLSInsecure,
+		GrpcTarget:    opts.GrpcTarget,
+		GrpcAuthority: opts.GrpcAuthority,
+		WSPath:        opts.WSPath,
+		Sessions:      opts.Sessions,
+		Config:        opts.Config,
+		LogFormat:     opts.LogFormat,
+		LogFile:       opts.LogFile,
+		LogMaxSize:    opts.LogMaxSize,
+		LogMaxAge:     opts.LogMaxAge,
+		LogCompress:   opts.LogCompress,
 	}
 
-	if hostname, err := os.Hostname(); err == nil {
-		// Trim domain information like .lan or .local
-		if parts := strings.Split(hostname, "."); len(parts) > 0 {
-			hostname = parts[0]
+	if opts.Dashboard {
+		config.DashboardKey = opts.DashboardKey
+		// The default key file is under this user's home, so a service running
+		// as another user keeps its own default instead
+		if opts.ServiceUser == "" || opts.DashboardKeyFile != defaultDashboardKeyFile {
+			config.DashboardKeyFile = &opts.DashboardKeyFile
+		}
+		config.Tags = opts.Tags
+		if opts.HeartbeatInterval != dashboard.DefaultHeartbeatInterval {
+			config.DashboardHeartbeat = &opts.HeartbeatInterval
 		}
-		sessionName += "@" + hostname
 	}
 
-	return sessionName
-}
+	if opts.ReadersOnly {
+		config.ReadersOnly = true
+		config.WriteURLFile = opts.WriteURLFile
+	}
 
-// RegisterDashboardRequest matches the Rust implementation
-type RegisterDashboardRequest struct {
-	SessionName  string  `json:"sessionName"`
-	URL          string  `json:"url"`
-	WriteURL     *string `json:"writeUrl,omitempty"`
-	DisplayName  string  `json:"displayName"`
-	DashboardKey *string `json:"dashboardKey,omitempty"`
-}
+	config.NoWriteURLStdout = !opts.WriteURLStdout
 
-// RegisterDashboardResponse from the server
-type RegisterDashboardResponse struct {
-	DashboardKey string `json:"dashboardKey"`
-	DashboardURL string `json:"dashboardUrl"`
-}
+	if opts.URLFile != "" {
+		config.URLFile = opts.URLFile
+	}
 
-// DashboardInfo for display
-type DashboardInfo struct {
-	Key string
-	URL string
-}
+	if opts.IdleTimeout > 0 {
+		config.IdleTimeout = opts.IdleTimeout
+	}
+
+	config.BatchDelay = opts.BatchDelay
 
-// makeRelativeURL extracts relative URL from full URL for reverse proxy compatibility
-func makeRelativeURL(fullURL string) string {
-	if u, err := url.Parse(fullURL); err == nil {
-		relative := u.Path
-		if u.RawQuery != "" {
-			relative += "?" + u.RawQuery
+	config.ShellEnv = opts.Env
+	config.Cwd = opts.Cwd
+	config.Login = opts.Login
+	config.RestartShells = opts.RestartShells
+	config.Resume = opts.Resume
+	config.KillExisting = opts.KillExisting
+	config.DumpDir = opts.DumpDir
+	config.MaxUploadKbps = opts.MaxUploadKbps
+	config.MaxShells = opts.MaxShells
+	config.ShellMemoryMax = opts.ShellMemoryMax
+	config.ShellCPUQuota = opts.ShellCPUQuota
+	config.ScrollbackBytes = opts.ScrollbackBytes
+	config.ChunkBytes = opts.ChunkBytes
+	if opts.KillGrace != terminal.DefaultKillGrace {
+		config.KillGrace = opts.KillGrace
+	}
+	config.SanitizeOutput = opts.SanitizeOutput
+	config.InvalidUTF8 = opts.InvalidUTF8
+	config.ControlSocket = opts.ControlSocket
+	config.WebhookURL = opts.WebhookURL
+	config.AllowedShells = opts.AllowedShells
+	config.RunAsUser = opts.RunAsUser
+	config.User = opts.ServiceUser
+	config.Group = opts.ServiceGroup
+	config.UserScope = opts.UserService
+
+	// Pass variables given by name with their current value
+	for _, entry := range opts.ServiceEnv {
+		name, _, hasValue := strings.Cut(entry, "=")
+		if name == "" {
+			return fmt.Errorf("invalid --service-env %q (expected NAME or NAME=VALUE)", entry)
 		}
-		if u.Fragment != "" {
-			relative += "#" + u.Fragment
+		if !hasValue {
+			value, ok := os.LookupEnv(name)
+			if !ok {
+				util.Warnf("--service-env %s is not set in this environment, skipping", name)
+				continue
+			}
+			entry = name + "=" + value
 		}
-		return relative
+		config.Environment = append(config.Environment, entry)
 	}
-	// If parsing fails, assume it's already relative
-	return fullURL
-}
 
-func registerWithDashboard(server string, controller interface {
-	Name() string
-	URL() string
-	WriteURL() *string
-}, displayName string, dashboardKey *string) (*DashboardInfo, error) {
-	dashboardURL := server + "/api/dashboards/register"
+	if opts.LogMaxFiles != defaultLogMaxFiles {
+		config.LogMaxFiles = &opts.LogMaxFiles
+	}
 
-	// Prepare request payload - matches Rust RegisterDashboardRequest exactly
-	request := RegisterDashboardRequest{
-		SessionName:  controller.Name(),
-		URL:          makeRelativeURL(controller.URL()),
-		DisplayName:  displayName,
-		DashboardKey: dashboardKey,
+	if preference != transport.PreferAuto {
+		config.Transport = preference.String()
 	}
 
-	if writeURL := controller.WriteURL(); writeURL != nil {
-		relativeWriteURL := makeRelativeURL(*writeURL)
-		request.WriteURL = &relativeWriteURL
+	// The service runs from a different working directory, so resolve file paths now
+	paths := []*string{&config.TLSCert, &config.TLSKey, &config.TLSCA, &config.Config, &config.LogFile, &config.WriteURLFile, &config.URLFile, &config.Cwd, &config.DumpDir, &config.ControlSocket}
+	if config.DashboardKeyFile != nil {
+		paths = append(paths, config.DashboardKeyFile)
+	}
+	for _, path := range paths {
+		if *path != "" {
+			if abs, err := filepath.Abs(*path); err == nil {
+				*path = abs
+			}
+		}
 	}
 
-	// Convert to JSON
-	jsonData, err := json.Marshal(request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	if opts.Name != "" {
+		config.Name = &opts.Name
 	}
 
-	// Make HTTP POST request
-	resp, err := http.Post(dashboardURL, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, fmt.Errorf("failed to post to dashboard: %w", err)
+	if opts.Shell != "" {
+		config.Shell = &opts.Shell
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		var response RegisterDashboardResponse
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return nil, fmt.Errorf("failed to decode response: %w", err)
-		}
-		fmt.Println("\n  ✓ Session registered to dashboard")
+	if opts.Exec != "" {
+		config.Exec = &opts.Exec
+	}
 
-		return &DashboardInfo{
-			Key: response.DashboardKey,
-			URL: response.DashboardURL,
-		}, nil
-	} else {
-		log.Printf("Failed to register with dashboard: %s", resp.Status)
-		return nil, fmt.Errorf("Dashboard registration failed with status: %s", resp.Status)
+	config.AttachTmux = opts.AttachTmux
+	config.OnStart = opts.OnStart
+	config.Docker = opts.Docker
+	config.SSH = opts.SSH
+	config.SSHKey = opts.SSHKey
+
+	switch opts.Service {
+	case "install":
+		return service.InstallWithConfig(config)
+	case "uninstall":
+		return service.Uninstall(opts.UserService)
+	case "status":
+		return service.Status(opts.UserService)
+	case "start":
+		return service.Start(opts.UserService)
+	case "stop":
+		return service.Stop(opts.UserService)
+	default:
+		return fmt.Errorf("invalid service command: %s", opts.Service)
 	}
 }
 
-func printGreeting(shell string, controller interface {
-	URL() string
-	WriteURL() *string
-}, connectionMethod transport.ConnectionMethod, dashboardInfo *DashboardInfo) {
-	version := "v1.0.0" // You could make this dynamic
-	transportStr := connectionMethod.String()
+func printGreeting(info sshx.Info) {
+	version := buildVersion()
+	transportStr := info.Transport.String()
+	shell := info.Shell
+	dashboardInfo := info.Dashboard
 
-	if writeURL := controller.WriteURL(); writeURL != nil {
+	if writeURL := info.WriteURL; writeURL != nil {
 		if dashboardInfo != nil {
 			fmt.Printf(`
   %s%ssshx%s %s%s%s
@@ -358,7 +997,7 @@ func printGreeting(shell string, controller interface {
   %s➜%s  Transport:      %s%s%s
 
 `, BoldGreen, Green, Reset, Green, version, Reset,
-				Green, Reset, UnderlineCyan, controller.URL(), Reset,
+				Green, Reset, UnderlineCyan, info.URL, Reset,
 				Green, Reset, UnderlineCyan, *writeURL, Reset,
 				Green, Reset, UnderlineCyan, dashboardInfo.URL, Reset,
 				Green, Reset, Fixed8, dashboardInfo.Key, Reset,
@@ -374,7 +1013,7 @@ func printGreeting(shell string, controller interface {
   %s➜%s  Transport:      %s%s%s
 
 `, BoldGreen, Green, Reset, Green, version, Reset,
-				Green, Reset, UnderlineCyan, controller.URL(), Reset,
+				Green, Reset, UnderlineCyan, info.URL, Reset,
 				Green, Reset, UnderlineCyan, *writeURL, Reset,
 				Green, Reset, Fixed8, shell, Reset,
 				Green, Reset, Fixed8, transportStr, Reset)
@@ -391,7 +1030,7 @@ func printGreeting(shell string, controller interface {
   %s➜%s  Transport:    %s%s%s
 
 `, BoldGreen, Green, Reset, Green, version, Reset,
-				Green, Reset, UnderlineCyan, controller.URL(), Reset,
+				Green, Reset, UnderlineCyan, info.URL, Reset,
 				Green, Reset, UnderlineCyan, dashboardInfo.URL, Reset,
 				Green, Reset, Fixed8, dashboardInfo.Key, Reset,
 				Green, Reset, Fixed8, shell, Reset,
@@ -405,7 +1044,7 @@ func printGreeting(shell string, controller interface {
   %s➜%s  Transport: %s%s%s
 
 `, BoldGreen, Green, Reset, Green, version, Reset,
-				Green, Reset, UnderlineCyan, controller.URL(), Reset,
+				Green, Reset, UnderlineCyan, info.URL, Reset,
 				Green, Reset, Fixed8, shell, Reset,
 				Green, Reset, Fixed8, transportStr, Reset)
 		}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"sshx-go/pkg/config"
+	"sshx-go/pkg/sshx"
+)
+
+// sessionRecord is the machine-readable description of a session printed by
+// --output json and written to --url-file.
+type sessionRecord struct {
+	Name         string `json:"name"`
+	URL          string `json:"url"`
+	WriteURL     string `json:"writeUrl,omitempty"`
+	Shell        string `json:"shell,omitempty"`
+	Transport    string `json:"transport"`
+	DashboardURL string `json:"dashboardUrl,omitempty"`
+	DashboardKey string `json:"dashboardKey,omitempty"`
+}
+
+func newSessionRecord(info sshx.Info) sessionRecord {
+	record := sessionRecord{
+		Name:      info.Name,
+		URL:       info.URL,
+		Shell:     info.Shell,
+		Transport: info.Transport.String(),
+	}
+	if info.WriteURL != nil {
+		record.WriteURL = *info.WriteURL
+	}
+	if info.Dashboard != nil {
+		record.DashboardURL = info.Dashboard.URL
+		record.DashboardKey = info.Dashboard.Key
+	}
+	return record
+}
+
+// writeURLDelivered reports whether the writable link reaches the host other
+// than on stdout: through --url-file, the control socket or a notifier that
+// posts it.
+func writeURLDelivered(opts options, file *config.File) bool {
+	if opts.URLFile != "" || opts.ControlSocket != "" || opts.WebhookURL != "" {
+		return true
+	}
+	for _, entry := range file.Notifiers {
+		if entry.IncludeWriteURL {
+			return true
+		}
+	}
+	return false
+}
+
+// stdoutInfos returns infos as they may be printed: without writable links
+// under --write-url-stdout=false.
+func stdoutInfos(opts options, infos []sshx.Info) []sshx.Info {
+	if opts.WriteURLStdout {
+		return infos
+	}
+	shown := append([]sshx.Info(nil), infos...)
+	for i := range shown {
+		shown[i].WriteURL = nil
+	}
+	return shown
+}
+
+// encodeSessions writes one JSON object per session, each on its own line.
+func encodeSessions(w io.Writer, infos []sshx.Info) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	for _, info := range infos {
+		if err := encoder.Encode(newSessionRecord(info)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeURLFile saves the sessions as JSON lines to path. The file is private
+// since it may hold writable links.
+func writeURLFile(path string, infos []sshx.Info) error {
+	var buf bytes.Buffer
+	if err := encodeSessions(&buf, infos); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write --url-file: %w", err)
+	}
+	return nil
+}
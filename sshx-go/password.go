@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// minPasswordLength is the shortest passphrase accepted by --password. The
+// key derivation is deliberately slow, but short passphrases are still cheap
+// to guess offline from the encrypted zeros the server stores.
+const minPasswordLength = 8
+
+// sessionPassword returns the passphrase chosen with --password or
+// SSHX_PASSWORD, prompting for it when --password is given alone. It returns
+// an empty string when sessions should use generated keys.
+func sessionPassword(opts options) (string, error) {
+	password := opts.Password
+	if opts.PromptPassword {
+		var err error
+		if password, err = promptPassword(); err != nil {
+			return "", err
+		}
+	} else if password == "" {
+		password = os.Getenv("SSHX_PASSWORD")
+		if password == "" {
+			return "", nil
+		}
+	}
+
+	if len(password) < minPasswordLength {
+		return "", fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	return password, nil
+}
+
+// promptPassword reads a passphrase from the terminal without echoing it,
+// asking for it twice to catch typos.
+func promptPassword() (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("--password needs a terminal to prompt; use --password=SECRET or set SSHX_PASSWORD")
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := readPassword(reader)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprint(os.Stderr, "Confirm password: ")
+	confirm, err := readPassword(reader)
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", fmt.Errorf("passwords do not match")
+	}
+	return password, nil
+}
+
+// readPassword reads a line from reader, which wraps stdin, with terminal
+// echo disabled.
+func readPassword(reader *bufio.Reader) (string, error) {
+	restore, err := disableEcho(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to disable terminal echo: %w", err)
+	}
+	line, err := reader.ReadString('\n')
+	restore()
+	// The newline typed by the user was not echoed either
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,55 @@
+package client
+
+// batchFlushBytes is how much output of a shell ControllerConfig.BatchDelay
+// holds back at most before sending it right away.
+const batchFlushBytes = 16 << 10
+
+// outputBatch merges the terminal output of each shell that arrives within
+// ControllerConfig.BatchDelay, like Nagle's algorithm, so e.g. keystrokes
+// echoed one at a time are sent in a single message. Chunks at consecutive
+// offsets of a shell's stream merge into one, since their encryption only
+// depends on the offset.
+type outputBatch struct {
+	pending []*TerminalData // held output of each shell, in order of arrival
+}
+
+// add holds data back, merging it with the shell's held output when it
+// follows it. It returns the output to send now: the shell's earlier output
+// if data does not follow it, and the merged output once it is large.
+func (b *outputBatch) add(data *TerminalData) (send []*TerminalData) {
+	for i, held := range b.pending {
+		if held.ID != data.ID {
+			continue
+		}
+		if held.Seq+uint64(len(held.Data)) == data.Seq {
+			held.Data = append(held.Data, data.Data...)
+			if len(held.Data) >= batchFlushBytes {
+				b.pending = append(b.pending[:i], b.pending[i+1:]...)
+				send = append(send, held)
+			}
+			return send
+		}
+		// Resent after a sync, so it cannot be merged
+		b.pending = append(b.pending[:i], b.pending[i+1:]...)
+		send = append(send, held)
+		break
+	}
+
+	if len(data.Data) >= batchFlushBytes {
+		return append(send, data)
+	}
+	b.pending = append(b.pending, data)
+	return send
+}
+
+// drain returns all held output and empties the batch.
+func (b *outputBatch) drain() []*TerminalData {
+	pending := b.pending
+	b.pending = nil
+	return pending
+}
+
+// empty reports whether no output is held.
+func (b *outputBatch) empty() bool {
+	return len(b.pending) == 0
+}
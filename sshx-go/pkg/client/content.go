@@ -0,0 +1,127 @@
+package client
+
+import "unicode/utf8"
+
+const contentBlockBytes = 64 << 10 // Size of the blocks holding a shell's output
+
+// ContentSizes configures how much output each shell keeps and how much of
+// it is sent at a time. Zero fields use the defaults.
+type ContentSizes struct {
+	// Rolling is the recent output kept at least, which users joining late
+	// and reconnects replay. It defaults to 8 MiB.
+	Rolling int
+	// Prune is how much output is held before the output beyond Rolling is
+	// dropped. It defaults to one and a half times Rolling.
+	Prune int
+	// Chunk is the most output sent in one message, at least 4 bytes. It
+	// defaults to 64 KiB.
+	Chunk int
+}
+
+// withDefaults returns s with zero fields set to their defaults.
+func (s ContentSizes) withDefaults() ContentSizes {
+	if s.Rolling <= 0 {
+		s.Rolling = contentRollingBytes
+	}
+	if s.Prune <= 0 {
+		s.Prune = s.Rolling + s.Rolling/2
+	}
+	if s.Chunk <= 0 {
+		s.Chunk = contentChunkSize
+	}
+	// A chunk must hold any character, or sending would stall on one
+	s.Chunk = max(s.Chunk, utf8.UTFMax)
+	return s
+}
+
+// contentLog holds a shell's output from some offset on, in a list of
+// fixed-size blocks. Appending never copies earlier output, and pruning
+// drops whole blocks instead of rebuilding the buffer, so shells producing
+// output quickly cost little more than the copy of each byte in and out.
+//
+// Offsets are counted from the shell's first byte of output, including the
+// bytes already pruned.
+type contentLog struct {
+	blocks [][]byte // full blocks, then one being filled
+	head   int      // bytes of blocks[0] already pruned
+	start  int      // offset of the first byte kept
+	end    int      // offset after the last byte
+}
+
+// Start returns the offset of the first byte kept.
+func (l *contentLog) Start() int {
+	return l.start
+}
+
+// End returns the offset after the last byte, the total output so far.
+func (l *contentLog) End() int {
+	return l.end
+}
+
+// Len returns the number of bytes kept.
+func (l *contentLog) Len() int {
+	return l.end - l.start
+}
+
+// Write appends p.
+func (l *contentLog) Write(p []byte) {
+	for len(p) > 0 {
+		last := len(l.blocks) - 1
+		if last < 0 || len(l.blocks[last]) == contentBlockBytes {
+			l.blocks = append(l.blocks, make([]byte, 0, contentBlockBytes))
+			last++
+		}
+		n := min(len(p), contentBlockBytes-len(l.blocks[last]))
+		l.blocks[last] = append(l.blocks[last], p[:n]...)
+		l.end += n
+		p = p[n:]
+	}
+}
+
+// Slice returns a copy of the bytes from offset start to end, which must be
+// within the bytes kept.
+func (l *contentLog) Slice(start, end int) []byte {
+	out := make([]byte, 0, end-start)
+	for pos := start - l.start + l.head; len(out) < cap(out); {
+		block := l.blocks[pos/contentBlockBytes]
+		from := pos % contentBlockBytes
+		n := min(len(block)-from, cap(out)-len(out))
+		out = append(out, block[from:from+n]...)
+		pos += n
+	}
+	return out
+}
+
+// Boundary returns the last UTF-8 character boundary at or before offset i,
+// clamped to the bytes kept. Output passed through without decoding may not
+// be UTF-8, so no boundary within a character's length leaves i as is.
+func (l *contentLog) Boundary(i int) int {
+	if i >= l.end {
+		return l.end
+	}
+	if i <= l.start {
+		return l.start
+	}
+	for j := i; j > i-utf8.UTFMax; j-- {
+		if j == l.start || utf8.RuneStart(l.byteAt(j)) {
+			return j
+		}
+	}
+	return i
+}
+
+// Prune drops the bytes before offset i, which must be within the bytes kept.
+func (l *contentLog) Prune(i int) {
+	pos := i - l.start + l.head
+	dropped := pos / contentBlockBytes
+	clear(l.blocks[:dropped])
+	l.blocks = l.blocks[dropped:]
+	l.head = pos % contentBlockBytes
+	l.start = i
+}
+
+// byteAt returns the byte at offset i.
+func (l *contentLog) byteAt(i int) byte {
+	pos := i - l.start + l.head
+	return l.blocks[pos/contentBlockBytes][pos%contentBlockBytes]
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestContentLog checks slices and pruning across block boundaries against
+// a plain copy of the output.
+func TestContentLog(t *testing.T) {
+	var l contentLog
+	var want []byte
+	for i := 0; len(want) < 3*contentBlockBytes+100; i++ {
+		p := bytes.Repeat([]byte{byte(i)}, 1000+i)
+		l.Write(p)
+		want = append(want, p...)
+	}
+	if l.End() != len(want) || l.Len() != len(want) {
+		t.Fatalf("End() = %d, Len() = %d, want %d", l.End(), l.Len(), len(want))
+	}
+
+	for _, prune := range []int{0, 10, contentBlockBytes, contentBlockBytes + 1, 2*contentBlockBytes + 5} {
+		l.Prune(prune)
+		if l.Start() != prune || l.Len() != len(want)-prune {
+			t.Fatalf("after Prune(%d), Start() = %d, Len() = %d", prune, l.Start(), l.Len())
+		}
+		for _, span := range [][2]int{{prune, l.End()}, {prune, prune + 1}, {l.End() - 7, l.End()}, {prune + 3, prune + contentBlockBytes + 3}} {
+			start, end := span[0], min(span[1], l.End())
+			if got := l.Slice(start, end); !bytes.Equal(got, want[start:end]) {
+				t.Fatalf("after Prune(%d), Slice(%d, %d) differs from the output", prune, start, end)
+			}
+		}
+	}
+}
+
+// TestContentLogBoundary checks offsets are moved back to the start of a
+// character split across blocks, but not past the bytes kept.
+func TestContentLogBoundary(t *testing.T) {
+	var l contentLog
+	l.Write(bytes.Repeat([]byte("a"), contentBlockBytes-2))
+	l.Write([]byte("€b")) // 3 bytes, split across the first two blocks
+
+	for i, want := range map[int]int{
+		contentBlockBytes - 2: contentBlockBytes - 2,
+		contentBlockBytes - 1: contentBlockBytes - 2,
+		contentBlockBytes:     contentBlockBytes - 2,
+		contentBlockBytes + 1: contentBlockBytes + 1,
+		l.End() + 10:          l.End(),
+	} {
+		if got := l.Boundary(i); got != want {
+			t.Errorf("Boundary(%d) = %d, want %d", i, got, want)
+		}
+	}
+
+	l.Prune(contentBlockBytes - 1)
+	if got := l.Boundary(contentBlockBytes); got != contentBlockBytes-1 {
+		t.Errorf("Boundary past a pruned character start = %d, want %d", got, contentBlockBytes-1)
+	}
+}
+
+// BenchmarkContentLog feeds output the way terminalTask does for a shell
+// printing as fast as it can: 4 KiB reads, a chunk sent each time the
+// server falls behind, and pruning down to the rolling size.
+func BenchmarkContentLog(b *testing.B) {
+	sizes := ContentSizes{}.withDefaults()
+	read := bytes.Repeat([]byte("0123456789abcdef"), 4096/16)
+	b.SetBytes(int64(len(read)))
+	b.ReportAllocs()
+
+	var content contentLog
+	var seq int
+	for i := 0; i < b.N; i++ {
+		content.Write(read)
+		if content.End() > seq {
+			start := content.Boundary(seq)
+			end := content.Boundary(min(start+sizes.Chunk, content.End()))
+			_ = content.Slice(start, end)
+			seq = end
+		}
+		if content.Len() > sizes.Prune && seq-sizes.Rolling > content.Start() {
+			content.Prune(content.Boundary(seq - sizes.Rolling))
+		}
+	}
+}
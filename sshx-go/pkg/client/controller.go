@@ -2,12 +2,16 @@
 package client
 
 import (
+	"cmp"
 	"context"
 	"crypto/rand"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"math/big"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"sshx-go/pkg/encrypt"
@@ -19,16 +23,97 @@ import (
 const (
 	heartbeatInterval = 2 * time.Second
 	reconnectInterval = 60 * time.Second
+
+	// While on the WebSocket fallback, gRPC is tested again this often
+	grpcProbeInterval = 2 * time.Minute
+	// Failed attempts in a row before reconnecting with the other transport
+	transportSwitchFailures = 3
 )
 
 // ControllerConfig holds configuration for creating a controller.
 type ControllerConfig struct {
-	Origin        string
-	Name          string
+	Origin string
+	// Failover lists further origins of the same sshx deployment, tried in
+	// turn when the one in use cannot be reached, both when opening the
+	// session and when reconnecting. Host names are resolved again on every
+	// attempt, so frontends replaced behind a DNS name are picked up too.
+	Failover []string
+	Name     string
 	Runner        Runner
 	EnableReaders bool
+	// Reconnect selects the transport used when the channel is re-established.
+	Reconnect ReconnectPolicy
+	// ShellExit selects whether shells exiting end the controller.
+	ShellExit ShellExitPolicy
+	// IdleTimeout ends the controller after this long without terminal input
+	// or output. Zero disables the timeout.
+	IdleTimeout time.Duration
+	// Password replaces the generated encryption key when non-empty. Links
+	// carry it in their fragment like a generated key, which is the only
+	// place the web app reads the key from.
+	Password string
+	// OpenShell creates a shell as soon as Run starts, instead of waiting for
+	// a user to, e.g. to show output nobody needs to type into.
+	OpenShell bool
+	// OnStart is typed into the first shell once it starts, followed by
+	// Enter, e.g. "htop" to open a prepared view for users. Empty types
+	// nothing.
+	OnStart string
+	// BatchDelay holds terminal output back for up to this long, so output
+	// written in quick succession, such as echoed keystrokes, is sent in one
+	// message. Output is sent sooner once 16 KiB are held. Zero sends output
+	// as soon as it is read.
+	BatchDelay time.Duration
+	// MaxShells caps how many shells users may have open at once. Requests
+	// beyond it are refused with an error instead of starting a shell. Zero
+	// means no limit.
+	MaxShells int
+	// Content sets how much output each shell keeps for users joining late
+	// and how much is sent at a time, e.g. less on memory-constrained hosts.
+	Content ContentSizes
+	// Resume, when non-nil, reattaches to a session an earlier process left
+	// open, keeping its links. Its keys replace the generated ones. If the
+	// server no longer has the session, a new one is opened instead. The
+	// shells of the earlier process are closed, so Resume cannot be combined
+	// with OpenShell, whose shell ID the session already used.
+	Resume *Resume
 }
 
+// HostShellID is the ID of the shell created for ControllerConfig.OpenShell,
+// far above the IDs the server counts up from 1 for shells users create.
+const HostShellID = 1 << 31
+
+// ShellExitPolicy selects when exiting shells make Run return ErrShellsExited.
+type ShellExitPolicy int
+
+const (
+	// ShellExitNever keeps serving the session after its shells exit.
+	ShellExitNever ShellExitPolicy = iota
+	// ShellExitLast ends the session once every shell has exited.
+	ShellExitLast
+	// ShellExitFirst ends the session when the first shell created exits.
+	ShellExitFirst
+)
+
+// ErrShellsExited is returned by Run when shells exited as selected by
+// ControllerConfig.ShellExit.
+var ErrShellsExited = errors.New("shells exited")
+
+// ErrIdleTimeout is returned by Run when there was no terminal activity for
+// ControllerConfig.IdleTimeout.
+var ErrIdleTimeout = errors.New("idle timeout")
+
+// ReconnectPolicy selects how the controller re-establishes its transport.
+type ReconnectPolicy int
+
+const (
+	// ReconnectRemembered reconnects with the transport that first succeeded.
+	ReconnectRemembered ReconnectPolicy = iota
+	// ReconnectFallback repeats the gRPC-then-WebSocket negotiation on every
+	// reconnect, so a session can move between transports as networks change.
+	ReconnectFallback
+)
+
 // Controller handles a single session's communication with the remote server using transport abstraction.
 type Controller struct {
 	transport     transport.SshxTransport
@@ -36,15 +121,44 @@ type Controller struct {
 	encrypt       *encrypt.Encrypt
 	encryptionKey string
 
-	name     string
-	token    string
-	url      string
-	writeURL *string
+	name       string
+	token      string
+	url        string
+	sessionURL string // url without the key, as returned by the server
+	writeURL   *string
 
 	// Channels with backpressure routing messages to each shell task
 	shellsTx map[uint32]chan ShellData
 	shellsMu sync.RWMutex
 
+	// Last window size requested by the server for each shell, replayed on resume
+	shellSizes map[uint32][2]uint32
+
+	// Input waiting for shells whose channels are full
+	pendingInput map[uint32]*ringBuffer
+
+	// Shells whose input the host locked with LockInput, mapped to whether a
+	// dropped input was logged since
+	lockedShells map[uint32]bool
+
+	// Writers registered with Mirror, which receive each shell's output
+	mirrors map[uint32]io.Writer
+
+	// I/O statistics recorded by each shell task, see ShellStats
+	shellStats map[uint32]*shellCounters
+
+	// Set once a channel has been established, so later channels resume shells
+	resumable bool
+
+	// Signalled each time a later channel is established
+	reconnected chan struct{}
+
+	// Signalled by Reconnect to replace the current channel
+	reconnectNow chan struct{}
+
+	// Events returned by Events
+	events eventQueue
+
 	// Channel shared with tasks to allow them to output client messages
 	outputTx chan ClientMessage
 	outputRx chan ClientMessage
@@ -53,8 +167,39 @@ type Controller struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	// Connection method used
+	// Set by Close, after which the server ending the channel is expected
+	closing atomic.Bool
+
+	// Connection method used, written by Run under methodMu
 	connectionMethod transport.ConnectionMethod
+	methodMu         sync.Mutex
+
+	// Set when the next reconnect should use the other transport, and the
+	// failed attempts in a row that make it do so
+	switchTransport bool
+	failures        int
+
+	// Connection configuration reused when reconnecting
+	connConfig transport.ConnectionConfig
+
+	// Config.Origin followed by Config.Failover, and the index of the one in
+	// use, which moves on when a channel fails before the server answered
+	origins []string
+	origin  int
+	heard   bool
+
+	// Round-trip times and ping counters, see Stats
+	stats *statsRecorder
+
+	// Shell exit tracking for ControllerConfig.ShellExit, guarded by shellsMu
+	firstShell   uint32
+	spawnedShell bool
+	shellsExited chan struct{}
+	exitOnce     sync.Once
+
+	// Time of the last terminal input or output in Unix nanoseconds, for
+	// ControllerConfig.IdleTimeout
+	lastActivity atomic.Int64
 }
 
 // NewController constructs a new controller using transport abstraction, connecting to the remote server.
@@ -65,10 +210,44 @@ func NewController(config ControllerConfig) (*Controller, error) {
 
 // NewControllerWithConnection constructs a new controller with custom connection configuration.
 func NewControllerWithConnection(config ControllerConfig, connConfig transport.ConnectionConfig) (*Controller, error) {
+	if config.Resume != nil && config.OpenShell {
+		return nil, fmt.Errorf("resuming a session cannot be combined with OpenShell")
+	}
 	ctx, cancel := context.WithCancel(context.Background())
 
-	// Generate encryption key - matches Rust implementation
-	encryptionKey := randAlphanumeric(14) // 83.3 bits of entropy
+	// Record round-trip times measured by every transport, including reconnects
+	stats := &statsRecorder{}
+	events := make(eventQueue, eventBuffer)
+	onRTT := connConfig.OnRTT
+	connConfig.OnRTT = func(rtt time.Duration) {
+		stats.recordRTT(rtt)
+		events.emit(Event{Type: EventLatency, RTT: rtt})
+		if onRTT != nil {
+			onRTT(rtt)
+		}
+	}
+
+	// Reattach to the session of an earlier process if it is still open
+	origins := append([]string{config.Origin}, config.Failover...)
+	var connectionResult *transport.ConnectionResult
+	var origin int
+	var err error
+	if config.Resume != nil {
+		connectionResult, origin, err = resumeSession(*config.Resume, config, origins, connConfig)
+		if err != nil {
+			util.Warnf("Could not resume session %s, opening a new one: %v", config.Resume.Name, err)
+		}
+	}
+
+	// Generate encryption key - matches Rust implementation - unless the
+	// user chose their own password
+	encryptionKey := config.Password
+	if encryptionKey == "" {
+		encryptionKey = randAlphanumeric(14) // 83.3 bits of entropy
+	}
+	if connectionResult != nil {
+		encryptionKey = config.Resume.Key
+	}
 
 	// Create encryptor in background task (matches Rust spawn_blocking)
 	encryptor := encrypt.New(encryptionKey)
@@ -77,21 +256,16 @@ func NewControllerWithConnection(config ControllerConfig, connConfig transport.C
 	var writePasswordHash []byte
 	if config.EnableReaders {
 		writePasswordVal := randAlphanumeric(14) // 83.3 bits of entropy
+		if connectionResult != nil {
+			writePasswordVal = config.Resume.WritePassword
+		}
 		writePassword = &writePasswordVal
 		writeEncrypt := encrypt.New(writePasswordVal)
 		writePasswordHash = writeEncrypt.Zeros()
 	}
 
-	// Connect to server with fallback
-	connectionResult, err := transport.ConnectWithFallback(config.Origin, config.Name, connConfig)
-	if err != nil {
-		cancel()
-		return nil, fmt.Errorf("failed to connect to server: %w", err)
-	}
-
-	log.Printf("Connected to %s using %s transport", config.Origin, connectionResult.Method)
-
-	// Open session - matches Rust OpenRequest exactly
+	// Connect to server with fallback and open the session - matches Rust
+	// OpenRequest exactly
 	openReq := &proto.OpenRequest{
 		Origin:            config.Origin,
 		EncryptedZeros:    encryptor.Zeros(),
@@ -99,12 +273,28 @@ func NewControllerWithConnection(config ControllerConfig, connConfig transport.C
 		WritePasswordHash: writePasswordHash,
 	}
 
-	resp, err := connectionResult.Transport.Open(ctx, openReq)
-	if err != nil {
+	resumed := connectionResult != nil
+	for i := 0; connectionResult == nil && i < len(origins); i++ {
+		origin = i
+		openReq.Origin = origins[origin]
+		connectionResult, err = transport.OpenWithFallback(origins[origin], openReq, connConfig)
+		if err == nil {
+			break
+		}
+		if origin < len(origins)-1 {
+			util.Warnf("failed to open session on %s, trying %s: %v", origins[origin], origins[origin+1], err)
+		}
+	}
+	if connectionResult == nil {
 		cancel()
-		connectionResult.Transport.Cleanup()
 		return nil, fmt.Errorf("failed to open session: %w", err)
 	}
+	resp := connectionResult.Session
+
+	util.Infof("Connected to %s using %s transport", origins[origin], connectionResult.Method)
+	if resumed {
+		util.Infof("Resumed session %s", resp.Name)
+	}
 
 	// Build URLs exactly like Rust implementation
 	url := resp.Url + "#" + encryptionKey
@@ -127,13 +317,28 @@ func NewControllerWithConnection(config ControllerConfig, connConfig transport.C
 		token:            resp.Token,
 		url:              url,
 		writeURL:         writeURL,
+		sessionURL:       resp.Url,
 		shellsTx:         make(map[uint32]chan ShellData),
+		shellSizes:       make(map[uint32][2]uint32),
+		pendingInput:     make(map[uint32]*ringBuffer),
+		lockedShells:     make(map[uint32]bool),
+		mirrors:          make(map[uint32]io.Writer),
+		shellStats:       make(map[uint32]*shellCounters),
+		reconnected:      make(chan struct{}, 1),
+		reconnectNow:     make(chan struct{}, 1),
+		events:           events,
 		outputTx:         outputTx,
 		outputRx:         outputRx,
 		ctx:              ctx,
 		cancel:           cancel,
 		connectionMethod: connectionResult.Method,
+		connConfig:       connConfig,
+		origins:          origins,
+		origin:           origin,
+		stats:            stats,
+		shellsExited:     make(chan struct{}),
 	}
+	controller.lastActivity.Store(time.Now().UnixNano())
 
 	return controller, nil
 }
@@ -160,15 +365,87 @@ func (c *Controller) EncryptionKey() string {
 
 // ConnectionMethod returns the connection method used.
 func (c *Controller) ConnectionMethod() transport.ConnectionMethod {
+	c.methodMu.Lock()
+	defer c.methodMu.Unlock()
 	return c.connectionMethod
 }
 
+// Stats returns round-trip time and ping statistics for the connection.
+func (c *Controller) Stats() Stats {
+	return c.stats.snapshot()
+}
+
+// Reconnected returns a channel that receives a value after the controller
+// re-establishes its channel to the server. Notifications are coalesced.
+func (c *Controller) Reconnected() <-chan struct{} {
+	return c.reconnected
+}
+
+// Reconnect makes Run drop the current channel and connect again right away,
+// e.g. after the network changed. Requests are coalesced.
+func (c *Controller) Reconnect() {
+	select {
+	case c.reconnectNow <- struct{}{}:
+	default:
+	}
+}
+
+// ShellInfo describes a running shell.
+type ShellInfo struct {
+	ID     uint32 `json:"id"`
+	Rows   uint32 `json:"rows"`
+	Cols   uint32 `json:"cols"`
+	Locked bool   `json:"locked,omitempty"`
+}
+
+// Shells returns the running shells ordered by ID, with the window size last
+// requested for each, or zero if none was.
+func (c *Controller) Shells() []ShellInfo {
+	c.shellsMu.RLock()
+	defer c.shellsMu.RUnlock()
+
+	shells := make([]ShellInfo, 0, len(c.shellsTx))
+	for id := range c.shellsTx {
+		size := c.shellSizes[id]
+		shells = append(shells, ShellInfo{ID: id, Rows: size[0], Cols: size[1], Locked: c.inputLocked(id)})
+	}
+	slices.SortFunc(shells, func(a, b ShellInfo) int { return cmp.Compare(a.ID, b.ID) })
+	return shells
+}
+
+// ShellStats returns the I/O statistics of the running shells ordered by ID.
+// Shells whose Runner does not record statistics report zeros.
+func (c *Controller) ShellStats() []ShellStats {
+	c.shellsMu.RLock()
+	defer c.shellsMu.RUnlock()
+
+	stats := make([]ShellStats, 0, len(c.shellsTx))
+	for id := range c.shellsTx {
+		if counters, ok := c.shellStats[id]; ok {
+			stats = append(stats, counters.snapshot(id))
+		} else {
+			stats = append(stats, ShellStats{ID: id})
+		}
+	}
+	slices.SortFunc(stats, func(a, b ShellStats) int { return cmp.Compare(a.ID, b.ID) })
+	return stats
+}
+
 // Run runs the controller forever, listening for requests from the server.
 // This matches the Rust Controller::run method exactly.
 func (c *Controller) Run() error {
 	lastRetry := time.Now()
 	retries := 0
 
+	// The server adds the shell once the channel carries its creation
+	if c.config.OpenShell {
+		c.shellsMu.Lock()
+		if _, exists := c.shellsTx[HostShellID]; !exists {
+			c.spawnShellTask(HostShellID, [2]int32{0, 0})
+		}
+		c.shellsMu.Unlock()
+	}
+
 	for {
 		select {
 		case <-c.ctx.Done():
@@ -177,11 +454,24 @@ func (c *Controller) Run() error {
 		}
 
 		if err := c.tryChannel(); err != nil {
+			if errors.Is(err, ErrShellsExited) || errors.Is(err, ErrIdleTimeout) {
+				return err
+			}
+			if c.closing.Load() || c.ctx.Err() != nil {
+				// Closed, not disconnected; Close cancels ctx once done
+				<-c.ctx.Done()
+				return c.ctx.Err()
+			}
 			if time.Since(lastRetry) >= 10*time.Second {
 				retries = 0
 			}
 			secs := 1 << min(retries, 4) // Exponential backoff, max 16 seconds
-			log.Printf("disconnected, retrying in %ds: %v", secs, err)
+			util.Warnf("disconnected, retrying in %ds: %v", secs, err)
+			c.events.emit(Event{Type: EventDisconnected, Err: err})
+			if !c.heard {
+				c.failures++
+				c.nextOrigin()
+			}
 
 			select {
 			case <-time.After(time.Duration(secs) * time.Second):
@@ -197,35 +487,11 @@ func (c *Controller) Run() error {
 // tryChannel helper function used by Run() that can return errors.
 // This matches the Rust Controller::try_channel method exactly.
 func (c *Controller) tryChannel() error {
-	// For WebSocket connections, we need to recreate the transport on each attempt
-	// since WebSocket connections can't be reused after failure
-	if c.connectionMethod == transport.MethodWebSocketFallback {
-		// Cleanup old transport
-		c.transport.Cleanup()
-
-		// Reconnect using the specific transport type that worked initially
-		wsURL := transport.GrpcToWebSocketURL(c.config.Origin, c.config.Name)
-		util.DebugLog("Reconnecting via WebSocket (remembered preference): %s", wsURL)
-		newTransport, err := transport.ConnectWebSocket(wsURL)
-		if err != nil {
-			return fmt.Errorf("failed to reconnect via WebSocket: %w", err)
-		}
-		c.transport = newTransport
-	}
-
-	// For gRPC connections, also recreate the transport on reconnection attempts
-	// to prevent using stale connections that may have timed out
-	if c.connectionMethod == transport.MethodGrpc {
-		// Cleanup old transport
-		c.transport.Cleanup()
-
-		// Reconnect using gRPC
-		util.DebugLog("Reconnecting via gRPC (remembered preference): %s", c.config.Origin)
-		newTransport, err := transport.ConnectGrpc(c.config.Origin)
-		if err != nil {
-			return fmt.Errorf("failed to reconnect via gRPC: %w", err)
-		}
-		c.transport = newTransport
+	// Recreate the transport on each attempt, since WebSocket connections can't
+	// be reused after failure and gRPC connections may have gone stale
+	c.heard = false
+	if err := c.reconnect(); err != nil {
+		return err
 	}
 
 	// Get bidirectional channels from transport
@@ -245,6 +511,18 @@ func (c *Controller) tryChannel() error {
 	case <-c.ctx.Done():
 		return c.ctx.Err()
 	}
+	c.events.emit(Event{Type: EventConnected, Transport: c.connectionMethod, Reconnect: c.resumable})
+
+	// Resume handshake: shells survive transport drops, so tell each of them
+	// to adopt the server's next sequence number and replay its window size.
+	if c.resumable {
+		c.resumeShells()
+		select {
+		case c.reconnected <- struct{}{}:
+		default:
+		}
+	}
+	c.resumable = true
 
 	// Main loop - matches Rust tokio::select! exactly
 	heartbeat := time.NewTicker(heartbeatInterval)
@@ -253,8 +531,58 @@ func (c *Controller) tryChannel() error {
 	reconnectTimer := time.NewTimer(reconnectInterval)
 	defer reconnectTimer.Stop()
 
+	// Shut the session down after a period without terminal activity
+	var idle <-chan time.Time
+	var idleTimer *time.Timer
+	if c.config.IdleTimeout > 0 {
+		idleTimer = time.NewTimer(max(c.idleRemaining(), 0))
+		defer idleTimer.Stop()
+		idle = idleTimer.C
+	}
+
+	// While on the WebSocket fallback, test gRPC in the background now and
+	// then, and move the session over once it answers
+	var probe <-chan time.Time
+	probed := make(chan error, 1)
+	if c.canSwitchTransport() && c.connectionMethod == transport.MethodWebSocketFallback {
+		probeTicker := time.NewTicker(grpcProbeInterval)
+		defer probeTicker.Stop()
+		probe = probeTicker.C
+	}
+
+	// Output held back by BatchDelay, sent when batchTimer fires
+	var batch outputBatch
+	var batchTimer <-chan time.Time
+	send := func(msg ClientMessage) error {
+		select {
+		case clientUpdates <- c.clientMessageToUpdate(msg):
+			return nil
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		}
+	}
+	sendBatched := func(data []*TerminalData) error {
+		for _, d := range data {
+			if err := send(ClientMessage{Type: ClientMessageTypeData, Data: d}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
 	for {
+		// Retry delivering buffered input while any is waiting
+		var flushInput <-chan time.Time
+		if c.hasPendingInput() {
+			flushInput = time.After(inputRetryInterval)
+		}
+
 		select {
+		case <-flushInput:
+			c.shellsMu.Lock()
+			c.flushInput()
+			c.shellsMu.Unlock()
+
 		case <-heartbeat.C:
 			// Send heartbeat - matches Rust interval.tick()
 			select {
@@ -265,11 +593,31 @@ func (c *Controller) tryChannel() error {
 
 		case msg := <-c.outputRx:
 			// Send client message - matches Rust output_rx.recv()
-			update := c.clientMessageToUpdate(msg)
-			select {
-			case clientUpdates <- update:
-			case <-c.ctx.Done():
-				return c.ctx.Err()
+			if msg.Type == ClientMessageTypeData {
+				c.lastActivity.Store(time.Now().UnixNano())
+				if c.config.BatchDelay > 0 {
+					if err := sendBatched(batch.add(msg.Data)); err != nil {
+						return err
+					}
+					if !batch.empty() && batchTimer == nil {
+						batchTimer = time.After(c.config.BatchDelay)
+					}
+					break
+				}
+			} else if !batch.empty() {
+				// Keep held output ahead of what follows it, e.g. a shell closing
+				if err := sendBatched(batch.drain()); err != nil {
+					return err
+				}
+			}
+			if err := send(msg); err != nil {
+				return err
+			}
+
+		case <-batchTimer:
+			batchTimer = nil
+			if err := sendBatched(batch.drain()); err != nil {
+				return err
 			}
 
 		case resp, ok := <-serverUpdates:
@@ -277,60 +625,191 @@ func (c *Controller) tryChannel() error {
 			if !ok {
 				return fmt.Errorf("server updates channel closed")
 			}
+			c.heard = true
+			c.failures = 0
 			if err := c.handleServerMessage(resp); err != nil {
-				log.Printf("error handling server message: %v", err)
+				util.Warnf("error handling server message: %v", err)
 			}
 
 		case <-reconnectTimer.C:
 			// Force reconnection - matches Rust reconnect timer
 			return nil
 
+		case <-probe:
+			origin := c.origins[c.origin]
+			go func() {
+				probed <- transport.ProbeGrpc(origin, c.connConfig)
+			}()
+
+		case err := <-probed:
+			if err != nil {
+				util.DebugLog("gRPC is still unavailable: %v", err)
+				continue
+			}
+			util.Infof("gRPC is available again, switching from WebSocket")
+			c.switchTransport = true
+			return nil
+
+		case <-c.reconnectNow:
+			util.Infof("reconnecting on request")
+			return nil
+
+		case <-c.shellsExited:
+			return ErrShellsExited
+
+		case <-idle:
+			remaining := c.idleRemaining()
+			if remaining <= 0 {
+				util.Infof("no terminal activity for %s, shutting down", c.config.IdleTimeout)
+				return ErrIdleTimeout
+			}
+			util.DebugLog("idle timeout reset by terminal activity, %s remaining", remaining.Round(time.Second))
+			idleTimer.Reset(remaining)
+
 		case <-c.ctx.Done():
 			return c.ctx.Err()
 		}
 	}
 }
 
+// nextOrigin moves on to the next failover origin, after the one in use could
+// not be reached.
+func (c *Controller) nextOrigin() {
+	if len(c.origins) < 2 {
+		return
+	}
+	c.origin = (c.origin + 1) % len(c.origins)
+	util.Warnf("switching to server %s", c.origins[c.origin])
+}
+
+// reconnect replaces the transport with a fresh connection to the origin in
+// use according to the configured ReconnectPolicy.
+func (c *Controller) reconnect() error {
+	c.transport.Cleanup()
+
+	origin := c.origins[c.origin]
+	if c.config.Reconnect == ReconnectFallback {
+		util.DebugLog("Reconnecting with transport fallback: %s", origin)
+		result, err := transport.ConnectWithFallback(origin, c.config.Name, c.connConfig)
+		if err != nil {
+			return fmt.Errorf("failed to reconnect: %w", err)
+		}
+		c.transport = result.Transport
+		c.setConnectionMethod(result.Method)
+		return nil
+	}
+
+	// The remembered transport is given up on when gRPC came back, or when
+	// it failed repeatedly and the other one may still get through
+	method := c.connectionMethod
+	if c.switchTransport || (c.canSwitchTransport() && c.failures >= transportSwitchFailures) {
+		method = otherMethod(method)
+	}
+	c.switchTransport = false
+
+	switch method {
+	case transport.MethodWebSocketFallback:
+		// Reconnect using the specific transport type that worked initially
+		wsURL := c.connConfig.WebSocketURL(origin, c.config.Name)
+		util.DebugLog("Reconnecting via WebSocket (remembered preference): %s", wsURL)
+		newTransport, err := transport.ConnectWebSocketWithConfig(wsURL, c.connConfig)
+		if err != nil {
+			return fmt.Errorf("failed to reconnect via WebSocket: %w", err)
+		}
+		c.transport = newTransport
+
+	case transport.MethodGrpc:
+		util.DebugLog("Reconnecting via gRPC (remembered preference): %s", origin)
+		newTransport, err := transport.ConnectGrpcWithConfig(origin, c.connConfig)
+		if err != nil {
+			return fmt.Errorf("failed to reconnect via gRPC: %w", err)
+		}
+		c.transport = newTransport
+	}
+
+	c.setConnectionMethod(method)
+	return nil
+}
+
+// canSwitchTransport reports whether reconnects may move the session between
+// gRPC and WebSocket on their own, which remembering the first transport and
+// allowing both of them leaves to the controller.
+func (c *Controller) canSwitchTransport() bool {
+	return c.config.Reconnect == ReconnectRemembered && c.connConfig.Preference == transport.PreferAuto
+}
+
+// setConnectionMethod records the transport connected with, logging and
+// counting a switch from the previous one.
+func (c *Controller) setConnectionMethod(method transport.ConnectionMethod) {
+	if method == c.connectionMethod {
+		return
+	}
+	util.Infof("Switched from %s to %s transport", c.connectionMethod, method)
+	c.stats.recordTransportSwitch()
+
+	c.methodMu.Lock()
+	c.connectionMethod = method
+	c.methodMu.Unlock()
+}
+
+// otherMethod returns the transport to try instead of method.
+func otherMethod(method transport.ConnectionMethod) transport.ConnectionMethod {
+	if method == transport.MethodGrpc {
+		return transport.MethodWebSocketFallback
+	}
+	return transport.MethodGrpc
+}
+
 // handleServerMessage processes a message received from the server.
 // This matches the Rust message handling logic exactly.
 func (c *Controller) handleServerMessage(msg *proto.ServerUpdate) error {
 	switch serverMsg := msg.ServerMessage.(type) {
 	case *proto.ServerUpdate_Input:
 		// Decrypt input data - matches Rust implementation exactly
-		util.DebugLog("CONTROLLER[%s]: Received Input - id=%d, offset=%d, encrypted_len=%d, encrypted_data=%v", 
-			c.transport.ConnectionType(), serverMsg.Input.Id, serverMsg.Input.Offset, 
-			len(serverMsg.Input.Data), serverMsg.Input.Data)
-		
-		data := c.encrypt.Segment(0x200000000, serverMsg.Input.Offset, serverMsg.Input.Data)
-		
-		util.DebugLog("CONTROLLER[%s]: Decrypted Input - id=%d, decrypted_len=%d, decrypted_data=%q, raw=%v", 
-			c.transport.ConnectionType(), serverMsg.Input.Id, len(data), string(data), data)
-		
-		c.shellsMu.RLock()
-		if sender, ok := c.shellsTx[serverMsg.Input.Id]; ok {
-			select {
-			case sender <- ShellData{Type: ShellDataTypeData, Data: data}:
-				util.DebugLog("CONTROLLER[%s]: Sent data to shell %d", c.transport.ConnectionType(), serverMsg.Input.Id)
-			default:
-				log.Printf("shell %d channel full, dropping input", serverMsg.Input.Id)
-			}
-		} else {
-			log.Printf("received data for non-existing shell %d", serverMsg.Input.Id)
+		util.DebugLog("CONTROLLER[%s]: Received Input - id=%d, offset=%d, len=%d",
+			c.transport.ConnectionType(), serverMsg.Input.Id, serverMsg.Input.Offset, len(serverMsg.Input.Data))
+
+		// The message is not used again, so its data is decrypted in place
+		data := c.encrypt.SegmentInto(serverMsg.Input.Data, 0x200000000, serverMsg.Input.Offset, serverMsg.Input.Data)
+
+		c.lastActivity.Store(time.Now().UnixNano())
+
+		// Input for a busy shell is buffered rather than dropped, unless the
+		// host locked the shell
+		c.shellsMu.Lock()
+		if c.dropLockedInput(serverMsg.Input.Id) {
+			c.shellsMu.Unlock()
+			break
 		}
-		c.shellsMu.RUnlock()
+		c.queueInput(serverMsg.Input.Id, data)
+		c.shellsMu.Unlock()
 
 	case *proto.ServerUpdate_CreateShell:
 		id := serverMsg.CreateShell.Id
 		center := [2]int32{serverMsg.CreateShell.X, serverMsg.CreateShell.Y}
 
 		c.shellsMu.Lock()
-		if _, exists := c.shellsTx[id]; !exists {
-			c.spawnShellTask(id, center)
+		refused := false
+		if _, exists := c.shellsTx[id]; exists {
+			util.Warnf("server asked to create duplicate shell %d", id)
+		} else if c.shellLimitReached() {
+			refused = true
 		} else {
-			log.Printf("server asked to create duplicate shell %d", id)
+			c.spawnShellTask(id, center)
 		}
 		c.shellsMu.Unlock()
 
+		if refused {
+			util.Warnf("refused to create shell %d: %d shells are already open", id, c.config.MaxShells)
+			select {
+			case c.outputRx <- ClientMessage{
+				Type:  ClientMessageTypeError,
+				Error: fmt.Sprintf("shell %d: limit of %d shells reached", id, c.config.MaxShells),
+			}:
+			case <-c.ctx.Done():
+			}
+		}
+
 	case *proto.ServerUpdate_CloseShell:
 		id := serverMsg.CloseShell
 		c.shellsMu.Lock()
@@ -338,6 +817,9 @@ func (c *Controller) handleServerMessage(msg *proto.ServerUpdate) error {
 			close(ch)
 			delete(c.shellsTx, id)
 		}
+		delete(c.shellSizes, id)
+		delete(c.pendingInput, id)
+		delete(c.lockedShells, id)
 		c.shellsMu.Unlock()
 
 		// Send acknowledgment - matches Rust send_msg().await?
@@ -359,7 +841,7 @@ func (c *Controller) handleServerMessage(msg *proto.ServerUpdate) error {
 					// Channel full, skip sync
 				}
 			} else {
-				log.Printf("received sequence number for non-existing shell %d", id)
+				util.Warnf("received sequence number for non-existing shell %d", id)
 				// Send close acknowledgment for non-existing shell - matches Rust send_msg().await?
 				select {
 				case c.outputRx <- ClientMessage{
@@ -373,8 +855,9 @@ func (c *Controller) handleServerMessage(msg *proto.ServerUpdate) error {
 		}
 
 	case *proto.ServerUpdate_Resize:
-		c.shellsMu.RLock()
+		c.shellsMu.Lock()
 		if sender, ok := c.shellsTx[serverMsg.Resize.Id]; ok {
+			c.shellSizes[serverMsg.Resize.Id] = [2]uint32{serverMsg.Resize.Rows, serverMsg.Resize.Cols}
 			select {
 			case sender <- ShellData{
 				Type: ShellDataTypeSize,
@@ -385,11 +868,13 @@ func (c *Controller) handleServerMessage(msg *proto.ServerUpdate) error {
 				// Channel full, skip resize
 			}
 		} else {
-			log.Printf("received resize for non-existing shell %d", serverMsg.Resize.Id)
+			util.Warnf("received resize for non-existing shell %d", serverMsg.Resize.Id)
 		}
-		c.shellsMu.RUnlock()
+		c.shellsMu.Unlock()
 
 	case *proto.ServerUpdate_Ping:
+		c.stats.recordPing()
+
 		// Echo back the timestamp for latency measurement
 		// Block until send succeeds, matching Rust send_msg().await?
 		select {
@@ -401,22 +886,120 @@ func (c *Controller) handleServerMessage(msg *proto.ServerUpdate) error {
 		}
 
 	case *proto.ServerUpdate_Error:
-		log.Printf("error received from server: %s", serverMsg.Error)
+		util.Errorf("error received from server: %s", serverMsg.Error)
+		c.events.emit(Event{Type: EventServerError, Err: errors.New(serverMsg.Error)})
 	}
 
 	return nil
 }
 
+// hasPendingInput reports whether any shell has buffered input.
+func (c *Controller) hasPendingInput() bool {
+	c.shellsMu.RLock()
+	defer c.shellsMu.RUnlock()
+	return len(c.pendingInput) > 0
+}
+
+// resumeShells notifies every running shell that the channel was re-established.
+func (c *Controller) resumeShells() {
+	c.shellsMu.RLock()
+	defer c.shellsMu.RUnlock()
+
+	for id, sender := range c.shellsTx {
+		size := c.shellSizes[id]
+		select {
+		case sender <- ShellData{Type: ShellDataTypeResume, Rows: size[0], Cols: size[1]}:
+			util.DebugLog("resuming shell %d after reconnect", id)
+		default:
+			// Channel full; the shell falls back to the regular sync logic
+			util.Warnf("shell %d channel full, skipping resume", id)
+		}
+	}
+}
+
+// Snapshot returns the recent output of every running shell, keyed by shell
+// ID, so hosts can keep what collaborators saw. Each shell keeps at least the
+// last ControllerConfig.Content.Rolling bytes, 8 MiB by default. Shells that
+// do not answer before ctx is done are left out.
+func (c *Controller) Snapshot(ctx context.Context) map[uint32][]byte {
+	replies := make(map[uint32]chan []byte)
+	c.shellsMu.RLock()
+	for id, sender := range c.shellsTx {
+		reply := make(chan []byte, 1)
+		select {
+		case sender <- ShellData{Type: ShellDataTypeSnapshot, Snapshot: reply}:
+			replies[id] = reply
+		default:
+			util.Warnf("shell %d channel full, skipping snapshot", id)
+		}
+	}
+	c.shellsMu.RUnlock()
+
+	snapshot := make(map[uint32][]byte, len(replies))
+	for id, reply := range replies {
+		select {
+		case data := <-reply:
+			snapshot[id] = data
+		case <-ctx.Done():
+			return snapshot
+		}
+	}
+	return snapshot
+}
+
+// shellLimitReached reports whether users already have
+// ControllerConfig.MaxShells shells open. The host's shell does not count.
+// Must be called with shellsMu held.
+func (c *Controller) shellLimitReached() bool {
+	if c.config.MaxShells <= 0 {
+		return false
+	}
+	open := len(c.shellsTx)
+	if _, ok := c.shellsTx[HostShellID]; ok {
+		open--
+	}
+	return open >= c.config.MaxShells
+}
+
 // spawnShellTask starts a new terminal task on the client.
 // This matches the Rust Controller::spawn_shell_task method exactly.
 func (c *Controller) spawnShellTask(id uint32, center [2]int32) {
 	shellTx := make(chan ShellData, 16) // Same buffer size as Rust
 	c.shellsTx[id] = shellTx
+	stats := new(shellCounters)
+	c.shellStats[id] = stats
+	shellTx <- ShellData{Type: ShellDataTypeStats, Stats: stats}
+	if c.config.Content != (ContentSizes{}) {
+		shellTx <- ShellData{Type: ShellDataTypeContentSizes, Content: c.config.Content}
+	}
+	if w, ok := c.mirrors[id]; ok {
+		shellTx <- ShellData{Type: ShellDataTypeMirror, Mirror: w}
+	}
+	if size, ok := c.shellSizes[id]; ok {
+		shellTx <- ShellData{Type: ShellDataTypeSize, Rows: size[0], Cols: size[1]}
+	}
+	c.events.emit(Event{Type: EventShellCreated, Shell: id})
+	if !c.spawnedShell {
+		c.spawnedShell = true
+		c.firstShell = id
+		// The terminal holds the command until the shell is ready to read it
+		if c.config.OnStart != "" {
+			shellTx <- ShellData{Type: ShellDataTypeData, Data: []byte(c.config.OnStart + "\r")}
+		}
+	}
 
 	go func() {
+		var runErr error
 		defer func() {
+			c.events.emit(Event{Type: EventShellClosed, Shell: id, Err: runErr})
 			c.shellsMu.Lock()
 			delete(c.shellsTx, id)
+			delete(c.shellSizes, id)
+			delete(c.pendingInput, id)
+			delete(c.lockedShells, id)
+			delete(c.mirrors, id)
+			delete(c.shellStats, id)
+			exited := c.shellExitReached(id)
 			c.shellsMu.Unlock()
 
 			// Block until send succeeds, matching Rust output_tx.send().await.ok()
@@ -427,6 +1010,10 @@ func (c *Controller) spawnShellTask(id uint32, center [2]int32) {
 			}:
 			case <-c.ctx.Done():
 			}
+
+			if exited {
+				c.exitOnce.Do(func() { close(c.shellsExited) })
+			}
 		}()
 
 		util.DebugLog("spawning new shell %d using %s transport", id, c.transport.ConnectionType())
@@ -450,6 +1037,7 @@ func (c *Controller) spawnShellTask(id uint32, center [2]int32) {
 		// Run the shell
 		if err := c.config.Runner.Run(c.ctx, id, c.encrypt, shellTx, c.outputRx); err != nil {
 			if c.ctx.Err() == nil { // Only send error if not due to context cancellation
+				runErr = err
 				errMsg := ClientMessage{
 					Type:  ClientMessageTypeError,
 					Error: fmt.Sprintf("shell %d: %v", id, err),
@@ -464,6 +1052,25 @@ func (c *Controller) spawnShellTask(id uint32, center [2]int32) {
 	}()
 }
 
+// idleRemaining returns how long until ControllerConfig.IdleTimeout elapses
+// without terminal activity, negative once it has.
+func (c *Controller) idleRemaining() time.Duration {
+	return c.config.IdleTimeout - time.Since(time.Unix(0, c.lastActivity.Load()))
+}
+
+// shellExitReached reports whether shell id exiting should end the session
+// under the configured ShellExitPolicy. The caller must hold shellsMu.
+func (c *Controller) shellExitReached(id uint32) bool {
+	switch c.config.ShellExit {
+	case ShellExitLast:
+		return len(c.shellsTx) == 0
+	case ShellExitFirst:
+		return id == c.firstShell
+	default:
+		return false
+	}
+}
+
 // clientMessageToUpdate converts a ClientMessage to a ClientUpdate protobuf message.
 func (c *Controller) clientMessageToUpdate(msg ClientMessage) *proto.ClientUpdate {
 	switch msg.Type {
@@ -472,9 +1079,8 @@ func (c *Controller) clientMessageToUpdate(msg ClientMessage) *proto.ClientUpdat
 			ClientMessage: &proto.ClientUpdate_Hello{Hello: msg.Hello},
 		}
 	case ClientMessageTypeData:
-		util.DebugLog("CONTROLLER[%s]: Sending outbound Data - id=%d, len=%d, data=%q, raw=%v, seq=%d", 
-			c.transport.ConnectionType(), msg.Data.ID, len(msg.Data.Data), 
-			string(msg.Data.Data), msg.Data.Data, msg.Data.Seq)
+		util.DebugLog("CONTROLLER[%s]: Sending outbound Data - id=%d, len=%d, seq=%d",
+			c.transport.ConnectionType(), msg.Data.ID, len(msg.Data.Data), msg.Data.Seq)
 		
 		return &proto.ClientUpdate{
 			ClientMessage: &proto.ClientUpdate_Data{
@@ -511,6 +1117,7 @@ func (c *Controller) clientMessageToUpdate(msg ClientMessage) *proto.ClientUpdat
 // Close terminates this session gracefully.
 // This matches the Rust Controller::close method exactly.
 func (c *Controller) Close() error {
+	c.closing.Store(true)
 	defer c.cancel()
 	defer c.transport.Cleanup()
 
@@ -519,10 +1126,8 @@ func (c *Controller) Close() error {
 		Token: c.token,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	err := c.transport.Close(ctx, req)
+	// The transport bounds the request by ConnectionConfig.CloseTimeout
+	err := c.transport.Close(context.Background(), req)
 	if err != nil {
 		return fmt.Errorf("failed to close session: %w", err)
 	}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"sshx-go/internal/sshxtest"
+	"sshx-go/pkg/encrypt"
+	"sshx-go/pkg/proto"
+	"sshx-go/pkg/transport"
+)
+
+// typeInput sends data to shell id as a user typing at offset.
+func typeInput(t *testing.T, controller *Controller, session *sshxtest.Session, id uint32, offset uint64, data string) {
+	t.Helper()
+	encrypted := encrypt.New(controller.EncryptionKey()).Segment(0x200000000, offset, []byte(data))
+	err := session.Send(&proto.ServerUpdate{
+		ServerMessage: &proto.ServerUpdate_Input{Input: &proto.TerminalInput{Id: id, Data: encrypted, Offset: offset}},
+	})
+	if err != nil {
+		t.Fatalf("failed to send input: %v", err)
+	}
+}
+
+// TestEchoSession types into a shell over each transport and reads back
+// what it echoes.
+func TestEchoSession(t *testing.T) {
+	for _, tt := range testTransports {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, sshxtest.Options{})
+			controller, session := startController(t, server, tt.preference, ControllerConfig{Runner: &EchoRunner{}})
+			if got := session.Transports(); !slices.Equal(got, []string{tt.name}) {
+				t.Fatalf("connected over %v, want %s", got, tt.name)
+			}
+
+			createShell(t, session, 1)
+			typeInput(t, controller, session, 1, 0, "hello ")
+			typeInput(t, controller, session, 1, 6, "world")
+			if got := waitOutput(t, controller, session, 1, 11); string(got) != "hello world" {
+				t.Fatalf("got output %q, want %q", got, "hello world")
+			}
+		})
+	}
+}
+
+// TestFallbackToWebSocket checks the controller opens its session over
+// WebSocket when the server rejects gRPC.
+func TestFallbackToWebSocket(t *testing.T) {
+	server := newServer(t, sshxtest.Options{DisableGrpc: true})
+	controller, session := startController(t, server, transport.PreferAuto, ControllerConfig{Runner: &EchoRunner{}})
+	if method := controller.ConnectionMethod(); method != transport.MethodWebSocketFallback {
+		t.Fatalf("connected with %v, want WebSocket", method)
+	}
+
+	createShell(t, session, 1)
+	typeInput(t, controller, session, 1, 0, "fallback")
+	if got := waitOutput(t, controller, session, 1, 8); string(got) != "fallback" {
+		t.Fatalf("got output %q, want %q", got, "fallback")
+	}
+}
+
+// TestCloseSession checks closing the controller closes its session on the
+// server over each transport.
+func TestCloseSession(t *testing.T) {
+	for _, tt := range testTransports {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, sshxtest.Options{})
+			controller, session := startController(t, server, tt.preference, ControllerConfig{Runner: &EchoRunner{}})
+
+			if err := controller.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if !session.Closed() {
+				t.Fatal("session is still open after Close")
+			}
+		})
+	}
+}
+
+// TestCloseShell checks a shell closed by a user is acknowledged, so the
+// server forgets it.
+func TestCloseShell(t *testing.T) {
+	server := newServer(t, sshxtest.Options{})
+	_, session := startController(t, server, transport.PreferGrpc, ControllerConfig{Runner: &EchoRunner{}})
+	createShell(t, session, 1)
+
+	waitUpdate(t, session, func(u *proto.ClientUpdate) bool { return u.GetCreatedShell() != nil })
+	err := session.Send(&proto.ServerUpdate{ServerMessage: &proto.ServerUpdate_CloseShell{CloseShell: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitUpdate(t, session, func(u *proto.ClientUpdate) bool { return u.GetClosedShell() == 1 })
+}
+
+// waitUpdate waits for a message from the client matching match.
+func waitUpdate(t *testing.T, session *sshxtest.Session, match func(*proto.ClientUpdate) bool) *proto.ClientUpdate {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case update := <-session.Updates():
+			if match(update) {
+				return update
+			}
+		case <-timeout:
+			t.Fatal("client did not send the expected update")
+		}
+	}
+}
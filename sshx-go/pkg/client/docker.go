@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"sshx-go/pkg/docker"
+	"sshx-go/pkg/encrypt"
+)
+
+// dockerTimeout bounds each call to the Docker daemon made outside a shell's
+// stream, such as starting an exec or resizing its TTY.
+const dockerTimeout = 10 * time.Second
+
+// DefaultDockerShell starts bash in containers that have it, or else sh.
+var DefaultDockerShell = []string{"/bin/sh", "-c", "if command -v bash >/dev/null 2>&1; then exec bash; fi; exec sh"}
+
+// DockerRunner implements a variant that runs each shell inside a Docker
+// container, as docker exec -it does, through the Docker Engine API. Users
+// share the container without the host being entered first.
+//
+// Docker does not stop an exec when its client disconnects, so the shell of
+// a pane closed by a user keeps running until it exits on its own.
+type DockerRunner struct {
+	// Client connects to the Docker daemon.
+	Client *docker.Client
+	// Container is the name or ID of a running container.
+	Container string
+	// Command is run in the container. Empty uses DefaultDockerShell.
+	Command []string
+	// Env holds extra KEY=VALUE variables for each shell.
+	Env []string
+	// Dir is the working directory in the container. Empty uses the container's.
+	Dir string
+	// Limiter caps the rate of output sent to the server when non-nil.
+	Limiter *OutputLimiter
+	// Sanitize selects escape sequences removed from the output.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how output that is not valid UTF-8 is sent.
+	InvalidUTF8 InvalidUTF8Policy
+}
+
+// Check reports an error if the container does not exist or is not running,
+// so a mistyped name fails before the session is shared rather than in every
+// pane.
+func (dr *DockerRunner) Check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, dockerTimeout)
+	defer cancel()
+
+	container, err := dr.Client.Inspect(ctx, dr.Container)
+	if err != nil {
+		return fmt.Errorf("container %q not available: %w", dr.Container, err)
+	}
+	if !container.State.Running {
+		return fmt.Errorf("container %q is not running", dr.Container)
+	}
+	return nil
+}
+
+// Run implements the Runner interface for DockerRunner.
+func (dr *DockerRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	command := dr.Command
+	if len(command) == 0 {
+		command = DefaultDockerShell
+	}
+
+	startCtx, cancel := context.WithTimeout(ctx, dockerTimeout)
+	exec, err := dr.Client.Exec(startCtx, dr.Container, docker.ExecConfig{
+		Cmd:        command,
+		Env:        append([]string{"TERM=xterm-256color", "COLORTERM=truecolor", "TERM_PROGRAM=sshx"}, dr.Env...),
+		WorkingDir: dr.Dir,
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("failed to start shell in container %q: %w", dr.Container, err)
+	}
+	return terminalTask(ctx, id, encrypt, &dockerTerminal{exec: exec}, nil, dr.Limiter, dr.Sanitize, dr.InvalidUTF8, shellRx, outputTx)
+}
+
+// dockerTerminal adapts a Docker exec to the terminal driven by terminalTask.
+type dockerTerminal struct {
+	exec *docker.Exec
+}
+
+func (t *dockerTerminal) Read(p []byte) (int, error)  { return t.exec.Read(p) }
+func (t *dockerTerminal) Write(p []byte) (int, error) { return t.exec.Write(p) }
+func (t *dockerTerminal) Close() error                { return t.exec.Close() }
+
+// SetWinsize sets the window size of the exec's TTY.
+func (t *dockerTerminal) SetWinsize(rows, cols uint16) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dockerTimeout)
+	defer cancel()
+	return t.exec.Resize(ctx, rows, cols)
+}
+
+// ResizeNotify applies the size right away, as a local daemon does so
+// quickly enough not to need coalescing.
+func (t *dockerTerminal) ResizeNotify(rows, cols uint16) error {
+	return t.SetWinsize(rows, cols)
+}
+
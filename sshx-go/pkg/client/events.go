@@ -0,0 +1,89 @@
+package client
+
+import (
+	"time"
+
+	"sshx-go/pkg/transport"
+)
+
+// eventBuffer is how many events Events holds for a slow reader before newer
+// ones are dropped.
+const eventBuffer = 256
+
+// EventType is the kind of an Event.
+type EventType int
+
+const (
+	// EventConnected is sent whenever the channel to the server is
+	// established, including after the periodic reconnect.
+	EventConnected EventType = iota
+	// EventDisconnected is sent when the channel failed and Run retries.
+	EventDisconnected
+	// EventShellCreated is sent when a shell starts.
+	EventShellCreated
+	// EventShellClosed is sent when a shell exits or is closed.
+	EventShellClosed
+	// EventServerError is sent when the server reports an error.
+	EventServerError
+	// EventLatency is sent for every round-trip time measured.
+	EventLatency
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventShellCreated:
+		return "shell_created"
+	case EventShellClosed:
+		return "shell_closed"
+	case EventServerError:
+		return "server_error"
+	case EventLatency:
+		return "latency"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is something that happened to a controller, as sent on Events.
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Transport is the transport connected with, for EventConnected.
+	Transport transport.ConnectionMethod
+	// Reconnect reports whether the channel was established before, for
+	// EventConnected.
+	Reconnect bool
+	// Shell is the shell created or closed.
+	Shell uint32
+	// Err is why the channel failed for EventDisconnected, the shell's
+	// failure for EventShellClosed if it did not exit cleanly, and the
+	// server's message for EventServerError.
+	Err error
+	// RTT is the round-trip time measured, for EventLatency.
+	RTT time.Duration
+}
+
+// eventQueue delivers events to Events without ever blocking the controller.
+type eventQueue chan Event
+
+// emit sends e stamped with the current time, dropping it if the reader
+// fell behind.
+func (q eventQueue) emit(e Event) {
+	e.Time = time.Now()
+	select {
+	case q <- e:
+	default:
+	}
+}
+
+// Events returns the channel the controller sends its events on, so callers
+// can react to them without parsing logs. Events are dropped rather than
+// delaying the session when 256 of them wait to be read. The channel is
+// never closed.
+func (c *Controller) Events() <-chan Event {
+	return c.events
+}
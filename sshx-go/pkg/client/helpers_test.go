@@ -0,0 +1,95 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"sshx-go/internal/sshxtest"
+	"sshx-go/pkg/encrypt"
+	"sshx-go/pkg/proto"
+	"sshx-go/pkg/transport"
+)
+
+// testTimeout bounds each wait of the tests on the mock server.
+const testTimeout = 30 * time.Second
+
+// testTransports are the transports tests connect with, by name.
+var testTransports = []struct {
+	name       string
+	preference transport.TransportPreference
+}{
+	{"grpc", transport.PreferGrpc},
+	{"websocket", transport.PreferWebSocket},
+}
+
+// newServer starts a mock server until the test ends.
+func newServer(t *testing.T, opts sshxtest.Options) *sshxtest.Server {
+	t.Helper()
+	server, err := sshxtest.NewServerWithOptions(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+// startController opens a session on server with config over the transport
+// chosen by preference, running the controller until the test ends.
+func startController(t *testing.T, server *sshxtest.Server, preference transport.TransportPreference, config ControllerConfig) (*Controller, *sshxtest.Session) {
+	t.Helper()
+	config.Origin = server.URL
+	if config.Name == "" {
+		config.Name = "test"
+	}
+	connConfig := transport.DefaultConnectionConfig()
+	connConfig.Preference = preference
+
+	controller, err := NewControllerWithConnection(config, connConfig)
+	if err != nil {
+		t.Fatalf("failed to open session: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		controller.Run()
+	}()
+	t.Cleanup(func() {
+		controller.Close()
+		<-done
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	session, err := server.Session(ctx, controller.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.WaitConnections(ctx, 1); err != nil {
+		t.Fatalf("controller did not connect: %v", err)
+	}
+	return controller, session
+}
+
+// createShell asks the controller to create shell id, as a user would.
+func createShell(t *testing.T, session *sshxtest.Session, id uint32) {
+	t.Helper()
+	err := session.Send(&proto.ServerUpdate{
+		ServerMessage: &proto.ServerUpdate_CreateShell{CreateShell: &proto.NewShell{Id: id}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create shell: %v", err)
+	}
+}
+
+// waitOutput waits for n bytes of output of shell id and decrypts them.
+func waitOutput(t *testing.T, controller *Controller, session *sshxtest.Session, id uint32, n int) []byte {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+	defer cancel()
+	output, err := session.WaitOutput(ctx, id, n)
+	if err != nil {
+		t.Fatalf("got %d of %d bytes of output: %v", len(output), n, err)
+	}
+	return encrypt.New(controller.EncryptionKey()).Segment(0x100000000|uint64(id), 0, output)
+}
@@ -0,0 +1,146 @@
+package client
+
+import (
+	"fmt"
+	"time"
+
+	"sshx-go/pkg/util"
+)
+
+const (
+	inputBufferBytes   = 1 << 20               // Input buffered per shell before dropping
+	inputChunkBytes    = 4096                  // Deliver buffered input in chunks of this size
+	inputRetryInterval = 10 * time.Millisecond // Retry delivering buffered input this often
+)
+
+// ringBuffer is a fixed-capacity FIFO of bytes.
+type ringBuffer struct {
+	buf  []byte
+	head int // index of the first byte
+	size int // number of bytes stored
+}
+
+func newRingBuffer(capacity int) *ringBuffer {
+	return &ringBuffer{buf: make([]byte, capacity)}
+}
+
+// Len returns the number of bytes stored.
+func (r *ringBuffer) Len() int {
+	return r.size
+}
+
+// Write appends as much of p as fits and returns the number of bytes written.
+func (r *ringBuffer) Write(p []byte) int {
+	n := min(len(p), len(r.buf)-r.size)
+	tail := (r.head + r.size) % len(r.buf)
+	written := copy(r.buf[tail:], p[:n])
+	copy(r.buf, p[written:n])
+	r.size += n
+	return n
+}
+
+// Peek copies up to len(p) bytes from the front without removing them.
+func (r *ringBuffer) Peek(p []byte) int {
+	n := min(len(p), r.size)
+	copied := copy(p[:n], r.buf[r.head:])
+	copy(p[copied:n], r.buf)
+	return n
+}
+
+// Discard removes n bytes from the front.
+func (r *ringBuffer) Discard(n int) {
+	r.head = (r.head + n) % len(r.buf)
+	r.size -= n
+}
+
+// queueInput delivers input to a shell, buffering it behind any input that is
+// already waiting, so keystrokes are delayed rather than dropped while the
+// shell catches up. The caller must hold shellsMu for writing.
+func (c *Controller) queueInput(id uint32, data []byte) {
+	sender, ok := c.shellsTx[id]
+	if !ok {
+		util.Warnf("received data for non-existing shell %d", id)
+		return
+	}
+
+	queue := c.pendingInput[id]
+	if queue == nil {
+		select {
+		case sender <- ShellData{Type: ShellDataTypeData, Data: data}:
+			return
+		default:
+		}
+		util.DebugLog("shell %d channel full, buffering input", id)
+		queue = newRingBuffer(inputBufferBytes)
+		c.pendingInput[id] = queue
+	}
+
+	if n := queue.Write(data); n < len(data) {
+		util.Warnf("shell %d input buffer full, dropping %d bytes", id, len(data)-n)
+	}
+}
+
+// flushInput delivers as much buffered input as the shells will accept.
+// The caller must hold shellsMu for writing.
+func (c *Controller) flushInput() {
+	for id, queue := range c.pendingInput {
+		sender, ok := c.shellsTx[id]
+		if !ok {
+			delete(c.pendingInput, id)
+			continue
+		}
+
+	deliver:
+		for queue.Len() > 0 {
+			chunk := make([]byte, min(queue.Len(), inputChunkBytes))
+			n := queue.Peek(chunk)
+			select {
+			case sender <- ShellData{Type: ShellDataTypeData, Data: chunk[:n]}:
+				queue.Discard(n)
+			default:
+				break deliver
+			}
+		}
+
+		if queue.Len() == 0 {
+			delete(c.pendingInput, id)
+		}
+	}
+}
+
+// LockInput stops delivering users' input to shell id while locked is true, so
+// the host can take exclusive control, e.g. during sensitive operations. Input
+// received meanwhile is dropped, so users' keystrokes have no effect.
+func (c *Controller) LockInput(id uint32, locked bool) error {
+	c.shellsMu.Lock()
+	if _, ok := c.shellsTx[id]; !ok {
+		c.shellsMu.Unlock()
+		return fmt.Errorf("no shell %d", id)
+	}
+	if !locked {
+		delete(c.lockedShells, id)
+	} else if _, ok := c.lockedShells[id]; !ok {
+		c.lockedShells[id] = false
+	}
+	c.shellsMu.Unlock()
+	return nil
+}
+
+// inputLocked reports whether the host locked the input of shell id.
+// The caller must hold shellsMu.
+func (c *Controller) inputLocked(id uint32) bool {
+	_, locked := c.lockedShells[id]
+	return locked
+}
+
+// dropLockedInput reports whether input for shell id must be dropped because
+// the host locked it, logging the first drop of each lock.
+// The caller must hold shellsMu for writing.
+func (c *Controller) dropLockedInput(id uint32) bool {
+	logged, locked := c.lockedShells[id]
+	if locked && !logged {
+		util.Infof("Dropping input to shell %d, which is locked", id)
+		c.lockedShells[id] = true
+	}
+	return locked
+}
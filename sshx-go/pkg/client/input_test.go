@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+// TestSlowShellInput sends a shell that is not reading more input than its
+// channel and buffer hold, then lets it read. Input must be buffered in order
+// up to inputBufferBytes and the rest dropped.
+func TestSlowShellInput(t *testing.T) {
+	shell := make(chan ShellData, 4)
+	c := &Controller{
+		shellsTx:     map[uint32]chan ShellData{1: shell},
+		pendingInput: make(map[uint32]*ringBuffer),
+		lockedShells: make(map[uint32]bool),
+	}
+
+	// Fill the channel, then the buffer, then some more
+	const chunk = 64 << 10
+	var input bytes.Buffer
+	for i := 0; input.Len() < cap(shell)*chunk+inputBufferBytes+4*chunk; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, chunk)
+		input.Write(data)
+		c.shellsMu.Lock()
+		c.queueInput(1, data)
+		c.shellsMu.Unlock()
+	}
+	if got := c.pendingInput[1].Len(); got != inputBufferBytes {
+		t.Fatalf("buffered %d bytes, want %d", got, inputBufferBytes)
+	}
+
+	// The shell reads everything it is given until the buffer is empty
+	var received bytes.Buffer
+	for len(c.pendingInput) > 0 {
+		for len(shell) > 0 {
+			received.Write((<-shell).Data)
+		}
+		c.shellsMu.Lock()
+		c.flushInput()
+		c.shellsMu.Unlock()
+	}
+	for len(shell) > 0 {
+		received.Write((<-shell).Data)
+	}
+
+	want := input.Bytes()[:cap(shell)*chunk+inputBufferBytes]
+	if !bytes.Equal(received.Bytes(), want) {
+		t.Fatalf("shell received %d bytes, want the first %d in order", received.Len(), len(want))
+	}
+}
+
+// TestLockInput checks input to a locked shell is dropped until it is
+// unlocked, without telling the server.
+func TestLockInput(t *testing.T) {
+	c := &Controller{
+		shellsTx:     map[uint32]chan ShellData{1: make(chan ShellData)},
+		pendingInput: make(map[uint32]*ringBuffer),
+		lockedShells: make(map[uint32]bool),
+		outputRx:     make(chan ClientMessage, 1),
+		ctx:          context.Background(),
+	}
+	if err := c.LockInput(2, true); err == nil {
+		t.Fatal("locked a shell that does not exist")
+	}
+	if err := c.LockInput(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if !c.dropLockedInput(1) {
+		t.Fatal("input to a locked shell was not dropped")
+	}
+	if err := c.LockInput(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if c.dropLockedInput(1) {
+		t.Fatal("input to an unlocked shell was dropped")
+	}
+	if len(c.outputRx) > 0 {
+		t.Fatalf("locking sent %v", (<-c.outputRx).Type)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Mirror copies the output of shell id to w, starting with its recent output,
+// so the host can follow the shell on their own terminal. A nil w stops
+// mirroring. A shell that has not started yet, such as the one opened for
+// ControllerConfig.OpenShell before Run, is mirrored from its first byte.
+//
+// Writes to w happen on the shell's task, so a slow w slows the shell down.
+func (c *Controller) Mirror(id uint32, w io.Writer) error {
+	c.shellsMu.Lock()
+	if w == nil {
+		delete(c.mirrors, id)
+	} else {
+		c.mirrors[id] = w
+	}
+	sender, ok := c.shellsTx[id]
+	c.shellsMu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	select {
+	case sender <- ShellData{Type: ShellDataTypeMirror, Mirror: w}:
+		return nil
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+// WriteInput sends data to shell id as if a user typed it. Unlike users'
+// input, it is delivered while the shell is locked, since it comes from the
+// host.
+func (c *Controller) WriteInput(id uint32, data []byte) error {
+	c.shellsMu.Lock()
+	if _, ok := c.shellsTx[id]; !ok {
+		c.shellsMu.Unlock()
+		return fmt.Errorf("no shell %d", id)
+	}
+	c.lastActivity.Store(time.Now().UnixNano())
+	c.queueInput(id, data)
+	c.shellsMu.Unlock()
+	return nil
+}
+
+// Resize sets the window size of shell id, as a user resizing its window
+// does. Whichever resize came last, the host's or a user's, applies. A shell
+// that has not started yet, like in Mirror, gets the size when it starts.
+func (c *Controller) Resize(id uint32, rows, cols uint32) error {
+	c.shellsMu.Lock()
+	defer c.shellsMu.Unlock()
+
+	c.shellSizes[id] = [2]uint32{rows, cols}
+	sender, ok := c.shellsTx[id]
+	if !ok {
+		return nil
+	}
+	select {
+	case sender <- ShellData{Type: ShellDataTypeSize, Rows: rows, Cols: cols}:
+	default:
+		// Channel full, skip resize
+	}
+	return nil
+}
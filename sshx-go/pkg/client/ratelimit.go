@@ -0,0 +1,58 @@
+package client
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// OutputLimiter caps the rate at which terminal output is sent to the server,
+// using a token bucket that holds up to one second of output. It may be
+// shared by the shells of several sessions to cap their combined rate.
+//
+// Output beyond the limit is not dropped: it accumulates in the shell's
+// buffer and is sent in larger chunks as the budget allows, and the shell's
+// program is blocked from writing more once too much is pending.
+type OutputLimiter struct {
+	rate  float64 // bytes per second
+	burst float64 // largest number of tokens held
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// minOutputBurst lets a single chunk carry at least this many bytes, so slow
+// limits still send whole characters and escape sequences together.
+const minOutputBurst = 1024
+
+// NewOutputLimiter creates a limiter allowing bytesPerSecond of output.
+func NewOutputLimiter(bytesPerSecond int) *OutputLimiter {
+	burst := float64(max(bytesPerSecond, minOutputBurst))
+	return &OutputLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// reserve takes up to n bytes from the budget, returning how many may be sent
+// now. When that is zero, wait is how long until a chunk of n bytes, or a
+// full burst if smaller, may be sent, so pending output is coalesced.
+func (l *OutputLimiter) reserve(n int) (allowed int, wait time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	need := math.Min(float64(n), l.burst)
+	if l.tokens < need {
+		return 0, time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+	}
+	allowed = min(n, int(l.tokens))
+	l.tokens -= float64(allowed)
+	return allowed, 0
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"sshx-go/internal/sshxtest"
+	"sshx-go/pkg/encrypt"
+	"sshx-go/pkg/proto"
+	"sshx-go/pkg/transport"
+)
+
+// recordingRunner hands every message routed to a shell to the test.
+type recordingRunner struct {
+	items chan ShellData
+}
+
+func (r *recordingRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	for {
+		select {
+		case item, ok := <-shellRx:
+			if !ok {
+				return nil
+			}
+			r.items <- item
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// next waits for the next message of the given type.
+func (r *recordingRunner) next(t *testing.T, typ ShellDataType) ShellData {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case item := <-r.items:
+			if item.Type == typ {
+				return item
+			}
+		case <-timeout:
+			t.Fatalf("shell got no message of type %d", typ)
+		}
+	}
+}
+
+// TestResumeReplaysSize checks a shell is given its window size again once
+// the connection is back, since the server may have missed resizes meanwhile.
+func TestResumeReplaysSize(t *testing.T) {
+	for _, tt := range testTransports {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, sshxtest.Options{})
+			runner := &recordingRunner{items: make(chan ShellData, 64)}
+			_, session := startController(t, server, tt.preference, ControllerConfig{Runner: runner})
+			createShell(t, session, 1)
+
+			err := session.Send(&proto.ServerUpdate{
+				ServerMessage: &proto.ServerUpdate_Resize{Resize: &proto.TerminalSize{Id: 1, Rows: 31, Cols: 97}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			runner.next(t, ShellDataTypeSize)
+
+			session.Drop()
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			if err := session.WaitConnections(ctx, 2); err != nil {
+				t.Fatalf("controller did not reconnect: %v", err)
+			}
+
+			resume := runner.next(t, ShellDataTypeResume)
+			if resume.Rows != 31 || resume.Cols != 97 {
+				t.Fatalf("shell resumed with size %dx%d, want 31x97", resume.Rows, resume.Cols)
+			}
+		})
+	}
+}
+
+// TestResumeMidOutput loses output in transit before the connection drops,
+// and checks the server ends up with every byte exactly once: both the lost
+// output and output printed while disconnected follow on the new connection,
+// after a single sync rather than waiting for repeated outdated syncs.
+func TestResumeMidOutput(t *testing.T) {
+	script := `i=0; while [ $i -lt 1000 ]; do echo "line $i"; i=$((i+1)); done
+sleep 0.5
+while [ $i -lt 2000 ]; do echo "line $i"; i=$((i+1)); done
+sleep 60`
+	var want bytes.Buffer
+	for i := 0; i < 2000; i++ {
+		fmt.Fprintf(&want, "line %d\r\n", i)
+	}
+
+	for _, tt := range testTransports {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, sshxtest.Options{})
+			runner := &ExecRunner{Command: "/bin/sh", Args: []string{"-c", script}}
+			controller, session := startController(t, server, tt.preference, ControllerConfig{Runner: runner})
+
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			session.Lose()
+			createShell(t, session, 1)
+			if err := session.WaitLost(ctx, 1); err != nil {
+				t.Fatalf("shell printed nothing: %v", err)
+			}
+			session.Drop()
+
+			if err := session.WaitConnections(ctx, 2); err != nil {
+				t.Fatalf("controller did not reconnect: %v", err)
+			}
+			if err := session.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			got := waitOutput(t, controller, session, 1, want.Len())
+			if !bytes.Equal(got, want.Bytes()) {
+				t.Fatalf("output differs after reconnect: got %d bytes, want %d", len(got), want.Len())
+			}
+		})
+	}
+}
+
+// TestReconnectPolicy starts a session over WebSocket while gRPC is down,
+// then checks which transport the controller reconnects with once gRPC is
+// back: the remembered one, or whichever negotiation picks now.
+func TestReconnectPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ReconnectPolicy
+		want   []string
+	}{
+		{"remembered", ReconnectRemembered, []string{"websocket", "websocket"}},
+		{"fallback", ReconnectFallback, []string{"websocket", "grpc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t, sshxtest.Options{DisableGrpc: true})
+			config := ControllerConfig{Runner: &EchoRunner{}, Reconnect: tt.policy}
+			_, session := startController(t, server, transport.PreferAuto, config)
+
+			server.SetGrpcDisabled(false)
+			session.Drop()
+			ctx, cancel := context.WithTimeout(context.Background(), testTimeout)
+			defer cancel()
+			if err := session.WaitConnections(ctx, 2); err != nil {
+				t.Fatalf("controller did not reconnect: %v", err)
+			}
+
+			if got := session.Transports(); !slices.Equal(got, tt.want) {
+				t.Fatalf("connected over %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
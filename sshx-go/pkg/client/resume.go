@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"sshx-go/pkg/proto"
+	"sshx-go/pkg/transport"
+)
+
+// resumeCheckTimeout is how long the server may take to refuse a session
+// being resumed. It refuses unknown sessions right away and otherwise sends
+// its first sync, so a channel still open by then counts as accepted too.
+const resumeCheckTimeout = 3 * time.Second
+
+// Resume identifies an open session and holds its keys, so a controller in a
+// later process can reattach to it with ControllerConfig.Resume, keeping its
+// links, instead of opening a new one.
+type Resume struct {
+	Name          string `json:"name"`
+	Token         string `json:"token"`
+	URL           string `json:"url"` // Without the key
+	Key           string `json:"key"`
+	WritePassword string `json:"writePassword,omitempty"`
+}
+
+// Resume returns what reattaches a later controller to this session.
+func (c *Controller) Resume() Resume {
+	resume := Resume{
+		Name:  c.name,
+		Token: c.token,
+		URL:   c.sessionURL,
+		Key:   c.encryptionKey,
+	}
+	if writeURL := c.WriteURL(); writeURL != nil {
+		resume.WritePassword = (*writeURL)[strings.LastIndex(*writeURL, ",")+1:]
+	}
+	return resume
+}
+
+// resumeSession reattaches to the session of resume on the first of origins
+// that accepts it, returning the connection like OpenWithFallback would.
+func resumeSession(resume Resume, config ControllerConfig, origins []string, connConfig transport.ConnectionConfig) (*transport.ConnectionResult, int, error) {
+	if config.Password != "" && config.Password != resume.Key {
+		return nil, 0, errors.New("the session has another password")
+	}
+	if config.EnableReaders != (resume.WritePassword != "") {
+		return nil, 0, errors.New("read-only links were enabled differently")
+	}
+
+	var err error
+	for i, origin := range origins {
+		var result *transport.ConnectionResult
+		result, err = transport.ConnectWithFallback(origin, resume.Name, connConfig)
+		if err != nil {
+			continue
+		}
+		if err = checkSession(result.Transport, resume.Name, resume.Token); err != nil {
+			result.Transport.Cleanup()
+			continue
+		}
+		result.Session = &proto.OpenResponse{Name: resume.Name, Token: resume.Token, Url: resume.URL}
+		return result, i, nil
+	}
+	return nil, 0, err
+}
+
+// CloseSession closes the session of resume, which an earlier process left
+// open, on the first of origins that can be reached.
+func CloseSession(resume Resume, origins []string, connConfig transport.ConnectionConfig) error {
+	var err error
+	for _, origin := range origins {
+		var result *transport.ConnectionResult
+		result, err = transport.ConnectWithFallback(origin, resume.Name, connConfig)
+		if err != nil {
+			continue
+		}
+		// The transport bounds the request by ConnectionConfig.CloseTimeout
+		err = result.Transport.Close(context.Background(), &proto.CloseRequest{Name: resume.Name, Token: resume.Token})
+		result.Transport.Cleanup()
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to close session: %w", err)
+}
+
+// checkSession opens a channel to session name over t, which Run replaces
+// later, and reports whether the server accepted the token.
+func checkSession(t transport.SshxTransport, name, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resumeCheckTimeout)
+	defer cancel()
+
+	updates, send, err := t.Channel(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to create channel: %w", err)
+	}
+	select {
+	case send <- &proto.ClientUpdate{ClientMessage: &proto.ClientUpdate_Hello{Hello: name + "," + token}}:
+	case <-ctx.Done():
+		return fmt.Errorf("failed to send hello: %w", ctx.Err())
+	}
+
+	select {
+	case update, ok := <-updates:
+		if !ok {
+			return errors.New("the server refused the session")
+		}
+		if msg, isErr := update.ServerMessage.(*proto.ServerUpdate_Error); isErr {
+			return fmt.Errorf("the server refused the session: %s", msg.Error)
+		}
+		return nil
+	case <-ctx.Done():
+		return nil
+	}
+}
@@ -3,23 +3,33 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
-	"strings"
-	"unicode/utf8"
+	"sync"
+	"syscall"
+	"time"
 
 	"sshx-go/pkg/encrypt"
 	"sshx-go/pkg/proto"
 	"sshx-go/pkg/terminal"
+	"sshx-go/pkg/util"
 )
 
 const (
-	contentChunkSize    = 1 << 16  // Send at most this many bytes at a time
-	contentRollingBytes = 8 << 20  // Store at least this much content
-	contentPruneBytes   = 12 << 20 // Prune when we exceed this length
+	contentChunkSize    = 1 << 16 // Send at most this many bytes at a time, by default
+	contentRollingBytes = 8 << 20 // Store at least this much content, by default
 )
 
+// readBuffers holds the buffers terminal output is read into, so sustained
+// output does not allocate one per read.
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 4096) // same size as Rust
+		return &buf
+	},
+}
+
 // Runner variants define different terminal behaviors.
 type Runner interface {
 	Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error
@@ -28,6 +38,55 @@ type Runner interface {
 // ShellRunner implements the shell variant that spawns a subprocess.
 type ShellRunner struct {
 	Shell string
+	// Args are passed to the shell, e.g. "-l" to start a login shell.
+	Args []string
+	// RunAs starts shells as another, typically unprivileged, user when non-nil.
+	RunAs *terminal.RunAs
+	// Env holds extra KEY=VALUE variables for each shell.
+	Env []string
+	// Dir is the working directory of each shell. Empty inherits the current one.
+	Dir string
+	// Limits caps the resources of each shell when non-nil.
+	Limits *terminal.Limits
+	// KillGrace is how long a closing shell's processes get to exit after
+	// each signal. Zero uses terminal.DefaultKillGrace.
+	KillGrace time.Duration
+	// Restart starts a shell again in the same pane when it exits, instead
+	// of closing the pane.
+	Restart bool
+	// Limiter caps the rate of output sent to the server when non-nil.
+	Limiter *OutputLimiter
+	// Sanitize selects escape sequences removed from the output.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how output that is not valid UTF-8 is sent.
+	InvalidUTF8 InvalidUTF8Policy
+}
+
+// ExecRunner implements a variant that runs an arbitrary program instead of an
+// interactive shell. The pane closes when the program exits, unless Restart is set.
+type ExecRunner struct {
+	Command string
+	Args    []string
+	// RunAs starts the program as another, typically unprivileged, user when non-nil.
+	RunAs *terminal.RunAs
+	// Env holds extra KEY=VALUE variables for each program.
+	Env []string
+	// Dir is the working directory of each program. Empty inherits the current one.
+	Dir string
+	// Limits caps the resources of each program when non-nil.
+	Limits *terminal.Limits
+	// KillGrace is how long a closing program's processes get to exit after
+	// each signal. Zero uses terminal.DefaultKillGrace.
+	KillGrace time.Duration
+	// Restart starts the program again in the same pane when it exits,
+	// instead of closing the pane.
+	Restart bool
+	// Limiter caps the rate of output sent to the server when non-nil.
+	Limiter *OutputLimiter
+	// Sanitize selects escape sequences removed from the output.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how output that is not valid UTF-8 is sent.
+	InvalidUTF8 InvalidUTF8Policy
 }
 
 // EchoRunner implements a mock runner that echoes input, useful for testing.
@@ -40,6 +99,14 @@ type ShellData struct {
 	Seq  uint64
 	Rows uint32
 	Cols uint32
+	// Snapshot receives the shell's recent output for ShellDataTypeSnapshot.
+	Snapshot chan<- []byte
+	// Mirror receives the shell's output for ShellDataTypeMirror.
+	Mirror io.Writer
+	// Stats receives the shell's I/O statistics for ShellDataTypeStats.
+	Stats *shellCounters
+	// Content holds the sizes for ShellDataTypeContentSizes.
+	Content ContentSizes
 }
 
 type ShellDataType int
@@ -48,6 +115,22 @@ const (
 	ShellDataTypeData ShellDataType = iota
 	ShellDataTypeSync
 	ShellDataTypeSize
+	// ShellDataTypeResume is sent after the transport reconnects. Rows and Cols
+	// carry the last known window size, or zero if none was received.
+	ShellDataTypeResume
+	// ShellDataTypeSnapshot asks for the shell's recent output, up to
+	// ContentSizes.Rolling, to be sent on Snapshot.
+	ShellDataTypeSnapshot
+	// ShellDataTypeMirror asks for the shell's recent output, then all
+	// further output, to be written to Mirror, or for mirroring to stop if
+	// Mirror is nil.
+	ShellDataTypeMirror
+	// ShellDataTypeStats asks for the shell's I/O statistics to be recorded
+	// in Stats from then on.
+	ShellDataTypeStats
+	// ShellDataTypeContentSizes replaces the default sizes of the shell's
+	// output buffer with Content.
+	ShellDataTypeContentSizes
 )
 
 // ClientMessage represents messages sent from client to server.
@@ -82,7 +165,14 @@ type TerminalData struct {
 // Run implements the Runner interface for ShellRunner.
 // This matches the Rust shell_task function exactly.
 func (sr *ShellRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
-	return shellTask(ctx, id, encrypt, sr.Shell, shellRx, outputTx)
+	argv := append([]string{sr.Shell}, sr.Args...)
+	return shellTask(ctx, id, encrypt, argv, terminal.Options{RunAs: sr.RunAs, Env: sr.Env, Dir: sr.Dir, Limits: sr.Limits, KillGrace: sr.KillGrace}, sr.Restart, sr.Limiter, sr.Sanitize, sr.InvalidUTF8, shellRx, outputTx)
+}
+
+// Run implements the Runner interface for ExecRunner.
+func (er *ExecRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	argv := append([]string{er.Command}, er.Args...)
+	return shellTask(ctx, id, encrypt, argv, terminal.Options{RunAs: er.RunAs, Env: er.Env, Dir: er.Dir, Limits: er.Limits, KillGrace: er.KillGrace}, er.Restart, er.Limiter, er.Sanitize, er.InvalidUTF8, shellRx, outputTx)
 }
 
 // Run implements the Runner interface for EchoRunner.
@@ -91,83 +181,142 @@ func (er *EchoRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encry
 	return echoTask(ctx, id, encrypt, shellRx, outputTx)
 }
 
-// shellTask handles a single shell within the session.
+// shellTerminal is the terminal driven by terminalTask: a local PTY, or one
+// provided elsewhere, such as by a Docker exec.
+type shellTerminal interface {
+	io.ReadWriteCloser
+	SetWinsize(rows, cols uint16) error
+	// ResizeNotify requests a window size change, which may be coalesced
+	// with further requests.
+	ResizeNotify(rows, cols uint16) error
+}
+
+// shellTask handles a single shell within the session, running argv in a PTY
+// customized by termOpts, with output rate limited by limiter when non-nil
+// and sanitized according to sanitize, with bytes that are not valid UTF-8
+// handled according to invalid. With restart, argv is started again in the
+// same pane whenever it exits.
 // This matches the Rust shell_task function exactly.
-func shellTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shell string, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
-	term, err := terminal.New(shell)
+func shellTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, argv []string, termOpts terminal.Options, restart bool, limiter *OutputLimiter, sanitize SanitizePolicy, invalid InvalidUTF8Policy, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	start := func() (shellTerminal, error) {
+		term, err := terminal.NewCommandWithOptions(termOpts, argv[0], argv[1:]...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create terminal: %w", err)
+		}
+		return term, nil
+	}
+	term, err := start()
 	if err != nil {
-		return fmt.Errorf("failed to create terminal: %w", err)
+		return err
 	}
-	defer term.Close()
+	if !restart {
+		start = nil
+	}
+	return terminalTask(ctx, id, encrypt, term, start, limiter, sanitize, invalid, shellRx, outputTx)
+}
+
+// exitReporter is implemented by terminals that know how their process exited
+// once closed, such as local PTYs.
+type exitReporter interface {
+	ExitStatus() (code int, signal string, ok bool)
+}
+
+// terminalTask relays a shell's terminal to the server like shellTask, for a
+// terminal already started. When term's output ends and restart is not nil,
+// it starts a replacement with restart after restartDelay, continuing the
+// same output stream. It closes the terminal when done.
+func terminalTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, term shellTerminal, restart func() (shellTerminal, error), limiter *OutputLimiter, sanitize SanitizePolicy, invalid InvalidUTF8Policy, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	defer func() { term.Close() }()
 
 	// Set initial window size - matches Rust implementation
-	if err := term.SetWinsize(24, 80); err != nil {
-		log.Printf("failed to set initial window size: %v", err)
+	rows, cols := uint16(24), uint16(80)
+	if err := term.SetWinsize(rows, cols); err != nil {
+		util.Warnf("failed to set initial window size: %v", err)
 	}
 
-	var content strings.Builder // content from the terminal
-	var contentOffset int       // bytes before the first character of content
-	var seq int                 // our log of the server's sequence number
-	var seqOutdated int         // number of times seq has been outdated
-	buf := make([]byte, 4096)   // buffer for reading - same size as Rust
-	finished := false           // set when this is done
+	var content contentLog           // content from the terminal
+	var seq int                      // our log of the server's sequence number
+	var seqOutdated int              // number of times seq has been outdated
+	var resyncPending bool           // trust the next sync after a reconnect
+	finished := false                // set when this is done
+	exited := false                  // set once the terminal's output ended
+	var limitWait <-chan time.Time   // fires when more output may be sent
+	var restartWait <-chan time.Time // fires when the exited process restarts
+	var mirror io.Writer             // local copy of the output, if any
+	var acked int                    // output acknowledged by the server
+	stats := new(shellCounters)      // replaced by the controller's, if any
+	sanitizer := newOutputSanitizer(sanitize)
+	decoder := utf8Decoder{policy: invalid}
+	sizes := ContentSizes{}.withDefaults()
 
-	// Start a goroutine to read from terminal
-	termOutput := make(chan []byte, 100)
-	termError := make(chan error, 1)
-	
-	go func() {
-		defer close(termOutput)
-		for {
-			n, err := term.Read(buf)
-			if err != nil {
-				if err != io.EOF {
-					termError <- err
-				}
-				return
-			}
-			if n == 0 {
-				return
-			}
-			
-			// Make a copy of the data
-			data := make([]byte, n)
-			copy(data, buf[:n])
-			
-			select {
-			case termOutput <- data:
-			case <-ctx.Done():
-				return
+	termOutput, termError := readTerminal(ctx, term)
+
+	// record stores output of the terminal, or written on its behalf
+	record := func(data []byte) {
+		content.Write(data)
+		if mirror != nil {
+			if _, err := mirror.Write(data); err != nil {
+				util.Warnf("stopped mirroring shell %d: %v", id, err)
+				mirror = nil
 			}
 		}
-	}()
+	}
+
+	// Once the output ends, send what remains before closing
+	for !finished && (!exited || restartWait != nil || content.End() > seq) {
+		// Stop reading while too much output awaits the limiter, which blocks
+		// the program instead of buffering without bound
+		output := termOutput
+		if limiter != nil && content.End()-seq > sizes.Rolling {
+			output = nil
+		}
 
-	for !finished {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
-			
-		case data, ok := <-termOutput:
+
+		case <-limitWait:
+			limitWait = nil
+
+		case buf, ok := <-output:
 			if !ok {
-				finished = true
+				exited = true
+				termOutput = nil
+				record(decoder.Flush())
+				if restart != nil {
+					term.Close()
+					record([]byte(restartBanner(term)))
+					restartWait = time.After(restartDelay)
+				}
 			} else {
-				// Process UTF-8 decoding like Rust implementation
-				validData := make([]byte, 0, len(data))
-				for len(data) > 0 {
-					r, size := utf8.DecodeRune(data)
-					if r == utf8.RuneError && size == 1 {
-						// Skip invalid UTF-8 byte
-						data = data[1:]
-					} else {
-						validData = append(validData, data[:size]...)
-						data = data[size:]
-					}
+				data := *buf
+				stats.recordOutput(len(data))
+				if sanitizer != nil {
+					data = sanitizer.Filter(data)
 				}
-				content.Write(validData)
+
+				// Process UTF-8 decoding like Rust implementation
+				record(decoder.Decode(data))
+				readBuffers.Put(buf)
 			}
 			
 		case err := <-termError:
 			return fmt.Errorf("terminal read error: %w", err)
+
+		case <-restartWait:
+			restartWait = nil
+			next, err := restart()
+			if err != nil {
+				// Report the exit of the last process instead
+				util.Warnf("failed to restart shell %d: %v", id, err)
+				break
+			}
+			term = next
+			if err := term.SetWinsize(rows, cols); err != nil {
+				util.Warnf("failed to set window size: %v", err)
+			}
+			termOutput, termError = readTerminal(ctx, term)
+			exited = false
 			
 		case item, ok := <-shellRx:
 			if !ok {
@@ -177,11 +326,29 @@ func shellTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shell s
 			
 			switch item.Type {
 			case ShellDataTypeData:
+				if exited {
+					// Nothing reads input until the process restarts
+					break
+				}
 				if _, err := term.Write(item.Data); err != nil {
 					return fmt.Errorf("failed to write to terminal: %w", err)
 				}
+				stats.recordInput(len(item.Data))
 				
 			case ShellDataTypeSync:
+				acked = int(item.Seq)
+
+				// After a reconnect, output sent on the old channel may have been
+				// lost, so rewind to the server's position right away
+				if resyncPending {
+					resyncPending = false
+					if item.Seq < uint64(seq) {
+						seq = int(item.Seq)
+					}
+					seqOutdated = 0
+					break
+				}
+
 				// Sync logic matches Rust implementation exactly
 				if item.Seq < uint64(seq) {
 					seqOutdated++
@@ -191,29 +358,83 @@ func shellTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shell s
 				}
 				
 			case ShellDataTypeSize:
-				if err := term.SetWinsize(uint16(item.Rows), uint16(item.Cols)); err != nil {
-					log.Printf("failed to resize terminal: %v", err)
+				rows, cols = uint16(item.Rows), uint16(item.Cols)
+				// Rapid resizes, e.g. while a window is dragged, are coalesced
+				if err := term.ResizeNotify(uint16(item.Rows), uint16(item.Cols)); err != nil {
+					util.Warnf("failed to resize terminal: %v", err)
 				}
+
+			case ShellDataTypeResume:
+				resyncPending = true
+				if item.Rows > 0 && item.Cols > 0 {
+					rows, cols = uint16(item.Rows), uint16(item.Cols)
+					if err := term.ResizeNotify(uint16(item.Rows), uint16(item.Cols)); err != nil {
+						util.Warnf("failed to restore terminal size: %v", err)
+					}
+				}
+
+			case ShellDataTypeSnapshot:
+				start := content.Boundary(content.End() - sizes.Rolling)
+				item.Snapshot <- content.Slice(start, content.End())
+
+			case ShellDataTypeMirror:
+				mirror = item.Mirror
+				if mirror != nil {
+					start := content.Boundary(content.End() - sizes.Rolling)
+					if _, err := mirror.Write(content.Slice(start, content.End())); err != nil {
+						util.Warnf("stopped mirroring shell %d: %v", id, err)
+						mirror = nil
+					}
+				}
+
+			case ShellDataTypeStats:
+				// Output may have been read before the request arrived
+				item.Stats.merge(stats)
+				stats = item.Stats
+
+			case ShellDataTypeContentSizes:
+				sizes = item.Content.withDefaults()
+			}
+		}
+
+		backlog := content.End() - acked
+		if stats.setBacklog(backlog) {
+			if backlog > slowBacklogBytes {
+				util.Warnf("shell %d is outrunning the server, %d bytes of output not acknowledged", id, backlog)
+			} else {
+				util.Infof("shell %d caught up with the server", id)
 			}
 		}
 
 		// Send data if the server has fallen behind - matches Rust logic exactly
-		contentStr := content.String()
-		if contentOffset+len(contentStr) > seq {
-			start := prevCharBoundary(contentStr, seq-contentOffset)
-			end := prevCharBoundary(contentStr, min(start+contentChunkSize, len(contentStr)))
-			
-			// Encrypt segment exactly like Rust implementation
-			data := encrypt.Segment(
+		if content.End() > seq && limitWait == nil {
+			start := content.Boundary(seq)
+			end := content.Boundary(min(start+sizes.Chunk, content.End()))
+
+			if limiter != nil {
+				allowed, wait := limiter.reserve(end - start)
+				if allowed == 0 {
+					// Let more output accumulate and send it as one chunk
+					limitWait = time.After(wait)
+					continue
+				}
+				end = content.Boundary(start + allowed)
+			}
+
+			// Encrypt segment exactly like Rust implementation, in place
+			// since the chunk is a copy of the content
+			chunk := content.Slice(start, end)
+			data := encrypt.SegmentInto(
+				chunk,
 				0x100000000|uint64(id), // stream number - matches Rust
-				uint64(contentOffset+start),
-				[]byte(contentStr[start:end]),
+				uint64(start),
+				chunk,
 			)
 			
 			termData := &TerminalData{
 				ID:   id,
 				Data: data,
-				Seq:  uint64(contentOffset + start),
+				Seq:  uint64(start),
 			}
 			
 			msg := ClientMessage{
@@ -227,30 +448,82 @@ func shellTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shell s
 				return ctx.Err()
 			}
 			
-			seq = contentOffset + end
+			seq = end
 			seqOutdated = 0
+
+			// Come back for the rest without waiting for more output, which
+			// small chunk sizes and the limiter leave behind
+			if end < content.End() {
+				limitWait = time.After(0)
+			}
 		}
 
 		// Prune content if it gets too large - matches Rust logic exactly
-		if len(contentStr) > contentPruneBytes && seq-contentRollingBytes > contentOffset {
-			pruned := (seq - contentRollingBytes) - contentOffset
-			pruned = prevCharBoundary(contentStr, pruned)
-			contentOffset += pruned
-			
-			// Rebuild content without the pruned part
-			newContent := contentStr[pruned:]
-			content.Reset()
-			content.WriteString(newContent)
+		if content.Len() > sizes.Prune && seq-sizes.Rolling > content.Start() {
+			content.Prune(content.Boundary(seq - sizes.Rolling))
 		}
 	}
-	
+
 	return nil
 }
 
+// readTerminal starts a goroutine reading from term, into buffers from
+// readBuffers that are returned once the output was stored. The output
+// channel is closed when term's output ends, after an error on the other
+// channel if it did not end normally.
+func readTerminal(ctx context.Context, term io.Reader) (<-chan *[]byte, <-chan error) {
+	termOutput := make(chan *[]byte, 100)
+	termError := make(chan error, 1)
+
+	go func() {
+		defer close(termOutput)
+		for {
+			buf := readBuffers.Get().(*[]byte)
+			n, err := term.Read((*buf)[:cap(*buf)])
+			if err != nil || n == 0 {
+				readBuffers.Put(buf)
+				// Linux reports EIO once the process has exited and the PTY is drained
+				if err != nil && err != io.EOF && !errors.Is(err, syscall.EIO) {
+					termError <- err
+				}
+				return
+			}
+			*buf = (*buf)[:n]
+
+			select {
+			case termOutput <- buf:
+			case <-ctx.Done():
+				readBuffers.Put(buf)
+				return
+			}
+		}
+	}()
+	return termOutput, termError
+}
+
+// restartDelay is how long an exited process is given before it is restarted,
+// so one that exits right away does not restart in a busy loop.
+const restartDelay = time.Second
+
+// restartBanner is shown in a pane, after the output of term's process, when
+// it exited and is restarted.
+func restartBanner(term shellTerminal) string {
+	reason := "process exited"
+	if status, ok := term.(exitReporter); ok {
+		if code, signal, ok := status.ExitStatus(); ok && signal != "" {
+			reason = "process killed by " + signal
+		} else if ok {
+			reason = fmt.Sprintf("process exited with code %d", code)
+		}
+	}
+	return "\r\n\x1b[7m " + reason + ", restarting \x1b[0m\r\n"
+}
+
 // echoTask implements the echo runner for testing.
 // This matches the Rust echo_task function exactly.
 func echoTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
 	var seq uint64
+	stats := &shellCounters{}
 	
 	for {
 		select {
@@ -265,6 +538,7 @@ func echoTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx
 			switch item.Type {
 			case ShellDataTypeData:
 				msg := string(item.Data)
+				stats.recordInput(len(msg))
 				
 				termData := &TerminalData{
 					ID:   id,
@@ -284,31 +558,26 @@ func echoTask(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx
 				}
 				
 				seq += uint64(len(msg))
+				stats.recordOutput(len(msg))
 				
 			case ShellDataTypeSync:
 				// Ignore sync messages in echo mode
 				
 			case ShellDataTypeSize:
 				// Ignore resize messages in echo mode
+
+			case ShellDataTypeResume:
+				// Nothing to replay in echo mode
+
+			case ShellDataTypeSnapshot:
+				// No output is kept in echo mode
+				item.Snapshot <- nil
+
+			case ShellDataTypeStats:
+				// Shows the echoed traffic in sshx ctl stats
+				item.Stats.merge(stats)
+				stats = item.Stats
 			}
 		}
 	}
 }
-
-// prevCharBoundary finds the last UTF-8 character boundary before an index.
-// This matches the Rust prev_char_boundary function exactly.
-func prevCharBoundary(s string, i int) int {
-	if i >= len(s) {
-		return len(s)
-	}
-	if i <= 0 {
-		return 0
-	}
-	
-	// In Go, we can use utf8.RuneStart to find character boundaries
-	for i > 0 && !utf8.RuneStart(s[i]) {
-		i--
-	}
-	return i
-}
-
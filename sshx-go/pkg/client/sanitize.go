@@ -0,0 +1,187 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SanitizePolicy selects the escape sequences removed from terminal output
+// before it is sent, since users' terminals act on whatever the shell's
+// programs print.
+type SanitizePolicy int
+
+const (
+	// SanitizeOff sends output unchanged.
+	SanitizeOff SanitizePolicy = iota
+	// SanitizeClipboard removes OSC 52 sequences, which set or query users'
+	// clipboards.
+	SanitizeClipboard
+	// SanitizeStrict removes every operating system command (OSC), such as
+	// window titles, hyperlinks, notifications and clipboard access, and
+	// every device control (DCS), SOS, PM and APC string.
+	SanitizeStrict
+)
+
+// String returns the name of the policy as accepted by ParseSanitizePolicy.
+func (p SanitizePolicy) String() string {
+	switch p {
+	case SanitizeOff:
+		return "off"
+	case SanitizeClipboard:
+		return "clipboard"
+	case SanitizeStrict:
+		return "strict"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSanitizePolicy parses a policy name as accepted by the --sanitize-output flag.
+func ParseSanitizePolicy(s string) (SanitizePolicy, error) {
+	switch strings.ToLower(s) {
+	case "", "off", "none":
+		return SanitizeOff, nil
+	case "clipboard":
+		return SanitizeClipboard, nil
+	case "strict":
+		return SanitizeStrict, nil
+	default:
+		return SanitizeOff, fmt.Errorf("invalid sanitize policy %q (expected off, clipboard, or strict)", s)
+	}
+}
+
+// Control characters that start, end or abort escape sequences.
+const (
+	asciiBEL = 0x07
+	asciiCAN = 0x18
+	asciiSUB = 0x1a
+	asciiESC = 0x1b
+)
+
+// maxOSCHead bounds the OSC command number held back while deciding whether
+// the command is removed.
+const maxOSCHead = 8
+
+type sanitizeState int
+
+const (
+	sanitizeGround  sanitizeState = iota
+	sanitizeEscape                // after ESC
+	sanitizeOSCHead               // reading the number of an OSC, held in pending
+	sanitizePass                  // inside a string that is kept
+	sanitizePassEsc               // after ESC inside a string that is kept
+	sanitizeDrop                  // inside a string that is removed
+	sanitizeDropEsc               // after ESC inside a string that is removed
+)
+
+// outputSanitizer removes escape sequences from a shell's output according
+// to a policy. Sequences may be split across calls to Filter.
+//
+// Only 7-bit sequences are recognized: output is UTF-8, where terminals do
+// not treat the 8-bit C1 bytes as controls.
+type outputSanitizer struct {
+	policy  SanitizePolicy
+	state   sanitizeState
+	belEnds bool   // whether BEL ends the current string, as it does an OSC
+	pending []byte // start of an OSC not yet known to be kept
+}
+
+// newOutputSanitizer returns a sanitizer for policy, or nil for SanitizeOff.
+func newOutputSanitizer(policy SanitizePolicy) *outputSanitizer {
+	if policy == SanitizeOff {
+		return nil
+	}
+	return &outputSanitizer{policy: policy}
+}
+
+// Filter returns p without the removed sequences.
+func (s *outputSanitizer) Filter(p []byte) []byte {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		out = s.step(out, b)
+	}
+	return out
+}
+
+// step processes one byte of output, appending what is kept to out.
+func (s *outputSanitizer) step(out []byte, b byte) []byte {
+	switch s.state {
+	case sanitizeGround:
+		if b == asciiESC {
+			s.state = sanitizeEscape
+			return out
+		}
+		return append(out, b)
+
+	case sanitizeEscape:
+		switch {
+		case b == ']' && s.policy == SanitizeStrict:
+			s.state, s.belEnds = sanitizeDrop, true
+		case b == ']':
+			s.state = sanitizeOSCHead
+			s.pending = append(s.pending[:0], asciiESC, b)
+		case (b == 'P' || b == 'X' || b == '^' || b == '_') && s.policy == SanitizeStrict:
+			s.state, s.belEnds = sanitizeDrop, false
+		case b == asciiESC:
+			// The first ESC started nothing; the second may
+			out = append(out, asciiESC)
+		default:
+			s.state = sanitizeGround
+			out = append(out, asciiESC, b)
+		}
+		return out
+
+	case sanitizeOSCHead:
+		if b >= '0' && b <= '9' && len(s.pending) < 2+maxOSCHead {
+			s.pending = append(s.pending, b)
+			return out
+		}
+		if b == ';' && string(s.pending[2:]) == "52" {
+			s.pending = s.pending[:0]
+			s.state, s.belEnds = sanitizeDrop, true
+			return out
+		}
+		// Not a clipboard command: send what was held back, then the rest
+		out = append(out, s.pending...)
+		s.pending = s.pending[:0]
+		s.state, s.belEnds = sanitizePass, true
+		return s.step(out, b)
+
+	case sanitizePass:
+		switch {
+		case b == asciiESC:
+			s.state = sanitizePassEsc
+			return out
+		case b == asciiBEL && s.belEnds, b == asciiCAN, b == asciiSUB:
+			s.state = sanitizeGround
+		}
+		return append(out, b)
+
+	case sanitizePassEsc:
+		if b == '\\' {
+			s.state = sanitizeGround
+			return append(out, asciiESC, b)
+		}
+		// Any other escape aborts the string and starts a new sequence
+		s.state = sanitizeEscape
+		return s.step(out, b)
+
+	case sanitizeDrop:
+		switch {
+		case b == asciiESC:
+			s.state = sanitizeDropEsc
+		case b == asciiBEL && s.belEnds, b == asciiCAN, b == asciiSUB:
+			s.state = sanitizeGround
+		}
+		return out
+
+	case sanitizeDropEsc:
+		if b == '\\' {
+			s.state = sanitizeGround
+			return out
+		}
+		s.state = sanitizeEscape
+		return s.step(out, b)
+	}
+	return out
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"sshx-go/pkg/encrypt"
+	"sshx-go/pkg/sshjump"
+)
+
+// SSHRunner implements a variant that opens each shell on a remote host over
+// SSH, so sshx can run on a bastion while the shared terminals are on another
+// machine. The pane closes when the remote shell exits.
+type SSHRunner struct {
+	// Host is the connection the shells are opened on.
+	Host *sshjump.Host
+	// Command runs instead of the user's login shell when non-empty.
+	Command []string
+	// Env holds extra KEY=VALUE variables for each shell. Servers usually
+	// only accept those listed in sshd's AcceptEnv and ignore the rest.
+	Env []string
+	// Limiter caps the rate of output sent to the server when non-nil.
+	Limiter *OutputLimiter
+	// Sanitize selects escape sequences removed from the output.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how output that is not valid UTF-8 is sent.
+	InvalidUTF8 InvalidUTF8Policy
+}
+
+// Run implements the Runner interface for SSHRunner.
+func (sr *SSHRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	session, err := sr.Host.NewSession(ctx)
+	if err != nil {
+		return err
+	}
+	term, err := startSSHTerminal(session, sr.Command, sr.Env)
+	if err != nil {
+		session.Close()
+		return fmt.Errorf("failed to start shell on %s: %w", sr.Host, err)
+	}
+	return terminalTask(ctx, id, encrypt, term, nil, sr.Limiter, sr.Sanitize, sr.InvalidUTF8, shellRx, outputTx)
+}
+
+// sshTerminal adapts an SSH session with a PTY to the terminal driven by
+// terminalTask.
+type sshTerminal struct {
+	session *ssh.Session
+	stdin   io.WriteCloser
+	stdout  io.Reader
+}
+
+// startSSHTerminal requests a PTY on session and starts command in it, or
+// the user's shell if command is empty.
+func startSSHTerminal(session *ssh.Session, command, env []string) (*sshTerminal, error) {
+	for _, kv := range env {
+		if key, value, ok := strings.Cut(kv, "="); ok {
+			// Refused unless the server accepts the variable
+			session.Setenv(key, value)
+		}
+	}
+	modes := ssh.TerminalModes{ssh.ECHO: 1, ssh.TTY_OP_ISPEED: 38400, ssh.TTY_OP_OSPEED: 38400}
+	if err := session.RequestPty("xterm-256color", 24, 80, modes); err != nil {
+		return nil, fmt.Errorf("failed to request PTY: %w", err)
+	}
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if len(command) > 0 {
+		err = session.Start(shellJoin(command))
+	} else {
+		err = session.Shell()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &sshTerminal{session: session, stdin: stdin, stdout: stdout}, nil
+}
+
+func (t *sshTerminal) Read(p []byte) (int, error)  { return t.stdout.Read(p) }
+func (t *sshTerminal) Write(p []byte) (int, error) { return t.stdin.Write(p) }
+
+// Close closes the session, which hangs up the remote shell.
+func (t *sshTerminal) Close() error {
+	return t.session.Close()
+}
+
+// SetWinsize sets the window size of the remote PTY.
+func (t *sshTerminal) SetWinsize(rows, cols uint16) error {
+	return t.session.WindowChange(int(rows), int(cols))
+}
+
+// ResizeNotify applies the size right away: a window change is a single
+// message on the connection, which the server handles in order.
+func (t *sshTerminal) ResizeNotify(rows, cols uint16) error {
+	return t.SetWinsize(rows, cols)
+}
+
+// shellJoin joins a command line for the remote user's shell, quoting the
+// arguments that need it.
+func shellJoin(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		if arg != "" && strings.IndexFunc(arg, needsQuote) < 0 {
+			quoted[i] = arg
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// needsQuote reports whether r is special to POSIX shells.
+func needsQuote(r rune) bool {
+	return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=@,+%", r))
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sshx-go/pkg/util"
+)
+
+// Stats reports the health of a controller's connection to the server.
+type Stats struct {
+	// RTT is the most recent round-trip time, or zero if none was measured.
+	RTT time.Duration
+	// MinRTT, MaxRTT and AvgRTT summarize all round-trip times. AvgRTT is
+	// smoothed like TCP's SRTT, weighting recent samples more heavily.
+	MinRTT time.Duration
+	MaxRTT time.Duration
+	AvgRTT time.Duration
+	// RTTSamples is the number of round-trip times measured.
+	RTTSamples int
+	// Pings is the number of latency pings answered for the server.
+	Pings uint64
+	// LastPing is when the server last sent a ping, or zero if never.
+	LastPing time.Time
+	// TransportSwitches is the number of times reconnecting moved the
+	// session between gRPC and WebSocket.
+	TransportSwitches int
+}
+
+// statsRecorder accumulates Stats from the transport and the channel loop.
+type statsRecorder struct {
+	mu    sync.Mutex
+	stats Stats
+}
+
+// recordRTT adds a round-trip time measured by the transport.
+func (s *statsRecorder) recordRTT(rtt time.Duration) {
+	s.mu.Lock()
+	st := &s.stats
+	st.RTT = rtt
+	if st.RTTSamples == 0 {
+		st.MinRTT, st.MaxRTT, st.AvgRTT = rtt, rtt, rtt
+	} else {
+		if rtt < st.MinRTT {
+			st.MinRTT = rtt
+		}
+		if rtt > st.MaxRTT {
+			st.MaxRTT = rtt
+		}
+		st.AvgRTT = (7*st.AvgRTT + rtt) / 8
+	}
+	st.RTTSamples++
+	avg := st.AvgRTT
+	s.mu.Unlock()
+
+	util.DebugLog("round-trip time to server: %s (avg %s)", rtt.Round(time.Microsecond), avg.Round(time.Microsecond))
+}
+
+// recordPing counts a ping answered for the server.
+func (s *statsRecorder) recordPing() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.Pings++
+	s.stats.LastPing = time.Now()
+}
+
+// recordTransportSwitch counts a move between gRPC and WebSocket.
+func (s *statsRecorder) recordTransportSwitch() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats.TransportSwitches++
+}
+
+// snapshot returns a copy of the current stats.
+func (s *statsRecorder) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
+
+// slowBacklogBytes is the output the server may leave unacknowledged before
+// a shell is reported as outrunning it.
+const slowBacklogBytes = 1 << 20
+
+// ShellStats reports the I/O of a running shell, e.g. to find the pane
+// flooding a session.
+type ShellStats struct {
+	ID uint32 `json:"id"`
+	// BytesIn is the input written to the shell, and BytesOut the output
+	// read from it.
+	BytesIn  uint64 `json:"bytesIn"`
+	BytesOut uint64 `json:"bytesOut"`
+	// Backlog is the output the server has not acknowledged yet, in bytes.
+	Backlog uint64 `json:"backlog"`
+	// Slow reports that the backlog exceeds 1 MiB, so the server or the
+	// connection is not keeping up with the shell's output.
+	Slow bool `json:"slow,omitempty"`
+	// LastActivity is when the shell last received input or produced
+	// output, or zero if it has not yet.
+	LastActivity time.Time `json:"lastActivity"`
+}
+
+// shellCounters accumulates a shell's ShellStats. The shell's task updates
+// them while the controller reads them.
+type shellCounters struct {
+	bytesIn      atomic.Uint64
+	bytesOut     atomic.Uint64
+	backlog      atomic.Uint64
+	lastActivity atomic.Int64 // Unix nanoseconds, zero if none
+}
+
+// recordInput counts input written to the shell.
+func (s *shellCounters) recordInput(n int) {
+	s.bytesIn.Add(uint64(n))
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// recordOutput counts output read from the shell.
+func (s *shellCounters) recordOutput(n int) {
+	s.bytesOut.Add(uint64(n))
+	s.lastActivity.Store(time.Now().UnixNano())
+}
+
+// setBacklog records the output not acknowledged by the server, and reports
+// whether the shell just started or stopped outrunning the server.
+func (s *shellCounters) setBacklog(n int) (changed bool) {
+	previous := s.backlog.Swap(uint64(max(n, 0)))
+	return (previous > slowBacklogBytes) != (n > slowBacklogBytes)
+}
+
+// merge adds the counts of other, which the shell recorded before s.
+func (s *shellCounters) merge(other *shellCounters) {
+	s.bytesIn.Add(other.bytesIn.Load())
+	s.bytesOut.Add(other.bytesOut.Load())
+	s.backlog.Store(other.backlog.Load())
+	if last := other.lastActivity.Load(); last > s.lastActivity.Load() {
+		s.lastActivity.Store(last)
+	}
+}
+
+// snapshot returns the current stats of shell id.
+func (s *shellCounters) snapshot(id uint32) ShellStats {
+	stats := ShellStats{
+		ID:       id,
+		BytesIn:  s.bytesIn.Load(),
+		BytesOut: s.bytesOut.Load(),
+		Backlog:  s.backlog.Load(),
+	}
+	stats.Slow = stats.Backlog > slowBacklogBytes
+	if last := s.lastActivity.Load(); last != 0 {
+		stats.LastActivity = time.Unix(0, last)
+	}
+	return stats
+}
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"sshx-go/pkg/encrypt"
+)
+
+// StreamRunner implements a read-only variant that runs a command once and
+// shows its output, stdout and stderr together, without a PTY. Nothing users
+// type reaches the command, which reads from the null device, so output such
+// as a build or a deploy log can be broadcast without risk of remote input.
+//
+// The command runs in the first shell, normally opened by the client with
+// ControllerConfig.OpenShell. Shells users create later stay empty.
+type StreamRunner struct {
+	Command string
+	Args    []string
+	// Env holds extra KEY=VALUE variables for the command.
+	Env []string
+	// Dir is the working directory of the command. Empty inherits the current one.
+	Dir string
+	// Local receives a copy of the output as the command writes it when
+	// non-nil, e.g. so the host still sees it.
+	Local io.Writer
+	// Linger keeps the output shown this long after the command exits, so
+	// users can read its end before the shell closes.
+	Linger time.Duration
+	// OnExit is called with the result of the command once it exits, as
+	// returned by exec.Cmd.Wait.
+	OnExit func(err error)
+	// Limiter caps the rate of output sent to the server when non-nil.
+	Limiter *OutputLimiter
+	// Sanitize selects escape sequences removed from the output.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how output that is not valid UTF-8 is sent.
+	InvalidUTF8 InvalidUTF8Policy
+
+	started sync.Once
+}
+
+// Run implements the Runner interface for StreamRunner.
+func (sr *StreamRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	first := false
+	sr.started.Do(func() { first = true })
+	if !first {
+		return fmt.Errorf("this session only streams %s, in its first shell", filepath.Base(sr.Command))
+	}
+
+	term, err := startStream(sr)
+	if err != nil {
+		return err
+	}
+	return terminalTask(ctx, id, encrypt, term, nil, sr.Limiter, sr.Sanitize, sr.InvalidUTF8, shellRx, outputTx)
+}
+
+// streamTerminal presents a command's output as a terminal that ignores
+// input. Once the command exits and its status is shown, reads block for
+// the linger period before reporting the end of output.
+type streamTerminal struct {
+	runner *StreamRunner
+	cmd    *exec.Cmd
+	output *os.File
+	buf    []byte // reused for reading output
+
+	lastCR bool   // whether the output so far ended with CR
+	footer []byte // status line not yet read, once the command exited
+	ended  bool   // whether the status was shown
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	exited    chan struct{} // closed once cmd.Wait returned waitErr
+	waitErr   error
+}
+
+// startStream starts the command of sr with its output on a pipe.
+func startStream(sr *StreamRunner) (*streamTerminal, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe: %w", err)
+	}
+	cmd := exec.Command(sr.Command, sr.Args...)
+	cmd.Env = append(os.Environ(), sr.Env...)
+	cmd.Dir = sr.Dir
+	cmd.Stdout, cmd.Stderr = w, w
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", sr.Command, err)
+	}
+	// The pipe reports the end of output once the command and its children
+	// hold the only write ends
+	w.Close()
+
+	t := &streamTerminal{
+		runner: sr,
+		cmd:    cmd,
+		output: r,
+		closed: make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	go func() {
+		t.waitErr = cmd.Wait()
+		close(t.exited)
+		if sr.OnExit != nil {
+			sr.OnExit(t.waitErr)
+		}
+	}()
+	return t, nil
+}
+
+// Read returns the command's output with bare line feeds turned into CRLF,
+// as a terminal expects, then its exit status.
+func (t *streamTerminal) Read(p []byte) (int, error) {
+	if t.ended {
+		if len(t.footer) > 0 {
+			n := copy(p, t.footer)
+			t.footer = t.footer[n:]
+			return n, nil
+		}
+		select {
+		case <-time.After(t.runner.Linger):
+		case <-t.closed:
+		}
+		return 0, io.EOF
+	}
+
+	// Leave room for a CR before every byte read
+	size := max(len(p)/2, 1)
+	if cap(t.buf) < size {
+		t.buf = make([]byte, size)
+	}
+	buf := t.buf[:size]
+	n, err := t.output.Read(buf)
+	if n > 0 && t.runner.Local != nil {
+		t.runner.Local.Write(buf[:n])
+	}
+	out := p[:0]
+	for _, b := range buf[:n] {
+		if b == '\n' && !t.lastCR {
+			out = append(out, '\r')
+		}
+		out = append(out, b)
+		t.lastCR = b == '\r'
+	}
+	if err == io.EOF {
+		<-t.exited
+		t.ended = true
+		t.footer = []byte(t.status())
+		if len(out) == 0 {
+			// Readers take an empty read for the end of output
+			return t.Read(p)
+		}
+		err = nil
+	}
+	return len(out), err
+}
+
+// status returns the line shown to users once the command exited.
+func (t *streamTerminal) status() string {
+	err := t.waitErr
+	prefix := "\r\n"
+	if t.lastCR {
+		prefix = "\n"
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return prefix + "[process exited]\r\n"
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return prefix + fmt.Sprintf("[process exited with status %d]\r\n", exitErr.ExitCode())
+	default:
+		return prefix + fmt.Sprintf("[process ended: %v]\r\n", err)
+	}
+}
+
+// Write discards input: nothing users type reaches the command.
+func (t *streamTerminal) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Close stops the command if it is still running, interrupting it first.
+func (t *streamTerminal) Close() error {
+	t.closeOnce.Do(func() { close(t.closed) })
+	defer t.output.Close()
+	select {
+	case <-t.exited:
+		return nil
+	default:
+	}
+
+	t.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-t.exited:
+	case <-time.After(2 * time.Second):
+		t.cmd.Process.Kill()
+		<-t.exited
+	}
+	return nil
+}
+
+// SetWinsize does nothing: the command has no terminal to resize.
+func (t *streamTerminal) SetWinsize(rows, cols uint16) error {
+	return nil
+}
+
+// ResizeNotify does nothing, like SetWinsize.
+func (t *streamTerminal) ResizeNotify(rows, cols uint16) error {
+	return nil
+}
+
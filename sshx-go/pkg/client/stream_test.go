@@ -0,0 +1,35 @@
+package client
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+// BenchmarkStreamRead reads a command's output the way terminalTask does, in
+// 4 KiB reads, while the command keeps writing.
+func BenchmarkStreamRead(b *testing.B) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		line := bytes.Repeat([]byte("0123456789abcde\n"), 256)
+		for {
+			if _, err := w.Write(line); err != nil {
+				return
+			}
+		}
+	}()
+	defer w.Close()
+
+	t := &streamTerminal{runner: &StreamRunner{}, output: r}
+	p := make([]byte, 4096)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := t.Read(p); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
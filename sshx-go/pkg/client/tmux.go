@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"sshx-go/pkg/encrypt"
+	"sshx-go/pkg/terminal"
+)
+
+// TmuxRunner implements a variant that attaches each pane to an existing tmux
+// session instead of starting a shell, so users share work that is already
+// running. Every pane is a separate tmux client: tmux resizes the session's
+// windows to follow the most recently active client, as for local clients,
+// and the pane closes when its client detaches or the session ends.
+type TmuxRunner struct {
+	// Session is the tmux target session, e.g. "work", or "work:2" to start
+	// on a given window.
+	Session string
+	// RunAs attaches as another user, to that user's tmux server, when non-nil.
+	RunAs *terminal.RunAs
+	// Env holds extra KEY=VALUE variables for each tmux client.
+	Env []string
+	// Limiter caps the rate of output sent to the server when non-nil.
+	Limiter *OutputLimiter
+	// Sanitize selects escape sequences removed from the output.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how output that is not valid UTF-8 is sent.
+	InvalidUTF8 InvalidUTF8Policy
+}
+
+// Command returns the command line attaching a pane to the session.
+func (tr *TmuxRunner) Command() []string {
+	return append(tr.tmux(), "attach-session", "-t", tr.Session)
+}
+
+// tmux returns the tmux command line selecting the server. When sshx itself
+// runs inside tmux, that client's server is used, as tmux commands run from
+// the same shell would.
+func (tr *TmuxRunner) tmux() []string {
+	if tr.RunAs == nil {
+		if socket, _, _ := strings.Cut(os.Getenv("TMUX"), ","); socket != "" {
+			return []string{"tmux", "-S", socket}
+		}
+	}
+	return []string{"tmux"}
+}
+
+// Check reports an error if the tmux session does not exist, so a mistyped
+// name fails before the session is shared rather than in every pane.
+func (tr *TmuxRunner) Check() error {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return fmt.Errorf("tmux is not installed: %w", err)
+	}
+	target, _, _ := strings.Cut(tr.Session, ":")
+	argv := append(tr.tmux(), "has-session", "-t", target)
+	out, err := exec.Command(argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("tmux session %q not found: %s", target, msg)
+		}
+		return fmt.Errorf("tmux session %q not found: %w", target, err)
+	}
+	return nil
+}
+
+// Run implements the Runner interface for TmuxRunner.
+func (tr *TmuxRunner) Run(ctx context.Context, id uint32, encrypt *encrypt.Encrypt, shellRx <-chan ShellData, outputTx chan<- ClientMessage) error {
+	// tmux refuses to attach from inside another tmux client, which sshx
+	// itself often runs in; an empty TMUX allows it, and Command names the
+	// server explicitly
+	env := append([]string{"TMUX="}, tr.Env...)
+	argv := tr.Command()
+	return shellTask(ctx, id, encrypt, argv, terminal.Options{RunAs: tr.RunAs, Env: env}, false, tr.Limiter, tr.Sanitize, tr.InvalidUTF8, shellRx, outputTx)
+}
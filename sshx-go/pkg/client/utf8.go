@@ -0,0 +1,124 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// InvalidUTF8Policy selects how terminal output that is not valid UTF-8, such
+// as ISO-8859 text or a zmodem transfer, is sent.
+type InvalidUTF8Policy int
+
+const (
+	// InvalidUTF8Drop removes the invalid bytes, like the Rust client.
+	InvalidUTF8Drop InvalidUTF8Policy = iota
+	// InvalidUTF8Replace sends U+FFFD in place of each invalid byte, so text
+	// in a single-byte encoding stays aligned.
+	InvalidUTF8Replace
+	// InvalidUTF8Pass sends the output unchanged, leaving invalid bytes to
+	// users' terminals.
+	InvalidUTF8Pass
+)
+
+// String returns the name of the policy as accepted by ParseInvalidUTF8Policy.
+func (p InvalidUTF8Policy) String() string {
+	switch p {
+	case InvalidUTF8Drop:
+		return "drop"
+	case InvalidUTF8Replace:
+		return "replace"
+	case InvalidUTF8Pass:
+		return "passthrough"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseInvalidUTF8Policy parses a policy name as accepted by the --invalid-utf8 flag.
+func ParseInvalidUTF8Policy(s string) (InvalidUTF8Policy, error) {
+	switch strings.ToLower(s) {
+	case "", "drop":
+		return InvalidUTF8Drop, nil
+	case "replace":
+		return InvalidUTF8Replace, nil
+	case "passthrough", "pass":
+		return InvalidUTF8Pass, nil
+	default:
+		return InvalidUTF8Drop, fmt.Errorf("invalid UTF-8 policy %q (expected drop, replace, or passthrough)", s)
+	}
+}
+
+// utf8Decoder keeps the valid UTF-8 of a terminal's output across reads: a
+// character split between two reads is held back until its last bytes
+// arrive, rather than treated as invalid. Invalid bytes are handled according
+// to policy.
+type utf8Decoder struct {
+	policy  InvalidUTF8Policy
+	pending [utf8.UTFMax]byte // start of a character cut off by the last read
+	n       int               // bytes of pending in use
+	buf     []byte            // pending and the next read, when joined
+	out     []byte            // output with replacement characters
+}
+
+// Decode returns the valid UTF-8 of data, with any character cut off by the
+// previous read completed. The result reuses data, or the decoder's own
+// buffers, so it is only valid until the next call.
+func (d *utf8Decoder) Decode(data []byte) []byte {
+	if d.policy == InvalidUTF8Pass {
+		return data
+	}
+	if d.n > 0 {
+		d.buf = append(append(d.buf[:0], d.pending[:d.n]...), data...)
+		data = d.buf
+		d.n = 0
+	}
+
+	// Keep the valid bytes in place, unless replacements make it longer
+	valid := data[:0]
+	if d.policy == InvalidUTF8Replace {
+		valid = d.out[:0]
+	}
+	for len(data) > 0 {
+		if data[0] < utf8.RuneSelf {
+			valid = append(valid, data[0])
+			data = data[1:]
+			continue
+		}
+		if !utf8.FullRune(data) {
+			// The rest of the character may come with the next read
+			d.n = copy(d.pending[:], data)
+			break
+		}
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && size == 1 {
+			if d.policy == InvalidUTF8Replace {
+				valid = utf8.AppendRune(valid, utf8.RuneError)
+			}
+			data = data[1:]
+			continue
+		}
+		valid = append(valid, data[:size]...)
+		data = data[size:]
+	}
+	if d.policy == InvalidUTF8Replace {
+		d.out = valid
+	}
+	return valid
+}
+
+// Flush returns what is left of a character cut off by the last read, once
+// the output it belonged to ended, handled as invalid bytes. Like Decode's
+// result, it is only valid until the next call.
+func (d *utf8Decoder) Flush() []byte {
+	n := d.n
+	d.n = 0
+	if d.policy != InvalidUTF8Replace {
+		return nil
+	}
+	d.out = d.out[:0]
+	for range n {
+		d.out = utf8.AppendRune(d.out, utf8.RuneError)
+	}
+	return d.out
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+)
+
+// utf8Outputs are terminal outputs with characters of every length, and the
+// result of decoding them under each policy.
+var utf8Outputs = []struct {
+	name    string
+	input   string
+	drop    string
+	replace string
+}{
+	{"ascii", "ls -l\r\n", "ls -l\r\n", "ls -l\r\n"},
+	{"latin", "café crème", "café crème", "café crème"},
+	{"cjk", "日本語のテキスト", "日本語のテキスト", "日本語のテキスト"},
+	{"emoji", "ok 👍🏽 🎉\r\n", "ok 👍🏽 🎉\r\n", "ok 👍🏽 🎉\r\n"},
+	{"flag", "🇯🇵🇫🇷", "🇯🇵🇫🇷", "🇯🇵🇫🇷"},
+	{"mixed", "a€日😀b", "a€日😀b", "a€日😀b"},
+	{"invalid", "a\xffb\xe6\x97c", "abc", "a�b��c"},
+	{"latin1", "caf\xe9 \xe6\x97\xa5", "caf 日", "caf� 日"},
+}
+
+// decodeReads decodes reads in order, then flushes the decoder as when the
+// output ends.
+func decodeReads(policy InvalidUTF8Policy, reads ...[]byte) string {
+	d := utf8Decoder{policy: policy}
+	var out []byte
+	for _, read := range reads {
+		// Decode may reuse its input, as terminalTask's read buffers are
+		out = append(out, d.Decode(bytes.Clone(read))...)
+	}
+	return string(append(out, d.Flush()...))
+}
+
+// TestUTF8DecoderSplit checks output split in two reads at every byte
+// boundary, and read one byte at a time, decodes like a single read.
+func TestUTF8DecoderSplit(t *testing.T) {
+	for _, tt := range utf8Outputs {
+		input := []byte(tt.input)
+		for _, policy := range []struct {
+			policy InvalidUTF8Policy
+			want   string
+		}{
+			{InvalidUTF8Drop, tt.drop},
+			{InvalidUTF8Replace, tt.replace},
+			{InvalidUTF8Pass, tt.input},
+		} {
+			if got := decodeReads(policy.policy, input); got != policy.want {
+				t.Errorf("%s/%v: got %q, want %q", tt.name, policy.policy, got, policy.want)
+			}
+			for i := range input {
+				if got := decodeReads(policy.policy, input[:i], input[i:]); got != policy.want {
+					t.Errorf("%s/%v split at %d: got %q, want %q", tt.name, policy.policy, i, got, policy.want)
+				}
+			}
+			var bytewise [][]byte
+			for i := range input {
+				bytewise = append(bytewise, input[i:i+1])
+			}
+			if got := decodeReads(policy.policy, bytewise...); got != policy.want {
+				t.Errorf("%s/%v byte by byte: got %q, want %q", tt.name, policy.policy, got, policy.want)
+			}
+		}
+	}
+}
+
+// TestUTF8DecoderFlush checks a character cut off by the end of the output
+// is handled as invalid, rather than held back or joined with what follows.
+func TestUTF8DecoderFlush(t *testing.T) {
+	for _, tt := range []struct {
+		policy InvalidUTF8Policy
+		want   string
+	}{
+		{InvalidUTF8Drop, "ok "},
+		{InvalidUTF8Replace, "ok ���"},
+		{InvalidUTF8Pass, "ok \xf0\x9f\x91"},
+	} {
+		d := utf8Decoder{policy: tt.policy}
+		got := string(d.Decode([]byte("ok \xf0\x9f\x91")))
+		got += string(d.Flush())
+		if got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.policy, got, tt.want)
+		}
+		fresh := utf8Decoder{policy: tt.policy}
+		if got, want := string(d.Decode([]byte("\x8d"))), string(fresh.Decode([]byte("\x8d"))); got != want {
+			t.Errorf("%v: continuation byte after Flush decoded as %q, want %q", tt.policy, got, want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package config loads the optional sshx JSON configuration file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// SessionConfig describes one session opened by the process. Empty fields
+// inherit the values given on the command line.
+type SessionConfig struct {
+	Name          string  `json:"name,omitempty"`
+	Server        string  `json:"server,omitempty"`
+	Shell         string  `json:"shell,omitempty"`
+	Exec          string  `json:"exec,omitempty"`
+	Cwd           string  `json:"cwd,omitempty"`
+	OnStart       string  `json:"onStart,omitempty"`
+	EnableReaders *bool   `json:"enableReaders,omitempty"`
+	Dashboard     *string `json:"dashboard,omitempty"`
+	// Tags are sent with dashboard registrations, over those given with --tag.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// NotifierConfig configures a chat service told when sessions start, open
+// shells, lose their connection and close.
+type NotifierConfig struct {
+	// Type is "slack" or "matrix".
+	Type string `json:"type"`
+	// URL is the Slack incoming webhook.
+	URL string `json:"url,omitempty"`
+	// Homeserver, Room and AccessToken select the Matrix room and the user
+	// posting to it. An empty AccessToken is taken from SSHX_MATRIX_TOKEN.
+	Homeserver  string `json:"homeserver,omitempty"`
+	Room        string `json:"room,omitempty"`
+	AccessToken string `json:"accessToken,omitempty"`
+	// Template is a Go template for the messages; see webhook.ParseTemplate.
+	Template string `json:"template,omitempty"`
+	// IncludeWriteURL shows writable links too, which are left out by default
+	// so everyone in the channel does not get write access.
+	IncludeWriteURL bool `json:"includeWriteUrl,omitempty"`
+}
+
+// File is the top-level structure of the configuration file.
+type File struct {
+	Sessions  []SessionConfig  `json:"sessions,omitempty"`
+	Notifiers []NotifierConfig `json:"notifiers,omitempty"`
+	// UpdateCheck disables the startup check for newer releases when false.
+	UpdateCheck *bool `json:"updateCheck,omitempty"`
+}
+
+// DefaultPath returns the default configuration file location,
+// ~/.config/sshx/config.json (or the platform equivalent).
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sshx", "config.json")
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &file, nil
+}
+
+// LoadDefault reads the configuration file from DefaultPath, returning an
+// empty configuration if it does not exist.
+func LoadDefault() (*File, error) {
+	path := DefaultPath()
+	if path == "" {
+		return &File{}, nil
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return &File{}, nil
+	}
+	return Load(path)
+}
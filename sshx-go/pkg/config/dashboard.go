@@ -0,0 +1,44 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultDashboardKeyPath returns the default location of the persisted
+// dashboard key, ~/.config/sshx/dashboard.key (or the platform equivalent).
+func DefaultDashboardKeyPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sshx", "dashboard.key")
+}
+
+// LoadDashboardKey reads a dashboard key saved by SaveDashboardKey. It returns
+// an empty key if the file does not exist.
+func LoadDashboardKey(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read dashboard key: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SaveDashboardKey writes a dashboard key to path so later runs rejoin the
+// same dashboard. The key grants access to the dashboard, so the file is
+// readable only by its owner.
+func SaveDashboardKey(path, key string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create dashboard key directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write dashboard key: %w", err)
+	}
+	return nil
+}
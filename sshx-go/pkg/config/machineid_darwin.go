@@ -0,0 +1,24 @@
+//go:build darwin
+
+package config
+
+import (
+	"errors"
+	"os/exec"
+	"regexp"
+)
+
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// readMachineID reads the hardware UUID of the Mac.
+func readMachineID() (string, error) {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", err
+	}
+	match := platformUUID.FindSubmatch(out)
+	if match == nil {
+		return "", errors.New("no IOPlatformUUID in the output of ioreg")
+	}
+	return string(match[1]), nil
+}
@@ -0,0 +1,20 @@
+//go:build linux
+
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// readMachineID reads the ID systemd or D-Bus generated for the machine.
+func readMachineID() (string, error) {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		data, err := os.ReadFile(path)
+		if id := strings.TrimSpace(string(data)); err == nil && id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.New("no machine ID in /etc/machine-id or /var/lib/dbus/machine-id")
+}
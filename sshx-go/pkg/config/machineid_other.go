@@ -0,0 +1,21 @@
+//go:build !linux && !darwin && !windows
+
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// readMachineID reads the host ID of BSDs, or the machine ID of systems
+// that keep one like Linux.
+func readMachineID() (string, error) {
+	for _, path := range []string{"/etc/hostid", "/etc/machine-id", "/var/db/dbus/machine-id"} {
+		data, err := os.ReadFile(path)
+		if id := strings.TrimSpace(string(data)); err == nil && id != "" {
+			return id, nil
+		}
+	}
+	return "", errors.New("no machine ID found")
+}
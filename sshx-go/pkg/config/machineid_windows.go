@@ -0,0 +1,16 @@
+//go:build windows
+
+package config
+
+import "golang.org/x/sys/windows/registry"
+
+// readMachineID reads the GUID Windows generated when it was installed.
+func readMachineID() (string, error) {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`, registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return "", err
+	}
+	defer key.Close()
+	id, _, err := key.GetStringValue("MachineGuid")
+	return id, err
+}
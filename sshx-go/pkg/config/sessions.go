@@ -0,0 +1,228 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// sessionsMagic starts the file of a SessionStore, followed by the nonce and
+// the sealed entries.
+const sessionsMagic = "sshx sessions v1\n"
+
+// machineID returns an identifier of this machine that stays the same
+// across reboots and is not stored with the user's files.
+var machineID = readMachineID
+
+// DefaultSessionsPath returns the default location of the sessions kept for
+// --resume and --kill-existing, ~/.config/sshx/sessions (or the platform
+// equivalent).
+func DefaultSessionsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "sshx", "sessions")
+}
+
+// SessionStore keeps the sessions a later run may reattach to or close in a
+// file. The entries hold session tokens and encryption keys, so the file is
+// encrypted with a key derived from the machine's ID and the user's, which
+// are not stored beside it: a copy of the file, as in a backup, cannot be
+// opened on another machine or by another user. It is also only readable by
+// its owner, in a directory only they can open.
+type SessionStore struct {
+	path string
+	mu   sync.Mutex
+	aead cipher.AEAD // from the machine key, once derived
+}
+
+// NewSessionStore returns the store kept at path.
+func NewSessionStore(path string) *SessionStore {
+	return &SessionStore{path: path}
+}
+
+// Load decodes the entry saved under id into v. It reports false if there is
+// no such entry.
+func (s *SessionStore) Load(id string, v any) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return false, err
+	}
+	entry, ok := entries[id]
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(entry, v); err != nil {
+		return false, fmt.Errorf("failed to parse saved session %s: %w", id, err)
+	}
+	return true, nil
+}
+
+// Save stores v under id, replacing any earlier entry.
+func (s *SessionStore) Save(id string, v any) error {
+	entry, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to encode session %s: %w", id, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries, err := s.read()
+	if err != nil {
+		// An unreadable store only held sessions that cannot be resumed or closed
+		entries = map[string]json.RawMessage{}
+	}
+	entries[id] = entry
+	return s.write(entries)
+}
+
+// Forget removes the entry saved under id, if any.
+func (s *SessionStore) Forget(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := entries[id]; !ok {
+		return nil
+	}
+	delete(entries, id)
+	return s.write(entries)
+}
+
+// read returns the entries, or none if the store does not exist.
+func (s *SessionStore) read() (map[string]json.RawMessage, error) {
+	entries := map[string]json.RawMessage{}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read saved sessions: %w", err)
+	}
+	if data, err = s.open(data); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to parse saved sessions: %w", err)
+	}
+	return entries, nil
+}
+
+// write saves entries, replacing the file atomically.
+func (s *SessionStore) write(entries map[string]json.RawMessage) error {
+	if len(entries) == 0 {
+		if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove saved sessions: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode saved sessions: %w", err)
+	}
+	if data, err = s.seal(data); err != nil {
+		return err
+	}
+	// MkdirAll leaves the mode of an existing directory as it is
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create saved sessions directory: %w", err)
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return fmt.Errorf("failed to restrict saved sessions directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	os.Remove(tmp) // WriteFile keeps the mode of an existing file
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write saved sessions: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write saved sessions: %w", err)
+	}
+	return nil
+}
+
+// sealer returns the AEAD sealing the file, keyed by the machine and user.
+func (s *SessionStore) sealer() (cipher.AEAD, error) {
+	if s.aead != nil {
+		return s.aead, nil
+	}
+	id, err := machineID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive the key of saved sessions: %w", err)
+	}
+	var uid string
+	if u, err := user.Current(); err == nil {
+		uid = u.Uid
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(id), []byte("sshx saved sessions"), []byte(uid)), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	return s.aead, nil
+}
+
+// seal encrypts the encoded entries for the file.
+func (s *SessionStore) seal(data []byte) ([]byte, error) {
+	aead, err := s.sealer()
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(sessionsMagic)+aead.NonceSize(), len(sessionsMagic)+aead.NonceSize()+len(data)+aead.Overhead())
+	copy(out, sessionsMagic)
+	nonce := out[len(sessionsMagic):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, nonce, data, []byte(sessionsMagic)), nil
+}
+
+// open decrypts the contents of the file.
+func (s *SessionStore) open(data []byte) ([]byte, error) {
+	rest, ok := bytes.CutPrefix(data, []byte(sessionsMagic))
+	if !ok {
+		return nil, errors.New("failed to parse saved sessions: unknown format")
+	}
+	aead, err := s.sealer()
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, errors.New("failed to parse saved sessions: truncated")
+	}
+	data, err = aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(sessionsMagic))
+	if err != nil {
+		return nil, errors.New("failed to decrypt saved sessions: they were saved on another machine or by another user")
+	}
+	return data, nil
+}
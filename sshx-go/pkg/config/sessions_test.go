@@ -0,0 +1,77 @@
+//go:build !windows
+
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// useMachineID makes the stores of the test run on a machine with ID id.
+func useMachineID(t *testing.T, id string) {
+	old := machineID
+	machineID = func() (string, error) { return id, nil }
+	t.Cleanup(func() { machineID = old })
+}
+
+// TestSessionStore checks entries round-trip encrypted, and that the file
+// and its directory are only accessible by their owner.
+func TestSessionStore(t *testing.T) {
+	useMachineID(t, "machine-a")
+	path := filepath.Join(t.TempDir(), "sshx", "sessions")
+	// An existing directory others can open is restricted too
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	store := NewSessionStore(path)
+
+	type entry struct{ Token string }
+	if err := store.Save("a", entry{"secret"}); err != nil {
+		t.Fatal(err)
+	}
+	var got entry
+	if ok, err := store.Load("a", &got); !ok || err != nil || got.Token != "secret" {
+		t.Fatalf("Load() = %v, %v, %+v", ok, err, got)
+	}
+	for file, want := range map[string]os.FileMode{path: 0600, filepath.Dir(path): 0700} {
+		info, err := os.Stat(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != want {
+			t.Errorf("%s has mode %v, want %v", file, mode, want)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("Token")) {
+		t.Fatalf("the store is not encrypted: %q", data)
+	}
+
+	if err := store.Forget("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("the store was kept without entries")
+	}
+}
+
+// TestSessionStoreOtherMachine checks a store copied to another machine
+// cannot be opened there.
+func TestSessionStoreOtherMachine(t *testing.T) {
+	useMachineID(t, "machine-a")
+	path := filepath.Join(t.TempDir(), "sessions")
+	if err := NewSessionStore(path).Save("a", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	useMachineID(t, "machine-b")
+	var got string
+	if ok, err := NewSessionStore(path).Load("a", &got); ok || err == nil {
+		t.Fatalf("Load() on another machine = %v, %v, %q", ok, err, got)
+	}
+}
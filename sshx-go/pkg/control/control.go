@@ -0,0 +1,253 @@
+// Package control serves a local administration API for a running sshx
+// process over a Unix-domain socket.
+//
+// Requests and responses are JSON-RPC 2.0 objects, one per line. The socket
+// is only accessible to the user running sshx, since results include the
+// session links.
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"sshx-go/pkg/util"
+)
+
+// callTimeout bounds a single call made by Call.
+const callTimeout = 30 * time.Second
+
+// JSON-RPC 2.0 error codes.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInternalError  = -32603
+)
+
+// DefaultSocketPath returns the socket used when no path is given:
+// $XDG_RUNTIME_DIR/sshx.sock, or a per-user file in the temporary directory.
+func DefaultSocketPath() string {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "sshx.sock")
+	}
+	return filepath.Join(os.TempDir(), "sshx-"+strconv.Itoa(os.Getuid())+".sock")
+}
+
+// Handler answers a method call. params is nil when the call had none.
+type Handler func(params json.RawMessage) (any, error)
+
+// request is a JSON-RPC 2.0 request.
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC 2.0 response.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  any             `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// Error is an error returned by a method.
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Server serves method calls on a control socket.
+type Server struct {
+	handlers map[string]Handler
+	listener net.Listener
+	path     string
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// NewServer creates a server with no methods.
+func NewServer() *Server {
+	return &Server{
+		handlers: make(map[string]Handler),
+		conns:    make(map[net.Conn]struct{}),
+	}
+}
+
+// Handle registers the handler for a method. It must be called before Listen.
+func (s *Server) Handle(method string, handler Handler) {
+	s.handlers[method] = handler
+}
+
+// Listen creates the socket at path and serves calls on it in the background
+// until Close. A stale socket left by an earlier process is replaced, but one
+// still in use is an error.
+func (s *Server) Listen(path string) error {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use by another sshx process", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+
+	// Create the socket without access for other users
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict control socket: %w", err)
+	}
+
+	s.listener = listener
+	s.path = path
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// serve accepts connections until the listener is closed.
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = struct{}{}
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+
+			s.mu.Lock()
+			delete(s.conns, conn)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// serveConn answers the requests on one connection, in order.
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	encoder := json.NewEncoder(conn)
+	encoder.SetEscapeHTML(false)
+
+	for scanner.Scan() {
+		resp := s.call(scanner.Bytes())
+		if resp == nil {
+			continue // notifications get no response
+		}
+		if err := encoder.Encode(resp); err != nil {
+			return
+		}
+	}
+}
+
+// call runs the method named by a request line, returning nil for notifications.
+func (s *Server) call(line []byte) *response {
+	var req request
+	if err := json.Unmarshal(line, &req); err != nil {
+		return &response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{Code: codeParseError, Message: "invalid request"}}
+	}
+
+	resp := &response{JSONRPC: "2.0", ID: req.ID}
+	handler, ok := s.handlers[req.Method]
+	if !ok {
+		resp.Error = &Error{Code: codeMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)}
+	} else if result, err := handler(req.Params); err != nil {
+		util.DebugLog("control method %s failed: %v", req.Method, err)
+		resp.Error = &Error{Code: codeInternalError, Message: err.Error()}
+	} else if result != nil {
+		resp.Result = result
+	} else {
+		resp.Result = struct{}{}
+	}
+
+	if req.ID == nil {
+		return nil
+	}
+	return resp
+}
+
+// Close stops serving, closes open connections, and removes the socket.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+
+	os.Remove(s.path)
+	return err
+}
+
+// Call invokes method with params on the control socket at path and decodes
+// its result into result, which may be nil to discard it.
+func Call(path, method string, params, result any) error {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control socket %s (is sshx running with --control-socket?): %w", path, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callTimeout))
+
+	req := request{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method}
+	if params != nil {
+		if req.Params, err = json.Marshal(params); err != nil {
+			return fmt.Errorf("failed to encode params: %w", err)
+		}
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *Error          `json:"error"`
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result != nil && resp.Result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+	return nil
+}
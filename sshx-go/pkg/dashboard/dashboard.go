@@ -0,0 +1,242 @@
+// Package dashboard registers sessions with the sshx server's dashboard API.
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Session is the view of a session needed to register it with a dashboard.
+type Session interface {
+	Name() string
+	URL() string
+	WriteURL() *string
+}
+
+// RegisterRequest matches the Rust RegisterDashboardRequest.
+type RegisterRequest struct {
+	SessionName  string  `json:"sessionName"`
+	URL          string  `json:"url"`
+	WriteURL     *string `json:"writeUrl,omitempty"`
+	DisplayName  string  `json:"displayName"`
+	DashboardKey *string `json:"dashboardKey,omitempty"`
+	// Tags let dashboards filter and group sessions, e.g. env=prod.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// RegisterResponse from the server.
+type RegisterResponse struct {
+	DashboardKey string `json:"dashboardKey"`
+	DashboardURL string `json:"dashboardUrl"`
+}
+
+// Heartbeat reports that a session is alive, so dashboards can mark sessions
+// that stop sending them as stale.
+type Heartbeat struct {
+	// Uptime is how long the session has been open, in seconds.
+	Uptime int64 `json:"uptime"`
+	// Shells is the number of open shells.
+	Shells int `json:"shells"`
+	// Transport is the connection method in use, e.g. gRPC or WebSocket.
+	Transport string `json:"transport"`
+}
+
+// ErrNotListed is returned by SendHeartbeat when the dashboard does not list
+// the session, e.g. because its entry expired or the server predates
+// heartbeats.
+var ErrNotListed = errors.New("session is not listed on the dashboard")
+
+// Info describes the dashboard a session was registered with.
+type Info struct {
+	Key string
+	URL string
+}
+
+// Limits on tags, which dashboards show next to every session.
+const (
+	maxTagKey   = 64
+	maxTagValue = 256
+)
+
+// HostTags returns the tags sent with every registration: the hostname, the
+// operating system and architecture, and the client version if known.
+func HostTags(clientVersion string) map[string]string {
+	tags := map[string]string{
+		"os":   runtime.GOOS,
+		"arch": runtime.GOARCH,
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		tags["hostname"] = hostname
+	}
+	if clientVersion != "" {
+		tags["version"] = clientVersion
+	}
+	return tags
+}
+
+// ValidateTag checks a tag. Keys are up to 64 letters, digits and . _ - : /,
+// values up to 256 bytes of printable text.
+func ValidateTag(key, value string) error {
+	if key == "" || len(key) > maxTagKey {
+		return fmt.Errorf("invalid tag key %q (expected 1 to %d characters)", key, maxTagKey)
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("._-:/", r)) {
+			return fmt.Errorf("invalid tag key %q (expected letters, digits and . _ - : /)", key)
+		}
+	}
+	if len(value) > maxTagValue {
+		return fmt.Errorf("value of tag %q is longer than %d bytes", key, maxTagValue)
+	}
+	for _, r := range value {
+		if r < ' ' || r == 0x7f {
+			return fmt.Errorf("value of tag %q contains control characters", key)
+		}
+	}
+	return nil
+}
+
+// ParseTags parses tags given as KEY=VALUE. Later values of a key replace
+// earlier ones.
+func ParseTags(pairs []string) (map[string]string, error) {
+	tags := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid tag %q (expected KEY=VALUE)", pair)
+		}
+		if err := ValidateTag(key, value); err != nil {
+			return nil, err
+		}
+		tags[key] = value
+	}
+	return tags, nil
+}
+
+// MakeRelativeURL extracts relative URL from full URL for reverse proxy compatibility.
+func MakeRelativeURL(fullURL string) string {
+	if u, err := url.Parse(fullURL); err == nil {
+		relative := u.Path
+		if u.RawQuery != "" {
+			relative += "?" + u.RawQuery
+		}
+		if u.Fragment != "" {
+			relative += "#" + u.Fragment
+		}
+		return relative
+	}
+	// If parsing fails, assume it's already relative
+	return fullURL
+}
+
+// Register registers a session with the dashboard on server. A nil dashboardKey
+// or an empty key creates a new dashboard. Servers that predate tags ignore them.
+func Register(httpClient *http.Client, server string, session Session, displayName string, dashboardKey *string, tags map[string]string) (*Info, error) {
+	dashboardURL := strings.TrimSuffix(server, "/") + "/api/dashboards/register"
+
+	// Prepare request payload - matches Rust RegisterDashboardRequest exactly
+	request := RegisterRequest{
+		SessionName:  session.Name(),
+		URL:          MakeRelativeURL(session.URL()),
+		DisplayName:  displayName,
+		DashboardKey: dashboardKey,
+		Tags:         tags,
+	}
+
+	if writeURL := session.WriteURL(); writeURL != nil {
+		relativeWriteURL := MakeRelativeURL(*writeURL)
+		request.WriteURL = &relativeWriteURL
+	}
+
+	// Convert to JSON
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	// Make HTTP POST request
+	resp, err := httpClient.Post(dashboardURL, "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to post to dashboard: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("Dashboard registration failed with status: %s", resp.Status)
+	}
+
+	var response RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &Info{
+		Key: response.DashboardKey,
+		URL: response.DashboardURL,
+	}, nil
+}
+
+// Unregister removes a session from the dashboard identified by dashboardKey.
+// Servers that do not support removal leave the session listed until it expires.
+func Unregister(httpClient *http.Client, server string, dashboardKey string, sessionName string) error {
+	unregisterURL := strings.TrimSuffix(server, "/") + "/api/dashboards/" + url.PathEscape(dashboardKey) + "/sessions/" + url.PathEscape(sessionName)
+
+	req, err := http.NewRequest(http.MethodDelete, unregisterURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete from dashboard: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		// Already gone, or the server predates dashboard removal
+		return nil
+	default:
+		return fmt.Errorf("Dashboard deregistration failed with status: %s", resp.Status)
+	}
+}
+
+// SendHeartbeat reports that a session of the dashboard identified by
+// dashboardKey is alive.
+func SendHeartbeat(httpClient *http.Client, server string, dashboardKey string, sessionName string, heartbeat Heartbeat) error {
+	heartbeatURL := strings.TrimSuffix(server, "/") + "/api/dashboards/" + url.PathEscape(dashboardKey) + "/sessions/" + url.PathEscape(sessionName) + "/heartbeat"
+
+	jsonData, err := json.Marshal(heartbeat)
+	if err != nil {
+		return fmt.Errorf("failed to marshal heartbeat: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPut, heartbeatURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send heartbeat: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed:
+		return ErrNotListed
+	default:
+		return fmt.Errorf("Dashboard heartbeat failed with status: %s", resp.Status)
+	}
+}
@@ -0,0 +1,217 @@
+package dashboard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"sshx-go/pkg/util"
+)
+
+const (
+	// refreshInterval re-registers periodically in case the entry expired.
+	refreshInterval = 10 * time.Minute
+
+	// DefaultHeartbeatInterval is how often sessions report to their
+	// dashboard that they are alive.
+	DefaultHeartbeatInterval = 30 * time.Second
+
+	retryMin = time.Second
+	retryMax = time.Minute
+)
+
+// Registrar keeps a session registered with a dashboard. It re-registers when
+// the session reconnects or its URL changes, retrying failures with backoff.
+type Registrar struct {
+	httpClient  *http.Client
+	server      string
+	session     Session
+	displayName string
+	tags        map[string]string
+
+	mu      sync.Mutex
+	key     string // Dashboard key, learned from the first registration if empty
+	info    *Info
+	lastURL string
+	closed  bool // Set by Unregister to stop further registrations
+
+	// Signalled by Changed to re-register right away
+	changed chan struct{}
+}
+
+// NewRegistrar creates a registrar for session. An empty dashboardKey creates a
+// new dashboard on the first registration, which later registrations join.
+// Every registration carries tags.
+func NewRegistrar(httpClient *http.Client, server string, session Session, displayName, dashboardKey string, tags map[string]string) *Registrar {
+	return &Registrar{
+		httpClient:  httpClient,
+		server:      server,
+		session:     session,
+		displayName: displayName,
+		tags:        tags,
+		key:         dashboardKey,
+		changed:     make(chan struct{}, 1),
+	}
+}
+
+// Register registers the session once and returns the dashboard it joined.
+func (r *Registrar) Register() (*Info, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("session was unregistered")
+	}
+
+	url := r.session.URL()
+	key := r.key
+	info, err := Register(r.httpClient, r.server, r.session, r.displayName, &key, r.tags)
+	if err != nil {
+		return nil, err
+	}
+
+	r.info = info
+	r.lastURL = url
+	if info.Key != "" {
+		r.key = info.Key
+	}
+	return info, nil
+}
+
+// Unregister removes the session from its dashboard and stops further
+// registrations. It does nothing if the session was never registered.
+func (r *Registrar) Unregister() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.info == nil {
+		return nil
+	}
+	return Unregister(r.httpClient, r.server, r.info.Key, r.session.Name())
+}
+
+// Info returns the dashboard from the most recent successful registration, or nil.
+func (r *Registrar) Info() *Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.info
+}
+
+// Changed makes Watch re-register the session, e.g. once the dashboard no
+// longer lists it. Requests are coalesced.
+func (r *Registrar) Changed() {
+	select {
+	case r.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Watch re-registers whenever reconnected fires, the session URL changes,
+// Changed is called, or the refresh interval elapses, until ctx is cancelled. A failed registration,
+// including an initial one, is retried with exponential backoff.
+func (r *Registrar) Watch(ctx context.Context, reconnected <-chan struct{}) {
+	refresh := time.NewTicker(refreshInterval)
+	defer refresh.Stop()
+
+	r.mu.Lock()
+	pending := r.info == nil
+	r.mu.Unlock()
+
+	for {
+		if pending || r.urlChanged() {
+			r.registerWithRetry(ctx)
+			pending = false
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-reconnected:
+			util.DebugLog("re-registering %s with dashboard after reconnect", r.session.Name())
+			pending = true
+		case <-r.changed:
+			pending = true
+		case <-refresh.C:
+			pending = true
+		}
+	}
+}
+
+// urlChanged reports whether the session URL differs from the registered one.
+func (r *Registrar) urlChanged() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session.URL() != r.lastURL
+}
+
+// isClosed reports whether Unregister has been called.
+func (r *Registrar) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// registerWithRetry registers until it succeeds or ctx is cancelled.
+func (r *Registrar) registerWithRetry(ctx context.Context) {
+	delay := retryMin
+	for {
+		_, err := r.Register()
+		if err == nil || r.isClosed() {
+			return
+		}
+		util.Warnf("Dashboard registration failed, retrying in %s: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, retryMax)
+	}
+}
+
+// Heartbeats sends the heartbeat status returns every interval while the
+// session is registered, until ctx is cancelled or Unregister is called. A
+// session the dashboard no longer lists is re-registered once; if its
+// heartbeats are still refused, the server is taken not to support them.
+func (r *Registrar) Heartbeats(ctx context.Context, interval time.Duration, status func() Heartbeat) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	reregistered := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		info := r.Info()
+		if r.isClosed() {
+			return
+		}
+		if info == nil {
+			// Not registered yet, which Watch keeps retrying
+			continue
+		}
+
+		err := SendHeartbeat(r.httpClient, r.server, info.Key, r.session.Name(), status())
+		switch {
+		case err == nil:
+			reregistered = false
+		case errors.Is(err, ErrNotListed) && !reregistered:
+			util.DebugLog("%s is no longer listed on the dashboard, re-registering", r.session.Name())
+			reregistered = true
+			r.Changed()
+		case errors.Is(err, ErrNotListed):
+			util.DebugLog("dashboard does not accept heartbeats, stopping them")
+			return
+		default:
+			util.DebugLog("dashboard heartbeat failed: %v", err)
+		}
+	}
+}
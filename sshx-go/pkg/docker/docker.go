@@ -0,0 +1,242 @@
+// Package docker is a minimal client of the Docker Engine API, covering what
+// is needed to run interactive commands in containers as docker exec -it
+// does, without depending on the docker CLI.
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	// DefaultHost is the daemon address used when DOCKER_HOST is unset.
+	DefaultHost = "unix:///var/run/docker.sock"
+
+	// apiVersion is the oldest API version with every call used here,
+	// supported since Docker 20.10.
+	apiVersion = "v1.41"
+)
+
+// Client talks to a Docker daemon.
+type Client struct {
+	network, address string
+	http             *http.Client
+}
+
+// NewClient creates a client of the daemon at host, e.g.
+// "unix:///var/run/docker.sock" or "tcp://127.0.0.1:2375". An empty host
+// uses DOCKER_HOST, or else DefaultHost. TLS connections are not supported.
+func NewClient(host string) (*Client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = DefaultHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Docker host %q: %w", host, err)
+	}
+	c := &Client{}
+	switch u.Scheme {
+	case "unix":
+		c.network, c.address = "unix", u.Path
+	case "tcp", "http":
+		c.network, c.address = "tcp", u.Host
+	default:
+		return nil, fmt.Errorf("unsupported Docker host %q", host)
+	}
+	c.http = &http.Client{
+		Transport: &http.Transport{DialContext: c.dial},
+	}
+	return c, nil
+}
+
+// dial connects to the daemon, whatever address was asked for.
+func (c *Client) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, c.network, c.address)
+}
+
+// Container describes a container.
+type Container struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+}
+
+// Inspect looks up a container by name or ID.
+func (c *Client) Inspect(ctx context.Context, container string) (*Container, error) {
+	var result Container
+	if err := c.call(ctx, http.MethodGet, "/containers/"+url.PathEscape(container)+"/json", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// ExecConfig configures a command run in a container.
+type ExecConfig struct {
+	// Cmd is the command and its arguments.
+	Cmd []string
+	// Env holds extra KEY=VALUE variables.
+	Env []string
+	// User runs the command as this user, instead of the container's.
+	User string
+	// WorkingDir is the working directory. Empty uses the container's.
+	WorkingDir string
+}
+
+// Exec is a command running in a container with a TTY. Reading returns its
+// output and writing sends it input, over a connection hijacked from the
+// daemon.
+type Exec struct {
+	client *Client
+	id     string
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Exec starts a command in a running container with a TTY attached.
+//
+// Docker leaves the command running when its connection closes, so Close
+// does not stop it; an interactive shell is expected to be exited by its
+// user.
+func (c *Client) Exec(ctx context.Context, container string, config ExecConfig) (*Exec, error) {
+	create := map[string]any{
+		"AttachStdin":  true,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Tty":          true,
+		"Cmd":          config.Cmd,
+		"Env":          config.Env,
+		"User":         config.User,
+		"WorkingDir":   config.WorkingDir,
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.call(ctx, http.MethodPost, "/containers/"+url.PathEscape(container)+"/exec", create, &created); err != nil {
+		return nil, err
+	}
+
+	// Starting upgrades the connection to a raw stream, which net/http
+	// clients do not support, so the request is made by hand
+	conn, err := c.dial(ctx, "", "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	body, _ := json.Marshal(map[string]bool{"Detach": false, "Tty": true})
+	req, err := http.NewRequest(http.MethodPost, "http://docker/"+apiVersion+"/exec/"+created.ID+"/start", bytes.NewReader(body))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start exec: %w", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to start exec: %w", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		return nil, responseError(resp)
+	}
+	conn.SetDeadline(time.Time{})
+
+	return &Exec{client: c, id: created.ID, conn: conn, reader: reader}, nil
+}
+
+// Read reads output from the command.
+func (e *Exec) Read(p []byte) (int, error) {
+	return e.reader.Read(p)
+}
+
+// Write sends input to the command.
+func (e *Exec) Write(p []byte) (int, error) {
+	return e.conn.Write(p)
+}
+
+// Close closes the connection to the command.
+func (e *Exec) Close() error {
+	return e.conn.Close()
+}
+
+// Resize sets the window size of the command's TTY.
+func (e *Exec) Resize(ctx context.Context, rows, cols uint16) error {
+	query := url.Values{}
+	query.Set("h", strconv.Itoa(int(rows)))
+	query.Set("w", strconv.Itoa(int(cols)))
+	return e.client.call(ctx, http.MethodPost, "/exec/"+e.id+"/resize?"+query.Encode(), nil, nil)
+}
+
+// call makes an API request with an optional JSON body, decoding the JSON
+// response into out when non-nil.
+func (c *Client) call(ctx context.Context, method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker/"+apiVersion+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("invalid response from Docker: %w", err)
+	}
+	return nil
+}
+
+// responseError turns an error response into an error, using the daemon's
+// message when it sent one.
+func responseError(resp *http.Response) error {
+	var result struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if json.Unmarshal(data, &result) == nil && result.Message != "" {
+		return fmt.Errorf("docker: %s", result.Message)
+	}
+	return fmt.Errorf("docker: unexpected status %s", resp.Status)
+}
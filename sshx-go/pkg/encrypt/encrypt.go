@@ -16,6 +16,7 @@ const salt = "This is a non-random salt for sshx.io, since we want to stretch th
 // Encrypt handles stream encryption using Argon2 + AES-CTR.
 type Encrypt struct {
 	aesKey [16]byte
+	block  cipher.Block // AES with aesKey, safe for concurrent use
 }
 
 // New creates a new encryptor from a password string.
@@ -26,24 +27,25 @@ func New(key string) *Encrypt {
 	
 	var keyArray [16]byte
 	copy(keyArray[:], aesKey)
-	
+
+	block, err := aes.NewCipher(keyArray[:])
+	if err != nil {
+		panic(fmt.Sprintf("failed to create AES cipher: %v", err))
+	}
+
 	return &Encrypt{
 		aesKey: keyArray,
+		block:  block,
 	}
 }
 
 // Zeros returns the encrypted zero block for client verification.
 func (e *Encrypt) Zeros() []byte {
 	zeros := make([]byte, 16)
-	
-	block, err := aes.NewCipher(e.aesKey[:])
-	if err != nil {
-		panic(fmt.Sprintf("failed to create AES cipher: %v", err))
-	}
-	
+
 	// Use zero IV for the zero block
 	iv := make([]byte, 16)
-	stream := cipher.NewCTR(block, iv)
+	stream := cipher.NewCTR(e.block, iv)
 	stream.XORKeyStream(zeros, zeros)
 	
 	return zeros
@@ -52,36 +54,43 @@ func (e *Encrypt) Zeros() []byte {
 // Segment encrypts a data segment from a stream.
 // streamNum must be non-zero for security.
 // offset specifies the byte offset within the stream.
+//
+// Offsets need not be block-aligned: encrypting pieces of a stream at their
+// own offsets gives the same bytes as encrypting the stream at once, like
+// seeking the Rust client's Ctr64BE cipher. The server and browsers depend on
+// this to stitch together chunks, so any change must keep it exact.
 func (e *Encrypt) Segment(streamNum uint64, offset uint64, data []byte) []byte {
+	return e.SegmentInto(make([]byte, len(data)), streamNum, offset, data)
+}
+
+// SegmentInto is like Segment, but writes the result to dst, which must be at
+// least as long as data, and returns dst[:len(data)]. dst may be data itself
+// to encrypt in place, e.g. a chunk that was already copied out of a buffer.
+// Otherwise, dst and data must not overlap.
+func (e *Encrypt) SegmentInto(dst []byte, streamNum uint64, offset uint64, data []byte) []byte {
 	if streamNum == 0 {
 		panic("stream number must be nonzero")
 	}
-	
-	block, err := aes.NewCipher(e.aesKey[:])
-	if err != nil {
-		panic(fmt.Sprintf("failed to create AES cipher: %v", err))
-	}
-	
+
 	// Construct IV: stream number (8 bytes big-endian) + counter offset (8 bytes big-endian)
 	// The counter offset is offset / 16 (since AES block size is 16 bytes)
-	iv := make([]byte, 16)
+	var iv [16]byte
 	binary.BigEndian.PutUint64(iv[0:8], streamNum)
 	binary.BigEndian.PutUint64(iv[8:16], offset/16)
-	
-	stream := cipher.NewCTR(block, iv)
+
+	stream := cipher.NewCTR(e.block, iv[:])
 	
 	// Handle partial block offset within the current counter block
 	blockOffset := offset % 16
 	if blockOffset > 0 {
 		// We need to advance within the current block
-		skipBuf := make([]byte, blockOffset)
-		stream.XORKeyStream(skipBuf, skipBuf)
+		var skipBuf [16]byte
+		stream.XORKeyStream(skipBuf[:blockOffset], skipBuf[:blockOffset])
 	}
 	
 	// Encrypt the actual data
-	result := make([]byte, len(data))
-	copy(result, data)
-	stream.XORKeyStream(result, result)
-	
-	return result
+	dst = dst[:len(data)]
+	stream.XORKeyStream(dst, data)
+
+	return dst
 }
\ No newline at end of file
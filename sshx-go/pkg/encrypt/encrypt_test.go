@@ -0,0 +1,135 @@
+package encrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+)
+
+// reference encrypts data like the Rust client's Ctr64BE cipher, one block at
+// a time: block i of the stream is XORed with AES of the stream number and i,
+// where only the low 64 bits count up.
+func reference(key [16]byte, streamNum uint64, offset uint64, data []byte) []byte {
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		panic(err)
+	}
+	out := make([]byte, len(data))
+	var counter, keystream [16]byte
+	for i := range data {
+		pos := offset + uint64(i)
+		if i == 0 || pos%16 == 0 {
+			binary.BigEndian.PutUint64(counter[0:8], streamNum)
+			binary.BigEndian.PutUint64(counter[8:16], pos/16)
+			block.Encrypt(keystream[:], counter[:])
+		}
+		out[i] = data[i] ^ keystream[pos%16]
+	}
+	return out
+}
+
+func TestZeros(t *testing.T) {
+	// Test vector from the Rust client's make_encrypt test.
+	want := []byte{198, 3, 249, 238, 65, 10, 224, 98, 253, 73, 148, 1, 138, 3, 108, 143}
+	if got := New("test").Zeros(); !bytes.Equal(got, want) {
+		t.Fatalf("Zeros() = %v, want %v", got, want)
+	}
+}
+
+func TestSegmentRoundtrip(t *testing.T) {
+	e := New("this is a test key")
+	data := []byte("hello world")
+	encrypted := e.Segment(1, 0, data)
+	if bytes.Equal(encrypted, data) {
+		t.Fatal("segment was not encrypted")
+	}
+	if got := e.Segment(1, 0, encrypted); !bytes.Equal(got, data) {
+		t.Fatalf("decrypted %q, want %q", got, data)
+	}
+}
+
+func TestSegmentZeroStream(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Segment did not panic on stream number 0")
+		}
+	}()
+	New("this is a test key").Segment(0, 0, []byte("hello world"))
+}
+
+// TestSegmentOffsets checks random segments at arbitrary, mostly unaligned
+// offsets against the reference, and that splitting a segment anywhere and
+// encrypting both pieces at their own offsets gives the same bytes.
+func TestSegmentOffsets(t *testing.T) {
+	e := New("this is a test key")
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		streamNum := rng.Uint64() | 1
+		offset := rng.Uint64() >> 16
+		if i%4 == 0 {
+			offset %= 64
+		}
+		data := make([]byte, rng.Intn(100))
+		rng.Read(data)
+
+		want := reference(e.aesKey, streamNum, offset, data)
+		got := e.Segment(streamNum, offset, data)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("Segment(%#x, %d, %d bytes) differs from the reference", streamNum, offset, len(data))
+		}
+
+		split := 0
+		if len(data) > 0 {
+			split = rng.Intn(len(data) + 1)
+		}
+		pieces := append(e.Segment(streamNum, offset, data[:split]), e.Segment(streamNum, offset+uint64(split), data[split:])...)
+		if !bytes.Equal(pieces, want) {
+			t.Fatalf("Segment(%#x, %d, %d bytes) split at %d differs from the whole", streamNum, offset, len(data), split)
+		}
+	}
+}
+
+// FuzzSegment compares Segment with the reference. Offsets are kept below
+// 2^60 so the block counter cannot wrap, which no real stream gets near.
+func FuzzSegment(f *testing.F) {
+	f.Add(uint64(1), uint64(0), []byte("hello world"))
+	f.Add(uint64(0x100000001), uint64(15), []byte("1st block.(16B)|2nd block......|3rd block"))
+	f.Add(uint64(0x200000000), uint64(1<<40+7), []byte{0, 1, 2})
+
+	e := New("this is a test key")
+	f.Fuzz(func(t *testing.T, streamNum uint64, offset uint64, data []byte) {
+		if streamNum == 0 {
+			return
+		}
+		offset >>= 4
+		want := reference(e.aesKey, streamNum, offset, data)
+		if got := e.Segment(streamNum, offset, data); !bytes.Equal(got, want) {
+			t.Fatalf("Segment(%#x, %d, %d bytes) differs from the reference", streamNum, offset, len(data))
+		}
+	})
+}
+
+// benchmarkSegment encrypts a full terminal chunk at an unaligned offset.
+func benchmarkSegment(b *testing.B, segment func(e *Encrypt, data []byte) []byte) {
+	e := New("benchmark")
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64<<10/16)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		segment(e, data)
+	}
+}
+
+func BenchmarkSegment(b *testing.B) {
+	benchmarkSegment(b, func(e *Encrypt, data []byte) []byte {
+		return e.Segment(1, 1000003, data)
+	})
+}
+
+func BenchmarkSegmentInPlace(b *testing.B) {
+	benchmarkSegment(b, func(e *Encrypt, data []byte) []byte {
+		return e.SegmentInto(data, 1, 1000003, data)
+	})
+}
@@ -0,0 +1,344 @@
+package qr
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Tables of the standard for level L, indexed by version.
+var (
+	// Bytes held in byte mode
+	goldenCapacity = [maxVersion + 1]int{0, 17, 32, 53, 78, 106, 134, 154, 192, 230, 271}
+	// Codewords, data and error correction
+	goldenRawCodewords = [maxVersion + 1]int{0, 26, 44, 70, 100, 134, 172, 196, 242, 292, 346}
+	// Error correction codewords per block, and blocks
+	goldenECC    = [maxVersion + 1]int{0, 7, 10, 15, 20, 26, 18, 20, 24, 30, 18}
+	goldenBlocks = [maxVersion + 1]int{0, 1, 1, 1, 1, 1, 2, 2, 2, 2, 4}
+	// Centers of the alignment patterns
+	goldenAlignment = [maxVersion + 1][]int{
+		nil, nil, {6, 18}, {6, 22}, {6, 26}, {6, 30}, {6, 34},
+		{6, 22, 38}, {6, 24, 42}, {6, 26, 46}, {6, 28, 50},
+	}
+	// Version information of versions 7 and up
+	goldenVersionInfo = map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99, 10: 0x0A4D3}
+)
+
+// goldenFormat is the format information of level L with each mask, bit 14
+// first.
+var goldenFormat = [8]string{
+	"111011111000100", "111001011110011", "111110110101010", "111100010011101",
+	"110011000101111", "110001100011000", "110110001000001", "110100101110110",
+}
+
+func TestVersionSelection(t *testing.T) {
+	for version := 1; version <= maxVersion; version++ {
+		if got := rawCodewords(version); got != goldenRawCodewords[version] {
+			t.Errorf("rawCodewords(%d) = %d, want %d", version, got, goldenRawCodewords[version])
+		}
+		if got := alignmentPositions(version, 4*version+17); !slices.Equal(got, goldenAlignment[version]) {
+			t.Errorf("alignmentPositions(%d) = %v, want %v", version, got, goldenAlignment[version])
+		}
+
+		// The longest text of each version, and one byte more
+		for n, want := range map[int]int{goldenCapacity[version]: version, goldenCapacity[version] + 1: version + 1} {
+			code, err := Encode(strings.Repeat("a", n))
+			if want > maxVersion {
+				if !errors.Is(err, ErrTooLong) {
+					t.Errorf("Encode of %d bytes returned %v, want ErrTooLong", n, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if code.Size != 4*want+17 {
+				t.Errorf("%d bytes encoded in version %d, want %d", n, (code.Size-17)/4, want)
+			}
+		}
+	}
+}
+
+func TestReedSolomon(t *testing.T) {
+	// The generator polynomial of degree 7, x^7 + 127x^6 + ... + 117
+	if got, want := rsDivisor(7), []byte{127, 122, 154, 164, 11, 68, 117}; !slices.Equal(got, want) {
+		t.Errorf("rsDivisor(7) = %v, want %v", got, want)
+	}
+	// "HELLO WORLD" at 1-M, the example of the standard's tutorials
+	data := []byte{32, 91, 11, 120, 209, 114, 220, 77, 67, 64, 236, 17, 236, 17, 236, 17}
+	want := []byte{196, 35, 39, 119, 235, 215, 231, 226, 93, 23}
+	if got := rsRemainder(data, rsDivisor(10)); !slices.Equal(got, want) {
+		t.Errorf("error correction codewords = %v, want %v", got, want)
+	}
+}
+
+func TestFormatAndVersionInfo(t *testing.T) {
+	for version := 1; version <= maxVersion; version++ {
+		code, err := Encode(strings.Repeat("a", goldenCapacity[version]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for mask := range 8 {
+			code.drawFormat(mask)
+			if got := readFormat(t, code); got != goldenFormat[mask] {
+				t.Errorf("version %d mask %d: format information %s, want %s", version, mask, got, goldenFormat[mask])
+			}
+		}
+		if want, ok := goldenVersionInfo[version]; ok {
+			var topRight, bottomLeft int
+			for i := range 18 {
+				a, b := code.Size-11+i%3, i/3
+				if code.Dark(a, b) {
+					topRight |= 1 << i
+				}
+				if code.Dark(b, a) {
+					bottomLeft |= 1 << i
+				}
+			}
+			if topRight != want || bottomLeft != want {
+				t.Errorf("version %d: version information %#x and %#x, want %#x", version, topRight, bottomLeft, want)
+			}
+		}
+	}
+}
+
+// TestRoundTrip decodes codes of every version independently of the encoder:
+// the format information must name a mask, the error correction codewords of
+// each block must check out, and the data must hold the text.
+func TestRoundTrip(t *testing.T) {
+	texts := []string{"", "a", "https://sshx.io/s/abcdefghij#0123456789abcdefghij"}
+	for version := 1; version <= maxVersion; version++ {
+		texts = append(texts, strings.Repeat("é", goldenCapacity[version]/2))
+	}
+	for _, text := range texts {
+		code, err := Encode(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := decode(t, code)
+		if err != nil {
+			t.Errorf("decoding %q: %v", text, err)
+		} else if got != text {
+			t.Errorf("decoded %q, want %q", got, text)
+		}
+	}
+}
+
+// TestMask checks the mask chosen has the lowest penalty, by the standard's
+// rules as scored on known lines.
+func TestMask(t *testing.T) {
+	lines := []struct {
+		line string
+		want int
+	}{
+		{"10101010101", 0},
+		{"11111010101", 3},  // Run of 5
+		{"11111110101", 5},  // Run of 7
+		{"10111010000", 40}, // Finder lookalike
+		{"00001011101", 40}, // Backwards
+	}
+	for _, tt := range lines {
+		line := make([]bool, len(tt.line))
+		for i, c := range tt.line {
+			line[i] = c == '1'
+		}
+		if got := linePenalty(line); got != tt.want {
+			t.Errorf("linePenalty(%s) = %d, want %d", tt.line, got, tt.want)
+		}
+	}
+
+	for version := 1; version <= maxVersion; version++ {
+		code, err := Encode(fmt.Sprintf("%0*d", goldenCapacity[version], version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		chosen := slices.Index(goldenFormat[:], readFormat(t, code))
+		best := code.penalty()
+		for mask := range 8 {
+			code.applyMask(chosen)
+			code.applyMask(mask)
+			code.drawFormat(mask)
+			if penalty := code.penalty(); penalty < best {
+				t.Errorf("version %d: mask %d has penalty %d, below %d of the chosen mask %d", version, mask, penalty, best, chosen)
+			}
+			chosen = mask
+		}
+	}
+}
+
+// readFormat reads both copies of the format information, bit 14 first.
+func readFormat(t *testing.T, c *Code) string {
+	t.Helper()
+	var first, second [15]bool
+	for i := range 6 {
+		first[i] = c.Dark(8, i)
+	}
+	first[6], first[7], first[8] = c.Dark(8, 7), c.Dark(8, 8), c.Dark(7, 8)
+	for i := 9; i < 15; i++ {
+		first[i] = c.Dark(14-i, 8)
+	}
+	for i := range 8 {
+		second[i] = c.Dark(c.Size-1-i, 8)
+	}
+	for i := 8; i < 15; i++ {
+		second[i] = c.Dark(8, c.Size-15+i)
+	}
+	if first != second {
+		t.Fatal("the copies of the format information differ")
+	}
+	var s strings.Builder
+	for i := 14; i >= 0; i-- {
+		s.WriteByte('0' + b2i(first[i]))
+	}
+	return s.String()
+}
+
+// decode reads the text of c, a byte mode code at level L.
+func decode(t *testing.T, c *Code) (string, error) {
+	version := (c.Size - 17) / 4
+	mask := slices.Index(goldenFormat[:], readFormat(t, c))
+	if mask < 0 {
+		return "", errors.New("no mask of level L matches the format information")
+	}
+
+	// Read the data modules in their zigzag order, unmasked
+	var codewords []byte
+	var bits, n int
+	for right := c.Size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := range c.Size {
+			i := vert // row
+			if (right+1)&2 == 0 {
+				i = c.Size - 1 - vert
+			}
+			for _, j := range []int{right, right - 1} { // column
+				if isFunction(version, c.Size, j, i) {
+					continue
+				}
+				bit := c.Dark(j, i) != masked(mask, i, j)
+				bits, n = bits<<1|int(b2i(bit)), n+1
+				if n == 8 {
+					codewords = append(codewords, byte(bits))
+					bits, n = 0, 0
+				}
+			}
+		}
+	}
+	if len(codewords) != goldenRawCodewords[version] {
+		return "", fmt.Errorf("read %d codewords, want %d", len(codewords), goldenRawCodewords[version])
+	}
+
+	// Undo the interleaving; the last blocks have one more data codeword
+	blocks, ecc := goldenBlocks[version], goldenECC[version]
+	dataLen := len(codewords)/blocks - ecc
+	long := len(codewords) % blocks
+	split := make([][]byte, blocks)
+	k := 0
+	for i := range dataLen + 1 {
+		for b := range blocks {
+			if i < dataLen || b >= blocks-long {
+				split[b] = append(split[b], codewords[k])
+				k++
+			}
+		}
+	}
+	for range ecc {
+		for b := range blocks {
+			split[b] = append(split[b], codewords[k])
+			k++
+		}
+	}
+	var data []byte
+	for b, block := range split {
+		// Every root of the generator is a root of a valid block
+		for root, x := 0, byte(1); root < ecc; root, x = root+1, gfMul(x, 2) {
+			var y byte
+			for _, coef := range block {
+				y = gfMul(y, x) ^ coef
+			}
+			if y != 0 {
+				return "", fmt.Errorf("block %d fails error correction check %d", b, root)
+			}
+		}
+		data = append(data, block[:len(block)-ecc]...)
+	}
+
+	var reader bitBuffer
+	for _, b := range data {
+		reader.append(int(b), 8)
+	}
+	read := func(n int) int {
+		v := 0
+		for _, bit := range reader[:n] {
+			v = v<<1 | int(b2i(bit))
+		}
+		reader = reader[n:]
+		return v
+	}
+	if read(4) != 0b0100 {
+		return "", errors.New("not byte mode")
+	}
+	count := read(countBits(version))
+	text := make([]byte, count)
+	for i := range text {
+		text[i] = byte(read(8))
+	}
+	return string(text), nil
+}
+
+// isFunction reports whether the module at column x and row y belongs to a
+// pattern or the format or version information.
+func isFunction(version, size, x, y int) bool {
+	switch {
+	case x < 9 && y < 9, x >= size-8 && y < 9, x < 9 && y >= size-8:
+		return true // Finders, separators and format information
+	case x == 6 || y == 6:
+		return true // Timing patterns
+	case version >= 7 && (x >= size-11 && x < size-8 && y < 6 || y >= size-11 && y < size-8 && x < 6):
+		return true
+	}
+	positions := goldenAlignment[version]
+	for i, cx := range positions {
+		for j, cy := range positions {
+			corner := (i == 0 && j == 0) || (i == 0 && j == len(positions)-1) || (i == len(positions)-1 && j == 0)
+			if !corner && abs(x-cx) <= 2 && abs(y-cy) <= 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// masked reports whether mask inverts the module at row i and column j, as
+// the standard defines the masks.
+func masked(mask, i, j int) bool {
+	switch mask {
+	case 0:
+		return (i+j)%2 == 0
+	case 1:
+		return i%2 == 0
+	case 2:
+		return j%3 == 0
+	case 3:
+		return (i+j)%3 == 0
+	case 4:
+		return (i/2+j/3)%2 == 0
+	case 5:
+		return i*j%2+i*j%3 == 0
+	case 6:
+		return (i*j%2+i*j%3)%2 == 0
+	default:
+		return ((i+j)%2+i*j%3)%2 == 0
+	}
+}
+
+func b2i(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	launchdDir   = "/Library/LaunchDaemons"
+	launchdLabel = "com.sshx.client"
+	launchdPlist = "/Library/LaunchDaemons/com.sshx.client.plist"
+	launchdLog   = "/var/log/sshx.log"
+)
+
+// launchdManager manages the sshx service through launchd on macOS.
+type launchdManager struct{}
+
+// install writes the launch daemon plist and loads it with launchctl.
+func (launchdManager) install(config ServiceConfig) error {
+	// Check permissions
+	if err := checkLaunchdPermissions(); err != nil {
+		return err
+	}
+
+	account, err := lookupServiceAccount(config)
+	if err != nil {
+		return err
+	}
+
+	// Copy binary
+	if err := copyBinary(binaryPath); err != nil {
+		return err
+	}
+
+	// Unload any previous definition so the new plist takes effect
+	_ = runCommand("launchctl", "unload", launchdPlist) // Ignore errors
+
+	fmt.Println("Installing launchd daemon...")
+	if err := os.WriteFile(launchdPlist, []byte(generatePlist(config, account)), 0644); err != nil {
+		return fmt.Errorf("failed to write plist file: %w", err)
+	}
+
+	fmt.Println("Loading sshx daemon...")
+	if err := runCommand("launchctl", "load", "-w", launchdPlist); err != nil {
+		return fmt.Errorf("failed to load launchd daemon: %w", err)
+	}
+
+	fmt.Println("✓ SSHX service installed and started successfully")
+	fmt.Printf("  Use 'sudo launchctl list %s' to check status\n", launchdLabel)
+	fmt.Printf("  Use 'tail -f %s' to view logs\n", launchdLog)
+
+	return nil
+}
+
+// uninstall unloads and removes the launch daemon.
+func (launchdManager) uninstall() error {
+	// Check permissions
+	if err := checkLaunchdPermissions(); err != nil {
+		return err
+	}
+
+	fmt.Println("Unloading sshx daemon...")
+	_ = runCommand("launchctl", "unload", "-w", launchdPlist) // Ignore errors
+
+	fmt.Println("Removing plist file...")
+	_ = os.Remove(launchdPlist) // Ignore if file doesn't exist
+
+	fmt.Println("Removing binary...")
+	_ = os.Remove(binaryPath) // Ignore if file doesn't exist
+
+	fmt.Println("✓ SSHX service uninstalled successfully")
+	return nil
+}
+
+// status prints the launchd job information.
+func (launchdManager) status() error {
+	return runCommand("launchctl", "list", launchdLabel)
+}
+
+// start starts the launchd job.
+func (launchdManager) start() error {
+	return runCommand("launchctl", "start", launchdLabel)
+}
+
+// stop stops the launchd job. KeepAlive only restarts it on failure.
+func (launchdManager) stop() error {
+	return runCommand("launchctl", "stop", launchdLabel)
+}
+
+// restart restarts the launchd job, killing the running instance first.
+func (launchdManager) restart() error {
+	return runCommand("launchctl", "kickstart", "-k", "system/"+launchdLabel)
+}
+
+// binary returns the installed binary if the plist exists.
+func (launchdManager) binary() (string, bool) {
+	if !fileExists(launchdPlist) {
+		return "", false
+	}
+	return binaryPath, true
+}
+
+// checkLaunchdPermissions verifies that we can write to the LaunchDaemons directory.
+func checkLaunchdPermissions() error {
+	if !fileExists(launchdDir) {
+		return fmt.Errorf("launchd directory not found. This system may not support launchd services")
+	}
+
+	return checkWritable(launchdDir)
+}
+
+// generatePlist creates the launchd property list content.
+func generatePlist(config ServiceConfig, account serviceAccount) string {
+	var args strings.Builder
+	for _, arg := range append([]string{binaryPath}, serviceArgs(config)...) {
+		args.WriteString("\n\t\t<string>")
+		xml.EscapeText(&args, []byte(arg))
+		args.WriteString("</string>")
+	}
+
+	var identity strings.Builder
+	identity.WriteString("\n\t<key>UserName</key>\n\t<string>")
+	xml.EscapeText(&identity, []byte(account.User))
+	identity.WriteString("</string>")
+	if account.Group != "" {
+		identity.WriteString("\n\t<key>GroupName</key>\n\t<string>")
+		xml.EscapeText(&identity, []byte(account.Group))
+		identity.WriteString("</string>")
+	}
+
+	var home strings.Builder
+	xml.EscapeText(&home, []byte(account.Home))
+
+	var env strings.Builder
+	for _, kv := range config.Environment {
+		key, value, _ := strings.Cut(kv, "=")
+		env.WriteString("\n\t\t<key>")
+		xml.EscapeText(&env, []byte(key))
+		env.WriteString("</key>\n\t\t<string>")
+		xml.EscapeText(&env, []byte(value))
+		env.WriteString("</string>")
+	}
+
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>%s</string>
+	<key>ProgramArguments</key>
+	<array>%s
+	</array>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ThrottleInterval</key>
+	<integer>5</integer>%s
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>HOME</key>
+		<string>%s</string>%s
+	</dict>
+	<key>WorkingDirectory</key>
+	<string>%s</string>
+	<key>StandardOutPath</key>
+	<string>%s</string>
+	<key>StandardErrorPath</key>
+	<string>%s</string>
+</dict>
+</plist>
+`, launchdLabel, args.String(), identity.String(), home.String(), env.String(), home.String(), launchdLog, launchdLog)
+}
@@ -0,0 +1,568 @@
+// Package service provides system service management functionality.
+//
+// Linux hosts are managed through systemd, while macOS hosts use launchd.
+package service
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	serviceName   = "sshx"
+	binaryPath    = "/usr/local/bin/sshx"
+	defaultServer = "https://sshx.stream"
+)
+
+// ServiceConfig holds configuration for the system service.
+type ServiceConfig struct {
+	Server        string
+	Dashboard     bool
+	EnableReaders bool
+	Name          *string
+	Shell         *string
+	Exec          *string
+	AttachTmux    string
+	OnStart       string
+	Docker        string
+	SSH           string
+	SSHKey        string
+	Transport     string
+	Proxy         string
+	SOCKS5        string
+	TLSCert       string
+	TLSKey        string
+	TLSCA         string
+	TLSServerName string
+	TLSInsecure   bool
+	GrpcTarget    string
+	GrpcAuthority string
+	WSPath        string
+	Sessions      int
+	Config        string
+	LogFormat     string
+	LogMaxSize    string
+	LogMaxAge     time.Duration
+	LogMaxFiles   *int
+	LogCompress   bool
+	LogFile       string
+
+	IdleTimeout      time.Duration
+	BatchDelay       time.Duration
+	AllowedShells    []string
+	RunAsUser        string
+	ShellEnv         []string
+	Cwd              string
+	Login            bool
+	RestartShells    bool
+	Resume           bool
+	KillExisting     bool
+	DumpDir          string
+	MaxUploadKbps    int
+	MaxShells        int
+	ShellMemoryMax   string
+	ShellCPUQuota    string
+	ScrollbackBytes  string
+	ChunkBytes       string
+	KillGrace        time.Duration
+	SanitizeOutput   string
+	InvalidUTF8      string
+	ControlSocket    string
+	WebhookURL       string
+	ReadersOnly      bool
+	WriteURLFile     string
+	NoWriteURLStdout bool
+	URLFile          string
+	DashboardKey     string
+	DashboardKeyFile *string
+	Tags             []string
+	// DashboardHeartbeat overrides the interval of dashboard heartbeats;
+	// zero disables them.
+	DashboardHeartbeat *time.Duration
+
+	// User and Group are the account the service runs as. An empty User runs
+	// it as root; an empty Group uses the user's primary group.
+	User  string
+	Group string
+
+	// Environment holds KEY=VALUE pairs set in the service's environment.
+	Environment []string
+
+	// UserScope installs a per-user systemd service, managed with
+	// "systemctl --user", instead of the system-wide service.
+	UserScope bool
+}
+
+// manager is implemented by each platform's service backend.
+type manager interface {
+	install(config ServiceConfig) error
+	uninstall() error
+	status() error
+	start() error
+	stop() error
+	restart() error
+	// binary returns the path of the installed service's binary, and false
+	// if the service is not installed.
+	binary() (string, bool)
+}
+
+// currentManager returns the service backend for the running platform. A
+// userScope backend manages a per-user service, which requires systemd.
+func currentManager(userScope bool) (manager, error) {
+	if runtime.GOOS == "darwin" {
+		if userScope {
+			return nil, fmt.Errorf("per-user services are only supported with systemd")
+		}
+		return launchdManager{}, nil
+	}
+	return systemdManager{user: userScope}, nil
+}
+
+// InstallWithConfig installs the sshx service with the provided configuration.
+func InstallWithConfig(config ServiceConfig) error {
+	m, err := currentManager(config.UserScope)
+	if err != nil {
+		return err
+	}
+	return m.install(config)
+}
+
+// Install installs the sshx service with default configuration.
+func Install() error {
+	return InstallWithConfig(ServiceConfig{
+		Server: defaultServer,
+	})
+}
+
+// Uninstall removes the sshx service, or the current user's service if userScope is set.
+func Uninstall(userScope bool) error {
+	m, err := currentManager(userScope)
+	if err != nil {
+		return err
+	}
+	return m.uninstall()
+}
+
+// Status checks the status of the sshx service, or the current user's service if userScope is set.
+func Status(userScope bool) error {
+	m, err := currentManager(userScope)
+	if err != nil {
+		return err
+	}
+	return m.status()
+}
+
+// Start starts the sshx service, or the current user's service if userScope is set.
+func Start(userScope bool) error {
+	m, err := currentManager(userScope)
+	if err != nil {
+		return err
+	}
+	return m.start()
+}
+
+// Stop stops the sshx service, or the current user's service if userScope is set.
+func Stop(userScope bool) error {
+	m, err := currentManager(userScope)
+	if err != nil {
+		return err
+	}
+	return m.stop()
+}
+
+// Restart restarts the sshx service, or the current user's service if userScope is set.
+func Restart(userScope bool) error {
+	m, err := currentManager(userScope)
+	if err != nil {
+		return err
+	}
+	return m.restart()
+}
+
+// InstalledBinary returns the path of the binary run by the installed sshx
+// service, or the current user's service if userScope is set, and false if
+// that service is not installed.
+func InstalledBinary(userScope bool) (string, bool) {
+	m, err := currentManager(userScope)
+	if err != nil {
+		return "", false
+	}
+	return m.binary()
+}
+
+// serviceAccount is the account the installed service runs as.
+type serviceAccount struct {
+	User  string
+	Group string // Empty for the user's primary group
+	Home  string
+}
+
+// lookupServiceAccount resolves the configured service user and group.
+func lookupServiceAccount(config ServiceConfig) (serviceAccount, error) {
+	name := config.User
+	if name == "" {
+		name = "root"
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return serviceAccount{}, fmt.Errorf("service user %q not found: %w", name, err)
+	}
+	if config.Group != "" {
+		if _, err := user.LookupGroup(config.Group); err != nil {
+			return serviceAccount{}, fmt.Errorf("service group %q not found: %w", config.Group, err)
+		}
+	}
+	return serviceAccount{User: u.Username, Group: config.Group, Home: u.HomeDir}, nil
+}
+
+// serviceArgs builds the command-line arguments passed to the installed binary.
+func serviceArgs(config ServiceConfig) []string {
+	var args []string
+
+	// Add server argument if not default
+	if config.Server != defaultServer {
+		args = append(args, "--server", config.Server)
+	}
+
+	// Add dashboard flag, with the key to join and where a new key is saved
+	if config.Dashboard {
+		args = append(args, "--dashboard")
+		if config.DashboardKey != "" {
+			args = append(args, "--dashboard-key", config.DashboardKey)
+		}
+		if config.DashboardKeyFile != nil {
+			args = append(args, "--dashboard-key-file", *config.DashboardKeyFile)
+		}
+		for _, tag := range config.Tags {
+			args = append(args, "--tag", tag)
+		}
+		if config.DashboardHeartbeat != nil {
+			args = append(args, "--dashboard-heartbeat", config.DashboardHeartbeat.String())
+		}
+	}
+
+	// Add enable-readers flag
+	if config.EnableReaders {
+		args = append(args, "--enable-readers")
+	}
+
+	// Keep the writable link out of the service logs if requested
+	if config.ReadersOnly {
+		args = append(args, "--readers-only")
+	}
+	if config.WriteURLFile != "" {
+		args = append(args, "--write-url-file", config.WriteURLFile)
+	}
+	if config.NoWriteURLStdout {
+		args = append(args, "--write-url-stdout=false")
+	}
+
+	// Add machine-readable link file if specified
+	if config.URLFile != "" {
+		args = append(args, "--url-file", config.URLFile)
+	}
+
+	// Add name if specified
+	if config.Name != nil {
+		args = append(args, "--name", *config.Name)
+	}
+
+	// Add shell if specified
+	if config.Shell != nil {
+		args = append(args, "--shell", *config.Shell)
+	}
+
+	// Start login shells if requested
+	if config.Login {
+		args = append(args, "--login")
+	}
+
+	// Add shell restarts if enabled
+	if config.RestartShells {
+		args = append(args, "--restart-shells")
+	}
+
+	// Reattach to the sessions of the last run if enabled
+	if config.Resume {
+		args = append(args, "--resume")
+	}
+
+	// Close the sessions a crashed run left open if enabled
+	if config.KillExisting {
+		args = append(args, "--kill-existing")
+	}
+
+	// Add command to run instead of a shell if specified
+	if config.Exec != nil {
+		args = append(args, "--exec", *config.Exec)
+	}
+
+	// Attach to a tmux session instead of a shell if specified
+	if config.AttachTmux != "" {
+		args = append(args, "--attach-tmux", config.AttachTmux)
+	}
+
+	// Type a command into the first shell if specified
+	if config.OnStart != "" {
+		args = append(args, "--on-start", config.OnStart)
+	}
+
+	// Run shells inside a container if specified
+	if config.Docker != "" {
+		args = append(args, "--docker", config.Docker)
+	}
+
+	// Run shells on a remote host if specified
+	if config.SSH != "" {
+		args = append(args, "--ssh", config.SSH)
+		if config.SSHKey != "" {
+			args = append(args, "--ssh-key", config.SSHKey)
+		}
+	}
+
+	// Restrict which shells may run and the user they run as, if specified
+	for _, shell := range config.AllowedShells {
+		args = append(args, "--allowed-shell", shell)
+	}
+	if config.RunAsUser != "" {
+		args = append(args, "--run-as-user", config.RunAsUser)
+	}
+
+	// Cap the resources of each shell if specified
+	if config.ShellMemoryMax != "" {
+		args = append(args, "--shell-memory-max", config.ShellMemoryMax)
+	}
+	if config.ShellCPUQuota != "" {
+		args = append(args, "--shell-cpu-quota", config.ShellCPUQuota)
+	}
+
+	// Add working directory for spawned shells if specified
+	if config.Cwd != "" {
+		args = append(args, "--cwd", config.Cwd)
+	}
+
+	// Add environment variables for spawned shells
+	for _, kv := range config.ShellEnv {
+		args = append(args, "--env", kv)
+	}
+
+	// Add transport preference if not automatic
+	if config.Transport != "" {
+		args = append(args, "--transport", config.Transport)
+	}
+
+	// Add proxy override if specified
+	if config.Proxy != "" {
+		args = append(args, "--proxy", config.Proxy)
+	}
+
+	// Add SOCKS5 proxy if specified
+	if config.SOCKS5 != "" {
+		args = append(args, "--socks5", config.SOCKS5)
+	}
+
+	// Add TLS client certificate and CA if specified
+	if config.TLSCert != "" {
+		args = append(args, "--tls-cert", config.TLSCert)
+	}
+	if config.TLSKey != "" {
+		args = append(args, "--tls-key", config.TLSKey)
+	}
+	if config.TLSCA != "" {
+		args = append(args, "--tls-ca", config.TLSCA)
+	}
+	if config.TLSServerName != "" {
+		args = append(args, "--tls-server-name", config.TLSServerName)
+	}
+	if config.TLSInsecure {
+		args = append(args, "--tls-insecure")
+	}
+
+	// Add gRPC target and authority overrides if specified
+	if config.GrpcTarget != "" {
+		args = append(args, "--grpc-target", config.GrpcTarget)
+	}
+	if config.GrpcAuthority != "" {
+		args = append(args, "--grpc-authority", config.GrpcAuthority)
+	}
+
+	// Add WebSocket endpoint path if specified
+	if config.WSPath != "" {
+		args = append(args, "--ws-path-template", config.WSPath)
+	}
+
+	// Add multi-session settings if specified
+	if config.Sessions > 1 {
+		args = append(args, "--sessions", strconv.Itoa(config.Sessions))
+	}
+	if config.Config != "" {
+		args = append(args, "--config", config.Config)
+	}
+
+	// Add output sanitizing if enabled
+	if config.SanitizeOutput != "" && config.SanitizeOutput != "off" {
+		args = append(args, "--sanitize-output", config.SanitizeOutput)
+	}
+
+	// Add invalid UTF-8 handling if not the default
+	if config.InvalidUTF8 != "" && config.InvalidUTF8 != "drop" {
+		args = append(args, "--invalid-utf8", config.InvalidUTF8)
+	}
+
+	// Add output rate limit if specified
+	if config.MaxUploadKbps > 0 {
+		args = append(args, "--max-upload-kbps", strconv.Itoa(config.MaxUploadKbps))
+	}
+
+	// Add shell limit if specified
+	if config.MaxShells > 0 {
+		args = append(args, "--max-shells", strconv.Itoa(config.MaxShells))
+	}
+
+	// Add scrollback dump directory if specified
+	if config.DumpDir != "" {
+		args = append(args, "--dump-dir", config.DumpDir)
+	}
+
+	// Add control socket if specified
+	if config.ControlSocket != "" {
+		args = append(args, "--control-socket="+config.ControlSocket)
+	}
+
+	// Add webhook notifications if specified
+	if config.WebhookURL != "" {
+		args = append(args, "--webhook-url", config.WebhookURL)
+	}
+
+	// Add idle timeout if specified
+	if config.IdleTimeout > 0 {
+		args = append(args, "--idle-timeout", config.IdleTimeout.String())
+	}
+
+	// Add output batching delay if specified
+	if config.BatchDelay > 0 {
+		args = append(args, "--batch-delay", config.BatchDelay.String())
+	}
+
+	// Add output buffer sizes if specified
+	if config.ScrollbackBytes != "" {
+		args = append(args, "--scrollback-bytes", config.ScrollbackBytes)
+	}
+	if config.ChunkBytes != "" {
+		args = append(args, "--chunk-bytes", config.ChunkBytes)
+	}
+
+	// Add shell termination grace period if not the default
+	if config.KillGrace != 0 {
+		args = append(args, "--kill-grace", config.KillGrace.String())
+	}
+
+	// Add log output settings if not the defaults
+	if config.LogFormat != "" && config.LogFormat != "text" {
+		args = append(args, "--log-format", config.LogFormat)
+	}
+	if config.LogFile != "" {
+		args = append(args, "--log-file", config.LogFile)
+	}
+	if config.LogMaxSize != "" {
+		args = append(args, "--log-max-size", config.LogMaxSize)
+	}
+	if config.LogMaxAge > 0 {
+		args = append(args, "--log-max-age", config.LogMaxAge.String())
+	}
+	if config.LogMaxFiles != nil {
+		args = append(args, "--log-max-files", strconv.Itoa(*config.LogMaxFiles))
+	}
+	if config.LogCompress {
+		args = append(args, "--log-compress")
+	}
+
+	return args
+}
+
+// copyBinary copies the current executable to dest.
+func copyBinary(dest string) error {
+	currentExe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get current executable path: %w", err)
+	}
+
+	fmt.Printf("Copying binary from %s to %s\n", currentExe, dest)
+
+	input, err := os.ReadFile(currentExe)
+	if err != nil {
+		return fmt.Errorf("failed to read current binary: %w", err)
+	}
+
+	if err := os.WriteFile(dest, input, 0755); err != nil {
+		return fmt.Errorf("failed to copy binary to %s: %w", dest, err)
+	}
+
+	return nil
+}
+
+// checkWritable verifies that we can create files in the given directory.
+func checkWritable(dir string) error {
+	testFile := dir + "/.sshx-test"
+	if err := os.WriteFile(testFile, []byte(""), 0644); err != nil {
+		return fmt.Errorf("service management requires root privileges. Please run with sudo")
+	}
+	os.Remove(testFile)
+	return nil
+}
+
+// envFileHeader starts the environment file created by install.
+const envFileHeader = `# Environment for the sshx service, one KEY=VALUE per line, e.g.
+#   SSHX_SERVER=https://sshx.example.com
+#   HTTPS_PROXY=http://proxy.corp:3128
+# Restart the service after editing this file.
+`
+
+// ensureEnvFile creates the service environment file at path if it does not
+// exist yet, leaving an existing file untouched. It is private since it may
+// hold proxy credentials.
+func ensureEnvFile(path string) error {
+	if fileExists(path) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(envFileHeader), 0600); err != nil {
+		return fmt.Errorf("failed to write environment file: %w", err)
+	}
+	return nil
+}
+
+// shellQuote quotes a single argument for inclusion in a unit file command line.
+// systemd expands % specifiers and $ variables even inside quotes, so both are
+// doubled to reach sshx unchanged.
+func shellQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	if arg != "" && !strings.ContainsAny(arg, " \t\n'\"\\") {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// runCommand executes a system command.
+func runCommand(name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// fileExists checks if a file or directory exists.
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
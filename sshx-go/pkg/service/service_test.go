@@ -0,0 +1,24 @@
+package service
+
+import "testing"
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"--dashboard", "--dashboard"},
+		{"", "''"},
+		{"web server", "'web server'"},
+		{"it's", `'it'\''s'`},
+		{"%h", "%%h"},
+		{"$HOME", "$$HOME"},
+		{"${USER}@%H", "$${USER}@%%H"},
+		{"100% $x", "'100%% $$x'"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.arg); got != tt.want {
+			t.Errorf("shellQuote(%q) = %s, want %s", tt.arg, got, tt.want)
+		}
+	}
+}
@@ -1,93 +1,164 @@
-// Package service provides systemd service management functionality.
 package service
 
 import (
 	"fmt"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 )
 
 const (
-	serviceName = "sshx"
+	systemdDir  = "/etc/systemd/system"
 	serviceFile = "/etc/systemd/system/sshx.service"
-	binaryPath  = "/usr/local/bin/sshx"
+	envFile     = "/etc/sshx/env"
 )
 
-// ServiceConfig holds configuration for the systemd service.
-type ServiceConfig struct {
-	Server        string
-	Dashboard     bool
-	EnableReaders bool
-	Name          *string
-	Shell         *string
+// systemdManager manages the sshx service through systemd on Linux. With user
+// set, it manages a per-user service through "systemctl --user" instead.
+type systemdManager struct {
+	user bool
 }
 
-// InstallWithConfig installs the sshx service with the provided configuration.
-func InstallWithConfig(config ServiceConfig) error {
+// systemdPaths holds the locations of an installed systemd service.
+type systemdPaths struct {
+	unitDir  string
+	unitFile string
+	binary   string
+	envFile  string
+}
+
+// paths returns where the unit file and binary are installed. A per-user
+// service lives under ~/.config/systemd/user and ~/.local/bin.
+func (m systemdManager) paths() (systemdPaths, error) {
+	if !m.user {
+		return systemdPaths{unitDir: systemdDir, unitFile: serviceFile, binary: binaryPath, envFile: envFile}, nil
+	}
+
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return systemdPaths{}, fmt.Errorf("failed to locate user config directory: %w", err)
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return systemdPaths{}, fmt.Errorf("failed to locate home directory: %w", err)
+	}
+	unitDir := filepath.Join(configDir, "systemd", "user")
+	return systemdPaths{
+		unitDir:  unitDir,
+		unitFile: filepath.Join(unitDir, serviceName+".service"),
+		binary:   filepath.Join(home, ".local", "bin", "sshx"),
+		envFile:  filepath.Join(configDir, "sshx", "env"),
+	}, nil
+}
+
+// systemctl runs systemctl against the system manager, or the user's manager.
+func (m systemdManager) systemctl(args ...string) error {
+	if m.user {
+		args = append([]string{"--user"}, args...)
+	}
+	return runCommand("systemctl", args...)
+}
+
+// scope returns the flag selecting the user's manager in printed commands.
+func (m systemdManager) scope() string {
+	if m.user {
+		return " --user"
+	}
+	return ""
+}
+
+// install installs and starts the systemd unit.
+func (m systemdManager) install(config ServiceConfig) error {
+	p, err := m.paths()
+	if err != nil {
+		return err
+	}
+
 	// Check permissions
-	if err := checkPermissions(); err != nil {
+	if err := m.checkPermissions(p); err != nil {
+		return err
+	}
+
+	// Generate the service file for the account it runs as
+	var serviceContent string
+	if m.user {
+		if config.User != "" || config.Group != "" {
+			return fmt.Errorf("a per-user service always runs as the installing user; omit --service-user and --service-group")
+		}
+		serviceContent = generateUserServiceFile(config, p.binary, p.envFile)
+	} else {
+		account, err := lookupServiceAccount(config)
+		if err != nil {
+			return err
+		}
+		serviceContent = generateServiceFile(config, account)
+	}
+
+	// Create the environment file read by the unit, for settings added later
+	if err := ensureEnvFile(p.envFile); err != nil {
 		return err
 	}
 
 	// Copy binary
-	if err := copyBinary(); err != nil {
+	if err := copyBinary(p.binary); err != nil {
 		return err
 	}
 
-	// Generate and write service file
-	serviceContent := generateServiceFile(config)
-	if err := writeServiceFile(serviceContent); err != nil {
+	// Write service file
+	if err := writeServiceFile(p.unitFile, serviceContent); err != nil {
 		return err
 	}
 
 	// Reload systemd and enable/start service
-	if err := reloadSystemd(); err != nil {
+	if err := m.reloadSystemd(); err != nil {
 		return err
 	}
 
-	if err := enableService(); err != nil {
+	if err := m.enableService(); err != nil {
 		return err
 	}
 
-	if err := startService(); err != nil {
+	if err := m.startService(); err != nil {
 		return err
 	}
 
 	fmt.Println("✓ SSHX service installed and started successfully")
-	fmt.Println("  Use 'systemctl status sshx' to check status")
-	fmt.Println("  Use 'journalctl -u sshx -f' to view logs")
+	fmt.Printf("  Use 'systemctl%s status sshx' to check status\n", m.scope())
+	fmt.Printf("  Use 'journalctl%s -u sshx -f' to view logs\n", m.scope())
+	fmt.Printf("  Set environment variables in %s\n", p.envFile)
+	if m.user {
+		fmt.Println("  Use 'loginctl enable-linger' to keep it running after you log out")
+	}
 
 	return nil
 }
 
-// Install installs the sshx service with default configuration.
-func Install() error {
-	return InstallWithConfig(ServiceConfig{
-		Server: "https://sshx.stream",
-	})
-}
+// uninstall stops, disables, and removes the systemd unit.
+func (m systemdManager) uninstall() error {
+	p, err := m.paths()
+	if err != nil {
+		return err
+	}
 
-// Uninstall removes the sshx service.
-func Uninstall() error {
 	// Check permissions
-	if err := checkPermissions(); err != nil {
+	if err := m.checkPermissions(p); err != nil {
 		return err
 	}
 
 	fmt.Println("Stopping sshx service...")
-	_ = runCommand("systemctl", "stop", serviceName) // Ignore errors
+	_ = m.systemctl("stop", serviceName) // Ignore errors
 
 	fmt.Println("Disabling sshx service...")
-	_ = runCommand("systemctl", "disable", serviceName) // Ignore errors
+	_ = m.systemctl("disable", serviceName) // Ignore errors
 
 	fmt.Println("Removing service file...")
-	_ = os.Remove(serviceFile) // Ignore if file doesn't exist
+	_ = os.Remove(p.unitFile) // Ignore if file doesn't exist
 
 	fmt.Println("Removing binary...")
-	_ = os.Remove(binaryPath) // Ignore if file doesn't exist
+	_ = os.Remove(p.binary) // Ignore if file doesn't exist
 
 	fmt.Println("Reloading systemd daemon...")
-	if err := runCommand("systemctl", "daemon-reload"); err != nil {
+	if err := m.systemctl("daemon-reload"); err != nil {
 		return fmt.Errorf("failed to reload systemd daemon: %w", err)
 	}
 
@@ -95,141 +166,157 @@ func Uninstall() error {
 	return nil
 }
 
-// Status checks the status of the sshx service.
-func Status() error {
-	return runCommand("systemctl", "status", serviceName)
+// status checks the status of the systemd unit.
+func (m systemdManager) status() error {
+	return m.systemctl("status", serviceName)
 }
 
-// Start starts the sshx service.
-func Start() error {
-	return runCommand("systemctl", "start", serviceName)
+// start starts the systemd unit.
+func (m systemdManager) start() error {
+	return m.systemctl("start", serviceName)
 }
 
-// Stop stops the sshx service.
-func Stop() error {
-	return runCommand("systemctl", "stop", serviceName)
+// stop stops the systemd unit.
+func (m systemdManager) stop() error {
+	return m.systemctl("stop", serviceName)
 }
 
-// checkPermissions verifies that we have the necessary permissions.
-func checkPermissions() error {
-	if !fileExists("/etc/systemd/system") {
-		return fmt.Errorf("systemd directory not found. This system may not support systemd services")
-	}
+// restart restarts the systemd unit.
+func (m systemdManager) restart() error {
+	return m.systemctl("restart", serviceName)
+}
 
-	// Try to create a test file to check permissions
-	testFile := "/etc/systemd/system/.sshx-test"
-	if err := os.WriteFile(testFile, []byte(""), 0644); err != nil {
-		return fmt.Errorf("service management requires root privileges. Please run with sudo")
+// binary returns the installed binary if the unit file exists.
+func (m systemdManager) binary() (string, bool) {
+	p, err := m.paths()
+	if err != nil || !fileExists(p.unitFile) {
+		return "", false
 	}
-	os.Remove(testFile)
-
-	return nil
+	return p.binary, true
 }
 
-// copyBinary copies the current executable to the system location.
-func copyBinary() error {
-	currentExe, err := os.Executable()
-	if err != nil {
-		return fmt.Errorf("failed to get current executable path: %w", err)
+// checkPermissions verifies that we have the necessary permissions. A per-user
+// service is installed without sudo, creating its directories as needed.
+func (m systemdManager) checkPermissions(p systemdPaths) error {
+	if !m.user {
+		if !fileExists(systemdDir) {
+			return fmt.Errorf("systemd directory not found. This system may not support systemd services")
+		}
+		return checkWritable(systemdDir)
 	}
 
-	fmt.Printf("Copying binary from %s to %s\n", currentExe, binaryPath)
-
-	input, err := os.ReadFile(currentExe)
-	if err != nil {
-		return fmt.Errorf("failed to read current binary: %w", err)
+	if os.Geteuid() == 0 {
+		return fmt.Errorf("per-user services belong to the user running the command. Please run --user without sudo")
 	}
-
-	if err := os.WriteFile(binaryPath, input, 0755); err != nil {
-		return fmt.Errorf("failed to copy binary to %s: %w", binaryPath, err)
+	for _, dir := range []string{p.unitDir, filepath.Dir(p.binary)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dir, err)
+		}
+		testFile := filepath.Join(dir, ".sshx-test")
+		if err := os.WriteFile(testFile, []byte(""), 0644); err != nil {
+			return fmt.Errorf("cannot write to %s: %w", dir, err)
+		}
+		os.Remove(testFile)
 	}
-
 	return nil
 }
 
 // generateServiceFile creates the systemd service file content.
-func generateServiceFile(config ServiceConfig) string {
+func generateServiceFile(config ServiceConfig, account serviceAccount) string {
 	execStart := binaryPath
-
-	// Add server argument if not default
-	if config.Server != "https://sshx.stream" {
-		execStart += fmt.Sprintf(" --server %s", config.Server)
+	for _, arg := range serviceArgs(config) {
+		execStart += " " + shellQuote(arg)
 	}
 
-	// Add dashboard flag
-	if config.Dashboard {
-		execStart += " --dashboard"
+	identity := "User=" + account.User
+	if account.Group != "" {
+		identity += "\nGroup=" + account.Group
 	}
 
-	// Add enable-readers flag
-	if config.EnableReaders {
-		execStart += " --enable-readers"
-	}
+	return fmt.Sprintf(`[Unit]
+Description=SSHX Terminal Sharing Service
+After=network.target
 
-	// Add name if specified
-	if config.Name != nil {
-		execStart += fmt.Sprintf(" --name '%s'", *config.Name)
-	}
+[Service]
+Type=simple
+ExecStart=%s
+Restart=on-failure
+RestartSec=5
+%s
+Environment=HOME=%s
+%s
+WorkingDirectory=-%s
+
+[Install]
+WantedBy=multi-user.target`, execStart, identity, account.Home, systemdEnvironment(config, envFile), account.Home)
+}
 
-	// Add shell if specified
-	if config.Shell != nil {
-		execStart += fmt.Sprintf(" --shell '%s'", *config.Shell)
+// generateUserServiceFile creates the content of a per-user systemd service,
+// which runs as the user in their home directory.
+func generateUserServiceFile(config ServiceConfig, binary, envFile string) string {
+	execStart := shellQuote(binary)
+	for _, arg := range serviceArgs(config) {
+		execStart += " " + shellQuote(arg)
 	}
 
 	return fmt.Sprintf(`[Unit]
 Description=SSHX Terminal Sharing Service
-After=network.target
+After=network-online.target
 
 [Service]
 Type=simple
 ExecStart=%s
 Restart=on-failure
 RestartSec=5
-User=root
-Environment=HOME=/root
-WorkingDirectory=/root
+%s
+WorkingDirectory=%%h
 
 [Install]
-WantedBy=multi-user.target`, execStart)
+WantedBy=default.target`, execStart, systemdEnvironment(config, envFile))
 }
 
-// writeServiceFile writes the service file content to the systemd directory.
-func writeServiceFile(content string) error {
+// systemdEnvironment returns the unit lines setting the service environment,
+// from the configured variables and then the optional environment file.
+func systemdEnvironment(config ServiceConfig, envFile string) string {
+	var lines []string
+	for _, kv := range config.Environment {
+		lines = append(lines, "Environment="+systemdQuote(kv))
+	}
+	lines = append(lines, "EnvironmentFile=-"+envFile)
+	return strings.Join(lines, "\n")
+}
+
+// systemdQuote quotes a value for a unit file setting, escaping specifiers.
+func systemdQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "%", "%%")
+	return `"` + value + `"`
+}
+
+// writeServiceFile writes the service file content to path.
+func writeServiceFile(path, content string) error {
 	fmt.Println("Installing systemd service...")
-	if err := os.WriteFile(serviceFile, []byte(content), 0644); err != nil {
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 		return fmt.Errorf("failed to write service file: %w", err)
 	}
 	return nil
 }
 
 // reloadSystemd reloads the systemd daemon.
-func reloadSystemd() error {
+func (m systemdManager) reloadSystemd() error {
 	fmt.Println("Reloading systemd daemon...")
-	return runCommand("systemctl", "daemon-reload")
+	return m.systemctl("daemon-reload")
 }
 
 // enableService enables the systemd service.
-func enableService() error {
+func (m systemdManager) enableService() error {
 	fmt.Println("Enabling sshx service...")
-	return runCommand("systemctl", "enable", serviceName)
+	return m.systemctl("enable", serviceName)
 }
 
 // startService starts the systemd service.
-func startService() error {
+func (m systemdManager) startService() error {
 	fmt.Println("Starting sshx service...")
-	return runCommand("systemctl", "start", serviceName)
-}
-
-// runCommand executes a system command.
-func runCommand(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// fileExists checks if a file or directory exists.
-func fileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+	return m.systemctl("start", serviceName)
 }
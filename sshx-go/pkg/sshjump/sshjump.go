@@ -0,0 +1,239 @@
+// Package sshjump opens shells on a remote host over SSH, so that sshx can
+// run on a bastion while the shared terminals are on another machine.
+//
+// Users authenticate with the SSH agent and the usual key files, and host
+// keys are verified against known_hosts, as the ssh command does. Hosts
+// missing from known_hosts are refused rather than trusted on first use.
+package sshjump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// dialTimeout bounds connecting to the host, including the SSH handshake.
+const dialTimeout = 15 * time.Second
+
+// defaultKeyFiles are tried, when present, if Config.KeyFile is empty.
+var defaultKeyFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// Config configures the remote host.
+type Config struct {
+	// Target is the host as [user@]host[:port]. The user defaults to the
+	// current one and the port to 22.
+	Target string
+	// KeyFile is a private key used in addition to the SSH agent. Empty tries
+	// ~/.ssh/id_ed25519, id_ecdsa and id_rsa. Keys protected by a passphrase
+	// must be loaded into the agent instead.
+	KeyFile string
+	// KnownHosts is the file host keys are verified against. Empty uses
+	// ~/.ssh/known_hosts.
+	KnownHosts string
+}
+
+// Host is a connection to a remote host, shared by the shells opened on it.
+type Host struct {
+	user, addr string
+	config     *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client // nil until connected, or after the connection failed
+}
+
+// Dial connects to the host described by config, failing early if it cannot
+// be reached or refuses the keys, before any shell is opened.
+func Dial(ctx context.Context, config Config) (*Host, error) {
+	username, addr, err := parseTarget(config.Target)
+	if err != nil {
+		return nil, err
+	}
+	clientConfig, err := config.clientConfig(username)
+	if err != nil {
+		return nil, err
+	}
+
+	h := &Host{user: username, addr: addr, config: clientConfig}
+	if _, err := h.connect(ctx); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// String returns the host as user@host:port.
+func (h *Host) String() string {
+	return h.user + "@" + h.addr
+}
+
+// NewSession opens a session on the host, reconnecting first if the
+// connection was lost.
+func (h *Host) NewSession(ctx context.Context) (*ssh.Session, error) {
+	client, err := h.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	// The connection may have dropped since it was last used
+	h.drop(client)
+	if client, err = h.connect(ctx); err != nil {
+		return nil, err
+	}
+	session, err = client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session on %s: %w", h, err)
+	}
+	return session, nil
+}
+
+// Close closes the connection.
+func (h *Host) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client == nil {
+		return nil
+	}
+	err := h.client.Close()
+	h.client = nil
+	return err
+}
+
+// connect returns the current connection, or makes a new one.
+func (h *Host) connect(ctx context.Context) (*ssh.Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client != nil {
+		return h.client, nil
+	}
+
+	var d net.Dialer
+	ctx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	conn, err := d.DialContext(ctx, "tcp", h.addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", h.addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, h.addr, h.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to %s: %w", h, err)
+	}
+	conn.SetDeadline(time.Time{})
+
+	h.client = ssh.NewClient(c, chans, reqs)
+	return h.client, nil
+}
+
+// drop forgets client if it is still the current connection.
+func (h *Host) drop(client *ssh.Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.client == client {
+		h.client.Close()
+		h.client = nil
+	}
+}
+
+// parseTarget splits [user@]host[:port] into a user and a dialable address.
+func parseTarget(target string) (username, addr string, err error) {
+	username, host, ok := strings.Cut(target, "@")
+	if !ok {
+		host = target
+		current, err := user.Current()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to determine the SSH user: %w", err)
+		}
+		username = current.Username
+	}
+	if username == "" || host == "" {
+		return "", "", fmt.Errorf("invalid SSH target %q (expected [user@]host[:port])", target)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(strings.Trim(host, "[]"), "22")
+	}
+	return username, host, nil
+}
+
+// clientConfig builds the SSH configuration for username.
+func (c Config) clientConfig(username string) (*ssh.ClientConfig, error) {
+	home, _ := os.UserHomeDir()
+
+	knownHostsFile := c.KnownHosts
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts (connect once with ssh to add the host): %w", err)
+	}
+
+	var signers []ssh.Signer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			if agentSigners, err := agent.NewClient(conn).Signers(); err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	if c.KeyFile != "" {
+		signer, err := readKey(c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else {
+		for _, name := range defaultKeyFiles {
+			// Missing keys, and those needing a passphrase, are left to the agent
+			if signer, err := readKey(filepath.Join(home, ".ssh", name)); err == nil {
+				signers = append(signers, signer)
+			}
+		}
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no SSH keys found: start an SSH agent or pass a key file")
+	}
+
+	return &ssh.ClientConfig{
+		User:            username,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback: hostKeys,
+		Timeout:         dialTimeout,
+	}, nil
+}
+
+// readKey reads an unencrypted private key.
+func readKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		var missing *ssh.PassphraseMissingError
+		if errors.As(err, &missing) {
+			return nil, fmt.Errorf("SSH key %s needs a passphrase; add it to the SSH agent instead", path)
+		}
+		return nil, fmt.Errorf("invalid SSH key %s: %w", path, err)
+	}
+	return signer, nil
+}
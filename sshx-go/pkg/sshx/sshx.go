@@ -0,0 +1,662 @@
+// Package sshx provides a stable API for embedding sshx sessions in Go programs.
+//
+// A minimal embedding opens a session, shares its URL, and runs until the
+// context is cancelled:
+//
+//	session, err := sshx.Open(sshx.Options{
+//		OnReady: func(info sshx.Info) { fmt.Println(info.URL) },
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	defer session.Close()
+//	return session.Run(ctx)
+package sshx
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"slices"
+	"strings"
+	"time"
+
+	"sshx-go/pkg/client"
+	"sshx-go/pkg/dashboard"
+	"sshx-go/pkg/docker"
+	"sshx-go/pkg/sshjump"
+	"sshx-go/pkg/terminal"
+	"sshx-go/pkg/transport"
+	"sshx-go/pkg/util"
+)
+
+// DefaultServer is the sshx server used when Options.Server is empty.
+const DefaultServer = "https://sshx.stream"
+
+// Runner is implemented by terminal backends driving a single shell.
+type Runner = client.Runner
+
+// HostShellID is the ID of the shell opened for Options.OpenShell.
+const HostShellID = client.HostShellID
+
+// StreamRunner is a Runner broadcasting a command's output read-only, see
+// client.StreamRunner. It is usually combined with Options.OpenShell.
+type StreamRunner = client.StreamRunner
+
+// EchoRunner is a Runner that sends each shell's input back as its output,
+// without starting any process, for testing transports and servers.
+type EchoRunner = client.EchoRunner
+
+// ShellData is a message routed from the server to a Runner.
+type ShellData = client.ShellData
+
+// ClientMessage is a message sent from a Runner to the server.
+type ClientMessage = client.ClientMessage
+
+// ShellExitPolicy selects whether a session ends when its shells exit.
+type ShellExitPolicy = client.ShellExitPolicy
+
+// Shell exit policies, as described for client.ShellExitPolicy.
+const (
+	ShellExitNever = client.ShellExitNever
+	ShellExitLast  = client.ShellExitLast
+	ShellExitFirst = client.ShellExitFirst
+)
+
+// SanitizePolicy selects escape sequences removed from terminal output.
+type SanitizePolicy = client.SanitizePolicy
+
+// Output sanitizing policies, as described for client.SanitizePolicy.
+const (
+	SanitizeOff       = client.SanitizeOff
+	SanitizeClipboard = client.SanitizeClipboard
+	SanitizeStrict    = client.SanitizeStrict
+)
+
+// ParseSanitizePolicy parses a policy name: off, clipboard or strict.
+func ParseSanitizePolicy(s string) (SanitizePolicy, error) {
+	return client.ParseSanitizePolicy(s)
+}
+
+// InvalidUTF8Policy selects how terminal output that is not valid UTF-8 is sent.
+type InvalidUTF8Policy = client.InvalidUTF8Policy
+
+// Invalid UTF-8 policies, as described for client.InvalidUTF8Policy.
+const (
+	InvalidUTF8Drop    = client.InvalidUTF8Drop
+	InvalidUTF8Replace = client.InvalidUTF8Replace
+	InvalidUTF8Pass    = client.InvalidUTF8Pass
+)
+
+// ParseInvalidUTF8Policy parses a policy name: drop, replace or passthrough.
+func ParseInvalidUTF8Policy(s string) (InvalidUTF8Policy, error) {
+	return client.ParseInvalidUTF8Policy(s)
+}
+
+// ErrShellsExited is returned by Session.Run when shells exited as selected
+// by Options.ShellExit.
+var ErrShellsExited = client.ErrShellsExited
+
+// ErrIdleTimeout is returned by Session.Run when there was no terminal
+// activity for Options.IdleTimeout.
+var ErrIdleTimeout = client.ErrIdleTimeout
+
+// ShellInfo describes a running shell of a session.
+type ShellInfo = client.ShellInfo
+
+// Stats reports round-trip times and ping counters for a session's connection.
+type Stats = client.Stats
+
+// ShellStats reports the I/O of a running shell of a session.
+type ShellStats = client.ShellStats
+
+// ContentSizes configures the output buffer of each shell of a session.
+type ContentSizes = client.ContentSizes
+
+// ResumeState identifies an open session and holds its keys, so a later
+// process can reattach to it with Options.Resume.
+type ResumeState = client.Resume
+
+// Event is something that happened to a session, as sent on Session.Events.
+type Event = client.Event
+
+// EventType is the kind of an Event.
+type EventType = client.EventType
+
+// Event types, as described for client.EventType.
+const (
+	EventConnected    = client.EventConnected
+	EventDisconnected = client.EventDisconnected
+	EventShellCreated = client.EventShellCreated
+	EventShellClosed  = client.EventShellClosed
+	EventServerError  = client.EventServerError
+	EventLatency      = client.EventLatency
+)
+
+// OutputLimiter caps the rate of terminal output sent to the server.
+type OutputLimiter = client.OutputLimiter
+
+// NewOutputLimiter creates a limiter allowing bytesPerSecond of output. One
+// limiter may be shared by several sessions to cap their combined rate.
+func NewOutputLimiter(bytesPerSecond int) *OutputLimiter {
+	return client.NewOutputLimiter(bytesPerSecond)
+}
+
+// Options configures a session.
+type Options struct {
+	// Server is the address of the remote sshx server. Several comma-separated
+	// addresses of the same deployment are tried in turn, when opening the
+	// session and whenever the one in use cannot be reached.
+	Server string
+	// Name is the session name displayed in the title. Defaults to user@hostname.
+	Name string
+	// Runner drives each shell created by viewers. Defaults to a ShellRunner for Shell.
+	Runner Runner
+	// OpenShell creates a shell as soon as Run starts, without waiting for a
+	// user to, e.g. for a StreamRunner's output.
+	OpenShell bool
+	// OnStart is typed into the session's first shell once it starts,
+	// followed by Enter, e.g. "cd /var/log && tail -f syslog".
+	OnStart string
+	// MaxShells caps how many shells users may have open at once. Zero means
+	// no limit.
+	MaxShells int
+	// Shell is the local shell command used by the default Runner.
+	Shell string
+	// ShellArgs are passed to Shell, e.g. "-i".
+	ShellArgs []string
+	// Login starts Shell as a login shell, so profiles are sourced, by
+	// prepending "-l" to ShellArgs.
+	Login bool
+	// Command runs a program with arguments in each pane instead of a shell.
+	Command []string
+	// AttachTmux attaches each pane to this existing tmux session instead of
+	// starting a shell, e.g. "work" or "work:2". It takes precedence over
+	// Command and Shell.
+	AttachTmux string
+	// Docker runs each pane's Shell, or Command, inside this running container
+	// instead of on this host, through the Docker daemon named by
+	// DOCKER_HOST. An empty Shell starts bash where the container has it, or
+	// else sh. Dir is then a directory in the container.
+	Docker string
+	// SSH opens each pane's shell, or Command, on a remote host over SSH when
+	// non-nil, so this process may run on a bastion. Shell, when set, runs
+	// instead of the remote user's login shell.
+	SSH *sshjump.Config
+	// AllowedShells restricts the shell or command of the default Runner to
+	// these programs when non-empty. Open fails for any other program.
+	AllowedShells []string
+	// RunAs starts the default Runner's shells as this user when non-nil.
+	RunAs *terminal.RunAs
+	// Env holds extra KEY=VALUE variables for the default Runner's shells.
+	// SSHX_URL, SSHX_WRITE_URL and SSHX_SESSION_NAME are always set to the
+	// session's links and name, unless overridden here.
+	Env []string
+	// HideWriteURL leaves SSHX_WRITE_URL unset, for sessions whose writable
+	// link should never be shown to viewers.
+	HideWriteURL bool
+	// Dir is the working directory of the default Runner's shells. Empty
+	// inherits the current one.
+	Dir string
+	// Limits caps the resources of each of the default Runner's shells when
+	// non-nil. It does not apply with AttachTmux, Docker or SSH.
+	Limits *terminal.Limits
+	// KillGrace is how long the processes of the default Runner's closing
+	// shells get to exit after each of SIGTERM, SIGHUP and SIGKILL. Zero uses
+	// terminal.DefaultKillGrace.
+	KillGrace time.Duration
+	// RestartShells starts the default Runner's shells again in the same
+	// pane when they exit, instead of closing the pane. It does not apply
+	// with AttachTmux, Docker or SSH.
+	RestartShells bool
+	// OutputLimit caps the rate of the default Runner's output when non-nil.
+	// Output beyond it is coalesced and sent later rather than dropped.
+	OutputLimit *OutputLimiter
+	// Sanitize removes escape sequences from the default Runner's output
+	// before users' terminals act on them, e.g. OSC 52 clipboard writes.
+	Sanitize SanitizePolicy
+	// InvalidUTF8 selects how the default Runner's output that is not valid
+	// UTF-8 is sent: dropped, replaced with U+FFFD or passed through.
+	InvalidUTF8 InvalidUTF8Policy
+	// EnableReaders generates separate URLs for viewers and editors.
+	EnableReaders bool
+	// Password replaces the generated encryption key when non-empty. Links
+	// carry it in their fragment like a generated key.
+	Password string
+	// Dashboard registers the session with the server's dashboard.
+	Dashboard bool
+	// DashboardKey joins an existing dashboard. Empty creates a new one.
+	DashboardKey string
+	// DashboardHeartbeat is how often the session reports its uptime, shell
+	// count and transport to the dashboard, which marks sessions that stop
+	// reporting as stale. Zero uses dashboard.DefaultHeartbeatInterval;
+	// negative disables heartbeats.
+	DashboardHeartbeat time.Duration
+	// Tags are sent with dashboard registrations so dashboards can filter
+	// and group sessions. They override the hostname, os, arch and version
+	// tags added automatically.
+	Tags map[string]string
+	// ShellExit ends the session when its last shell, or its first shell,
+	// exits. Run then returns ErrShellsExited.
+	ShellExit ShellExitPolicy
+	// IdleTimeout ends the session after this long without terminal input or
+	// output. Run then returns ErrIdleTimeout. Zero disables the timeout.
+	IdleTimeout time.Duration
+	// BatchDelay holds terminal output back for up to this long, so small
+	// writes in quick succession are sent in one message. Zero sends output
+	// as soon as it is read.
+	BatchDelay time.Duration
+	// Content sets how much output each shell keeps for users joining late
+	// and how much is sent at a time. Zero fields use the defaults.
+	Content ContentSizes
+	// Resume reattaches to the session of an earlier process, as saved from
+	// Session.ResumeState, keeping its links. A new session is opened if the
+	// server no longer has it. It cannot be combined with OpenShell.
+	Resume *ResumeState
+	// Connection configures the transport used to reach the server.
+	Connection transport.ConnectionConfig
+	// OnReady is called once the session is open and registered.
+	OnReady func(Info)
+}
+
+// Info describes an open session.
+type Info struct {
+	// Name is the server-assigned session name.
+	Name string
+	// URL is the shareable link. It is read-only when EnableReaders is set.
+	URL string
+	// WriteURL is the writable link, present only when EnableReaders is set.
+	WriteURL *string
+	// Shell is the shell, with its arguments, or command line used by the
+	// default Runner, if any.
+	Shell string
+	// Transport is the connection method in use.
+	Transport transport.ConnectionMethod
+	// Dashboard is set when the session was registered with a dashboard.
+	Dashboard *dashboard.Info
+}
+
+// Session is an open sshx session.
+type Session struct {
+	controller *client.Controller
+	registrar  *dashboard.Registrar
+	heartbeat  time.Duration // Interval of dashboard heartbeats, if positive
+	remote     *sshjump.Host // SSH connection of the shells, if remote
+	opened     time.Time
+	info       Info
+}
+
+// Open connects to the server, opens a session, and registers it with the
+// dashboard if requested. Dashboard failures are logged but not fatal; Run
+// keeps retrying them and re-registers after reconnects.
+func Open(opts Options) (*Session, error) {
+	if opts.Server == "" {
+		opts.Server = DefaultServer
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultSessionName()
+	}
+	for key, value := range opts.Tags {
+		if err := dashboard.ValidateTag(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	runner := opts.Runner
+	if runner == nil && opts.AttachTmux != "" {
+		tmux := &client.TmuxRunner{Session: opts.AttachTmux, RunAs: opts.RunAs, Env: opts.Env, Limiter: opts.OutputLimit, Sanitize: opts.Sanitize, InvalidUTF8: opts.InvalidUTF8}
+		command := tmux.Command()
+		if len(opts.AllowedShells) > 0 && !terminal.ShellAllowed(command[0], opts.AllowedShells) {
+			return nil, fmt.Errorf("command %q is not an allowed shell", command[0])
+		}
+		// Another user's sessions live on their own tmux server
+		if opts.RunAs == nil {
+			if err := tmux.Check(); err != nil {
+				return nil, err
+			}
+		}
+		opts.Shell = strings.Join(command, " ")
+		runner = tmux
+	}
+	if runner == nil && opts.Docker != "" {
+		if opts.RunAs != nil {
+			return nil, fmt.Errorf("RunAs cannot be combined with Docker")
+		}
+		if len(opts.AllowedShells) > 0 && !terminal.ShellAllowed("docker", opts.AllowedShells) {
+			return nil, fmt.Errorf("command %q is not an allowed shell", "docker")
+		}
+		dockerClient, err := docker.NewClient("")
+		if err != nil {
+			return nil, err
+		}
+		command := opts.Command
+		if len(command) == 0 && opts.Shell != "" {
+			command = append([]string{opts.Shell}, opts.ShellArgs...)
+			if opts.Login && !slices.Contains(command, "-l") {
+				command = slices.Insert(command, 1, "-l")
+			}
+		}
+		container := &client.DockerRunner{Client: dockerClient, Container: opts.Docker, Command: command, Env: opts.Env, Dir: opts.Dir, Limiter: opts.OutputLimit, Sanitize: opts.Sanitize, InvalidUTF8: opts.InvalidUTF8}
+		if err := container.Check(context.Background()); err != nil {
+			return nil, err
+		}
+		opts.Shell = strings.Join(append([]string{"docker", "exec", "-it", opts.Docker}, command...), " ")
+		runner = container
+	}
+	var remote *sshjump.Host
+	if runner == nil && opts.SSH != nil {
+		if opts.RunAs != nil || opts.Dir != "" {
+			return nil, fmt.Errorf("RunAs and Dir cannot be combined with SSH")
+		}
+		if len(opts.AllowedShells) > 0 && !terminal.ShellAllowed("ssh", opts.AllowedShells) {
+			return nil, fmt.Errorf("command %q is not an allowed shell", "ssh")
+		}
+		host, err := sshjump.Dial(context.Background(), *opts.SSH)
+		if err != nil {
+			return nil, err
+		}
+		remote = host
+		command := opts.Command
+		if len(command) == 0 && opts.Shell != "" {
+			command = append([]string{opts.Shell}, opts.ShellArgs...)
+		}
+		runner = &client.SSHRunner{Host: host, Command: command, Env: opts.Env, Limiter: opts.OutputLimit, Sanitize: opts.Sanitize, InvalidUTF8: opts.InvalidUTF8}
+		opts.Shell = strings.Join(append([]string{"ssh", host.String()}, command...), " ")
+	}
+	if runner == nil && len(opts.Command) > 0 {
+		opts.Shell = strings.Join(opts.Command, " ")
+		if len(opts.AllowedShells) > 0 && !terminal.ShellAllowed(opts.Command[0], opts.AllowedShells) {
+			return nil, fmt.Errorf("command %q is not an allowed shell", opts.Command[0])
+		}
+		runner = &client.ExecRunner{Command: opts.Command[0], Args: opts.Command[1:], RunAs: opts.RunAs, Env: opts.Env, Dir: opts.Dir, Limits: opts.Limits, KillGrace: opts.KillGrace, Restart: opts.RestartShells, Limiter: opts.OutputLimit, Sanitize: opts.Sanitize, InvalidUTF8: opts.InvalidUTF8}
+	}
+	if runner == nil {
+		if opts.Shell == "" {
+			opts.Shell = terminal.GetDefaultShell()
+		}
+		if len(opts.AllowedShells) > 0 && !terminal.ShellAllowed(opts.Shell, opts.AllowedShells) {
+			return nil, fmt.Errorf("shell %q is not an allowed shell", opts.Shell)
+		}
+		args := opts.ShellArgs
+		if opts.Login && !slices.Contains(args, "-l") {
+			args = append([]string{"-l"}, args...)
+		}
+		runner = &client.ShellRunner{Shell: opts.Shell, Args: args, RunAs: opts.RunAs, Env: opts.Env, Dir: opts.Dir, Limits: opts.Limits, KillGrace: opts.KillGrace, Restart: opts.RestartShells, Limiter: opts.OutputLimit, Sanitize: opts.Sanitize, InvalidUTF8: opts.InvalidUTF8}
+		opts.Shell = strings.Join(append([]string{opts.Shell}, args...), " ")
+	}
+
+	servers := SplitServers(opts.Server)
+	config := client.ControllerConfig{
+		Origin:        servers[0],
+		Failover:      servers[1:],
+		Name:          opts.Name,
+		Runner:        runner,
+		EnableReaders: opts.EnableReaders,
+		ShellExit:     opts.ShellExit,
+		IdleTimeout:   opts.IdleTimeout,
+		Password:      opts.Password,
+		OpenShell:     opts.OpenShell,
+		OnStart:       opts.OnStart,
+		MaxShells:     opts.MaxShells,
+		BatchDelay:    opts.BatchDelay,
+		Content:       opts.Content,
+		Resume:        opts.Resume,
+	}
+
+	controller, err := client.NewControllerWithConnection(config, opts.Connection)
+	if err != nil {
+		if remote != nil {
+			remote.Close()
+		}
+		return nil, err
+	}
+
+	session := &Session{
+		controller: controller,
+		remote:     remote,
+		opened:     time.Now(),
+		info: Info{
+			Name:      controller.Name(),
+			URL:       controller.URL(),
+			WriteURL:  controller.WriteURL(),
+			Shell:     opts.Shell,
+			Transport: controller.ConnectionMethod(),
+		},
+	}
+
+	// Shells start only once Run is called, so the links are known by then
+	env := sessionEnv(session.info, opts.HideWriteURL)
+	switch r := runner.(type) {
+	case *client.ShellRunner:
+		r.Env = append(env, r.Env...)
+	case *client.ExecRunner:
+		r.Env = append(env, r.Env...)
+	case *client.TmuxRunner:
+		r.Env = append(env, r.Env...)
+	case *client.DockerRunner:
+		r.Env = append(env, r.Env...)
+	case *client.SSHRunner:
+		r.Env = append(env, r.Env...)
+	case *client.StreamRunner:
+		r.Env = append(env, r.Env...)
+	}
+
+	if opts.Dashboard {
+		if err := session.registerDashboard(opts, servers[0]); err != nil {
+			util.Warnf("Dashboard registration failed: %v", err)
+		}
+	}
+
+	if opts.OnReady != nil {
+		opts.OnReady(session.info)
+	}
+
+	return session, nil
+}
+
+// registerDashboard registers the session with the dashboard of server,
+// keeping the registrar to update it from then on.
+func (s *Session) registerDashboard(opts Options, server string) error {
+	// The dashboard is served by the sshx server, so use its TLS settings
+	httpClient, err := opts.Connection.ServerHTTPClient()
+	if err != nil {
+		return err
+	}
+	tags := dashboard.HostTags(opts.Connection.ClientVersion)
+	for key, value := range opts.Tags {
+		tags[key] = value
+	}
+	s.registrar = dashboard.NewRegistrar(httpClient, server, s.controller, opts.Name, opts.DashboardKey, tags)
+	s.heartbeat = opts.DashboardHeartbeat
+	if s.heartbeat == 0 {
+		s.heartbeat = dashboard.DefaultHeartbeatInterval
+	}
+	info, err := s.registrar.Register()
+	if err != nil {
+		return err
+	}
+	s.info.Dashboard = info
+	return nil
+}
+
+// sessionEnv returns the variables describing the session to its shells.
+func sessionEnv(info Info, hideWriteURL bool) []string {
+	env := []string{
+		"SSHX_URL=" + info.URL,
+		"SSHX_SESSION_NAME=" + info.Name,
+	}
+	if info.WriteURL != nil && !hideWriteURL {
+		env = append(env, "SSHX_WRITE_URL="+*info.WriteURL)
+	}
+	return env
+}
+
+// Info returns details about the open session.
+func (s *Session) Info() Info {
+	info := s.info
+	info.Transport = s.controller.ConnectionMethod()
+	return info
+}
+
+// ResumeState returns what reattaches a later process to the session. It
+// holds the session's keys, so it must be kept as secret as its links.
+func (s *Session) ResumeState() ResumeState {
+	return s.controller.Resume()
+}
+
+// CloseSession closes a session an earlier process left open, as saved from
+// Session.ResumeState, on server, which may list several addresses like
+// Options.Server.
+func CloseSession(server string, state ResumeState, conn transport.ConnectionConfig) error {
+	return client.CloseSession(state, SplitServers(server), conn)
+}
+
+// Events returns the channel the session sends its events on: connections,
+// disconnects, shells starting and closing, server errors and latency
+// samples. Events are dropped rather than delaying the session when the
+// reader falls behind.
+func (s *Session) Events() <-chan Event {
+	return s.controller.Events()
+}
+
+// Stats returns connection health statistics for the session.
+func (s *Session) Stats() Stats {
+	return s.controller.Stats()
+}
+
+// ShellStats returns the I/O statistics of the shells currently running in
+// the session.
+func (s *Session) ShellStats() []ShellStats {
+	return s.controller.ShellStats()
+}
+
+// Snapshot returns the recent output of every running shell, keyed by shell
+// ID. Shells that do not answer before ctx is done are left out.
+func (s *Session) Snapshot(ctx context.Context) map[uint32][]byte {
+	return s.controller.Snapshot(ctx)
+}
+
+// Shells returns the shells currently running in the session.
+func (s *Session) Shells() []ShellInfo {
+	return s.controller.Shells()
+}
+
+// LockInput stops users' input from reaching shell id while locked is true,
+// so the host can take exclusive control.
+func (s *Session) LockInput(id uint32, locked bool) error {
+	return s.controller.LockInput(id, locked)
+}
+
+// Mirror copies the output of shell id to w, starting with its recent output.
+// A nil w stops mirroring. Use HostShellID for the shell opened for
+// Options.OpenShell, which may be mirrored before Run starts it.
+func (s *Session) Mirror(id uint32, w io.Writer) error {
+	return s.controller.Mirror(id, w)
+}
+
+// WriteInput sends data to shell id as if a user typed it, even while the
+// shell is locked.
+func (s *Session) WriteInput(id uint32, data []byte) error {
+	return s.controller.WriteInput(id, data)
+}
+
+// Resize sets the window size of shell id, until a user resizes it.
+func (s *Session) Resize(id uint32, rows, cols uint32) error {
+	return s.controller.Resize(id, rows, cols)
+}
+
+// Reconnect makes Run drop its connection to the server and connect again
+// right away. Shells keep running across the reconnect.
+func (s *Session) Reconnect() {
+	s.controller.Reconnect()
+}
+
+// Controller returns the underlying controller for advanced use.
+func (s *Session) Controller() *client.Controller {
+	return s.controller
+}
+
+// Run serves the session until ctx is cancelled or the controller fails.
+// Cancellation is not an error; call Close afterwards to end the session.
+func (s *Session) Run(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.controller.Run()
+	}()
+
+	if s.registrar != nil {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.registrar.Watch(watchCtx, s.controller.Reconnected())
+		if s.heartbeat > 0 {
+			go s.registrar.Heartbeats(watchCtx, s.heartbeat, s.dashboardHeartbeat)
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-done:
+		return err
+	}
+}
+
+// dashboardHeartbeat returns the status reported to the dashboard.
+func (s *Session) dashboardHeartbeat() dashboard.Heartbeat {
+	return dashboard.Heartbeat{
+		Uptime:    int64(time.Since(s.opened).Seconds()),
+		Shells:    len(s.controller.Shells()),
+		Transport: s.controller.ConnectionMethod().String(),
+	}
+}
+
+// Close removes the session from its dashboard, if registered, and
+// terminates the session gracefully.
+func (s *Session) Close() error {
+	if s.registrar != nil {
+		if err := s.registrar.Unregister(); err != nil {
+			util.Warnf("Dashboard deregistration failed: %v", err)
+		}
+	}
+	err := s.controller.Close()
+	if s.remote != nil {
+		s.remote.Close()
+	}
+	return err
+}
+
+// DefaultSessionName returns the default session name, user@hostname.
+func DefaultSessionName() string {
+	sessionName := "unknown"
+
+	if currentUser, err := user.Current(); err == nil {
+		sessionName = currentUser.Username
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		// Trim domain information like .lan or .local
+		if parts := strings.Split(hostname, "."); len(parts) > 0 {
+			hostname = parts[0]
+		}
+		sessionName += "@" + hostname
+	}
+
+	return sessionName
+}
+
+// SplitServers returns the comma-separated addresses of server, as in
+// Options.Server, or DefaultServer if it lists none.
+func SplitServers(server string) []string {
+	var servers []string
+	for _, s := range strings.Split(server, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == 0 {
+		return []string{DefaultServer}
+	}
+	return servers
+}
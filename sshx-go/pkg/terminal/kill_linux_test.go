@@ -0,0 +1,89 @@
+package terminal
+
+import (
+	"bufio"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCloseKillsDescendants starts a shell whose child starts a process that
+// ignores SIGTERM and SIGHUP, then closes the terminal. The grandchild must
+// be killed, whether it stayed in the shell's session or left it.
+func TestCloseKillsDescendants(t *testing.T) {
+	// The grandchild prints its ID once it ignores the signals
+	const grandchild = `trap "" TERM HUP; echo pid=$$; exec sleep 300`
+	tests := []struct {
+		name  string
+		child string
+	}{
+		{"session", `sh -c "$GRANDCHILD" & wait`},
+		{"setsid", `setsid sh -c "$GRANDCHILD" & wait`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := exec.LookPath(strings.Fields(tt.child)[0]); err != nil {
+				t.Skip(err)
+			}
+			opts := Options{
+				Env:       []string{"CHILD=" + tt.child, "GRANDCHILD=" + grandchild},
+				KillGrace: 100 * time.Millisecond,
+			}
+			term, err := NewCommandWithOptions(opts, "sh", "-c", `sh -c "$CHILD"; sleep 300`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pid := readPid(t, term)
+			p, ok := readProcStat(pid)
+			if !ok {
+				t.Fatalf("grandchild %d is not running", pid)
+			}
+
+			start := time.Now()
+			if err := term.Close(); err != nil {
+				t.Fatalf("Close() = %v", err)
+			}
+			if running := (process{pid: pid, start: p.start}).running(); running {
+				t.Fatalf("grandchild %d still running after Close", pid)
+			}
+			// TERM and HUP are ignored, so only KILL ends it
+			if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+				t.Fatalf("Close() took %v, want the grace period after TERM and HUP", elapsed)
+			}
+		})
+	}
+}
+
+// readPid reads the "pid=" line the test's shell prints.
+func readPid(t *testing.T, term *Terminal) int {
+	t.Helper()
+	type result struct {
+		pid int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		scanner := bufio.NewScanner(term)
+		for scanner.Scan() {
+			if line, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "pid="); ok {
+				pid, err := strconv.Atoi(line)
+				done <- result{pid, err}
+				return
+			}
+		}
+		done <- result{0, scanner.Err()}
+	}()
+	select {
+	case r := <-done:
+		if r.err != nil || r.pid == 0 {
+			t.Fatalf("reading the grandchild pid: %v", r.err)
+		}
+		return r.pid
+	case <-time.After(10 * time.Second):
+		term.Close()
+		t.Fatal("timed out waiting for the grandchild pid")
+		return 0
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// killPollInterval is how often terminateSession checks whether the processes
+// it signalled have exited.
+const killPollInterval = 20 * time.Millisecond
+
+// terminateSession ends the session led by process sid: its processes, and
+// where they can be listed, the descendants of sid that left it. They are
+// sent SIGTERM, then SIGHUP, then SIGKILL, each once the previous signal left
+// some running for grace. Processes are tracked from tracked, listed before
+// anything ended the session, and before each signal, since init adopts them
+// once their parents exit.
+func terminateSession(sid int, tracked []process, grace time.Duration) error {
+	for _, sig := range []unix.Signal{unix.SIGTERM, unix.SIGHUP, unix.SIGKILL} {
+		tracked = append(tracked, sessionProcesses(sid)...)
+		unix.Kill(-sid, sig)
+		for _, p := range tracked {
+			if p.running() {
+				unix.Kill(p.pid, sig)
+			}
+		}
+
+		deadline := time.Now().Add(grace)
+		for sessionAlive(sid, tracked) {
+			if time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(killPollInterval)
+		}
+		if !sessionAlive(sid, tracked) {
+			return nil
+		}
+	}
+	return fmt.Errorf("processes of session %d survived SIGKILL", sid)
+}
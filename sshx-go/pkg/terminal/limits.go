@@ -0,0 +1,49 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits caps the resources used by a terminal process and its children, so
+// the shells of a shared session cannot exhaust the host. Create them with
+// NewLimits, which selects how they are applied on this system.
+type Limits struct {
+	// MemoryMax is the memory, in bytes, the processes may use. Zero means no
+	// limit.
+	MemoryMax int64
+	// CPUQuota is the CPU time the processes may use, in percent of one CPU,
+	// e.g. 50, or 200 for two CPUs. Zero means no limit.
+	CPUQuota int
+
+	// scope is the systemd-run command that starts processes in a transient
+	// scope with the limits, or nil to use resource limits instead
+	scope []string
+}
+
+// ParseMemorySize parses a size in bytes with an optional K, M, G or T suffix,
+// in powers of 1024 like systemd's MemoryMax=, e.g. "512M".
+func ParseMemorySize(s string) (int64, error) {
+	number, shift := s, 0
+	if n := len(s); n > 0 {
+		if i := strings.IndexByte("KMGT", s[n-1]&^0x20); i >= 0 {
+			number, shift = s[:n-1], 10*(i+1)
+		}
+	}
+	value, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || value <= 0 || value > (1<<62)>>shift {
+		return 0, fmt.Errorf("invalid size %q (expected bytes, or a number followed by K, M, G or T)", s)
+	}
+	return value << shift, nil
+}
+
+// ParseCPUQuota parses a percentage of one CPU, e.g. "50%", or "200%" for two
+// CPUs. The percent sign is optional.
+func ParseCPUQuota(s string) (int, error) {
+	value, err := strconv.Atoi(strings.TrimSuffix(s, "%"))
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid CPU quota %q (expected a percentage of one CPU, e.g. 50%%)", s)
+	}
+	return value, nil
+}
@@ -0,0 +1,88 @@
+package terminal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// NewLimits returns limits applied through a transient systemd scope for each
+// process when systemd manages this system, which covers the memory and CPU
+// time of everything the shell starts. Otherwise, memory is capped by the
+// RLIMIT_AS resource limit of each shell, which its children inherit and which
+// counts address space rather than memory in use, and CPU quotas are refused.
+func NewLimits(memoryMax int64, cpuQuota int) (*Limits, error) {
+	l := &Limits{MemoryMax: memoryMax, CPUQuota: cpuQuota}
+	scope, err := systemdScope(l)
+	switch {
+	case err == nil:
+		l.scope = scope
+	case cpuQuota > 0:
+		return nil, fmt.Errorf("CPU quotas require a systemd scope: %w", err)
+	}
+	return l, nil
+}
+
+// systemdScope returns the systemd-run command starting processes in a
+// transient scope with the limits of l, once it started one successfully.
+func systemdScope(l *Limits) ([]string, error) {
+	if _, err := os.Stat("/run/systemd/system"); err != nil {
+		return nil, errors.New("systemd is not running")
+	}
+	path, err := exec.LookPath("systemd-run")
+	if err != nil {
+		return nil, err
+	}
+
+	scope := []string{path, "--scope", "--quiet", "--collect"}
+	// Only root may create scopes in the system manager
+	if os.Geteuid() != 0 {
+		scope = append(scope, "--user")
+	}
+	if l.MemoryMax > 0 {
+		scope = append(scope, "--property=MemoryMax="+strconv.FormatInt(l.MemoryMax, 10))
+	}
+	if l.CPUQuota > 0 {
+		scope = append(scope, fmt.Sprintf("--property=CPUQuota=%d%%", l.CPUQuota))
+	}
+
+	if out, err := exec.Command(scope[0], append(scope[1:], "true")...).CombinedOutput(); err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return nil, fmt.Errorf("systemd-run failed: %s", out)
+		}
+		return nil, fmt.Errorf("systemd-run failed: %w", err)
+	}
+	return scope, nil
+}
+
+// wrap makes cmd start through systemd-run, in a scope of its own, when the
+// limits use scopes.
+func (l *Limits) wrap(cmd *exec.Cmd) {
+	if l.scope == nil {
+		return
+	}
+	argv := slices.Clone(l.scope)
+	if attr := cmd.SysProcAttr; attr != nil && attr.Credential != nil {
+		// systemd-run switches to the account itself, once it created the
+		// scope as root
+		argv = append(argv, fmt.Sprintf("--uid=%d", attr.Credential.Uid), fmt.Sprintf("--gid=%d", attr.Credential.Gid))
+		attr.Credential = nil
+	}
+	cmd.Args = append(append(argv, "--", cmd.Path), cmd.Args[1:]...)
+	cmd.Path = argv[0]
+}
+
+// started applies the resource limits to a process started without a scope.
+func (l *Limits) started(pid int) error {
+	if l.scope != nil || l.MemoryMax <= 0 {
+		return nil
+	}
+	limit := &unix.Rlimit{Cur: uint64(l.MemoryMax), Max: uint64(l.MemoryMax)}
+	return unix.Prlimit(pid, unix.RLIMIT_AS, limit, nil)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package terminal
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// NewLimits fails: resource limits are only supported on Linux.
+func NewLimits(memoryMax int64, cpuQuota int) (*Limits, error) {
+	return nil, errors.New("shell resource limits are only supported on Linux")
+}
+
+func (l *Limits) wrap(cmd *exec.Cmd) {}
+
+func (l *Limits) started(pid int) error { return nil }
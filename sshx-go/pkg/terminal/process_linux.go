@@ -0,0 +1,101 @@
+package terminal
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// process identifies a process by its start time as well as its ID, so an ID
+// reused after it exits is not mistaken for it.
+type process struct {
+	pid   int
+	start string
+}
+
+// procStat holds the fields of /proc/<pid>/stat used here.
+type procStat struct {
+	state   byte
+	ppid    int
+	session int
+	start   string
+}
+
+// readProcStat parses /proc/<pid>/stat, whose second field, the command
+// name, may itself contain spaces and parentheses.
+func readProcStat(pid int) (procStat, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return procStat{}, false
+	}
+	i := bytes.LastIndexByte(data, ')')
+	if i < 0 {
+		return procStat{}, false
+	}
+	// state ppid pgrp session tty_nr tpgid flags minflt cminflt majflt
+	// cmajflt utime stime cutime cstime priority nice num_threads
+	// itrealvalue starttime
+	fields := bytes.Fields(data[i+1:])
+	if len(fields) < 20 || len(fields[0]) != 1 {
+		return procStat{}, false
+	}
+	ppid, err1 := strconv.Atoi(string(fields[1]))
+	session, err2 := strconv.Atoi(string(fields[3]))
+	if err1 != nil || err2 != nil {
+		return procStat{}, false
+	}
+	return procStat{state: fields[0][0], ppid: ppid, session: session, start: string(fields[19])}, true
+}
+
+// sessionProcesses lists the processes of session sid and the descendants of
+// sid outside it, leaving out zombies, which only wait to be reaped.
+func sessionProcesses(sid int) []process {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	stats := make(map[int]procStat, len(entries))
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if stat, ok := readProcStat(pid); ok {
+			stats[pid] = stat
+		}
+	}
+
+	var procs []process
+	for pid, stat := range stats {
+		if stat.state == 'Z' {
+			continue
+		}
+		member := stat.session == sid
+		for parent, depth := stat.ppid, 0; !member && parent > 1 && depth < len(stats); depth++ {
+			member = parent == sid
+			parent = stats[parent].ppid
+		}
+		if member {
+			procs = append(procs, process{pid: pid, start: stat.start})
+		}
+	}
+	return procs
+}
+
+// running reports whether p has not exited.
+func (p process) running() bool {
+	stat, ok := readProcStat(p.pid)
+	return ok && stat.start == p.start && stat.state != 'Z'
+}
+
+// sessionAlive reports whether any process of session sid, descendant of sid
+// or process of tracked is running. Zombies do not count, as processes
+// adopted by an init that never reaps them would otherwise never end.
+func sessionAlive(sid int, tracked []process) bool {
+	for _, p := range tracked {
+		if p.running() {
+			return true
+		}
+	}
+	return len(sessionProcesses(sid)) > 0
+}
@@ -0,0 +1,30 @@
+//go:build !linux && !windows
+
+package terminal
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// process identifies a process. Without /proc, processes are not listed, so
+// only the process group of a session is signalled.
+type process struct {
+	pid int
+}
+
+// sessionProcesses returns nil, as processes cannot be listed here.
+func sessionProcesses(sid int) []process {
+	return nil
+}
+
+// running reports false, as no process is ever tracked.
+func (p process) running() bool {
+	return false
+}
+
+// sessionAlive reports whether the process group of sid has any process left.
+func sessionAlive(sid int, tracked []process) bool {
+	return !errors.Is(unix.Kill(-sid, 0), unix.ESRCH)
+}
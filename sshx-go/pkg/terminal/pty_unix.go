@@ -0,0 +1,193 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// Terminal represents a PTY terminal with an attached process.
+type Terminal struct {
+	cmd    *exec.Cmd
+	pty    *os.File
+	resize resizer
+	grace  time.Duration    // wait between signals on Close
+	state  *os.ProcessState // how the process exited, once Close reaped it
+}
+
+// NewCommandWithOptions is like NewCommand, but customizes the process with opts.
+func NewCommandWithOptions(opts Options, name string, args ...string) (*Terminal, error) {
+	cmd := exec.Command(name, args...)
+	
+	// Set environment variables
+	cmd.Env = append(os.Environ(),
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+		"TERM_PROGRAM=sshx",
+	)
+	if opts.RunAs != nil {
+		opts.RunAs.apply(cmd)
+	}
+	cmd.Env = append(cmd.Env, opts.Env...)
+	if opts.Dir != "" {
+		cmd.Dir = opts.Dir
+	}
+	if opts.Limits != nil {
+		opts.Limits.wrap(cmd)
+	}
+	
+	// Start the command with a PTY - this matches the Rust implementation
+	ptty, err := pty.Start(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start PTY: %w", err)
+	}
+	if opts.Limits != nil {
+		if err := opts.Limits.started(cmd.Process.Pid); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			ptty.Close()
+			return nil, fmt.Errorf("failed to apply resource limits: %w", err)
+		}
+	}
+	
+	grace := opts.KillGrace
+	if grace <= 0 {
+		grace = DefaultKillGrace
+	}
+	return &Terminal{
+		cmd:   cmd,
+		pty:   ptty,
+		grace: grace,
+	}, nil
+}
+
+// Read reads data from the terminal.
+func (t *Terminal) Read(p []byte) (int, error) {
+	return t.pty.Read(p)
+}
+
+// Write writes data to the terminal.
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.pty.Write(p)
+}
+
+// SetWinsize sets the window size of the terminal.
+func (t *Terminal) SetWinsize(rows, cols uint16) error {
+	size := &pty.Winsize{
+		Rows: rows,
+		Cols: cols,
+	}
+	return pty.Setsize(t.pty, size)
+}
+
+// GetWinsize gets the current window size of the terminal.
+func (t *Terminal) GetWinsize() (rows, cols uint16, err error) {
+	size, err := pty.GetsizeFull(t.pty)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size.Rows, size.Cols, nil
+}
+
+// Close closes the terminal and terminates the process, along with the
+// programs it started, which pty.Start placed in the process's own session.
+func (t *Terminal) Close() error {
+	var firstErr error
+	t.stopResize()
+
+	// List the processes before closing the PTY hangs up on the session, as
+	// the descendants that left it are only found through their parents
+	var tracked []process
+	if t.cmd != nil && t.cmd.Process != nil {
+		tracked = sessionProcesses(t.cmd.Process.Pid)
+	}
+	
+	// Close the PTY first to signal the process
+	if t.pty != nil {
+		if err := t.pty.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		t.pty = nil
+	}
+	
+	// Terminate the process and everything it left running
+	if t.cmd != nil && t.cmd.Process != nil {
+		// Reap the process as soon as it exits, so it is not counted as alive
+		done := make(chan error, 1)
+		go func() {
+			done <- t.cmd.Wait()
+		}()
+
+		if err := terminateSession(t.cmd.Process.Pid, tracked, t.grace); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		<-done
+
+		t.state = t.cmd.ProcessState
+		t.cmd = nil
+	}
+	
+	return firstErr
+}
+
+// Wait waits for the terminal process to exit.
+func (t *Terminal) Wait() error {
+	return t.cmd.Wait()
+}
+
+// GetDefaultShell returns the default shell for the current system.
+func GetDefaultShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	
+	// Try common shell locations
+	shells := []string{
+		"/bin/bash",
+		"/bin/sh",
+		"/usr/local/bin/bash",
+		"/usr/local/bin/sh",
+	}
+	
+	for _, shell := range shells {
+		if _, err := os.Stat(shell); err == nil {
+			return shell
+		}
+	}
+	
+	return "sh"
+}
+
+// Process returns the underlying process.
+func (t *Terminal) Process() *os.Process {
+	return t.cmd.Process
+}
+
+// ProcessState returns the process state.
+func (t *Terminal) ProcessState() *os.ProcessState {
+	if t.cmd == nil {
+		return t.state
+	}
+	return t.cmd.ProcessState
+}
+
+// ExitStatus reports how the process exited once Close returned: its exit
+// code, or -1 and the name of the signal that killed it, such as "SIGKILL".
+// ok is false if the process has not been reaped.
+func (t *Terminal) ExitStatus() (code int, signal string, ok bool) {
+	state := t.ProcessState()
+	if state == nil {
+		return 0, "", false
+	}
+	if status, isWait := state.Sys().(syscall.WaitStatus); isWait && status.Signaled() {
+		return -1, unix.SignalName(status.Signal()), true
+	}
+	return state.ExitCode(), "", true
+}
@@ -0,0 +1,333 @@
+//go:build windows
+
+package terminal
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Terminal represents a ConPTY pseudoconsole with an attached process.
+type Terminal struct {
+	path string
+	dir  string
+	pid  int
+
+	console windows.Handle
+	process windows.Handle
+	in      *os.File // writes to the console's input
+	out     *os.File // reads from the console's output
+
+	mu         sync.Mutex
+	rows, cols uint16
+	resize     resizer
+
+	consoleOnce sync.Once
+	exited      chan struct{}
+	exitCode    uint32
+	grace       time.Duration // wait before killing the process on Close
+}
+
+// NewCommandWithOptions is like NewCommand, but customizes the process with opts.
+func NewCommandWithOptions(opts Options, name string, args ...string) (*Terminal, error) {
+	if opts.RunAs != nil {
+		return nil, fmt.Errorf("starting processes as another user is not supported on Windows")
+	}
+	if opts.Limits != nil {
+		return nil, fmt.Errorf("resource limits are not supported on Windows")
+	}
+
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start ConPTY: %w", err)
+	}
+
+	// Connect pipes to the pseudoconsole, which keeps its own copies of the
+	// ends it was given
+	var inRead, inWrite, outRead, outWrite windows.Handle
+	if err := windows.CreatePipe(&inRead, &inWrite, nil, 0); err != nil {
+		return nil, fmt.Errorf("failed to create ConPTY input pipe: %w", err)
+	}
+	if err := windows.CreatePipe(&outRead, &outWrite, nil, 0); err != nil {
+		windows.CloseHandle(inRead)
+		windows.CloseHandle(inWrite)
+		return nil, fmt.Errorf("failed to create ConPTY output pipe: %w", err)
+	}
+
+	var console windows.Handle
+	err = windows.CreatePseudoConsole(windows.Coord{X: 80, Y: 24}, inRead, outWrite, 0, &console)
+	windows.CloseHandle(inRead)
+	windows.CloseHandle(outWrite)
+	if err != nil {
+		windows.CloseHandle(inWrite)
+		windows.CloseHandle(outRead)
+		return nil, fmt.Errorf("failed to create ConPTY: %w", err)
+	}
+
+	process, pid, err := startProcess(console, opts, path, append([]string{name}, args...))
+	if err != nil {
+		windows.ClosePseudoConsole(console)
+		windows.CloseHandle(inWrite)
+		windows.CloseHandle(outRead)
+		return nil, fmt.Errorf("failed to start ConPTY: %w", err)
+	}
+
+	t := &Terminal{
+		path:    path,
+		dir:     opts.Dir,
+		pid:     pid,
+		console: console,
+		process: process,
+		in:      os.NewFile(uintptr(inWrite), "conpty-in"),
+		out:     os.NewFile(uintptr(outRead), "conpty-out"),
+		rows:    24,
+		cols:    80,
+		exited:  make(chan struct{}),
+		grace:   opts.KillGrace,
+	}
+	if t.grace <= 0 {
+		t.grace = DefaultKillGrace
+	}
+
+	// Closing the pseudoconsole once the process exits ends the output stream,
+	// so Read reports io.EOF like a Unix PTY does
+	go func() {
+		windows.WaitForSingleObject(process, windows.INFINITE)
+		windows.GetExitCodeProcess(process, &t.exitCode)
+		close(t.exited)
+		t.closeConsole()
+	}()
+
+	return t, nil
+}
+
+// startProcess starts argv attached to console, returning its process handle and ID.
+func startProcess(console windows.Handle, opts Options, path string, argv []string) (windows.Handle, int, error) {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer attrs.Delete()
+
+	// The attribute value is the console handle itself, not a pointer to it
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, *(*unsafe.Pointer)(unsafe.Pointer(&console)), unsafe.Sizeof(console)); err != nil {
+		return 0, 0, err
+	}
+
+	si := new(windows.StartupInfoEx)
+	si.Cb = uint32(unsafe.Sizeof(*si))
+	si.ProcThreadAttributeList = attrs.List()
+	// Without this, the process inherits our standard handles when they are
+	// redirected instead of using the console
+	si.Flags = windows.STARTF_USESTDHANDLES
+
+	appName, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	cmdLine, err := windows.UTF16PtrFromString(windows.ComposeCommandLine(argv))
+	if err != nil {
+		return 0, 0, err
+	}
+	var dir *uint16
+	if opts.Dir != "" {
+		if dir, err = windows.UTF16PtrFromString(opts.Dir); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	env := append(os.Environ(),
+		"TERM=xterm-256color",
+		"COLORTERM=truecolor",
+		"TERM_PROGRAM=sshx",
+	)
+	env = append(env, opts.Env...)
+
+	var pi windows.ProcessInformation
+	flags := uint32(windows.EXTENDED_STARTUPINFO_PRESENT | windows.CREATE_UNICODE_ENVIRONMENT)
+	if err := windows.CreateProcess(appName, cmdLine, nil, nil, false, flags, envBlock(env), dir, &si.StartupInfo, &pi); err != nil {
+		return 0, 0, err
+	}
+	windows.CloseHandle(pi.Thread)
+	return pi.Process, int(pi.ProcessId), nil
+}
+
+// envBlock encodes env for CreateProcess. Later entries override earlier ones
+// with the same name, which Windows compares case-insensitively.
+func envBlock(env []string) *uint16 {
+	index := make(map[string]int)
+	var unique []string
+	for _, kv := range env {
+		name, _, _ := strings.Cut(kv, "=")
+		// Names of drive-specific directories such as "=C:" start with "="
+		if name == "" && len(kv) > 1 {
+			name, _, _ = strings.Cut(kv[1:], "=")
+			name = "=" + name
+		}
+		key := strings.ToUpper(name)
+		if i, ok := index[key]; ok {
+			unique[i] = kv
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, kv)
+	}
+
+	var block []uint16
+	for _, kv := range unique {
+		block = append(block, utf16.Encode([]rune(kv))...)
+		block = append(block, 0)
+	}
+	block = append(block, 0)
+	return &block[0]
+}
+
+// closeConsole closes the pseudoconsole, which sends CTRL_CLOSE_EVENT to the
+// processes attached to it.
+func (t *Terminal) closeConsole() {
+	t.consoleOnce.Do(func() {
+		windows.ClosePseudoConsole(t.console)
+	})
+}
+
+// Read reads data from the terminal.
+func (t *Terminal) Read(p []byte) (int, error) {
+	n, err := t.out.Read(p)
+	if errors.Is(err, windows.ERROR_BROKEN_PIPE) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Write writes data to the terminal.
+func (t *Terminal) Write(p []byte) (int, error) {
+	return t.in.Write(p)
+}
+
+// SetWinsize sets the window size of the terminal.
+func (t *Terminal) SetWinsize(rows, cols uint16) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	select {
+	case <-t.exited:
+		return fmt.Errorf("process has exited")
+	default:
+	}
+	if err := windows.ResizePseudoConsole(t.console, windows.Coord{X: int16(cols), Y: int16(rows)}); err != nil {
+		return err
+	}
+	t.rows, t.cols = rows, cols
+	return nil
+}
+
+// GetWinsize gets the current window size of the terminal.
+func (t *Terminal) GetWinsize() (rows, cols uint16, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.rows, t.cols, nil
+}
+
+// Close closes the terminal and terminates the process.
+func (t *Terminal) Close() error {
+	var firstErr error
+	t.stopResize()
+
+	if t.in != nil {
+		if err := t.in.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		t.in = nil
+	}
+
+	if t.process != 0 {
+		// Try graceful termination first; closing the console may block until
+		// its output is read, so do it in the background
+		go t.closeConsole()
+
+		// Wait a bit for graceful shutdown
+		select {
+		case <-t.exited:
+			// Process exited gracefully
+		case <-time.After(t.grace):
+			// Force kill if graceful shutdown failed
+			if err := windows.TerminateProcess(t.process, 1); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			<-t.exited // Wait for the killed process
+		}
+	}
+
+	// Closing our end of the output unblocks the console if nobody reads it
+	if t.out != nil {
+		if err := t.out.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		t.out = nil
+	}
+
+	if t.process != 0 {
+		windows.CloseHandle(t.process)
+		t.process = 0
+	}
+
+	return firstErr
+}
+
+// Wait waits for the terminal process to exit.
+func (t *Terminal) Wait() error {
+	<-t.exited
+	if t.exitCode != 0 {
+		return fmt.Errorf("exit status %d", t.exitCode)
+	}
+	return nil
+}
+
+// GetDefaultShell returns the default shell for the current system, preferring
+// PowerShell over cmd.exe.
+func GetDefaultShell() string {
+	for _, shell := range []string{"pwsh.exe", "powershell.exe"} {
+		if path, err := exec.LookPath(shell); err == nil {
+			return path
+		}
+	}
+
+	if comspec := os.Getenv("ComSpec"); comspec != "" {
+		return comspec
+	}
+	return "cmd.exe"
+}
+
+// Process returns the underlying process.
+func (t *Terminal) Process() *os.Process {
+	process, _ := os.FindProcess(t.pid)
+	return process
+}
+
+// ProcessState returns the process state. It is always nil on Windows, where
+// the process is not started through os/exec; use Wait for the exit status.
+func (t *Terminal) ProcessState() *os.ProcessState {
+	return nil
+}
+
+// ExitStatus reports the exit code of the process once it exited. Windows has
+// no signals, so signal is always empty; a process killed by Close exits
+// with code 1. ok is false while the process runs.
+func (t *Terminal) ExitStatus() (code int, signal string, ok bool) {
+	select {
+	case <-t.exited:
+		return int(t.exitCode), "", true
+	default:
+		return 0, "", false
+	}
+}
@@ -0,0 +1,70 @@
+package terminal
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// resizeDelay is how long ResizeNotify collects size changes before applying
+// the latest one.
+const resizeDelay = 50 * time.Millisecond
+
+// resizer coalesces the window size changes of a Terminal.
+type resizer struct {
+	mu         sync.Mutex
+	timer      *time.Timer // pending application, nil if none
+	rows, cols uint16      // latest requested size
+	err        error       // error from the last application
+	closed     bool
+}
+
+// ResizeNotify requests a window size change. Requests made within a short
+// window of each other are coalesced, so only the final size is applied and
+// the program redraws once, e.g. while a viewer drags a window over a
+// high-latency connection.
+//
+// The size is applied in the background. An error applying an earlier size is
+// returned by the next call, the way bufio.Writer reports write errors.
+func (t *Terminal) ResizeNotify(rows, cols uint16) error {
+	r := &t.resize
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return fmt.Errorf("terminal is closed")
+	}
+	err := r.err
+	r.err = nil
+	r.rows, r.cols = rows, cols
+	if r.timer == nil {
+		r.timer = time.AfterFunc(resizeDelay, t.applyResize)
+	}
+	return err
+}
+
+// applyResize applies the latest size requested with ResizeNotify.
+func (t *Terminal) applyResize() {
+	r := &t.resize
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.timer = nil
+	if !r.closed {
+		r.err = t.SetWinsize(r.rows, r.cols)
+	}
+}
+
+// stopResize discards any pending size change, so none is applied once the
+// terminal starts closing.
+func (t *Terminal) stopResize() {
+	r := &t.resize
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+}
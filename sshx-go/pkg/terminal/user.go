@@ -0,0 +1,77 @@
+package terminal
+
+import (
+	"fmt"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// RunAs is an account that terminal processes are started as, so shells
+// spawned by a privileged host run without its privileges.
+type RunAs struct {
+	Username string
+	UID      uint32
+	GID      uint32
+	Groups   []uint32
+	Home     string
+}
+
+// LookupRunAs resolves a user name or numeric user ID to the account and
+// groups that terminal processes should run as.
+func LookupRunAs(name string) (*RunAs, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("unknown user %q", name)
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has non-numeric uid %q", name, u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("user %q has non-numeric gid %q", name, u.Gid)
+	}
+
+	runAs := &RunAs{Username: u.Username, UID: uint32(uid), GID: uint32(gid), Home: u.HomeDir}
+	if groupIDs, err := u.GroupIds(); err == nil {
+		for _, id := range groupIDs {
+			if group, err := strconv.ParseUint(id, 10, 32); err == nil {
+				runAs.Groups = append(runAs.Groups, uint32(group))
+			}
+		}
+	}
+	return runAs, nil
+}
+
+// ShellAllowed reports whether program is one of the allowed shells, comparing
+// paths after resolving them through PATH.
+func ShellAllowed(program string, allowed []string) bool {
+	resolved := resolveProgram(program)
+	for _, entry := range allowed {
+		if entry == program || resolveProgram(entry) == resolved {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveProgram returns the absolute path of program, or program itself if
+// it cannot be found.
+func resolveProgram(program string) string {
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return program
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+	return path
+}
@@ -0,0 +1,38 @@
+//go:build !windows
+
+package terminal
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// apply configures cmd to start as the account, in its home directory and
+// with its identity in the environment.
+func (r *RunAs) apply(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: r.UID, Gid: r.GID, Groups: r.Groups},
+	}
+
+	if info, err := os.Stat(r.Home); err == nil && info.IsDir() {
+		cmd.Dir = r.Home
+	} else {
+		cmd.Dir = "/"
+	}
+
+	env := cmd.Env[:0]
+	for _, kv := range cmd.Env {
+		switch key, _, _ := strings.Cut(kv, "="); key {
+		case "HOME", "USER", "LOGNAME", "MAIL", "XDG_RUNTIME_DIR", "SUDO_USER", "SUDO_UID", "SUDO_GID", "SUDO_COMMAND":
+			continue
+		}
+		env = append(env, kv)
+	}
+	cmd.Env = append(env,
+		"HOME="+r.Home,
+		"USER="+r.Username,
+		"LOGNAME="+r.Username,
+	)
+}
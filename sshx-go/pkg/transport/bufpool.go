@@ -0,0 +1,42 @@
+package transport
+
+import (
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// maxPooledBuffer is the largest marshaling buffer kept for reuse, so a rare
+// large message does not pin its memory.
+const maxPooledBuffer = 1 << 20
+
+// marshalBuffers holds buffers for WebSocket messages, which are written out
+// before Send returns. Sustained output, such as a build log, would otherwise
+// allocate a buffer for every chunk. gRPC pools its own buffers.
+var marshalBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, 64<<10)
+		return &buf
+	},
+}
+
+// marshalPooled marshals m into a buffer from marshalBuffers, which the caller
+// must return with releaseBuffer once the message was written.
+func marshalPooled(m proto.Message) (*[]byte, error) {
+	buf := marshalBuffers.Get().(*[]byte)
+	data, err := proto.MarshalOptions{}.MarshalAppend((*buf)[:0], m)
+	if err != nil {
+		marshalBuffers.Put(buf)
+		return nil, err
+	}
+	*buf = data
+	return buf, nil
+}
+
+// releaseBuffer returns a buffer from marshalPooled to marshalBuffers.
+func releaseBuffer(buf *[]byte) {
+	if cap(*buf) > maxPooledBuffer {
+		return
+	}
+	marshalBuffers.Put(buf)
+}
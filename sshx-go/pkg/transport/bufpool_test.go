@@ -0,0 +1,61 @@
+package transport
+
+import (
+	"bytes"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	pb "sshx-go/pkg/proto"
+)
+
+// terminalChunk returns an update holding a full chunk of terminal output,
+// the message sent most often under sustained output.
+func terminalChunk() *pb.ClientUpdate {
+	return &pb.ClientUpdate{
+		ClientMessage: &pb.ClientUpdate_Data{Data: &pb.TerminalData{
+			Id:   1,
+			Data: bytes.Repeat([]byte("x"), 64<<10),
+			Seq:  1 << 20,
+		}},
+	}
+}
+
+func TestMarshalPooled(t *testing.T) {
+	m := terminalChunk()
+	buf, err := marshalPooled(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseBuffer(buf)
+
+	want, err := proto.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(*buf, want) {
+		t.Fatal("pooled marshaling differs from proto.Marshal")
+	}
+}
+
+// BenchmarkMarshal is the allocation per message marshalPooled avoids.
+func BenchmarkMarshal(b *testing.B) {
+	m := terminalChunk()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := proto.Marshal(m); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalPooled(b *testing.B) {
+	m := terminalChunk()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, err := marshalPooled(m)
+		if err != nil {
+			b.Fatal(err)
+		}
+		releaseBuffer(buf)
+	}
+}
@@ -7,10 +7,10 @@ package transport
 import (
 	"context"
 	"fmt"
-	"log"
 	"time"
 
 	"sshx-go/pkg/proto"
+	"sshx-go/pkg/util"
 )
 
 const (
@@ -18,6 +18,25 @@ const (
 	DefaultGrpcTimeout = 3 * time.Second
 	// DefaultWebSocketTimeout is the default timeout for WebSocket connection.
 	DefaultWebSocketTimeout = 5 * time.Second
+
+	// DefaultOpenTimeout is the default timeout for opening a session.
+	DefaultOpenTimeout = 30 * time.Second
+	// DefaultChannelTimeout is the default timeout for starting a WebSocket
+	// channel.
+	DefaultChannelTimeout = 30 * time.Second
+	// DefaultCloseTimeout is the default timeout for closing a session.
+	DefaultCloseTimeout = 5 * time.Second
+
+	// DefaultWebSocketReadTimeout drops WebSocket connections silent for
+	// this long.
+	DefaultWebSocketReadTimeout = 120 * time.Second
+	// DefaultWebSocketWriteTimeout bounds writing a WebSocket ping or message.
+	DefaultWebSocketWriteTimeout = 10 * time.Second
+	// DefaultWebSocketPingInterval is how often WebSocket pings are sent.
+	DefaultWebSocketPingInterval = 30 * time.Second
+	// DefaultWebSocketMissedPongs is the number of WebSocket pings in a row
+	// that may go unanswered before the connection is dropped.
+	DefaultWebSocketMissedPongs = 3
 )
 
 // ConnectWithFallback connects to an sshx server with automatic gRPC→WebSocket fallback.
@@ -26,12 +45,14 @@ const (
 // automatically falls back to WebSocket. The connection method is determined
 // by testing actual connectivity to the server.
 //
-// Behavior:
+// Behavior (with the default PreferAuto preference):
 // 1. Attempts gRPC connection with 3-second timeout
-// 2. Tests gRPC connectivity by making an actual Open call
+// 2. Waits for the connection to be ready and checks that the sshx service answers
 // 3. If gRPC fails, converts URL and attempts WebSocket connection
 // 4. Returns the first successful connection method
 //
+// PreferGrpc and PreferWebSocket restrict the attempt to a single transport.
+//
 // Arguments:
 //   - origin: The server URL to connect to (e.g., "https://sshx.io")
 //   - sessionName: Name for the session (used for WebSocket endpoint)
@@ -40,8 +61,37 @@ const (
 // Returns:
 //   - ConnectionResult containing the transport and connection method used
 func ConnectWithFallback(origin, sessionName string, config ConnectionConfig) (*ConnectionResult, error) {
+	return connectWithFallback(origin, sessionName, nil, config)
+}
+
+// OpenWithFallback connects like ConnectWithFallback and opens a session with
+// request over the chosen transport, returned in ConnectionResult.Session.
+//
+// The Open call doubles as the gRPC connectivity test, so only one session is
+// ever opened on the server and no separate probe round trip is needed. If it
+// fails over gRPC, the session is opened over WebSocket instead.
+func OpenWithFallback(origin string, request *proto.OpenRequest, config ConnectionConfig) (*ConnectionResult, error) {
+	return connectWithFallback(origin, request.Name, request, config)
+}
+
+// ProbeGrpc checks that the sshx service answers over gRPC at origin, as the
+// gRPC attempt of ConnectWithFallback does, and closes the connection again.
+func ProbeGrpc(origin string, config ConnectionConfig) error {
+	if config.GrpcTimeout == 0 {
+		config.GrpcTimeout = DefaultGrpcTimeout
+	}
+	transport, _, err := tryGrpcConnection(origin, nil, config)
+	if err != nil {
+		return err
+	}
+	return transport.Cleanup()
+}
+
+// connectWithFallback implements ConnectWithFallback and, when request is not
+// nil, OpenWithFallback.
+func connectWithFallback(origin, sessionName string, request *proto.OpenRequest, config ConnectionConfig) (*ConnectionResult, error) {
 	if config.VerboseErrors {
-		log.Printf("attempting connection with fallback to %s", origin)
+		util.Infof("attempting connection with fallback to %s", origin)
 	}
 
 	// Apply default timeouts if not specified
@@ -52,99 +102,123 @@ func ConnectWithFallback(origin, sessionName string, config ConnectionConfig) (*
 		config.WebSocketTimeout = DefaultWebSocketTimeout
 	}
 
+	switch config.Preference {
+	case PreferGrpc:
+		transport, session, err := tryGrpcConnection(origin, request, config)
+		if err != nil {
+			return nil, fmt.Errorf("gRPC connection failed for %s: %w", origin, err)
+		}
+		return &ConnectionResult{
+			Transport: transport,
+			Method:    MethodGrpc,
+			Session:   session,
+		}, nil
+	case PreferWebSocket:
+		transport, session, err := tryWebSocketConnection(origin, sessionName, request, config)
+		if err != nil {
+			return nil, fmt.Errorf("WebSocket connection failed for %s: %w", origin, err)
+		}
+		return &ConnectionResult{
+			Transport: transport,
+			Method:    MethodWebSocketFallback,
+			Session:   session,
+		}, nil
+	}
+
 	// First, try gRPC connection
-	if transport, err := tryGrpcConnection(origin, config); err == nil {
+	if transport, session, err := tryGrpcConnection(origin, request, config); err == nil {
 		if config.VerboseErrors {
-			log.Printf("gRPC connection successful to %s", origin)
+			util.Infof("gRPC connection successful to %s", origin)
 		}
 		return &ConnectionResult{
 			Transport: transport,
 			Method:    MethodGrpc,
+			Session:   session,
 		}, nil
 	} else {
 		if config.VerboseErrors {
-			log.Printf("gRPC connection failed to %s: %v, attempting WebSocket fallback", origin, err)
+			util.Infof("gRPC connection failed to %s: %v, attempting WebSocket fallback", origin, err)
 		}
 	}
 
 	// If gRPC failed, try WebSocket fallback
-	if transport, err := tryWebSocketConnection(origin, sessionName, config); err == nil {
+	if transport, session, err := tryWebSocketConnection(origin, sessionName, request, config); err == nil {
 		if config.VerboseErrors {
-			log.Printf("WebSocket fallback connection successful to %s", origin)
+			util.Infof("WebSocket fallback connection successful to %s", origin)
 		}
 		return &ConnectionResult{
 			Transport: transport,
 			Method:    MethodWebSocketFallback,
+			Session:   session,
 		}, nil
 	} else {
 		if config.VerboseErrors {
-			log.Printf("WebSocket fallback also failed to %s: %v", origin, err)
+			util.Warnf("WebSocket fallback also failed to %s: %v", origin, err)
 		}
-		return nil, fmt.Errorf("Both gRPC and WebSocket connections failed for %s", origin)
+		return nil, fmt.Errorf("Both gRPC and WebSocket connections failed for %s: %w", origin, err)
 	}
 }
 
 // tryGrpcConnection attempts to establish a gRPC connection and test its connectivity.
 //
-// This function not only connects to the gRPC endpoint but also performs
-// a real connectivity test by attempting an Open call to ensure the
-// connection is actually working. This matches the Rust implementation exactly.
-func tryGrpcConnection(origin string, config ConnectionConfig) (SshxTransport, error) {
+// This function not only connects to the gRPC endpoint but also checks that
+// the sshx service answers over it. When request is not nil, the check is
+// opening the session; otherwise no session is created on the server.
+func tryGrpcConnection(origin string, request *proto.OpenRequest, config ConnectionConfig) (SshxTransport, *proto.OpenResponse, error) {
 	if config.VerboseErrors {
-		log.Printf("Attempting gRPC connection to %s (timeout: %v)", origin, config.GrpcTimeout)
+		util.Infof("Attempting gRPC connection to %s (timeout: %v)", origin, config.GrpcTimeout)
 	}
 
 	// Create context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), config.GrpcTimeout)
 	defer cancel()
 
-	// First, test connectivity with a separate connection to avoid consuming the main transport
-	if config.VerboseErrors {
-		log.Printf("Testing gRPC connectivity to %s with Open call", origin)
-	}
-	testTransport, err := ConnectGrpc(origin)
+	transport, err := ConnectGrpcWithConfig(origin, config)
 	if err != nil {
-		return nil, fmt.Errorf("gRPC connection failed: %w", err)
-	}
-
-	testRequest := &proto.OpenRequest{
-		Origin:         origin,
-		EncryptedZeros: make([]byte, 32), // Dummy encrypted zeros for connectivity test
-		Name:           "connectivity-test",
-		WritePasswordHash: nil,
+		return nil, nil, fmt.Errorf("gRPC connection failed: %w", err)
 	}
 
-	// Test the connection with the dummy request
-	_, err = testTransport.Open(ctx, testRequest)
-	if err != nil {
-		testTransport.Cleanup()
+	if request == nil {
+		if config.VerboseErrors {
+			util.Infof("Testing gRPC connectivity to %s", origin)
+		}
+		if err := transport.probe(ctx); err != nil {
+			transport.Cleanup()
+			if config.VerboseErrors {
+				util.Warnf("gRPC connectivity test failed with error: %v", err)
+			}
+			return nil, nil, fmt.Errorf("gRPC connectivity test failed: %w", err)
+		}
 		if config.VerboseErrors {
-			log.Printf("gRPC connectivity test failed with error: %v", err)
+			util.Infof("gRPC connectivity test succeeded for %s", origin)
 		}
-		return nil, fmt.Errorf("gRPC connectivity test failed: %w", err)
+		return transport, nil, nil
 	}
 
-	// Clean up test transport
-	testTransport.Cleanup()
-
-	if config.VerboseErrors {
-		log.Printf("gRPC connectivity test succeeded for %s", origin)
+	if err := transport.waitReady(ctx); err != nil {
+		transport.Cleanup()
+		return nil, nil, fmt.Errorf("gRPC connection failed: %w", err)
 	}
 
-	// Now create a fresh transport for actual use (don't reuse the test transport)
-	transport, err := ConnectGrpc(origin)
+	openCtx, openCancel := context.WithTimeout(context.Background(), config.openTimeout())
+	defer openCancel()
+	session, err := transport.Open(openCtx, request)
 	if err != nil {
-		return nil, fmt.Errorf("gRPC connection failed: %w", err)
+		transport.Cleanup()
+		if config.VerboseErrors {
+			util.Warnf("gRPC open failed with error: %v", err)
+		}
+		return nil, nil, err
 	}
-
-	return transport, nil
+	return transport, session, nil
 }
 
-// tryWebSocketConnection attempts to establish a WebSocket connection.
-func tryWebSocketConnection(origin, sessionName string, config ConnectionConfig) (SshxTransport, error) {
-	wsURL := GrpcToWebSocketURL(origin, sessionName)
+// tryWebSocketConnection attempts to establish a WebSocket connection, opening
+// a session over it when request is not nil.
+func tryWebSocketConnection(origin, sessionName string, request *proto.OpenRequest, config ConnectionConfig) (SshxTransport, *proto.OpenResponse, error) {
+	wsURL := config.WebSocketURL(origin, sessionName)
 	if config.VerboseErrors {
-		log.Printf("Attempting WebSocket connection to %s (timeout: %v)", wsURL, config.WebSocketTimeout)
+		util.Infof("Attempting WebSocket connection to %s (timeout: %v)", wsURL, config.WebSocketTimeout)
 	}
 
 	// Create context with timeout
@@ -158,22 +232,36 @@ func tryWebSocketConnection(origin, sessionName string, config ConnectionConfig)
 	}, 1)
 
 	go func() {
-		transport, err := ConnectWebSocket(wsURL)
+		transport, err := ConnectWebSocketWithConfig(wsURL, config)
 		result <- struct {
 			transport SshxTransport
 			err       error
 		}{transport, err}
 	}()
 
+	var transport SshxTransport
 	select {
 	case res := <-result:
 		if res.err != nil {
-			return nil, fmt.Errorf("WebSocket connection failed: %w", res.err)
+			return nil, nil, fmt.Errorf("WebSocket connection failed: %w", res.err)
 		}
-		return res.transport, nil
+		transport = res.transport
 	case <-ctx.Done():
-		return nil, fmt.Errorf("WebSocket connection timed out after %v", config.WebSocketTimeout)
+		return nil, nil, fmt.Errorf("WebSocket connection timed out after %v", config.WebSocketTimeout)
+	}
+
+	if request == nil {
+		return transport, nil, nil
 	}
+
+	openCtx, openCancel := context.WithTimeout(context.Background(), config.openTimeout())
+	defer openCancel()
+	session, err := transport.Open(openCtx, request)
+	if err != nil {
+		transport.Cleanup()
+		return nil, nil, err
+	}
+	return transport, session, nil
 }
 
 // TestConnectivity tests gRPC connectivity to a server without establishing a full connection.
@@ -188,32 +276,24 @@ func tryWebSocketConnection(origin, sessionName string, config ConnectionConfig)
 // Returns:
 //   - true if gRPC connectivity is available, false otherwise
 func TestConnectivity(origin string, timeoutDuration time.Duration) bool {
-	log.Printf("Testing gRPC connectivity to %s", origin)
+	util.Infof("Testing gRPC connectivity to %s", origin)
 	
 	ctx, cancel := context.WithTimeout(context.Background(), timeoutDuration)
 	defer cancel()
 	
 	transport, err := ConnectGrpc(origin)
 	if err != nil {
-		log.Printf("gRPC connectivity test failed: %v", err)
+		util.Warnf("gRPC connectivity test failed: %v", err)
 		return false
 	}
 	defer transport.Cleanup()
-	
-	// Test with a dummy Open request to verify actual connectivity
-	testRequest := &proto.OpenRequest{
-		Origin:         origin,
-		EncryptedZeros: make([]byte, 32), // Dummy encrypted zeros for connectivity test
-		Name:           "connectivity-test",
-	}
-	
-	_, err = transport.Open(ctx, testRequest)
-	if err != nil {
-		log.Printf("gRPC connectivity test failed on Open call: %v", err)
+
+	if err := transport.probe(ctx); err != nil {
+		util.Warnf("gRPC connectivity test failed: %v", err)
 		return false
 	}
 	
-	log.Printf("gRPC connectivity test succeeded for %s", origin)
+	util.Infof("gRPC connectivity test succeeded for %s", origin)
 	return true
 }
 
@@ -222,11 +302,9 @@ func TestConnectivity(origin string, timeoutDuration time.Duration) bool {
 // This is useful for debugging connection issues or when you want
 // to show detailed error information to users.
 func VerboseConfig() ConnectionConfig {
-	return ConnectionConfig{
-		VerboseErrors:    true,
-		GrpcTimeout:     DefaultGrpcTimeout,
-		WebSocketTimeout: DefaultWebSocketTimeout,
-	}
+	config := DefaultConnectionConfig()
+	config.VerboseErrors = true
+	return config
 }
 
 // CustomTimeoutConfig creates a connection configuration with custom timeouts.
@@ -235,11 +313,10 @@ func VerboseConfig() ConnectionConfig {
 //   - grpcTimeout: Timeout for gRPC connection attempts
 //   - websocketTimeout: Timeout for WebSocket connection attempts
 func CustomTimeoutConfig(grpcTimeout, websocketTimeout time.Duration) ConnectionConfig {
-	return ConnectionConfig{
-		VerboseErrors:    false,
-		GrpcTimeout:     grpcTimeout,
-		WebSocketTimeout: websocketTimeout,
-	}
+	config := DefaultConnectionConfig()
+	config.GrpcTimeout = grpcTimeout
+	config.WebSocketTimeout = websocketTimeout
+	return config
 }
 
 // QuickConnectGrpc is a convenience function for connecting via gRPC only.
@@ -0,0 +1,30 @@
+package transport
+
+import (
+	"testing"
+	"time"
+)
+
+// TestConfigConstructors checks every preset keeps the defaults it does not
+// change, such as tracking streamed messages for rejections.
+func TestConfigConstructors(t *testing.T) {
+	for name, config := range map[string]ConnectionConfig{
+		"DefaultConnectionConfig": DefaultConnectionConfig(),
+		"VerboseConfig":           VerboseConfig(),
+		"CustomTimeoutConfig":     CustomTimeoutConfig(time.Second, 2*time.Second),
+	} {
+		if config.StreamAckWindow != DefaultStreamAckWindow {
+			t.Errorf("%s: StreamAckWindow = %d, want %d", name, config.StreamAckWindow, DefaultStreamAckWindow)
+		}
+		if window := newStreamWindow(config.StreamAckWindow, config.StreamErrorLimit); len(window.sent) == 0 {
+			t.Errorf("%s: streamed messages are not tracked", name)
+		}
+	}
+
+	if config := VerboseConfig(); !config.VerboseErrors || config.GrpcTimeout != DefaultGrpcTimeout {
+		t.Errorf("VerboseConfig() = %+v", config)
+	}
+	if config := CustomTimeoutConfig(time.Second, 2*time.Second); config.GrpcTimeout != time.Second || config.WebSocketTimeout != 2*time.Second {
+		t.Errorf("CustomTimeoutConfig() = %+v", config)
+	}
+}
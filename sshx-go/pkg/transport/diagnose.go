@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// probeSessionName is the session name in the WebSocket URL ProbeWebSocket
+// connects to. No session is opened under it.
+const probeSessionName = "probe"
+
+// ProbeWebSocket checks that the WebSocket CLI endpoint at origin accepts
+// connections, as the WebSocket attempt of ConnectWithFallback does, and
+// closes the connection again.
+func ProbeWebSocket(origin string, config ConnectionConfig) error {
+	if config.WebSocketTimeout == 0 {
+		config.WebSocketTimeout = DefaultWebSocketTimeout
+	}
+	transport, _, err := tryWebSocketConnection(origin, probeSessionName, nil, config)
+	if err != nil {
+		return err
+	}
+	return transport.Cleanup()
+}
+
+// ProxyURL returns the HTTP proxy connections to origin go through, or nil
+// if they are made with Dialer or directly.
+func (c ConnectionConfig) ProxyURL(origin string) (*url.URL, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", origin, err)
+	}
+	proxy := c.proxyFunc()
+	if proxy == nil {
+		return nil, nil
+	}
+	return proxy(u)
+}
+
+// CheckTLS completes a TLS handshake with the server of an https origin as
+// the transports would, through the same proxy, and returns the certificate
+// chain it verified. With TLSInsecureSkipVerify, the chain is returned as
+// presented, unverified.
+func CheckTLS(ctx context.Context, origin string, config ConnectionConfig) ([]*x509.Certificate, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL %q: %w", origin, err)
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("%s does not use TLS", origin)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+	}
+
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = u.Hostname()
+	}
+
+	conn, err := config.grpcDialer(true)(ctx, net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) > 0 {
+		return state.VerifiedChains[0], nil
+	}
+	return state.PeerCertificates, nil
+}
+
+// ServerTime returns the time the server at origin reports in the Date header
+// of its responses, fetched as the transports would connect, through the same
+// proxy and with the same TLS settings.
+func ServerTime(ctx context.Context, origin string, config ConnectionConfig) (time.Time, error) {
+	client, err := config.ServerHTTPClient()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer client.CloseIdleConnections()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, origin, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid server URL %q: %w", origin, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("server sent no valid Date header")
+	}
+	return date, nil
+}
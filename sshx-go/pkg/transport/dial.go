@@ -0,0 +1,164 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultDialAttemptDelay is how long a connection attempt to one address
+	// of the server runs before the next address is tried alongside it, as
+	// recommended by RFC 8305.
+	DefaultDialAttemptDelay = 250 * time.Millisecond
+	// DefaultDialAddressTimeout is how long a connection attempt to a single
+	// address of the server may take.
+	DefaultDialAddressTimeout = 5 * time.Second
+)
+
+// dialAttempt is the outcome of connecting to one address.
+type dialAttempt struct {
+	conn net.Conn
+	err  error
+	rtt  time.Duration // duration of the TCP handshake
+}
+
+// dialDirect connects to addr without a proxy, in the manner of Happy
+// Eyeballs (RFC 8305): the host's IPv6 and IPv4 addresses are tried in
+// alternation, each attempt starting once the previous one failed or ran for
+// the attempt delay, and the first connection made wins. A dual-stack host
+// with a broken IPv6 route thus connects over IPv4 after a short delay
+// instead of stalling.
+func (c ConnectionConfig) dialDirect(ctx context.Context, network, addr string) (net.Conn, time.Duration, error) {
+	addrs, err := resolveAddrs(ctx, network, addr)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.raceAddrs(ctx, network, addrs)
+}
+
+// raceAddrs connects to the first of addrs to accept a connection, starting
+// an attempt for each in turn as dialDirect describes.
+func (c ConnectionConfig) raceAddrs(ctx context.Context, network string, addrs []string) (net.Conn, time.Duration, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan dialAttempt, len(addrs))
+	next, pending := 0, 0
+	startNext := func() {
+		target := addrs[next]
+		next++
+		pending++
+		go func() {
+			attemptCtx, attemptCancel := context.WithTimeout(ctx, c.dialAddressTimeout())
+			defer attemptCancel()
+			var dialer net.Dialer
+			start := time.Now()
+			conn, err := dialer.DialContext(attemptCtx, network, target)
+			results <- dialAttempt{conn: conn, err: err, rtt: time.Since(start)}
+		}()
+	}
+
+	startNext()
+	delay := time.NewTimer(c.dialAttemptDelay())
+	defer delay.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// Close the connections of attempts that complete anyway
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return result.conn, result.rtt, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			// Move on to the next address right away
+			if next < len(addrs) {
+				startNext()
+				delay.Reset(c.dialAttemptDelay())
+			}
+
+		case <-delay.C:
+			if next < len(addrs) {
+				startNext()
+				delay.Reset(c.dialAttemptDelay())
+			}
+		}
+	}
+	return nil, 0, firstErr
+}
+
+// resolveAddrs returns the addresses to try for addr, as host:port pairs
+// alternating between the address families, starting with the family the
+// resolver prefers. Addresses of the family excluded by network, such as
+// IPv6 for "tcp4", are left out.
+func resolveAddrs(ctx context.Context, network, addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var first, second []string
+	var firstIsIPv4 bool
+	for _, ip := range ips {
+		isIPv4 := ip.IP.To4() != nil
+		if (network == "tcp4" && !isIPv4) || (network == "tcp6" && isIPv4) {
+			continue
+		}
+		target := net.JoinHostPort(ip.String(), port)
+		if len(first) == 0 {
+			firstIsIPv4 = isIPv4
+		}
+		if isIPv4 == firstIsIPv4 {
+			first = append(first, target)
+		} else {
+			second = append(second, target)
+		}
+	}
+	if len(first) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address found", Name: host, IsNotFound: true}
+	}
+
+	addrs := make([]string, 0, len(first)+len(second))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			addrs = append(addrs, first[i])
+		}
+		if i < len(second) {
+			addrs = append(addrs, second[i])
+		}
+	}
+	return addrs, nil
+}
+
+// dialAttemptDelay returns the delay between connection attempts.
+func (c ConnectionConfig) dialAttemptDelay() time.Duration {
+	if c.DialAttemptDelay <= 0 {
+		return DefaultDialAttemptDelay
+	}
+	return c.DialAttemptDelay
+}
+
+// dialAddressTimeout returns the timeout of a connection attempt.
+func (c ConnectionConfig) dialAddressTimeout() time.Duration {
+	if c.DialAddressTimeout <= 0 {
+		return DefaultDialAddressTimeout
+	}
+	return c.DialAddressTimeout
+}
@@ -2,44 +2,85 @@ package transport
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
-	"log"
 	"strings"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 
 	"sshx-go/pkg/proto"
+	"sshx-go/pkg/util"
 )
 
 // GrpcTransport wraps the existing gRPC client implementation.
 type GrpcTransport struct {
 	client proto.SshxServiceClient
 	conn   *grpc.ClientConn
+
+	// Timeout of Close, applied unless the caller's context ends sooner
+	closeTimeout time.Duration
 }
 
 // NewGrpcTransport creates a new gRPC transport from an existing client.
 func NewGrpcTransport(client proto.SshxServiceClient, conn *grpc.ClientConn) *GrpcTransport {
 	return &GrpcTransport{
-		client: client,
-		conn:   conn,
+		client:       client,
+		conn:         conn,
+		closeTimeout: DefaultCloseTimeout,
 	}
 }
 
+// grpcBackoff limits how long a gRPC connection waits between attempts to
+// recover from transient failures.
+var grpcBackoff = backoff.Config{
+	BaseDelay:  time.Second,
+	Multiplier: 1.6,
+	Jitter:     0.2,
+	MaxDelay:   16 * time.Second,
+}
+
 // ConnectGrpc creates a new gRPC transport by connecting to a server.
 func ConnectGrpc(origin string) (*GrpcTransport, error) {
+	return ConnectGrpcWithConfig(origin, DefaultConnectionConfig())
+}
+
+// ConnectGrpcWithConfig creates a new gRPC transport using the given connection configuration.
+func ConnectGrpcWithConfig(origin string, config ConnectionConfig) (*GrpcTransport, error) {
 	target := parseGRPCTarget(origin)
-	
+	if config.GrpcTarget != "" {
+		target = config.GrpcTarget
+	}
+	secure := strings.HasPrefix(origin, "https://")
+
 	// Use TLS for HTTPS origins, insecure for others
 	var opts []grpc.DialOption
-	if strings.HasPrefix(origin, "https://") {
-		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{})))
+	if secure {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	} else {
 		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	}
+
+	// Dial through the configured proxy, if any
+	opts = append(opts, grpc.WithContextDialer(config.grpcDialer(secure)))
+
+	// Detect silently dropped connections and re-dial them promptly, so the
+	// controller's channel fails fast and is re-established on a live connection
+	opts = append(opts, grpc.WithKeepaliveParams(config.grpcKeepalive()))
+	opts = append(opts, grpc.WithUserAgent(config.userAgent()))
+	if config.GrpcAuthority != "" {
+		opts = append(opts, grpc.WithAuthority(config.GrpcAuthority))
+	}
+	opts = append(opts, grpc.WithConnectParams(grpc.ConnectParams{Backoff: grpcBackoff}))
 	
 	conn, err := grpc.Dial(target, opts...)
 	if err != nil {
@@ -48,8 +89,9 @@ func ConnectGrpc(origin string) (*GrpcTransport, error) {
 	
 	client := proto.NewSshxServiceClient(conn)
 	return &GrpcTransport{
-		client: client,
-		conn:   conn,
+		client:       client,
+		conn:         conn,
+		closeTimeout: config.closeTimeout(),
 	}, nil
 }
 
@@ -77,7 +119,7 @@ func (g *GrpcTransport) Channel(ctx context.Context) (chan *proto.ServerUpdate,
 	go func() {
 		defer func() {
 			if err := stream.CloseSend(); err != nil {
-				log.Printf("Failed to close send stream: %v", err)
+				util.Warnf("Failed to close send stream: %v", err)
 			}
 		}()
 		
@@ -88,7 +130,7 @@ func (g *GrpcTransport) Channel(ctx context.Context) (chan *proto.ServerUpdate,
 					return // Channel closed
 				}
 				if err := stream.Send(update); err != nil {
-					log.Printf("Failed to send client update: %v", err)
+					util.Warnf("Failed to send client update: %v", err)
 					return
 				}
 			case <-ctx.Done():
@@ -105,7 +147,7 @@ func (g *GrpcTransport) Channel(ctx context.Context) (chan *proto.ServerUpdate,
 			update, err := stream.Recv()
 			if err != nil {
 				if err.Error() != "EOF" {
-					log.Printf("Failed to receive server update: %v", err)
+					util.Warnf("Failed to receive server update: %v", err)
 				}
 				return
 			}
@@ -123,6 +165,8 @@ func (g *GrpcTransport) Channel(ctx context.Context) (chan *proto.ServerUpdate,
 
 // Close closes an existing session on the server.
 func (g *GrpcTransport) Close(ctx context.Context, request *proto.CloseRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, g.closeTimeout)
+	defer cancel()
 	_, err := g.client.Close(ctx, request)
 	if err != nil {
 		return fmt.Errorf("gRPC close request failed: %w", err)
@@ -143,6 +187,36 @@ func (g *GrpcTransport) Cleanup() error {
 	return nil
 }
 
+// waitReady waits until the connection is established, failing as soon as a
+// connection attempt fails or ctx expires.
+func (g *GrpcTransport) waitReady(ctx context.Context) error {
+	g.conn.Connect()
+	for state := g.conn.GetState(); state != connectivity.Ready; state = g.conn.GetState() {
+		if state == connectivity.TransientFailure || state == connectivity.Shutdown {
+			return fmt.Errorf("gRPC connection is in state %s", state)
+		}
+		if !g.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("gRPC connection not ready: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// probe checks that the sshx service answers on this connection. It closes a
+// session that cannot exist, so the server answers with an authentication
+// error and keeps no state.
+func (g *GrpcTransport) probe(ctx context.Context) error {
+	if err := g.waitReady(ctx); err != nil {
+		return err
+	}
+
+	_, err := g.client.Close(ctx, &proto.CloseRequest{})
+	if err == nil || status.Code(err) == codes.Unauthenticated {
+		return nil
+	}
+	return fmt.Errorf("gRPC health check failed: %w", err)
+}
+
 // parseGRPCTarget extracts the host:port from a URL for gRPC dialing
 // This is copied from the existing controller.go to maintain compatibility
 func parseGRPCTarget(origin string) string {
@@ -182,15 +256,5 @@ func TestGrpcConnectivity(origin string, timeout time.Duration) bool {
 	}
 	defer transport.Cleanup()
 	
-	// Test with a dummy Open request to verify actual connectivity
-	testRequest := &proto.OpenRequest{
-		Origin:         origin,
-		EncryptedZeros: make([]byte, 32), // Dummy encrypted zeros for connectivity test
-		Name:           "connectivity-test",
-	}
-	
-	_, err = transport.Open(ctx, testRequest)
-	// We expect this to either succeed or fail with a meaningful error
-	// Either way, it proves the gRPC connection is working
-	return err == nil
-}
\ No newline at end of file
+	return transport.probe(ctx) == nil
+}
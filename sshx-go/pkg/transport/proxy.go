@@ -0,0 +1,229 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// SOCKS5Dialer returns a DialFunc that connects through the SOCKS5 proxy at
+// address, which may carry credentials as "user:password@host:port".
+func SOCKS5Dialer(address string) (DialFunc, error) {
+	var auth *proxy.Auth
+	if at := strings.LastIndex(address, "@"); at != -1 {
+		user, password, _ := strings.Cut(address[:at], ":")
+		auth = &proxy.Auth{User: user, Password: password}
+		address = address[at+1:]
+	}
+
+	dialer, err := proxy.SOCKS5("tcp", address, auth, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create SOCKS5 dialer: %w", err)
+	}
+
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("SOCKS5 dialer does not support contexts")
+	}
+	return contextDialer.DialContext, nil
+}
+
+// dial opens a connection with the configured Dialer, or directly if none is set.
+func (c ConnectionConfig) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.Dialer != nil {
+		return c.Dialer(ctx, network, addr)
+	}
+	conn, rtt, err := c.dialDirect(ctx, network, addr)
+	if err == nil && c.OnRTT != nil {
+		// The TCP handshake takes one round trip
+		c.OnRTT(rtt)
+	}
+	return conn, err
+}
+
+// proxyFunc returns the proxy selection function for the configuration.
+//
+// An explicit Proxy overrides HTTP_PROXY/HTTPS_PROXY but still honors NO_PROXY.
+// A nil function means connections are always made directly.
+func (c ConnectionConfig) proxyFunc() func(*url.URL) (*url.URL, error) {
+	switch strings.ToLower(c.Proxy) {
+	case "":
+		return httpproxy.FromEnvironment().ProxyFunc()
+	case "none", "direct":
+		return nil
+	}
+
+	noProxy := os.Getenv("NO_PROXY")
+	if noProxy == "" {
+		noProxy = os.Getenv("no_proxy")
+	}
+	return (&httpproxy.Config{
+		HTTPProxy:  c.Proxy,
+		HTTPSProxy: c.Proxy,
+		NoProxy:    noProxy,
+	}).ProxyFunc()
+}
+
+// httpProxy adapts proxyFunc to the signature used by net/http and gorilla/websocket.
+func (c ConnectionConfig) httpProxy() func(*http.Request) (*url.URL, error) {
+	proxy := c.proxyFunc()
+	if proxy == nil {
+		return nil
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}
+}
+
+// HTTPClient returns an HTTP client that uses the configured proxy settings,
+// for requests to third parties such as GitHub.
+func (c ConnectionConfig) HTTPClient() *http.Client {
+	return c.httpClient(nil)
+}
+
+// ServerHTTPClient returns an HTTP client for requests to the sshx server,
+// which also uses the TLS settings of the transports: the CA file, skipping
+// verification, the server name and the client certificate.
+func (c ConnectionConfig) ServerHTTPClient() (*http.Client, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return c.httpClient(tlsConfig), nil
+}
+
+func (c ConnectionConfig) httpClient(tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Transport: userAgentTransport{
+			agent: c.userAgent(),
+			base: &http.Transport{
+				Proxy:           c.httpProxy(),
+				DialContext:     c.dial,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+// userAgentTransport sets the user agent of requests made through base.
+type userAgentTransport struct {
+	agent string
+	base  http.RoundTripper
+}
+
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.agent)
+	return t.base.RoundTrip(req)
+}
+
+// grpcDialer returns a dialer for gRPC that tunnels through an HTTP proxy with
+// CONNECT when one applies to the target address, using the configured Dialer.
+func (c ConnectionConfig) grpcDialer(secure bool) func(context.Context, string) (net.Conn, error) {
+	proxy := c.proxyFunc()
+	scheme := "http"
+	if secure {
+		scheme = "https"
+	}
+
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		if proxy != nil {
+			proxyURL, err := proxy(&url.URL{Scheme: scheme, Host: addr})
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve proxy: %w", err)
+			}
+			if proxyURL != nil {
+				return dialConnect(ctx, c.dial, proxyURL, addr)
+			}
+		}
+
+		return c.dial(ctx, "tcp", addr)
+	}
+}
+
+// dialConnect opens a tunnel to addr through an HTTP proxy using the CONNECT method.
+func dialConnect(ctx context.Context, dial DialFunc, proxyURL *url.URL, addr string) (net.Conn, error) {
+	proxyAddr := proxyURL.Host
+	if proxyURL.Port() == "" {
+		if proxyURL.Scheme == "https" {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "443")
+		} else {
+			proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "80")
+		}
+	}
+
+	conn, err := dial(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %w", proxyAddr, err)
+	}
+
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with proxy failed: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := proxyURL.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT to %s: %s", addr, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn that drains bytes buffered while reading the
+// CONNECT response before reading from the underlying connection.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
@@ -0,0 +1,40 @@
+package transport
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestServerHTTPClientTLS checks requests to the server are verified with the
+// configured CA file, which the default roots do not trust.
+func TestServerHTTPClientTLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := DefaultConnectionConfig()
+	config.Proxy = "none"
+	if _, err := config.HTTPClient().Get(server.URL); err == nil {
+		t.Fatal("request succeeded without trusting the server certificate")
+	}
+
+	config.TLSCAFile = caFile
+	client, err := config.ServerHTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("request with the CA file failed: %v", err)
+	}
+	resp.Body.Close()
+}
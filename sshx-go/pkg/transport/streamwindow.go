@@ -0,0 +1,122 @@
+package transport
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	pb "sshx-go/pkg/proto"
+)
+
+// DefaultStreamAckWindow is the number of streamed messages
+// DefaultConnectionConfig tracks for rejections.
+const DefaultStreamAckWindow = 64
+
+// DefaultStreamErrorLimit is the number of rejected output or shell creation
+// messages within ConnectionConfig.StreamAckWindow that end a WebSocket
+// channel.
+const DefaultStreamErrorLimit = 3
+
+// streamIDPrefix starts the IDs of messages streamed over a WebSocket
+// channel, followed by the number of the message.
+const streamIDPrefix = "stream_"
+
+// streamWindow numbers the messages a WebSocket channel streams, which the
+// server answers only when it rejects them, and remembers the last of them
+// so rejections can be told apart from old ones and counted. Only rejections
+// of output and shell creations count, which the controller sends again after
+// reconnecting; reconnecting would not get any other message accepted, such
+// as one of a feature the server does not support.
+type streamWindow struct {
+	mu       sync.Mutex
+	sent     []streamEntry // ring of the last messages, empty if not tracked
+	next     uint64        // number of the next message
+	rejected int           // counted rejections among sent
+	limit    int
+}
+
+// streamEntry is a message in a streamWindow. It keeps what describes the
+// message rather than the message, so output is not held on to.
+type streamEntry struct {
+	n        uint64
+	kind     string
+	shell    uint32
+	seq      uint64
+	rejected bool
+}
+
+func newStreamWindow(size, limit int) *streamWindow {
+	if limit <= 0 {
+		limit = DefaultStreamErrorLimit
+	}
+	return &streamWindow{sent: make([]streamEntry, max(size, 0)), limit: limit}
+}
+
+// add records req as streamed and sets its ID.
+func (s *streamWindow) add(req *pb.CliRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.next
+	s.next++
+	if len(s.sent) > 0 {
+		slot := &s.sent[n%uint64(len(s.sent))]
+		if slot.rejected && slot.resent() {
+			s.rejected--
+		}
+		*slot = streamEntry{n: n}
+		switch msg := req.CliMessage.(type) {
+		case *pb.CliRequest_TerminalData:
+			slot.kind, slot.shell, slot.seq = "output", msg.TerminalData.Id, msg.TerminalData.Seq
+		case *pb.CliRequest_CreatedShell:
+			slot.kind, slot.shell = "shell creation", msg.CreatedShell.Id
+		case *pb.CliRequest_ClosedShell:
+			slot.kind, slot.shell = "shell closing", msg.ClosedShell
+		default:
+			slot.kind = "message"
+		}
+	}
+	req.Id = streamIDPrefix + strconv.FormatUint(n, 10)
+}
+
+// reject records that the server rejected the message with id. It returns a
+// description of the message, empty if it is no longer in the window, and
+// whether the rejections within the window reached the limit.
+func (s *streamWindow) reject(id string) (desc string, failed bool) {
+	n, err := strconv.ParseUint(strings.TrimPrefix(id, streamIDPrefix), 10, 64)
+	if err != nil || len(s.sent) == 0 {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	slot := &s.sent[n%uint64(len(s.sent))]
+	if slot.n != n || n >= s.next || slot.rejected {
+		return "", false
+	}
+	slot.rejected = true
+	if !slot.resent() {
+		return slot.describe(), false
+	}
+	s.rejected++
+	return slot.describe(), s.rejected >= s.limit
+}
+
+// resent reports whether the controller sends the message again after a
+// reconnect, so its rejection counts towards the limit.
+func (e *streamEntry) resent() bool {
+	return e.kind == "output" || e.kind == "shell creation"
+}
+
+// describe describes the message for logs.
+func (e *streamEntry) describe() string {
+	switch e.kind {
+	case "output":
+		return fmt.Sprintf("output of shell %d at offset %d", e.shell, e.seq)
+	case "message":
+		return fmt.Sprintf("message #%d", e.n)
+	default:
+		return fmt.Sprintf("%s of shell %d", e.kind, e.shell)
+	}
+}
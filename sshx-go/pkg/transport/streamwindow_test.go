@@ -0,0 +1,53 @@
+package transport
+
+import (
+	"testing"
+
+	pb "sshx-go/pkg/proto"
+)
+
+// TestStreamWindowRejections checks only rejected output and shell creations
+// end the channel, once the limit of them is within the window.
+func TestStreamWindowRejections(t *testing.T) {
+	s := newStreamWindow(8, 3)
+	send := func(req *pb.CliRequest) string {
+		s.add(req)
+		return req.Id
+	}
+	output := func() string {
+		return send(&pb.CliRequest{CliMessage: &pb.CliRequest_TerminalData{TerminalData: &pb.TerminalData{Id: 1}}})
+	}
+	closed := func() string {
+		return send(&pb.CliRequest{CliMessage: &pb.CliRequest_ClosedShell{ClosedShell: 1}})
+	}
+
+	// Messages the controller does not send again never end the channel
+	for range 20 {
+		if desc, failed := s.reject(closed()); desc == "" || failed {
+			t.Fatalf("rejected shell closing: desc %q, failed %v", desc, failed)
+		}
+	}
+
+	first := output()
+	if _, failed := s.reject(first); failed {
+		t.Fatal("failed after one rejected output")
+	}
+	if desc, failed := s.reject(first); desc != "" || failed {
+		t.Fatal("the same rejection was counted twice")
+	}
+
+	// The rejection leaves the window and no longer counts
+	for range 8 {
+		closed()
+	}
+	if desc, _ := s.reject(first); desc != "" {
+		t.Fatal("a message that left the window was rejected")
+	}
+
+	created := send(&pb.CliRequest{CliMessage: &pb.CliRequest_CreatedShell{CreatedShell: &pb.NewShell{Id: 2}}})
+	for i, id := range []string{output(), created, output()} {
+		if _, failed := s.reject(id); failed != (i == 2) {
+			t.Fatalf("failed = %v after %d rejections within the window", failed, i+1)
+		}
+	}
+}
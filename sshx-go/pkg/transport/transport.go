@@ -1,11 +1,23 @@
 // Package transport provides a unified interface for connecting to sshx servers
 // via either gRPC or WebSocket protocols, with automatic fallback capability.
+//
+// The fallback chain only holds transports the server serves. QUIC and
+// WebTransport are left out until the server carries the protobuf framing
+// over QUIC streams; a client attempt before that could only fail and delay
+// the WebSocket fallback. gRPC-Web is left out as well: it has no
+// bidirectional streams, so Channel would need splitting on the server into a
+// server stream plus unary sends first.
 package transport
 
 import (
 	"context"
+	"fmt"
+	"net"
+	"strings"
 	"time"
 
+	"google.golang.org/grpc/keepalive"
+
 	"sshx-go/pkg/proto"
 )
 
@@ -50,12 +62,54 @@ func (m ConnectionMethod) String() string {
 	}
 }
 
+// TransportPreference selects which transports may be used when connecting.
+type TransportPreference int
+
+const (
+	// PreferAuto tries gRPC first, then falls back to WebSocket.
+	PreferAuto TransportPreference = iota
+	// PreferGrpc only attempts a gRPC connection.
+	PreferGrpc
+	// PreferWebSocket only attempts a WebSocket connection, skipping the gRPC probe.
+	PreferWebSocket
+)
+
+func (p TransportPreference) String() string {
+	switch p {
+	case PreferAuto:
+		return "auto"
+	case PreferGrpc:
+		return "grpc"
+	case PreferWebSocket:
+		return "websocket"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTransportPreference parses a transport name as accepted by the --transport flag.
+func ParseTransportPreference(s string) (TransportPreference, error) {
+	switch strings.ToLower(s) {
+	case "", "auto":
+		return PreferAuto, nil
+	case "grpc":
+		return PreferGrpc, nil
+	case "websocket", "ws":
+		return PreferWebSocket, nil
+	default:
+		return PreferAuto, fmt.Errorf("invalid transport %q (expected grpc, websocket, or auto)", s)
+	}
+}
+
 // ConnectionResult contains the result of a connection attempt.
 type ConnectionResult struct {
 	// Transport is the established transport connection.
 	Transport SshxTransport
 	// Method is the connection method that was used.
 	Method ConnectionMethod
+	// Session is the session opened over Transport by OpenWithFallback, or
+	// nil for ConnectWithFallback.
+	Session *proto.OpenResponse
 }
 
 // ConnectionConfig holds configuration for creating a connection.
@@ -66,13 +120,177 @@ type ConnectionConfig struct {
 	GrpcTimeout time.Duration
 	// WebSocketTimeout is custom timeout for WebSocket connection attempts.
 	WebSocketTimeout time.Duration
+	// OpenTimeout, ChannelTimeout and CloseTimeout bound opening a session,
+	// starting a WebSocket channel and closing a session, including the wait
+	// for the server's answer. Zero uses DefaultOpenTimeout,
+	// DefaultChannelTimeout and DefaultCloseTimeout. A sooner deadline of the
+	// caller's context applies instead.
+	OpenTimeout    time.Duration
+	ChannelTimeout time.Duration
+	CloseTimeout   time.Duration
+	// StreamAckWindow, when positive, makes the WebSocket transport remember
+	// this many of the messages it streamed last. The server answers those
+	// only to reject them, so rejections are logged with the message they
+	// concern, and StreamErrorLimit rejections of output or shell creations
+	// within the window drop the connection for the controller to reconnect
+	// and resend, instead of output being lost silently. Zero disables
+	// tracking.
+	StreamAckWindow int
+	// StreamErrorLimit is the number of rejections of output or shell
+	// creations within StreamAckWindow that drop the connection. Zero uses
+	// DefaultStreamErrorLimit.
+	StreamErrorLimit int
+	// WebSocketReadTimeout drops a WebSocket connection nothing was read
+	// from for this long, WebSocketWriteTimeout one a ping or message could
+	// not be written to for this long, and WebSocketPingInterval is how often
+	// pings are sent. WebSocketMissedPongs pings in a row going unanswered
+	// also drop the connection. Zero values use the DefaultWebSocket ones.
+	WebSocketReadTimeout  time.Duration
+	WebSocketWriteTimeout time.Duration
+	WebSocketPingInterval time.Duration
+	WebSocketMissedPongs  int
+	// Preference restricts which transports are attempted.
+	Preference TransportPreference
+	// Proxy is an HTTP(S) proxy URL overriding HTTP_PROXY/HTTPS_PROXY.
+	// Empty uses the environment; "none" disables proxying.
+	Proxy string
+	// Dialer opens the underlying network connections for both transports.
+	// When nil, connections are made directly, trying the server's IPv6 and
+	// IPv4 addresses in alternation as Happy Eyeballs does.
+	Dialer DialFunc
+	// DialAttemptDelay is how long a direct connection attempt to one address
+	// runs before the next address is tried alongside it. Zero uses
+	// DefaultDialAttemptDelay.
+	DialAttemptDelay time.Duration
+	// DialAddressTimeout bounds a direct connection attempt to one address.
+	// Zero uses DefaultDialAddressTimeout.
+	DialAddressTimeout time.Duration
+	// TLSCertFile and TLSKeyFile hold a PEM client certificate and key
+	// presented to servers that require mutual TLS.
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSCAFile holds PEM CA certificates used to verify the server instead
+	// of the system roots.
+	TLSCAFile string
+	// TLSServerName overrides the server name used for SNI and certificate
+	// verification.
+	TLSServerName string
+	// TLSInsecureSkipVerify disables server certificate verification. Only
+	// intended for self-signed test servers.
+	TLSInsecureSkipVerify bool
+	// GrpcTarget is the host:port dialed by the gRPC transport, overriding the
+	// one derived from the origin, for servers serving gRPC on another port.
+	GrpcTarget string
+	// GrpcAuthority overrides the :authority of gRPC requests. It is also the
+	// TLS server name unless TLSServerName is set.
+	GrpcAuthority string
+	// WebSocketPathTemplate is the path of the WebSocket CLI endpoint, with
+	// {name} replaced by the session name, for servers behind a reverse proxy
+	// that mounts them elsewhere. Empty uses DefaultWebSocketPathTemplate
+	// below the path of the server URL.
+	WebSocketPathTemplate string
+	// OnRTT, if set, is called with each round-trip time to the server measured
+	// by the transport, from TCP connection setup and WebSocket pings.
+	OnRTT func(time.Duration)
+	// GrpcKeepalive controls HTTP/2 keepalive pings on gRPC connections, so
+	// idle connections dropped by NATs or proxies are detected and reconnected.
+	// The zero value uses DefaultGrpcKeepalive.
+	GrpcKeepalive keepalive.ClientParameters
+	// ClientVersion identifies this client to the server, sent with every
+	// connection as the user agent "sshx-go/<ClientVersion>".
+	ClientVersion string
+}
+
+// openTimeout returns the timeout for opening a session.
+func (c ConnectionConfig) openTimeout() time.Duration {
+	if c.OpenTimeout <= 0 {
+		return DefaultOpenTimeout
+	}
+	return c.OpenTimeout
+}
+
+// channelTimeout returns the timeout for starting a WebSocket channel.
+func (c ConnectionConfig) channelTimeout() time.Duration {
+	if c.ChannelTimeout <= 0 {
+		return DefaultChannelTimeout
+	}
+	return c.ChannelTimeout
+}
+
+// closeTimeout returns the timeout for closing a session.
+func (c ConnectionConfig) closeTimeout() time.Duration {
+	if c.CloseTimeout <= 0 {
+		return DefaultCloseTimeout
+	}
+	return c.CloseTimeout
 }
 
+// webSocketReadTimeout returns the WebSocket read deadline.
+func (c ConnectionConfig) webSocketReadTimeout() time.Duration {
+	if c.WebSocketReadTimeout <= 0 {
+		return DefaultWebSocketReadTimeout
+	}
+	return c.WebSocketReadTimeout
+}
+
+// webSocketWriteTimeout returns the WebSocket write deadline.
+func (c ConnectionConfig) webSocketWriteTimeout() time.Duration {
+	if c.WebSocketWriteTimeout <= 0 {
+		return DefaultWebSocketWriteTimeout
+	}
+	return c.WebSocketWriteTimeout
+}
+
+// webSocketPingInterval returns how often WebSocket pings are sent.
+func (c ConnectionConfig) webSocketPingInterval() time.Duration {
+	if c.WebSocketPingInterval <= 0 {
+		return DefaultWebSocketPingInterval
+	}
+	return c.WebSocketPingInterval
+}
+
+// webSocketMissedPongs returns the unanswered pings that drop a connection.
+func (c ConnectionConfig) webSocketMissedPongs() int {
+	if c.WebSocketMissedPongs <= 0 {
+		return DefaultWebSocketMissedPongs
+	}
+	return c.WebSocketMissedPongs
+}
+
+// userAgent returns the user agent sent to the server.
+func (c ConnectionConfig) userAgent() string {
+	if c.ClientVersion == "" {
+		return "sshx-go"
+	}
+	return "sshx-go/" + c.ClientVersion
+}
+
+// DefaultGrpcKeepalive pings the server after 30 seconds without activity,
+// even when no stream is open, and drops the connection if no reply arrives
+// within 10 seconds.
+var DefaultGrpcKeepalive = keepalive.ClientParameters{
+	Time:                30 * time.Second,
+	Timeout:             10 * time.Second,
+	PermitWithoutStream: true,
+}
+
+// grpcKeepalive returns the keepalive parameters for gRPC connections.
+func (c ConnectionConfig) grpcKeepalive() keepalive.ClientParameters {
+	if c.GrpcKeepalive == (keepalive.ClientParameters{}) {
+		return DefaultGrpcKeepalive
+	}
+	return c.GrpcKeepalive
+}
+
+// DialFunc opens a network connection, with the same signature as net.Dialer.DialContext.
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
 // DefaultConnectionConfig returns a default connection configuration.
 func DefaultConnectionConfig() ConnectionConfig {
 	return ConnectionConfig{
 		VerboseErrors:    false,
-		GrpcTimeout:     3 * time.Second,
-		WebSocketTimeout: 5 * time.Second,
+		GrpcTimeout:     DefaultGrpcTimeout,
+		WebSocketTimeout: DefaultWebSocketTimeout,
+		StreamAckWindow:  DefaultStreamAckWindow,
 	}
 }
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -137,38 +139,70 @@ type WebSocketTransport struct {
 	done            chan struct{}
 	mu              sync.RWMutex
 	closed          bool
+
+	// Timeouts of the requests awaiting the server's answer
+	openTimeout    time.Duration
+	channelTimeout time.Duration
+	closeTimeout   time.Duration
+
+	// Messages streamed over the channel, to correlate rejections
+	streamed *streamWindow
+
+	// Keep-alive settings, and the send time of the last ping answered in
+	// Unix nanoseconds
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	missedPongs  int
+	answered     atomic.Int64
 }
 
 // ConnectWebSocket creates a new WebSocket transport by connecting to a server.
 func ConnectWebSocket(endpoint string) (*WebSocketTransport, error) {
+	return ConnectWebSocketWithConfig(endpoint, DefaultConnectionConfig())
+}
+
+// ConnectWebSocketWithConfig creates a new WebSocket transport using the given connection configuration.
+func ConnectWebSocketWithConfig(endpoint string, config ConnectionConfig) (*WebSocketTransport, error) {
 	parsedURL, err := url.Parse(endpoint)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse WebSocket URL: %w", err)
 	}
 
-	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
-	}
-
-	conn, _, err := dialer.Dial(parsedURL.String(), nil)
+	conn, err := config.DialWebSocket(context.Background(), parsedURL.String())
 	if err != nil {
-		return nil, fmt.Errorf("failed to connect to WebSocket: %w", err)
+		return nil, err
 	}
 
-	// Configure WebSocket connection for proper keep-alive
-	// We'll update the read deadline on every message received in readLoop
-	conn.SetPongHandler(func(string) error {
-		conn.SetReadDeadline(time.Now().Add(120 * time.Second))
-		return nil
-	})
-
 	transport := &WebSocketTransport{
 		conn:           conn,
 		responseWriter: newResponseWriter(),
 		serverUpdates:  make(chan *pb.ServerUpdate, 256),
 		done:           make(chan struct{}),
+		openTimeout:    config.openTimeout(),
+		channelTimeout: config.channelTimeout(),
+		closeTimeout:   config.closeTimeout(),
+		streamed:       newStreamWindow(config.StreamAckWindow, config.StreamErrorLimit),
+		readTimeout:    config.webSocketReadTimeout(),
+		writeTimeout:   config.webSocketWriteTimeout(),
+		pingInterval:   config.webSocketPingInterval(),
+		missedPongs:    config.webSocketMissedPongs(),
 	}
 
+	// Configure WebSocket connection for proper keep-alive
+	// We'll update the read deadline on every message received in readLoop
+	// Pings carry their send time, so each pong also measures the round trip
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(transport.readTimeout))
+		if sent, err := strconv.ParseInt(appData, 10, 64); err == nil {
+			transport.answered.Store(sent)
+			if config.OnRTT != nil {
+				config.OnRTT(time.Since(time.Unix(0, sent)))
+			}
+		}
+		return nil
+	})
+
 	// Start background tasks to handle WebSocket communication
 	go transport.readLoop()
 	go transport.pingLoop()
@@ -190,7 +224,7 @@ func (w *WebSocketTransport) Open(ctx context.Context, request *pb.OpenRequest)
 	util.DebugLog("WebSocket sending Open request with session: %s", request.Name)
 	util.DebugLog("Go client encrypted_zeros length: %d bytes", len(request.EncryptedZeros))
 
-	response, err := w.sendRequestWithResponse(ctx, req, 30*time.Second)
+	response, err := w.sendRequestWithResponse(ctx, req, w.openTimeout)
 	if err != nil {
 		return nil, fmt.Errorf("WebSocket open request failed: %w", err)
 	}
@@ -199,8 +233,8 @@ func (w *WebSocketTransport) Open(ctx context.Context, request *pb.OpenRequest)
 	switch resp := response.CliResponseMessage.(type) {
 	case *pb.CliResponse_OpenSession:
 		openResp := resp.OpenSession
-		util.DebugLog("WebSocket Open response: Name=%s, Token=%s, URL=%s", 
-			openResp.Name, openResp.Token, openResp.Url)
+		util.DebugLog("WebSocket Open response: Name=%s, Token=%s, URL=%s",
+			openResp.Name, util.Secret(openResp.Token), openResp.Url)
 		util.DebugLog("WebSocket session validation - Server returned session name: %s", openResp.Name)
 		return openResp, nil
 
@@ -217,11 +251,16 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 	// Create channels for this streaming session
 	serverChan := make(chan *pb.ServerUpdate, 256)
 	clientChan := make(chan *pb.ClientUpdate, 256)
+	// The forwarder below must be done sending before serverChan is closed
+	stopForwarder := make(chan struct{})
+	forwarderDone := make(chan struct{})
 	
 	// Handle the protocol in a separate goroutine
 	go func() {
 		defer func() {
 			util.DebugLog("WebSocket channel protocol goroutine exiting")
+			close(stopForwarder)
+			<-forwarderDone
 			close(serverChan)
 		}()
 		
@@ -238,10 +277,10 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 				hello = firstUpdate.GetHello()
 				if hello != "" {
 					helloReceived = true
-					util.DebugLog("WebSocket received Hello: %s", hello)
+					util.DebugLog("WebSocket received Hello: %s", util.Secret(hello))
 				} else {
 					// Not a Hello, this is an error in protocol
-					util.DebugLog("WebSocket received non-Hello message while waiting for Hello: %T", firstUpdate.ClientMessage)
+					util.DebugLog("WebSocket received non-Hello message while waiting for Hello: %s", fmt.Sprintf("%T", firstUpdate.ClientMessage))
 					return
 				}
 			case <-ctx.Done():
@@ -254,7 +293,7 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 		// Parse name and token from Hello message
 		parts := strings.Split(hello, ",")
 		if len(parts) != 2 {
-			log.Printf("Invalid hello format: %s", hello)
+			util.Warnf("Invalid hello format: %s", util.Secret(hello))
 			return
 		}
 		name, token := parts[0], parts[1]
@@ -270,9 +309,9 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 			},
 		}
 		
-		response, err := w.sendRequestWithResponse(ctx, req, 30*time.Second)
+		response, err := w.sendRequestWithResponse(ctx, req, w.channelTimeout)
 		if err != nil {
-			log.Printf("Failed to start WebSocket channel: %v", err)
+			util.Warnf("Failed to start WebSocket channel: %v", err)
 			return
 		}
 		
@@ -281,10 +320,10 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 		case *pb.CliResponse_StartChannel:
 			util.DebugLog("WebSocket channel started successfully")
 		case *pb.CliResponse_Error:
-			log.Printf("Server error starting channel: %s", response.GetError())
+			util.Errorf("Server error starting channel: %s", response.GetError())
 			return
 		default:
-			log.Printf("Unexpected response to StartChannel")
+			util.Warnf("Unexpected response to StartChannel")
 			return
 		}
 		
@@ -308,7 +347,7 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 				
 				cliMsg, err := ClientUpdateToCliMessage(update)
 				if err != nil {
-					log.Printf("WebSocket failed to convert client message #%d: %v", messageCount, err)
+					util.Warnf("WebSocket failed to convert client message #%d: %v", messageCount, err)
 					continue
 				}
 				
@@ -317,8 +356,8 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 					continue
 				}
 				
-				// Create streaming request - these don't get individual responses
-				requestID := fmt.Sprintf("stream_%d", time.Now().UnixNano())
+				// Create streaming request - these are only answered when
+				// the server rejects them
 				// Convert interface{} to the right protobuf oneof type
 				var cliMessage interface{}
 				if cliMsg != nil {
@@ -326,9 +365,7 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 				}
 				
 				// Type assert to the correct protobuf oneof interface
-				req := &pb.CliRequest{
-					Id: requestID,
-				}
+				req := &pb.CliRequest{}
 				
 				// Set the cli message field based on type
 				switch msg := cliMessage.(type) {
@@ -345,29 +382,35 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 				default:
 					continue // Skip unsupported message types
 				}
+				w.streamed.add(req)
 				
-				// Serialize to protobuf binary
-				data, err := proto.Marshal(req)
+				// Serialize to protobuf binary, in a buffer reused once written
+				buf, err := marshalPooled(req)
 				if err != nil {
-					log.Printf("Failed to serialize client message: %v", err)
+					util.Warnf("Failed to serialize client message: %v", err)
 					continue
 				}
-				
+				size := len(*buf)
+
 				// Write to WebSocket
 				w.mu.Lock()
 				if w.closed {
 					w.mu.Unlock()
-					log.Printf("WebSocket transport closed while sending message #%d", messageCount)
+					releaseBuffer(buf)
+					util.Warnf("WebSocket transport closed while sending message #%d", messageCount)
 					return
 				}
-				err = w.conn.WriteMessage(websocket.BinaryMessage, data)
+				w.conn.SetWriteDeadline(time.Now().Add(w.writeTimeout))
+				err = w.conn.WriteMessage(websocket.BinaryMessage, *buf)
+				w.conn.SetWriteDeadline(time.Time{})
 				w.mu.Unlock()
+				releaseBuffer(buf)
 				
 				if err != nil {
-					log.Printf("WebSocket failed to send outbound message #%d: %v", messageCount, err)
+					util.Warnf("WebSocket failed to send outbound message #%d: %v", messageCount, err)
 					return
 				}
-				util.DebugLog("WebSocket sent streaming message #%d (%d bytes)", messageCount, len(data))
+				util.DebugLog("WebSocket sent streaming message #%d (%d bytes)", messageCount, size)
 				
 			case <-ctx.Done():
 				return
@@ -381,6 +424,7 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 	go func() {
 		defer func() {
 			util.DebugLog("WebSocket server message forwarder exiting")
+			close(forwarderDone)
 		}()
 		
 		var serverMessageCount int64
@@ -392,7 +436,7 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 					return
 				}
 				serverMessageCount++
-				util.DebugLog("WebSocket forwarding server message #%d: %T to controller", serverMessageCount, update.ServerMessage)
+				util.DebugLog("WebSocket forwarding server message #%d: %s to controller", serverMessageCount, fmt.Sprintf("%T", update.ServerMessage))
 				select {
 				case serverChan <- update:
 					util.DebugLog("WebSocket successfully forwarded server message #%d", serverMessageCount)
@@ -400,11 +444,15 @@ func (w *WebSocketTransport) Channel(ctx context.Context) (chan *pb.ServerUpdate
 					return
 				case <-w.done:
 					return
+				case <-stopForwarder:
+					return
 				}
 			case <-ctx.Done():
 				return
 			case <-w.done:
 				return
+			case <-stopForwarder:
+				return
 			}
 		}
 	}()
@@ -422,7 +470,7 @@ func (w *WebSocketTransport) Close(ctx context.Context, request *pb.CloseRequest
 		},
 	}
 
-	response, err := w.sendRequestWithResponse(ctx, req, 30*time.Second)
+	response, err := w.sendRequestWithResponse(ctx, req, w.closeTimeout)
 	if err != nil {
 		return fmt.Errorf("WebSocket close request failed: %w", err)
 	}
@@ -474,45 +522,54 @@ func (w *WebSocketTransport) Cleanup() error {
 	return err
 }
 
-// sendRequestWithResponse sends a request and waits for a correlated response.
+// sendRequestWithResponse sends a request and waits for a correlated response,
+// giving up after timeout or once ctx is done, whichever comes first. Writing
+// the request is bound by the same deadline.
 func (w *WebSocketTransport) sendRequestWithResponse(ctx context.Context, req *pb.CliRequest, timeout time.Duration) (*pb.CliResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("request not sent: %w", err)
+	}
+
 	responseCh := make(chan *pb.CliResponse, 1)
 	w.responseWriter.addPendingRequest(req.Id, responseCh)
 
-	// Send the request as binary protobuf
-	w.mu.RLock()
+	// Send the request as binary protobuf; the connection allows one writer at a time
+	w.mu.Lock()
 	if w.closed {
-		w.mu.RUnlock()
+		w.mu.Unlock()
 		return nil, fmt.Errorf("transport is closed")
 	}
 
 	// Marshal protobuf to binary
 	data, err := proto.Marshal(req)
 	if err != nil {
-		w.mu.RUnlock()
+		w.mu.Unlock()
 		w.responseWriter.removePendingRequest(req.Id)
 		return nil, fmt.Errorf("failed to marshal protobuf request: %w", err)
 	}
 
-	// Send binary message
-	if err := w.conn.WriteMessage(websocket.BinaryMessage, data); err != nil {
-		w.mu.RUnlock()
+	// Send binary message. A write cut short by the deadline leaves the
+	// connection unusable, but then the server is not reading it anyway
+	deadline, _ := ctx.Deadline()
+	w.conn.SetWriteDeadline(deadline)
+	err = w.conn.WriteMessage(websocket.BinaryMessage, data)
+	w.conn.SetWriteDeadline(time.Time{})
+	w.mu.Unlock()
+	if err != nil {
 		w.responseWriter.removePendingRequest(req.Id)
 		return nil, fmt.Errorf("failed to send binary request: %w", err)
 	}
-	w.mu.RUnlock()
-
-	// Wait for response with timeout
-	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
 
 	select {
 	case response := <-responseCh:
 		return response, nil
-	case <-timeoutCtx.Done():
+	case <-ctx.Done():
 		w.responseWriter.removePendingRequest(req.Id)
-		return nil, fmt.Errorf("request timed out")
+		return nil, fmt.Errorf("no response to request: %w", ctx.Err())
 	case <-w.done:
+		w.responseWriter.removePendingRequest(req.Id)
 		return nil, fmt.Errorf("transport closed")
 	}
 }
@@ -545,7 +602,7 @@ func (w *WebSocketTransport) readLoop() {
 		}
 
 		// Update read deadline to detect stale connections
-		w.conn.SetReadDeadline(time.Now().Add(120 * time.Second))
+		w.conn.SetReadDeadline(time.Now().Add(w.readTimeout))
 		
 		_, message, err := w.conn.ReadMessage()
 		if err != nil {
@@ -553,13 +610,13 @@ func (w *WebSocketTransport) readLoop() {
 			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) &&
 				!strings.Contains(err.Error(), "use of closed network connection") &&
 				!strings.Contains(err.Error(), "timeout") {
-				log.Printf("WebSocket read error: %v", err)
+				util.Warnf("WebSocket read error: %v", err)
 			}
 			return
 		}
 
 		if err := w.handleIncomingMessage(message); err != nil {
-			log.Printf("Error handling WebSocket message: %v", err)
+			util.Warnf("Error handling WebSocket message: %v", err)
 		}
 	}
 }
@@ -580,10 +637,10 @@ func (w *WebSocketTransport) handleIncomingMessage(message []byte) error {
 			
 			serverUpdate, err := CliResponseToServerUpdate(cliResponse.CliResponseMessage)
 			if err != nil {
-				log.Printf("Failed to convert server_update to ServerUpdate: %v, message: %+v", err, cliResponse.CliResponseMessage)
+				util.Warnf("Failed to convert server_update to ServerUpdate: %v, message: %+v", err, cliResponse.CliResponseMessage)
 				return fmt.Errorf("failed to convert CLI response to server update: %w", err)
 			}
-			util.DebugLog("WebSocket converted to ServerUpdate: %T", serverUpdate.ServerMessage)
+			util.DebugLog("WebSocket converted to ServerUpdate: %s", fmt.Sprintf("%T", serverUpdate.ServerMessage))
 
 			select {
 			case w.serverUpdates <- serverUpdate:
@@ -593,16 +650,39 @@ func (w *WebSocketTransport) handleIncomingMessage(message []byte) error {
 			return nil
 		}
 		
+		// Streamed messages are answered only when the server rejects them
+		if strings.HasPrefix(cliResponse.Id, streamIDPrefix) {
+			w.handleStreamError(&cliResponse)
+			return nil
+		}
+
 		// Handle regular request-response messages
 		w.responseWriter.handleResponse(&cliResponse)
 		return nil
 	}
 
 	// If we get here, the message format was invalid - matches Rust debug logging
-	log.Printf("Failed to parse WebSocket message: %s", string(message))
+	util.Warnf("Failed to parse WebSocket message: %s", string(message))
 	return nil
 }
 
+// handleStreamError reports the server rejecting a streamed message, and
+// drops the connection once it rejected ConnectionConfig.StreamErrorLimit of
+// the output and shell creations in the window, so the controller reconnects
+// and resends output from the server's offsets rather than losing it.
+func (w *WebSocketTransport) handleStreamError(response *pb.CliResponse) {
+	desc, failed := w.streamed.reject(response.Id)
+	if desc == "" {
+		util.DebugLog("WebSocket server rejected streamed message %s: %s", response.Id, response.GetError())
+		return
+	}
+	util.Warnf("WebSocket server rejected %s: %s", desc, response.GetError())
+	if failed {
+		util.Warnf("WebSocket server keeps rejecting messages, reconnecting")
+		w.Cleanup()
+	}
+}
+
 // parseJSONBytes converts JSON data back to []byte, handling JSON arrays
 func parseJSONBytes(value interface{}) []byte {
 	switch v := value.(type) {
@@ -616,7 +696,7 @@ func parseJSONBytes(value interface{}) []byte {
 		}
 		return result
 	default:
-		log.Printf("Warning: parseJSONBytes received unsupported type: %T", value)
+		util.Warnf("parseJSONBytes received unsupported type: %s", fmt.Sprintf("%T", value))
 		return nil
 	}
 }
@@ -723,24 +803,43 @@ func CliResponseToServerUpdate(cliMsg interface{}) (*pb.ServerUpdate, error) {
 }
 
 // pingLoop sends periodic ping frames to keep the WebSocket connection alive.
+// Failing to send one, or missing ConnectionConfig.WebSocketMissedPongs pongs
+// in a row, closes the transport, which ends the channel so the controller
+// reconnects.
 func (w *WebSocketTransport) pingLoop() {
-	ticker := time.NewTicker(30 * time.Second)
+	ticker := time.NewTicker(w.pingInterval)
 	defer ticker.Stop()
 
+	var lastPing int64
+	missed := 0
 	for {
 		select {
 		case <-ticker.C:
+			if lastPing != 0 && w.answered.Load() < lastPing {
+				missed++
+				if missed >= w.missedPongs {
+					util.Warnf("WebSocket server missed %d pings in a row, reconnecting", missed)
+					w.Cleanup()
+					return
+				}
+			} else {
+				missed = 0
+			}
+
 			w.mu.Lock()
 			if w.closed {
 				w.mu.Unlock()
 				return
 			}
 			
-			err := w.conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(10*time.Second))
+			now := time.Now()
+			lastPing = now.UnixNano()
+			err := w.conn.WriteControl(websocket.PingMessage, []byte(strconv.FormatInt(lastPing, 10)), now.Add(w.writeTimeout))
 			w.mu.Unlock()
 			
 			if err != nil {
-				log.Printf("WebSocket ping failed: %v", err)
+				util.Warnf("WebSocket ping failed, reconnecting: %v", err)
+				w.Cleanup()
 				return
 			}
 		case <-w.done:
@@ -749,13 +848,66 @@ func (w *WebSocketTransport) pingLoop() {
 	}
 }
 
+// DefaultWebSocketPathTemplate is the path of the WebSocket CLI endpoint
+// below the server URL, with {name} standing for the session name.
+const DefaultWebSocketPathTemplate = "/api/cli/{name}"
+
 // GrpcToWebSocketURL converts a gRPC server URL to its corresponding WebSocket CLI endpoint.
 func GrpcToWebSocketURL(grpcURL, sessionName string) string {
-	wsURL := strings.Replace(grpcURL, "https://", "wss://", 1)
-	wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
-	
-	// Handle the case where the URL might end with a slash
-	base := strings.TrimSuffix(wsURL, "/")
-	
-	return fmt.Sprintf("%s/api/cli/%s", base, sessionName)
+	return webSocketURL(grpcURL, sessionName, "")
+}
+
+// WebSocketURL returns the WebSocket CLI endpoint of a session on the server
+// at origin, using WebSocketPathTemplate when it is set.
+func (c ConnectionConfig) WebSocketURL(origin, sessionName string) string {
+	return webSocketURL(origin, sessionName, c.WebSocketPathTemplate)
+}
+
+// DialWebSocket connects to a WebSocket endpoint with the proxy and TLS
+// settings of c. The transports use it for the CLI endpoint, and it serves
+// others, such as the endpoint browsers join sessions on.
+func (c ConnectionConfig) DialWebSocket(ctx context.Context, endpoint string) (*websocket.Conn, error) {
+	tlsConfig, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := websocket.Dialer{
+		HandshakeTimeout: 10 * time.Second,
+		Proxy:            c.httpProxy(),
+		NetDialContext:   c.dial,
+		TLSClientConfig:  tlsConfig,
+	}
+
+	header := http.Header{"User-Agent": {c.userAgent()}}
+	conn, _, err := dialer.DialContext(ctx, endpoint, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to WebSocket: %w", err)
+	}
+	return conn, nil
+}
+
+// webSocketURL builds the WebSocket CLI endpoint for a session. An empty
+// template appends DefaultWebSocketPathTemplate to any path of origin, so
+// servers mounted under a subpath work without one.
+func webSocketURL(origin, sessionName, template string) string {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		wsURL := strings.Replace(origin, "https://", "wss://", 1)
+		wsURL = strings.Replace(wsURL, "http://", "ws://", 1)
+		return strings.TrimSuffix(wsURL, "/") + strings.ReplaceAll(DefaultWebSocketPathTemplate, "{name}", sessionName)
+	}
+
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+	if template == "" {
+		template = strings.TrimSuffix(u.Path, "/") + DefaultWebSocketPathTemplate
+	}
+	u.Path = strings.ReplaceAll(template, "{name}", sessionName)
+	u.RawPath = ""
+	return u.String()
 }
\ No newline at end of file
@@ -0,0 +1,92 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+
+	pb "sshx-go/pkg/proto"
+)
+
+// floodServer starts a WebSocket server that accepts a channel, sends many
+// server updates at once and then drops the connection.
+func floodServer(t *testing.T, updates int) string {
+	t.Helper()
+	var upgrader websocket.Upgrader
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		write := func(resp *pb.CliResponse) error {
+			data, err := proto.Marshal(resp)
+			if err != nil {
+				return err
+			}
+			return ws.WriteMessage(websocket.BinaryMessage, data)
+		}
+
+		for {
+			_, message, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req pb.CliRequest
+			if err := proto.Unmarshal(message, &req); err != nil {
+				return
+			}
+			if req.GetStartChannel() == nil {
+				continue
+			}
+			write(&pb.CliResponse{
+				Id:                 req.Id,
+				CliResponseMessage: &pb.CliResponse_StartChannel{StartChannel: &pb.ChannelStartResponse{}},
+			})
+			for i := 0; i < updates; i++ {
+				write(&pb.CliResponse{
+					Id:                 "server_update",
+					CliResponseMessage: &pb.CliResponse_Ping{Ping: uint64(i)},
+				})
+			}
+			return
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// TestWebSocketChannelDropWhileForwarding drops the connection while server
+// updates are still being forwarded to the channel. The channel must close
+// once, after the last update, without the forwarder sending on it after it
+// was closed. The race is only caught reliably with -race.
+func TestWebSocketChannelDropWhileForwarding(t *testing.T) {
+	endpoint := floodServer(t, 1000)
+	for i := 0; i < 100; i++ {
+		transport, err := ConnectWebSocket(endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		serverChan, clientChan, err := transport.Channel(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		clientChan <- &pb.ClientUpdate{ClientMessage: &pb.ClientUpdate_Hello{Hello: "name,token"}}
+
+		for range serverChan {
+		}
+		if ctx.Err() != nil {
+			t.Fatal("server channel was not closed after the connection dropped")
+		}
+		cancel()
+		transport.Cleanup()
+	}
+}
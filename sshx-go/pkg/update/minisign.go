@@ -0,0 +1,79 @@
+package update
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignKey is a minisign public key: "Ed", the key ID and the Ed25519 key.
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PublicKey
+}
+
+// parseMinisignKey parses a public key as minisign prints it, the base64
+// line alone or with the untrusted comment line before it.
+func parseMinisignKey(text string) (*minisignKey, error) {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil || len(data) != 2+8+ed25519.PublicKeySize || string(data[:2]) != "Ed" {
+		return nil, errors.New("invalid minisign public key")
+	}
+	k := &minisignKey{key: ed25519.PublicKey(data[10:])}
+	copy(k.id[:], data[2:10])
+	return k, nil
+}
+
+// verify checks sig, the contents of a .minisig file, signs message. Both
+// the prehashed signatures minisign makes by default and legacy ones are
+// accepted, and the trusted comment must be signed too.
+func (k *minisignKey) verify(message, sig []byte) error {
+	lines := strings.Split(strings.TrimRight(string(sig), "\r\n"), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[2], "trusted comment: ") {
+		return errors.New("invalid minisign signature")
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(data) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	}
+
+	if !bytes.Equal(data[2:10], k.id[:]) {
+		return fmt.Errorf("signed with key %X, not the release key %X", reverse(data[2:10]), reverse(k.id[:]))
+	}
+	switch string(data[:2]) {
+	case "ED":
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	case "Ed":
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", data[:2])
+	}
+	signature := data[10:]
+	if !ed25519.Verify(k.key, message, signature) {
+		return errors.New("signature does not match")
+	}
+	comment := strings.TrimPrefix(strings.TrimRight(lines[2], "\r"), "trusted comment: ")
+	if !ed25519.Verify(k.key, append(bytes.Clone(signature), comment...), global) {
+		return errors.New("trusted comment signature does not match")
+	}
+	return nil
+}
+
+// reverse returns b reversed, as minisign prints key IDs.
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i, c := range b {
+		out[len(b)-1-i] = c
+	}
+	return out
+}
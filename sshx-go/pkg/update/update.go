@@ -0,0 +1,269 @@
+// Package update finds newer sshx releases on GitHub and installs them.
+//
+// Release binaries are verified against the SHA-256 sums listed in the
+// release's checksums.txt before replacing the running executable, and the
+// sums against their minisign signature by the release key in PublicKey.
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultRepo is the GitHub repository sshx releases are published to.
+	DefaultRepo = "ovidiuvio/SSHXtend"
+
+	// checksumsAsset lists the SHA-256 sum of every other asset of a release,
+	// and signatureAsset is its minisign signature.
+	checksumsAsset = "checksums.txt"
+	signatureAsset = checksumsAsset + ".minisig"
+
+	apiBase = "https://api.github.com"
+)
+
+// PublicKey is the minisign public key releases are signed with, pinned at
+// build time by the release workflow:
+//
+//	go build -ldflags "-X sshx-go/pkg/update.PublicKey=RW..."
+//
+// Builds without it cannot verify who published a release, so they refuse
+// to install one.
+var PublicKey string
+
+// Release is a published GitHub release.
+type Release struct {
+	Tag    string  `json:"tag_name"`
+	URL    string  `json:"html_url"`
+	Assets []Asset `json:"assets"`
+}
+
+// Asset is a file attached to a release.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// asset returns the asset of the release with the given name.
+func (r *Release) asset(name string) (Asset, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Latest returns the latest published release of repo, e.g. DefaultRepo.
+func Latest(ctx context.Context, client *http.Client, repo string) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiBase+"/repos/"+repo+"/releases/latest", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query releases: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query releases: %s", resp.Status)
+	}
+
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("failed to decode release: %w", err)
+	}
+	return &release, nil
+}
+
+// AssetName returns the name of the release binary for this platform.
+func AssetName() string {
+	name := "sshxtend-go-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// IsRelease reports whether version is a release version such as "v1.2.3",
+// rather than a development build.
+func IsRelease(version string) bool {
+	_, ok := parseVersion(version)
+	return ok
+}
+
+// Newer reports whether release version latest is newer than current.
+// Development builds are older than every release.
+func Newer(latest, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return true
+	}
+	for i := range l {
+		if l[i] != c[i] {
+			return l[i] > c[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses "v1.2.3" or "1.2" into its numbers. Pre-release and
+// build suffixes are not release versions.
+func parseVersion(version string) ([3]int, bool) {
+	var parts [3]int
+	fields := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, false
+		}
+		parts[i] = n
+	}
+	return parts, true
+}
+
+// Install downloads this platform's binary from release, verifies its
+// checksum and the signature of the checksums, and atomically replaces the
+// executable at path with it.
+func Install(ctx context.Context, client *http.Client, release *Release, path string) error {
+	if PublicKey == "" {
+		return fmt.Errorf("this build has no release signing key to verify %s with; download it from %s", release.Tag, release.URL)
+	}
+	key, err := parseMinisignKey(PublicKey)
+	if err != nil {
+		return err
+	}
+	name := AssetName()
+	asset, ok := release.asset(name)
+	if !ok {
+		return fmt.Errorf("release %s has no binary for %s/%s", release.Tag, runtime.GOOS, runtime.GOARCH)
+	}
+	sums, ok := release.asset(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s to verify the download", release.Tag, checksumsAsset)
+	}
+	sig, ok := release.asset(signatureAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s to verify the checksums", release.Tag, signatureAsset)
+	}
+
+	want, err := expectedSum(ctx, client, key, sums, sig, name)
+	if err != nil {
+		return err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat executable: %w", err)
+	}
+
+	// Download next to the executable so the final rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sshx-upgrade-*")
+	if err != nil {
+		return fmt.Errorf("failed to create download file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	err = download(ctx, client, asset.URL, io.MultiWriter(tmp, hash))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: expected %s, got %s", name, want, got)
+	}
+
+	if err := os.Chmod(tmp.Name(), info.Mode().Perm()|0111); err != nil {
+		return fmt.Errorf("failed to make download executable: %w", err)
+	}
+	return replace(tmp.Name(), path)
+}
+
+// expectedSum returns the SHA-256 sum of the named asset from a checksums
+// file, once its signature by key is verified.
+func expectedSum(ctx context.Context, client *http.Client, key *minisignKey, sums, sig Asset, name string) (string, error) {
+	var buf, sigBuf bytes.Buffer
+	if err := download(ctx, client, sums.URL, &buf); err != nil {
+		return "", err
+	}
+	if err := download(ctx, client, sig.URL, &sigBuf); err != nil {
+		return "", err
+	}
+	if err := key.verify(buf.Bytes(), sigBuf.Bytes()); err != nil {
+		return "", fmt.Errorf("%s is not signed by the release key: %w", checksumsAsset, err)
+	}
+
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		// Lines are "<sum>  <name>", with "*" before binary-mode names
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s does not list %s", checksumsAsset, name)
+}
+
+// download writes the body at url to w.
+func download(ctx context.Context, client *http.Client, url string, w io.Writer) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	return nil
+}
+
+// replace renames src over dst. Windows cannot replace a running executable,
+// but it can rename one, so the old binary is moved aside first.
+func replace(src, dst string) error {
+	if runtime.GOOS == "windows" {
+		old := dst + ".old"
+		os.Remove(old)
+		if err := os.Rename(dst, old); err != nil {
+			return fmt.Errorf("failed to move old executable aside: %w", err)
+		}
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if runtime.GOOS == "windows" {
+			os.Rename(dst+".old", dst)
+		}
+		return fmt.Errorf("failed to replace executable: %w", err)
+	}
+	return nil
+}
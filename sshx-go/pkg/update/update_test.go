@@ -0,0 +1,142 @@
+package update
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testKey is a minisign key pair made for a test.
+type testKey struct {
+	id      [8]byte
+	private ed25519.PrivateKey
+	public  string // as minisign prints it
+}
+
+func newTestKey(t *testing.T) *testKey {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := &testKey{private: private}
+	rand.Read(k.id[:])
+	k.public = "untrusted comment: minisign public key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), k.id[:]...), public...))
+	return k
+}
+
+// sign returns the .minisig file minisign -S makes for message, prehashed
+// unless legacy is set.
+func (k *testKey) sign(message []byte, comment string, legacy bool) []byte {
+	alg := "ED"
+	if legacy {
+		alg = "Ed"
+	} else {
+		sum := blake2b.Sum512(message)
+		message = sum[:]
+	}
+	sig := ed25519.Sign(k.private, message)
+	global := ed25519.Sign(k.private, append(append([]byte(nil), sig...), comment...))
+	return []byte(fmt.Sprintf("untrusted comment: signature from minisign secret key\n%s\ntrusted comment: %s\n%s\n",
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), k.id[:]...), sig...)),
+		comment, base64.StdEncoding.EncodeToString(global)))
+}
+
+func TestMinisign(t *testing.T) {
+	k := newTestKey(t)
+	key, err := parseMinisignKey(k.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("0123  sshxtend-go-linux-amd64\n")
+
+	for _, legacy := range []bool{false, true} {
+		sig := k.sign(message, "timestamp:1 file:checksums.txt", legacy)
+		if err := key.verify(message, sig); err != nil {
+			t.Errorf("legacy %v: valid signature rejected: %v", legacy, err)
+		}
+		if err := key.verify([]byte("4567  sshxtend-go-linux-amd64\n"), sig); err == nil {
+			t.Errorf("legacy %v: signature of other checksums accepted", legacy)
+		}
+		forged := strings.Replace(string(sig), "file:checksums.txt", "file:other.txt", 1)
+		if err := key.verify(message, []byte(forged)); err == nil {
+			t.Errorf("legacy %v: changed trusted comment accepted", legacy)
+		}
+	}
+
+	other, err := parseMinisignKey(newTestKey(t).public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.verify(message, k.sign(message, "", false)); err == nil {
+		t.Error("signature by another key accepted")
+	}
+	for _, bad := range []string{"", "RWQ", "untrusted comment: x\n" + base64.StdEncoding.EncodeToString(make([]byte, 42))} {
+		if _, err := parseMinisignKey(bad); err == nil {
+			t.Errorf("parseMinisignKey(%q) succeeded", bad)
+		}
+	}
+}
+
+// TestInstall installs a release served locally, and checks tampered
+// checksums or a build without the release key are refused.
+func TestInstall(t *testing.T) {
+	k := newTestKey(t)
+	binary := []byte("new sshx")
+	sum := sha256.Sum256(binary)
+	checksums := []byte(hex.EncodeToString(sum[:]) + "  " + AssetName() + "\n")
+	files := map[string][]byte{
+		AssetName():    binary,
+		checksumsAsset: checksums,
+		signatureAsset: k.sign(checksums, "release", false),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(files[strings.TrimPrefix(r.URL.Path, "/")])
+	}))
+	defer server.Close()
+	release := &Release{Tag: "v1.0.0"}
+	for name := range files {
+		release.Assets = append(release.Assets, Asset{Name: name, URL: server.URL + "/" + name})
+	}
+
+	install := func(publicKey string) (string, error) {
+		old := PublicKey
+		PublicKey = publicKey
+		defer func() { PublicKey = old }()
+		path := filepath.Join(t.TempDir(), "sshx")
+		if err := os.WriteFile(path, []byte("old sshx"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := Install(context.Background(), server.Client(), release, path)
+		data, _ := os.ReadFile(path)
+		return string(data), err
+	}
+
+	if got, err := install(k.public); err != nil || got != "new sshx" {
+		t.Fatalf("Install() = %v, executable %q", err, got)
+	}
+	if got, err := install(""); err == nil || got != "old sshx" {
+		t.Errorf("build without a release key installed the release: %v", err)
+	}
+	if got, err := install(newTestKey(t).public); err == nil || got != "old sshx" {
+		t.Errorf("release signed by another key was installed: %v", err)
+	}
+
+	files[checksumsAsset] = []byte(strings.Repeat("0", 64) + "  " + AssetName() + "\n")
+	if got, err := install(k.public); err == nil || got != "old sshx" {
+		t.Errorf("tampered checksums were accepted: %v", err)
+	}
+}
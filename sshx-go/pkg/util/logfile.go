@@ -0,0 +1,186 @@
+package util
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedStamp names rotated log files after the time they were rotated, in
+// an order that sorts chronologically.
+const rotatedStamp = "20060102-150405.000"
+
+// RotateOptions configures the rotation of LogOptions.File. Rotated files are
+// renamed with the time of rotation appended, e.g. sshx.log.20240102-150405.000.
+type RotateOptions struct {
+	// MaxSize rotates the file before it grows past this many bytes. Zero
+	// never rotates on size.
+	MaxSize int64
+	// MaxAge rotates the file once it has been written to for this long.
+	// Zero never rotates on age.
+	MaxAge time.Duration
+	// MaxFiles is how many rotated files are kept; older ones are removed.
+	// Zero keeps them all.
+	MaxFiles int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// rotatingFile appends to a log file, rotating it as configured.
+type rotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// Held while rotated files are compressed and pruned, in the background
+	cleanup sync.Mutex
+}
+
+// openLogFile opens path for appending, rotating it as opts configures.
+func openLogFile(path string, opts RotateOptions) (io.Writer, error) {
+	if opts.MaxSize == 0 && opts.MaxAge == 0 {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		return file, nil
+	}
+
+	f := &rotatingFile{path: path, opts: opts}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file, f.size, f.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	full := f.opts.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.opts.MaxSize
+	old := f.opts.MaxAge > 0 && time.Since(f.opened) >= f.opts.MaxAge
+	if full || old {
+		// The logger cannot report its own failures, so they go to stderr
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "sshx: %v\n", err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the current file aside and starts a new one. Compressing and
+// pruning rotated files happen in the background.
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+
+	rotated := f.path + "." + time.Now().Format(rotatedStamp)
+	for i := 1; fileExists(rotated) || fileExists(rotated+".gz"); i++ {
+		rotated = fmt.Sprintf("%s.%s-%d", f.path, time.Now().Format(rotatedStamp), i)
+	}
+	renameErr := os.Rename(f.path, rotated)
+
+	// Keep logging even if the file could not be moved
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate log file: %w", renameErr)
+	}
+
+	go f.finish(rotated)
+	return nil
+}
+
+// finish compresses a rotated file if configured and removes the rotated
+// files beyond MaxFiles, oldest first.
+func (f *rotatingFile) finish(rotated string) {
+	f.cleanup.Lock()
+	defer f.cleanup.Unlock()
+
+	if f.opts.Compress {
+		if err := compressFile(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "sshx: failed to compress rotated log file: %v\n", err)
+		}
+	}
+	if f.opts.MaxFiles == 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return
+	}
+	var files []string
+	for _, match := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(match, f.path+"."), ".gz")
+		if _, err := time.Parse(rotatedStamp, stamp[:min(len(stamp), len(rotatedStamp))]); err == nil {
+			files = append(files, match)
+		}
+	}
+	sort.Strings(files)
+	for len(files) > f.opts.MaxFiles {
+		if err := os.Remove(files[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "sshx: failed to remove old log file: %v\n", err)
+		}
+		files = files[1:]
+	}
+}
+
+// compressFile replaces path with a gzipped copy named path.gz.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if closeErr := zw.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -1,18 +1,47 @@
 package util
 
 import (
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 )
 
 var (
 	// DebugEnabled controls whether debug logs are printed
 	DebugEnabled bool
+
+	// logger is the process-wide structured logger
+	logger = slog.Default()
+
+	// level is the minimum level printed by JSON output
+	level = new(slog.LevelVar)
+
+	// jsonOutput is set once the JSON handler replaces the default one
+	jsonOutput bool
 )
 
+// LogOptions configures the process-wide logger.
+type LogOptions struct {
+	// Verbose enables debug-level output.
+	Verbose bool
+	// Format is "text" (the default) or "json".
+	Format string
+	// File appends logs to this path instead of stderr.
+	File string
+	// Rotate rotates File, which otherwise grows for as long as logs are
+	// written.
+	Rotate RotateOptions
+	// Unsafe shows tokens, keys and terminal data in log messages, which
+	// are otherwise redacted.
+	Unsafe bool
+}
+
 // SetDebugMode enables or disables debug logging
 func SetDebugMode(enabled bool) {
 	DebugEnabled = enabled
+	applyLevel()
 }
 
 // InitLogger initializes the logger based on environment and flags
@@ -21,16 +50,86 @@ func InitLogger(verbose bool) {
 	if os.Getenv("SSHX_DEBUG") != "" {
 		DebugEnabled = true
 	}
-	
+
 	// Command line flag takes precedence
 	if verbose {
 		DebugEnabled = true
 	}
+
+	applyLevel()
 }
 
-// DebugLog prints a debug message only if debug mode is enabled
-func DebugLog(format string, args ...interface{}) {
+// ConfigureLogger initializes the logger with an output format and destination.
+// JSON output also captures messages written through the standard log package.
+func ConfigureLogger(opts LogOptions) error {
+	InitLogger(opts.Verbose)
+	LogUnsafe = opts.Unsafe
+
+	var out io.Writer = os.Stderr
+	if opts.File != "" {
+		file, err := openLogFile(opts.File, opts.Rotate)
+		if err != nil {
+			return err
+		}
+		out = file
+	}
+
+	switch opts.Format {
+	case "", "text":
+		log.SetOutput(out)
+		logger = slog.Default()
+	case "json":
+		handler := slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level})
+		logger = slog.New(handler)
+		slog.SetDefault(logger)
+		jsonOutput = true
+		// Messages from the standard log package are recorded at info level
+		slog.SetLogLoggerLevel(slog.LevelInfo)
+	default:
+		return fmt.Errorf("invalid log format %q (expected text or json)", opts.Format)
+	}
+
+	return nil
+}
+
+// Logger returns the process-wide structured logger.
+func Logger() *slog.Logger {
+	return logger
+}
+
+// applyLevel updates the minimum printed level from DebugEnabled.
+func applyLevel() {
 	if DebugEnabled {
-		log.Printf("[DEBUG] "+format, args...)
+		level.Set(slog.LevelDebug)
+	} else {
+		level.Set(slog.LevelInfo)
+	}
+	if !jsonOutput {
+		// Before SetDefault, this is the minimum level of the text output
+		slog.SetLogLoggerLevel(level.Level())
+	}
+}
+
+// DebugLog prints a debug message only if debug mode is enabled. Like the
+// messages of every level, secrets in it are redacted unless LogUnsafe is set.
+func DebugLog(format string, args ...interface{}) {
+	if !DebugEnabled {
+		return
 	}
-}
\ No newline at end of file
+	logger.Debug(logf(format, args))
+}
+
+// Infof logs an informational message.
+func Infof(format string, args ...interface{}) {
+	logger.Info(logf(format, args))
+}
+
+// Warnf logs a recoverable problem.
+func Warnf(format string, args ...interface{}) {
+	logger.Warn(logf(format, args))
+}
+
+// Errorf logs an error.
+func Errorf(format string, args ...interface{}) {
+	logger.Error(logf(format, args))
+}
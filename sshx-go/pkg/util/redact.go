@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+)
+
+// LogUnsafe turns off the redaction of log messages, so they show tokens,
+// keys and terminal data as they are.
+var LogUnsafe bool
+
+// urlFragment matches the fragment of links, which holds the session key and
+// the write password.
+var urlFragment = regexp.MustCompile(`(\w+://[^\s#]+)#[^\s"]+`)
+
+// Secret is a string, such as a token or a password, that log messages show
+// as "[redacted]" unless LogUnsafe is set.
+type Secret string
+
+// Format prints s redacted, whatever the verb.
+func (s Secret) Format(f fmt.State, verb rune) {
+	if LogUnsafe {
+		fmt.Fprintf(f, fmt.FormatString(f, verb), string(s))
+		return
+	}
+	fmt.Fprint(f, "[redacted]")
+}
+
+// redacted stands in for a masked argument, whatever verb formats it.
+type redacted string
+
+func (r redacted) Format(f fmt.State, verb rune) {
+	fmt.Fprintf(f, "[%s]", string(r))
+}
+
+// logf formats a log message, masking its secrets unless LogUnsafe is set.
+func logf(format string, args []interface{}) string {
+	if LogUnsafe {
+		return fmt.Sprintf(format, args...)
+	}
+	return redact(format, args)
+}
+
+// redact formats a log message with its secrets masked: byte slices, which
+// hold terminal data and keys, structures such as protocol messages, which
+// may hold any of these, and link fragments. Arguments are masked by their
+// type, whichever verbs format them.
+func redact(format string, args []interface{}) string {
+	masked := make([]interface{}, len(args))
+	for i, arg := range args {
+		masked[i] = redactArg(arg)
+	}
+	return urlFragment.ReplaceAllString(fmt.Sprintf(format, masked...), "$1#[redacted]")
+}
+
+// redactArg masks v if it may hold a secret.
+func redactArg(v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return redacted(fmt.Sprintf("%d bytes redacted", len(v)))
+	case Secret, error, fmt.Stringer:
+		return v
+	}
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct, reflect.Map:
+		return redacted(fmt.Sprintf("%T redacted", v))
+	case reflect.Slice, reflect.Array:
+		// Lists of names or numbers are shown; lists of messages are not
+		switch value.Type().Elem().Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+			return redacted(fmt.Sprintf("%T redacted", v))
+		}
+	}
+	return v
+}
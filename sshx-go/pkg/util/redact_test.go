@@ -0,0 +1,47 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type message struct {
+	Data []byte
+}
+
+func TestRedact(t *testing.T) {
+	data := []byte("secret input")
+	for _, tt := range []struct {
+		format string
+		args   []interface{}
+		want   string
+	}{
+		{"data=%q", []interface{}{data}, "data=[12 bytes redacted]"},
+		{"raw=%v", []interface{}{data}, "raw=[12 bytes redacted]"},
+		{"%[2]d: %[1]v", []interface{}{data, 1}, "1: [12 bytes redacted]"},
+		{"%*d %s", []interface{}{4, 7, data}, "   7 [12 bytes redacted]"},
+		{"%-*x|", []interface{}{3, data}, "[12 bytes redacted]|"},
+		{"%+v", []interface{}{&message{Data: data}}, "[*util.message redacted]"},
+		{"%v", []interface{}{map[string]string{"token": "t"}}, "[map[string]string redacted]"},
+		{"%v", []interface{}{[]*message{{}}}, "[[]*util.message redacted]"},
+		{"Token=%s", []interface{}{Secret("abc")}, "Token=[redacted]"},
+		{"%[1]s %[1]q", []interface{}{Secret("abc")}, "[redacted] [redacted]"},
+		{"%s, %d, %v", []interface{}{"name", 3, []string{"a", "b"}}, "name, 3, [a b]"},
+		{"%v after %v", []interface{}{errors.New("failed"), time.Second}, "failed after 1s"},
+		{"link https://sshx.io/s/abc#key,password", nil, "link https://sshx.io/s/abc#[redacted]"},
+		{"link %s", []interface{}{"https://sshx.io/s/abc#key"}, "link https://sshx.io/s/abc#[redacted]"},
+	} {
+		if got := redact(tt.format, tt.args); got != tt.want {
+			t.Errorf("redact(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestLogUnsafe(t *testing.T) {
+	LogUnsafe = true
+	defer func() { LogUnsafe = false }()
+	if got, want := logf("%q %s %s", []interface{}{Secret("abc"), []byte("data"), "https://sshx.io/s/abc#key"}), `"abc" data https://sshx.io/s/abc#key`; got != want {
+		t.Errorf("logf = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package webhook
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// Message renders the text chat targets post for a payload. Unless
+// IncludeWriteURL is set, the payload's writable link is removed first, so
+// neither the template nor the default text can show it to the channel.
+type Message struct {
+	// Template is executed with the Payload. Nil uses the default text of
+	// each event, and a template rendering only space skips the event,
+	// e.g. {{if eq .Event "session_started"}}...{{end}} posts starts only.
+	Template        *template.Template
+	IncludeWriteURL bool
+}
+
+// ParseTemplate parses a message template, whose fields are those of Payload:
+// {{.Event}}, {{.Session}}, {{.URL}}, {{.WriteURL}}, {{.Shell}} and {{.Error}}.
+// The writable link may be missing, so it is best shown with
+// {{with .WriteURL}}...{{end}}.
+func ParseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid message template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// render returns the text to post for p, empty if the event is skipped.
+func (m Message) render(p Payload) (string, error) {
+	if !m.IncludeWriteURL {
+		p.WriteURL = nil
+	}
+	if m.Template == nil {
+		return describe(p), nil
+	}
+	var text strings.Builder
+	if err := m.Template.Execute(&text, p); err != nil {
+		return "", fmt.Errorf("failed to render message: %w", err)
+	}
+	return strings.TrimSpace(text.String()), nil
+}
+
+// Slack posts messages to a Slack incoming webhook URL.
+type Slack struct {
+	URL string
+	Message
+}
+
+func (t Slack) Name() string {
+	return "Slack"
+}
+
+func (t Slack) Post(ctx context.Context, client *http.Client, p Payload) error {
+	text, err := t.render(p)
+	if err != nil || text == "" {
+		return err
+	}
+	return sendJSON(ctx, client, http.MethodPost, t.URL, nil, map[string]string{"text": text})
+}
+
+// Matrix sends messages to a Matrix room as notices, through the client API
+// of Homeserver, e.g. "https://matrix.example.org", as the user AccessToken
+// belongs to. Room is the ID of a room the user joined, like
+// "!abc:example.org".
+type Matrix struct {
+	Homeserver  string
+	Room        string
+	AccessToken string
+	Message
+}
+
+// matrixTxn numbers the messages sent to Matrix, so the server can tell
+// retries from new messages.
+var matrixTxn atomic.Uint64
+
+func (t Matrix) Name() string {
+	return "Matrix"
+}
+
+func (t Matrix) Post(ctx context.Context, client *http.Client, p Payload) error {
+	text, err := t.render(p)
+	if err != nil || text == "" {
+		return err
+	}
+	txn := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatUint(matrixTxn.Add(1), 10)
+	endpoint := strings.TrimSuffix(t.Homeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(t.Room) + "/send/m.room.message/" + txn
+	header := http.Header{"Authorization": {"Bearer " + t.AccessToken}}
+	return sendJSON(ctx, client, http.MethodPut, endpoint, header, map[string]string{"msgtype": "m.notice", "body": text})
+}
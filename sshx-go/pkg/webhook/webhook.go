@@ -0,0 +1,173 @@
+// Package webhook posts session lifecycle events to HTTP endpoints: generic
+// JSON webhooks, Slack incoming webhooks and Matrix rooms.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"sshx-go/pkg/util"
+)
+
+const (
+	// requestTimeout bounds each POST, so a slow endpoint only delays the
+	// events queued after it.
+	requestTimeout = 10 * time.Second
+
+	// queueSize is how many events wait to be posted before new ones are
+	// dropped.
+	queueSize = 64
+)
+
+// Event names of Payload.Event.
+const (
+	SessionStarted = "session_started"
+	ShellCreated   = "shell_created"
+	Disconnected   = "disconnected"
+	Reconnected    = "reconnected"
+	SessionClosed  = "session_closed"
+)
+
+// Payload is the JSON body posted for an event. Text summarizes it for
+// chat services, which show that field of incoming webhooks.
+type Payload struct {
+	Event    string    `json:"event"`
+	Session  string    `json:"session"`
+	Time     time.Time `json:"time"`
+	URL      string    `json:"url,omitempty"`
+	WriteURL *string   `json:"writeUrl,omitempty"`
+	Shell    uint32    `json:"shell,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Text     string    `json:"text"`
+}
+
+// Target delivers payloads to one endpoint.
+type Target interface {
+	// Name describes the endpoint in logs.
+	Name() string
+	// Post delivers p using client, giving up once ctx is done.
+	Post(ctx context.Context, client *http.Client, p Payload) error
+}
+
+// Notifier posts payloads to a target in the order they were sent, from a
+// goroutine of its own so sessions are never held up by the endpoint.
+type Notifier struct {
+	target Target
+	client *http.Client
+	queue  chan Payload
+	done   chan struct{}
+}
+
+// New returns a notifier posting to target with client.
+func New(target Target, client *http.Client) *Notifier {
+	n := &Notifier{
+		target: target,
+		client: client,
+		queue:  make(chan Payload, queueSize),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Send queues p to be posted, setting its time and filling in its text if
+// empty. It drops p if too many payloads are waiting.
+func (n *Notifier) Send(p Payload) {
+	p.Time = time.Now().UTC()
+	if p.Text == "" {
+		p.Text = describe(p)
+	}
+	select {
+	case n.queue <- p:
+	default:
+		util.Warnf("Dropped %s event of %s for %s: too many waiting", p.Event, p.Session, n.target.Name())
+	}
+}
+
+// Close posts the payloads still queued, giving up after timeout, and stops
+// the notifier. Send must not be called after Close.
+func (n *Notifier) Close(timeout time.Duration) {
+	close(n.queue)
+	select {
+	case <-n.done:
+	case <-time.After(timeout):
+		util.Warnf("Gave up posting %d events to %s", len(n.queue), n.target.Name())
+	}
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for p := range n.queue {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		if err := n.target.Post(ctx, n.client, p); err != nil {
+			util.Warnf("Failed to post %s event of %s to %s: %v", p.Event, p.Session, n.target.Name(), err)
+		}
+		cancel()
+	}
+}
+
+// JSON posts each payload as is to URL.
+type JSON struct {
+	URL string
+}
+
+func (t JSON) Name() string {
+	return "webhook"
+}
+
+func (t JSON) Post(ctx context.Context, client *http.Client, p Payload) error {
+	return sendJSON(ctx, client, http.MethodPost, t.URL, nil, p)
+}
+
+// sendJSON sends v encoded as JSON to url with the given method and headers.
+func sendJSON(ctx context.Context, client *http.Client, method, url string, header http.Header, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// describe returns the text shown for p by chat services.
+func describe(p Payload) string {
+	switch p.Event {
+	case SessionStarted:
+		text := fmt.Sprintf("sshx session %s started: %s", p.Session, p.URL)
+		if p.WriteURL != nil {
+			text += fmt.Sprintf(" (writable: %s)", *p.WriteURL)
+		}
+		return text
+	case ShellCreated:
+		return fmt.Sprintf("sshx session %s: shell %d opened", p.Session, p.Shell)
+	case Disconnected:
+		return fmt.Sprintf("sshx session %s lost its connection: %s", p.Session, p.Error)
+	case Reconnected:
+		return fmt.Sprintf("sshx session %s reconnected", p.Session)
+	case SessionClosed:
+		return fmt.Sprintf("sshx session %s closed", p.Session)
+	default:
+		return fmt.Sprintf("sshx session %s: %s", p.Session, p.Event)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+
+	"sshx-go/pkg/config"
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/util"
+)
+
+// savedSessions records the sessions of a run with --resume or
+// --kill-existing while they are open, so the next run reattaches to the ones
+// left open or closes them. A nil *savedSessions records nothing.
+type savedSessions struct {
+	store  *config.SessionStore
+	resume bool // reattach instead of closing
+	ids    map[*sshx.Session]string
+}
+
+// newSavedSessions returns the saved sessions if opts.Resume or
+// opts.KillExisting is set, and nil otherwise.
+func newSavedSessions(opts options) (*savedSessions, error) {
+	if !opts.Resume && !opts.KillExisting {
+		return nil, nil
+	}
+	path := config.DefaultSessionsPath()
+	if path == "" {
+		return nil, fmt.Errorf("--resume and --kill-existing need a config directory to save sessions in")
+	}
+	return &savedSessions{
+		store:  config.NewSessionStore(path),
+		resume: opts.Resume,
+		ids:    map[*sshx.Session]string{},
+	}, nil
+}
+
+// savedID identifies a session across runs by its server and name.
+func savedID(opts sshx.Options) string {
+	server := opts.Server
+	if server == "" {
+		server = sshx.DefaultServer
+	}
+	name := opts.Name
+	if name == "" {
+		name = sshx.DefaultSessionName()
+	}
+	return server + " " + name
+}
+
+// prepare handles the session an earlier run left open for opts, if any:
+// with --resume it sets opts.Resume to reattach to it, and with
+// --kill-existing it closes it.
+func (r *savedSessions) prepare(opts *sshx.Options) {
+	if r == nil {
+		return
+	}
+	id := savedID(*opts)
+	var state sshx.ResumeState
+	found, err := r.store.Load(id, &state)
+	if err != nil {
+		util.Warnf("Ignoring saved sessions: %v", err)
+		return
+	}
+	if !found {
+		return
+	}
+	if r.resume {
+		opts.Resume = &state
+		return
+	}
+
+	if err := sshx.CloseSession(opts.Server, state, opts.Connection); err != nil {
+		// The server may have expired it already
+		util.Warnf("Could not close session %s left open by an earlier run: %v", state.Name, err)
+	} else {
+		util.Infof("Closed session %s left open by an earlier run", state.Name)
+	}
+	if err := r.store.Forget(id); err != nil {
+		util.Warnf("Failed to forget session %s: %v", state.Name, err)
+	}
+}
+
+// track saves the keys of session, opened with opts.
+func (r *savedSessions) track(session *sshx.Session, opts sshx.Options) {
+	if r == nil {
+		return
+	}
+	r.ids[session] = savedID(opts)
+	if err := r.store.Save(r.ids[session], session.ResumeState()); err != nil {
+		util.Warnf("Failed to save session %s: %v", session.Info().Name, err)
+	}
+}
+
+// forget removes session once it is closed, so no later run reattaches to it
+// or closes it again.
+func (r *savedSessions) forget(session *sshx.Session) {
+	if r == nil {
+		return
+	}
+	if err := r.store.Forget(r.ids[session]); err != nil {
+		util.Warnf("Failed to forget session %s: %v", session.Info().Name, err)
+	}
+}
@@ -0,0 +1,554 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"sshx-go/pkg/config"
+	"sshx-go/pkg/dashboard"
+	"sshx-go/pkg/qr"
+	"sshx-go/pkg/sshjump"
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/terminal"
+	"sshx-go/pkg/transport"
+	"sshx-go/pkg/util"
+	"sshx-go/pkg/webhook"
+)
+
+// sessionOptions builds the options for every session this process should open.
+//
+// Sessions listed in the config file take precedence and inherit unset fields
+// from the command line; otherwise --sessions copies of the command-line
+// session are opened.
+func sessionOptions(opts options, file *config.File, connConfig transport.ConnectionConfig) ([]sshx.Options, error) {
+	base := sshx.Options{
+		Server:        opts.Server,
+		Name:          opts.Name,
+		Login:         opts.Login,
+		EnableReaders: opts.EnableReaders,
+		Password:      opts.Password,
+		Dashboard:     opts.Dashboard,
+		DashboardKey:  opts.DashboardKey,
+		ShellExit:     opts.ExitOnShellClose,
+		IdleTimeout:   opts.IdleTimeout,
+		BatchDelay:    opts.BatchDelay,
+		AllowedShells: opts.AllowedShells,
+		Env:           opts.Env,
+		HideWriteURL:  opts.ReadersOnly,
+		Dir:           opts.Cwd,
+		OnStart:       opts.OnStart,
+		MaxShells:     opts.MaxShells,
+		KillGrace:     opts.KillGrace,
+		RestartShells: opts.RestartShells,
+		Connection:    connConfig,
+	}
+	tags, err := dashboard.ParseTags(opts.Tags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --tag: %w", err)
+	}
+	if len(tags) > 0 {
+		// Sessions of the config file may register with a dashboard of their own
+		if !opts.Dashboard && len(file.Sessions) == 0 {
+			return nil, fmt.Errorf("--tag requires --dashboard")
+		}
+		base.Tags = tags
+	}
+	switch {
+	case opts.HeartbeatInterval < 0:
+		return nil, fmt.Errorf("invalid --dashboard-heartbeat %s (must not be negative)", opts.HeartbeatInterval)
+	case opts.HeartbeatInterval == 0:
+		base.DashboardHeartbeat = -1
+	default:
+		base.DashboardHeartbeat = opts.HeartbeatInterval
+	}
+	if opts.RunAsUser != "" {
+		runAs, err := terminal.LookupRunAs(opts.RunAsUser)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --run-as-user: %w", err)
+		}
+		base.RunAs = runAs
+	}
+	if opts.Shell != "" {
+		shell, err := terminal.SplitCommand(opts.Shell)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --shell command: %w", err)
+		}
+		base.Shell, base.ShellArgs = shell[0], shell[1:]
+	}
+	if opts.Exec != "" {
+		command, err := terminal.SplitCommand(opts.Exec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --exec command: %w", err)
+		}
+		base.Command = command
+	}
+	if opts.AttachTmux != "" {
+		if opts.Shell != "" || opts.Exec != "" {
+			return nil, fmt.Errorf("--attach-tmux cannot be combined with --shell or --exec")
+		}
+		base.AttachTmux = opts.AttachTmux
+	}
+	if opts.Echo {
+		if opts.Shell != "" || opts.Exec != "" || opts.Docker != "" || opts.SSH != "" || opts.AttachTmux != "" || opts.Attach || opts.Stream != nil {
+			return nil, fmt.Errorf("--echo runs no shells; remove --shell, --exec, --docker, --ssh, --attach-tmux, --attach and sshx stream")
+		}
+		// The runner keeps no state, so every session can share it
+		base.Runner = &sshx.EchoRunner{}
+		base.Shell = "echo"
+	}
+	if opts.Docker != "" {
+		if opts.AttachTmux != "" {
+			return nil, fmt.Errorf("--docker cannot be combined with --attach-tmux")
+		}
+		if opts.RunAsUser != "" {
+			return nil, fmt.Errorf("--docker cannot be combined with --run-as-user")
+		}
+		base.Docker = opts.Docker
+	}
+	if opts.SSH != "" {
+		if opts.Docker != "" || opts.AttachTmux != "" {
+			return nil, fmt.Errorf("--ssh cannot be combined with --docker or --attach-tmux")
+		}
+		if opts.RunAsUser != "" || opts.Cwd != "" {
+			return nil, fmt.Errorf("--ssh cannot be combined with --run-as-user or --cwd")
+		}
+		base.SSH = &sshjump.Config{Target: opts.SSH, KeyFile: opts.SSHKey}
+	} else if opts.SSHKey != "" {
+		return nil, fmt.Errorf("--ssh-key requires --ssh")
+	}
+	// With --docker, the directory is inside the container
+	if opts.Cwd != "" && opts.Docker == "" {
+		if info, err := os.Stat(opts.Cwd); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("invalid --cwd: %s is not a directory", opts.Cwd)
+		}
+	}
+	sanitize, err := sshx.ParseSanitizePolicy(opts.SanitizeOutput)
+	if err != nil {
+		return nil, err
+	}
+	base.Sanitize = sanitize
+	if base.InvalidUTF8, err = sshx.ParseInvalidUTF8Policy(opts.InvalidUTF8); err != nil {
+		return nil, err
+	}
+	if opts.ShellMemoryMax != "" || opts.ShellCPUQuota != "" {
+		if opts.AttachTmux != "" || opts.Docker != "" || opts.SSH != "" {
+			return nil, fmt.Errorf("--shell-memory-max and --shell-cpu-quota apply to local shells; remove --attach-tmux, --docker and --ssh")
+		}
+		var memoryMax int64
+		if opts.ShellMemoryMax != "" {
+			if memoryMax, err = terminal.ParseMemorySize(opts.ShellMemoryMax); err != nil {
+				return nil, fmt.Errorf("invalid --shell-memory-max: %w", err)
+			}
+		}
+		var cpuQuota int
+		if opts.ShellCPUQuota != "" {
+			if cpuQuota, err = terminal.ParseCPUQuota(opts.ShellCPUQuota); err != nil {
+				return nil, fmt.Errorf("invalid --shell-cpu-quota: %w", err)
+			}
+		}
+		if base.Limits, err = terminal.NewLimits(memoryMax, cpuQuota); err != nil {
+			return nil, err
+		}
+	}
+	if opts.RestartShells {
+		if opts.AttachTmux != "" || opts.Docker != "" || opts.SSH != "" {
+			return nil, fmt.Errorf("--restart-shells applies to local shells; remove --attach-tmux, --docker and --ssh")
+		}
+		if opts.ExitOnShellClose != sshx.ShellExitNever || opts.Attach {
+			return nil, fmt.Errorf("--restart-shells cannot be combined with --exit-on-shell-close or --attach, as shells never exit")
+		}
+	}
+	if opts.Resume && opts.KillExisting {
+		return nil, fmt.Errorf("--resume cannot be combined with --kill-existing")
+	}
+	if opts.Resume && opts.Attach {
+		// The resumed session already used the ID of the host's shell
+		return nil, fmt.Errorf("--resume cannot be combined with --attach")
+	}
+	if opts.KillGrace <= 0 {
+		return nil, fmt.Errorf("invalid --kill-grace %s (must be positive)", opts.KillGrace)
+	}
+	if opts.ScrollbackBytes != "" {
+		rolling, err := terminal.ParseMemorySize(opts.ScrollbackBytes)
+		if err != nil || rolling > math.MaxInt32 {
+			return nil, fmt.Errorf("invalid --scrollback-bytes %q (expected a size up to 2G, e.g. 1M or 64M)", opts.ScrollbackBytes)
+		}
+		base.Content.Rolling = int(rolling)
+	}
+	if opts.ChunkBytes != "" {
+		chunk, err := terminal.ParseMemorySize(opts.ChunkBytes)
+		if err != nil || chunk < 1<<10 || chunk > 1<<20 {
+			return nil, fmt.Errorf("invalid --chunk-bytes %q (expected a size from 1K to 1M, e.g. 16K)", opts.ChunkBytes)
+		}
+		base.Content.Chunk = int(chunk)
+	}
+	if opts.Attach {
+		// The host's shell is the first, and leaving it ends the session
+		base.OpenShell = true
+		base.ShellExit = sshx.ShellExitFirst
+	}
+	if opts.MaxUploadKbps > 0 {
+		// Shared by every session, since they use the same uplink
+		base.OutputLimit = sshx.NewOutputLimiter(opts.MaxUploadKbps * 1000 / 8)
+	}
+
+	if len(file.Sessions) > 0 {
+		var result []sshx.Options
+		for i, entry := range file.Sessions {
+			session := base
+			if entry.Name != "" {
+				session.Name = entry.Name
+			}
+			if entry.Server != "" {
+				session.Server = entry.Server
+			}
+			if entry.Shell != "" {
+				shell, err := terminal.SplitCommand(entry.Shell)
+				if err != nil {
+					return nil, fmt.Errorf("invalid shell command for session %d: %w", i+1, err)
+				}
+				session.Shell, session.ShellArgs = shell[0], shell[1:]
+				session.Command = nil
+			}
+			if entry.Exec != "" {
+				command, err := terminal.SplitCommand(entry.Exec)
+				if err != nil {
+					return nil, fmt.Errorf("invalid exec command for session %d: %w", i+1, err)
+				}
+				session.Command = command
+			}
+			if entry.Cwd != "" {
+				session.Dir = entry.Cwd
+			}
+			if entry.OnStart != "" {
+				session.OnStart = entry.OnStart
+			}
+			if entry.EnableReaders != nil {
+				session.EnableReaders = *entry.EnableReaders
+			}
+			if entry.Dashboard != nil {
+				session.Dashboard = true
+				session.DashboardKey = *entry.Dashboard
+			}
+			if len(entry.Tags) > 0 {
+				session.Tags = make(map[string]string, len(base.Tags)+len(entry.Tags))
+				for key, value := range base.Tags {
+					session.Tags[key] = value
+				}
+				for key, value := range entry.Tags {
+					if err := dashboard.ValidateTag(key, value); err != nil {
+						return nil, fmt.Errorf("invalid tags for session %d: %w", i+1, err)
+					}
+					session.Tags[key] = value
+				}
+			}
+			result = append(result, session)
+		}
+		return result, nil
+	}
+
+	if opts.Sessions <= 1 {
+		return []sshx.Options{base}, nil
+	}
+
+	name := base.Name
+	if name == "" {
+		name = sshx.DefaultSessionName()
+	}
+	result := make([]sshx.Options, opts.Sessions)
+	for i := range result {
+		result[i] = base
+		result[i].Name = fmt.Sprintf("%s-%d", name, i+1)
+	}
+	return result, nil
+}
+
+// webhookFlushTimeout is how long shutting down waits for each notifier to
+// receive the events still queued.
+const webhookFlushTimeout = 10 * time.Second
+
+// runSessions opens every session, prints their links, and serves them until
+// interrupted, until any one of them fails, or until one ends on its own with
+// --exit-on-shell-close or --idle-timeout. Targets are told about the sessions'
+// lifecycle. onReady, if not nil, is called once every session is open.
+func runSessions(opts options, sessionOpts []sshx.Options, targets []webhook.Target, onReady func([]sshx.Info)) error {
+	// With --resume or --kill-existing, sessions are saved while open and
+	// forgotten once closed
+	saved, err := newSavedSessions(opts)
+	if err != nil {
+		return err
+	}
+
+	var sessions []*sshx.Session
+	var notifiers []*webhook.Notifier
+	closeAll := func() error {
+		var firstErr error
+		for _, session := range sessions {
+			if err := session.Close(); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			saved.forget(session)
+			for _, notifier := range notifiers {
+				notifier.Send(webhook.Payload{Event: webhook.SessionClosed, Session: session.Info().Name})
+			}
+		}
+		return firstErr
+	}
+
+	// Sessions without a dashboard key join the dashboard saved by an earlier
+	// run, or the one created by the first such session
+	var dashboardKey string
+	if opts.DashboardKeyFile != "" {
+		var err error
+		if dashboardKey, err = config.LoadDashboardKey(opts.DashboardKeyFile); err != nil {
+			util.Warnf("Ignoring saved dashboard key: %v", err)
+		}
+	}
+
+	// Open the sessions using transport abstraction with automatic fallback
+	for _, sessionOpt := range sessionOpts {
+		if sessionOpt.Dashboard && sessionOpt.DashboardKey == "" {
+			sessionOpt.DashboardKey = dashboardKey
+		}
+		saved.prepare(&sessionOpt)
+
+		session, err := sshx.Open(sessionOpt)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("failed to create controller with transport: %w", err)
+		}
+		sessions = append(sessions, session)
+		saved.track(session, sessionOpt)
+
+		info := session.Info()
+
+		// Report connection method if verbose
+		if opts.Verbose {
+			switch info.Transport {
+			case transport.MethodGrpc:
+				util.Infof("✓ Connected %s via gRPC", info.Name)
+			case transport.MethodWebSocketFallback:
+				util.Infof("✓ Connected %s via WebSocket fallback", info.Name)
+			}
+		}
+
+		if info.Dashboard != nil {
+			if opts.Output == "text" {
+				fmt.Println("\n  ✓ Session registered to dashboard")
+			}
+
+			if sessionOpt.DashboardKey == "" && dashboardKey == "" {
+				dashboardKey = info.Dashboard.Key
+				if opts.DashboardKeyFile != "" {
+					if err := config.SaveDashboardKey(opts.DashboardKeyFile, dashboardKey); err != nil {
+						util.Warnf("Failed to save dashboard key: %v", err)
+					}
+				}
+			}
+		}
+	}
+
+	infos := make([]sshx.Info, len(sessions))
+	for i, session := range sessions {
+		infos[i] = session.Info()
+	}
+
+	if opts.ReadersOnly {
+		if err := hideWriteURLs(opts, infos); err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	if opts.URLFile != "" {
+		if err := writeURLFile(opts.URLFile, infos); err != nil {
+			closeAll()
+			return err
+		}
+	}
+
+	// Print greeting or URLs
+	shown := stdoutInfos(opts, infos)
+	if opts.Output == "json" {
+		if err := encodeSessions(os.Stdout, shown); err != nil {
+			closeAll()
+			return err
+		}
+	} else if opts.Quiet {
+		for _, info := range shown {
+			if info.WriteURL != nil {
+				fmt.Println(*info.WriteURL)
+			} else {
+				fmt.Println(info.URL)
+			}
+		}
+	} else if len(shown) == 1 {
+		printGreeting(shown[0])
+	} else {
+		printSessionsGreeting(shown)
+	}
+	if opts.QR {
+		printQRCodes(infos)
+	}
+	if opts.ReadersOnly && opts.Stream == nil && !opts.Quiet && opts.Output == "text" {
+		if opts.WriteURLFile != "" {
+			fmt.Printf("  %s➜%s  Writable link saved to %s\n\n", Green, Reset, opts.WriteURLFile)
+		} else {
+			fmt.Printf("  %s➜%s  Writable link hidden (--readers-only)\n\n", Green, Reset)
+		}
+	} else if !opts.WriteURLStdout && !opts.Quiet && opts.Output == "text" {
+		fmt.Printf("  %s➜%s  Writable link not printed (--write-url-stdout=false)\n\n", Green, Reset)
+	}
+
+	if onReady != nil {
+		onReady(infos)
+	}
+
+	if len(targets) > 0 {
+		client := sessionOpts[0].Connection.HTTPClient()
+		for _, target := range targets {
+			notifier := webhook.New(target, client)
+			// Closing the notifiers last posts the sessions closing too
+			defer notifier.Close(webhookFlushTimeout)
+			notifiers = append(notifiers, notifier)
+		}
+		stopWebhooks := watchWebhooks(notifiers, sessions, infos)
+		defer stopWebhooks()
+	}
+
+	if opts.Attach {
+		detach, err := attachSession(sessions[0])
+		if err != nil {
+			closeAll()
+			return err
+		}
+		defer detach()
+	}
+
+	// Dump scrollback on request while serving
+	stopDumps := watchDumps(opts.DumpDir, sessions)
+	defer stopDumps()
+
+	// Cancel the sessions on interrupt, or when closed over the control socket
+	closeCtx, requestClose := context.WithCancel(context.Background())
+	defer requestClose()
+	ctx, stop := signal.NotifyContext(closeCtx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if opts.ControlSocket != "" {
+		server, err := serveControl(opts, sessions, infos, requestClose)
+		if err != nil {
+			closeAll()
+			return err
+		}
+		defer server.Close()
+	}
+
+	// Serve every session; the first failure stops the others
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, len(sessions))
+	var wg sync.WaitGroup
+	for _, session := range sessions {
+		wg.Add(1)
+		go func(session *sshx.Session) {
+			defer wg.Done()
+			err := session.Run(runCtx)
+			if errors.Is(err, sshx.ErrShellsExited) || errors.Is(err, sshx.ErrIdleTimeout) {
+				util.Infof("Session %s ended (%v), shutting down...", session.Info().Name, err)
+				cancel()
+			} else if err != nil {
+				errs <- fmt.Errorf("controller error (%s): %w", session.Info().Name, err)
+				cancel()
+			}
+		}(session)
+	}
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	if ctx.Err() != nil && opts.Resume && closeCtx.Err() == nil {
+		// Stopped, e.g. for a reboot: keep the sessions for the next run
+		util.Infof("Received interrupt, leaving sessions open for --resume")
+		return nil
+	}
+	if ctx.Err() != nil {
+		util.Infof("Received interrupt, shutting down...")
+	}
+
+	// Graceful shutdown
+	return closeAll()
+}
+
+// hideWriteURLs removes the writable links from infos so they are never
+// printed, saving them one per line to --write-url-file if given.
+func hideWriteURLs(opts options, infos []sshx.Info) error {
+	var lines strings.Builder
+	for i := range infos {
+		if infos[i].WriteURL != nil {
+			lines.WriteString(*infos[i].WriteURL + "\n")
+			infos[i].WriteURL = nil
+		}
+	}
+
+	if opts.WriteURLFile != "" {
+		if err := os.WriteFile(opts.WriteURLFile, []byte(lines.String()), 0600); err != nil {
+			return fmt.Errorf("failed to write --write-url-file: %w", err)
+		}
+	}
+	return nil
+}
+
+// printSessionsGreeting prints a combined greeting listing the links of every session.
+func printSessionsGreeting(infos []sshx.Info) {
+	version := buildVersion()
+
+	fmt.Printf("\n  %s%ssshx%s %s%s%s  %s(%d sessions)%s\n", BoldGreen, Green, Reset, Green, version, Reset, Fixed8, len(infos), Reset)
+
+	for _, info := range infos {
+		fmt.Printf("\n  %s%s%s %s(%s, %s)%s\n", BoldGreen, info.Name, Reset, Fixed8, info.Shell, info.Transport, Reset)
+		if info.WriteURL != nil {
+			fmt.Printf("  %s➜%s  Read-only link: %s%s%s\n", Green, Reset, UnderlineCyan, info.URL, Reset)
+			fmt.Printf("  %s➜%s  Writable link:  %s%s%s\n", Green, Reset, UnderlineCyan, *info.WriteURL, Reset)
+		} else {
+			fmt.Printf("  %s➜%s  Link:           %s%s%s\n", Green, Reset, UnderlineCyan, info.URL, Reset)
+		}
+		if info.Dashboard != nil {
+			fmt.Printf("  %s➜%s  Dashboard:      %s%s%s\n", Green, Reset, UnderlineCyan, info.Dashboard.URL, Reset)
+		}
+	}
+	fmt.Println()
+}
+
+// printQRCodes prints the read-only link of each session as a QR code, under
+// the session's name when there are several.
+func printQRCodes(infos []sshx.Info) {
+	for _, info := range infos {
+		code, err := qr.Encode(info.URL)
+		if err != nil {
+			util.Warnf("Cannot show the link of %s as a QR code: %v", info.Name, err)
+			continue
+		}
+		if len(infos) > 1 {
+			fmt.Printf("  %s%s%s\n", BoldGreen, info.Name, Reset)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(code.Terminal(), "\n"), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+		fmt.Println()
+	}
+}
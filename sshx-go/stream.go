@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"sshx-go/pkg/sshx"
+)
+
+// defaultStreamLinger is how long sshx stream keeps showing the output after
+// the command exits.
+const defaultStreamLinger = 30 * time.Second
+
+// exitStatusError reports that the command of sshx stream failed, so this
+// process exits with the same status.
+type exitStatusError struct {
+	code int
+}
+
+func (e *exitStatusError) Error() string {
+	return fmt.Sprintf("command exited with status %d", e.code)
+}
+
+// streamSessions turns the single session of sshx stream into a read-only
+// broadcast of opts.Stream. The returned channel receives the command's
+// result once it exits.
+func streamSessions(opts options, sessionOpts []sshx.Options) ([]sshx.Options, <-chan error, error) {
+	if len(sessionOpts) != 1 {
+		return nil, nil, fmt.Errorf("sshx stream opens a single session; remove --sessions and sessions in the config file")
+	}
+	if opts.Shell != "" || opts.Exec != "" || opts.Docker != "" || opts.SSH != "" || opts.AttachTmux != "" {
+		return nil, nil, fmt.Errorf("sshx stream runs its own command; remove --shell, --exec, --docker, --ssh and --attach-tmux")
+	}
+	if opts.RunAsUser != "" || opts.OnStart != "" || opts.ShellMemoryMax != "" || opts.ShellCPUQuota != "" || opts.RestartShells || opts.Resume {
+		return nil, nil, fmt.Errorf("sshx stream cannot be combined with --run-as-user, --on-start, --shell-memory-max, --shell-cpu-quota, --restart-shells or --resume")
+	}
+	if opts.Linger < 0 {
+		return nil, nil, fmt.Errorf("invalid --linger %s (must not be negative)", opts.Linger)
+	}
+
+	exited := make(chan error, 1)
+	session := sessionOpts[0]
+	session.Runner = &sshx.StreamRunner{
+		Command:     opts.Stream[0],
+		Args:        opts.Stream[1:],
+		Env:         session.Env,
+		Dir:         session.Dir,
+		Local:       os.Stdout,
+		Linger:      opts.Linger,
+		OnExit:      func(err error) { exited <- err },
+		Limiter:     session.OutputLimit,
+		Sanitize:    session.Sanitize,
+		InvalidUTF8: session.InvalidUTF8,
+	}
+	session.OpenShell = true
+	session.Shell = strings.Join(opts.Stream, " ")
+	// The session ends with the command, once its output was shown
+	session.ShellExit = sshx.ShellExitFirst
+	return []sshx.Options{session}, exited, nil
+}
+
+// streamStatus returns the error to exit with for the command's result, if
+// it exited.
+func streamStatus(exited <-chan error) error {
+	select {
+	case err := <-exited:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() > 0 {
+			return &exitStatusError{code: exitErr.ExitCode()}
+		}
+		return err
+	default:
+		// Interrupted before the command exited
+		return nil
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/util"
+	"sshx-go/pkg/webhook"
+)
+
+const (
+	// superviseRetryMin and superviseRetryMax bound the delay before sessions
+	// are reopened after a fatal error.
+	superviseRetryMin = time.Second
+	superviseRetryMax = time.Minute
+
+	// superviseStableAfter resets the delay once sessions ran this long.
+	superviseStableAfter = 5 * time.Minute
+)
+
+// supervisor reopens sessions after fatal errors and reports its state on
+// /healthz, for running in containers without an init system.
+type supervisor struct {
+	mu        sync.Mutex
+	state     string // "starting", "running" or "restarting"
+	sessions  []sshx.Info
+	restarts  int
+	lastError string
+	since     time.Time
+}
+
+// healthReport is the JSON body served on /healthz.
+type healthReport struct {
+	Status    string    `json:"status"`
+	State     string    `json:"state"`
+	Since     time.Time `json:"since"`
+	Restarts  int       `json:"restarts"`
+	LastError string    `json:"lastError,omitempty"`
+	Sessions  []string  `json:"sessions"`
+}
+
+// superviseSessions runs the sessions until interrupted, reopening them with
+// backoff whenever they fail. It serves /healthz on --health-addr if not empty.
+func superviseSessions(opts options, sessionOpts []sshx.Options, targets []webhook.Target) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	s := &supervisor{state: "starting", since: time.Now()}
+
+	if opts.HealthAddr != "" {
+		listener, err := net.Listen("tcp", opts.HealthAddr)
+		if err != nil {
+			return err
+		}
+		server := &http.Server{Handler: s.handler(), ReadHeaderTimeout: 5 * time.Second}
+		go server.Serve(listener)
+		defer server.Close()
+		util.Infof("Serving health checks on http://%s/healthz", listener.Addr())
+	}
+
+	delay := superviseRetryMin
+	for {
+		started := time.Now()
+		err := runSessions(opts, sessionOpts, targets, s.ready)
+		if ctx.Err() != nil || err == nil {
+			return err
+		}
+
+		if time.Since(started) >= superviseStableAfter {
+			delay = superviseRetryMin
+		}
+		s.failed(err)
+		util.Errorf("Sessions failed, reopening in %s: %v", delay, err)
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, superviseRetryMax)
+	}
+}
+
+// ready records that sessions are open and serving.
+func (s *supervisor) ready(infos []sshx.Info) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = "running"
+	s.sessions = infos
+	s.since = time.Now()
+}
+
+// failed records a fatal error before the sessions are reopened.
+func (s *supervisor) failed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = "restarting"
+	s.sessions = nil
+	s.restarts++
+	s.lastError = err.Error()
+	s.since = time.Now()
+}
+
+// handler serves /healthz. The supervisor is live while it can answer, so the
+// status is always ok; the state tells whether sessions are currently open.
+func (s *supervisor) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		report := healthReport{
+			Status:    "ok",
+			State:     s.state,
+			Since:     s.since,
+			Restarts:  s.restarts,
+			LastError: s.lastError,
+			Sessions:  []string{},
+		}
+		// Links carry encryption keys, so only session names are reported
+		for _, info := range s.sessions {
+			report.Sessions = append(report.Sessions, info.Name)
+		}
+		s.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	})
+	return mux
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sshx-go/pkg/config"
+	"sshx-go/pkg/service"
+	"sshx-go/pkg/transport"
+	"sshx-go/pkg/update"
+	"sshx-go/pkg/util"
+)
+
+const (
+	// upgradeTimeout bounds querying, downloading and installing a release.
+	upgradeTimeout = 5 * time.Minute
+
+	// updateCheckTimeout bounds the startup check for a newer release.
+	updateCheckTimeout = 10 * time.Second
+)
+
+// runUpgrade implements --upgrade, replacing this executable with the latest
+// release and restarting an installed service that runs it.
+func runUpgrade(opts options, preference transport.TransportPreference) error {
+	connConfig, err := connectionConfig(opts, preference)
+	if err != nil {
+		return err
+	}
+	client := connConfig.HTTPClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), upgradeTimeout)
+	defer cancel()
+
+	release, err := update.Latest(ctx, client, update.DefaultRepo)
+	if err != nil {
+		return err
+	}
+	current := buildVersion()
+	if !update.Newer(release.Tag, current) {
+		fmt.Printf("✓ sshx %s is up to date\n", current)
+		return nil
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get current executable path: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+
+	fmt.Printf("Upgrading sshx %s to %s...\n", current, release.Tag)
+	if err := update.Install(ctx, client, release, exe); err != nil {
+		return fmt.Errorf("upgrade failed: %w", err)
+	}
+	fmt.Printf("✓ Installed %s to %s\n", release.Tag, exe)
+
+	// Restart services running this binary so they pick up the new version
+	for _, userScope := range []bool{false, true} {
+		binary, ok := service.InstalledBinary(userScope)
+		if !ok {
+			continue
+		}
+		if binary != exe {
+			fmt.Printf("  The installed service runs %s; run '%s upgrade' to upgrade it\n", binary, binary)
+			continue
+		}
+		fmt.Println("Restarting sshx service...")
+		if err := service.Restart(userScope); err != nil {
+			return fmt.Errorf("failed to restart service: %w", err)
+		}
+		fmt.Println("✓ SSHX service restarted")
+	}
+	return nil
+}
+
+// startUpdateCheck looks for a newer release in the background when sshx runs
+// interactively, returning a channel that receives a one-line notice if there
+// is one and is then closed. It returns nil when the check is skipped: for
+// quiet, JSON or supervised runs, development builds, with
+// SSHX_NO_UPDATE_CHECK set, or with "updateCheck": false in the config file.
+func startUpdateCheck(opts options, file *config.File, connConfig transport.ConnectionConfig) <-chan string {
+	if opts.Quiet || opts.Output != "text" || opts.Supervise || !isTerminal(os.Stdout) {
+		return nil
+	}
+	if os.Getenv("SSHX_NO_UPDATE_CHECK") != "" || (file.UpdateCheck != nil && !*file.UpdateCheck) {
+		return nil
+	}
+	current := buildVersion()
+	if !update.IsRelease(current) {
+		return nil
+	}
+
+	notices := make(chan string, 1)
+	go func() {
+		defer close(notices)
+		ctx, cancel := context.WithTimeout(context.Background(), updateCheckTimeout)
+		defer cancel()
+
+		release, err := update.Latest(ctx, connConfig.HTTPClient(), update.DefaultRepo)
+		if err != nil {
+			util.DebugLog("update check failed: %v", err)
+			return
+		}
+		if update.Newer(release.Tag, current) {
+			notices <- fmt.Sprintf("  %s➜%s  sshx %s is available (you have %s), run 'sshx upgrade'", Green, Reset, release.Tag, current)
+		}
+	}()
+	return notices
+}
+
+// isTerminal reports whether f is an interactive terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
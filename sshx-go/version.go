@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build details, set at build time with
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Unset values fall back to what the Go toolchain recorded in the binary.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildVersion returns the version set at build time, else the module version
+// or revision recorded by the Go toolchain, else "dev".
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	if revision := buildSetting(info, "vcs.revision"); len(revision) >= 12 {
+		return "dev-" + revision[:12]
+	}
+	return "dev"
+}
+
+// buildCommit returns the commit this binary was built from, if known.
+func buildCommit() string {
+	if commit != "" {
+		return commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return buildSetting(info, "vcs.revision")
+	}
+	return ""
+}
+
+// buildDate returns when this binary was built, or when its commit was made,
+// if known.
+func buildDate() string {
+	if date != "" {
+		return date
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return buildSetting(info, "vcs.time")
+	}
+	return ""
+}
+
+// buildSetting returns a setting recorded in the build info, or "".
+func buildSetting(info *debug.BuildInfo, key string) string {
+	for _, setting := range info.Settings {
+		if setting.Key == key {
+			return setting.Value
+		}
+	}
+	return ""
+}
+
+// printVersion implements "sshx version" and --version.
+func printVersion() {
+	fmt.Printf("sshx %s (%s, %s/%s)\n", buildVersion(), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	if commit := buildCommit(); commit != "" {
+		fmt.Printf("  commit: %s\n", commit)
+	}
+	if date := buildDate(); date != "" {
+		fmt.Printf("  built:  %s\n", date)
+	}
+}
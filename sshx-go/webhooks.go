@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+
+	"sshx-go/pkg/config"
+	"sshx-go/pkg/sshx"
+	"sshx-go/pkg/webhook"
+)
+
+// validateWebhookURL checks that --webhook-url can be posted to.
+func validateWebhookURL(raw string) error {
+	if !validHTTPURL(raw) {
+		return fmt.Errorf("invalid --webhook-url %q (expected an http:// or https:// URL)", raw)
+	}
+	return nil
+}
+
+// validHTTPURL reports whether raw is an absolute http:// or https:// URL.
+func validHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// notifyTargets returns the endpoints told about sessions: --webhook-url and
+// the notifiers of the config file.
+func notifyTargets(opts options, file *config.File) ([]webhook.Target, error) {
+	var targets []webhook.Target
+	if opts.WebhookURL != "" {
+		if err := validateWebhookURL(opts.WebhookURL); err != nil {
+			return nil, err
+		}
+		targets = append(targets, webhook.JSON{URL: opts.WebhookURL})
+	}
+
+	for i, entry := range file.Notifiers {
+		message := webhook.Message{IncludeWriteURL: entry.IncludeWriteURL}
+		if entry.Template != "" {
+			tmpl, err := webhook.ParseTemplate(entry.Template)
+			if err != nil {
+				return nil, fmt.Errorf("notifier %d: %w", i+1, err)
+			}
+			message.Template = tmpl
+		}
+
+		switch entry.Type {
+		case "slack":
+			if !validHTTPURL(entry.URL) {
+				return nil, fmt.Errorf("notifier %d: invalid Slack webhook url %q", i+1, entry.URL)
+			}
+			targets = append(targets, webhook.Slack{URL: entry.URL, Message: message})
+		case "matrix":
+			token := entry.AccessToken
+			if token == "" {
+				token = os.Getenv("SSHX_MATRIX_TOKEN")
+			}
+			if !validHTTPURL(entry.Homeserver) || entry.Room == "" || token == "" {
+				return nil, fmt.Errorf("notifier %d: Matrix needs a homeserver URL, a room and an accessToken (or SSHX_MATRIX_TOKEN)", i+1)
+			}
+			targets = append(targets, webhook.Matrix{Homeserver: entry.Homeserver, Room: entry.Room, AccessToken: token, Message: message})
+		default:
+			return nil, fmt.Errorf("notifier %d: unknown type %q (expected slack or matrix)", i+1, entry.Type)
+		}
+	}
+	return targets, nil
+}
+
+// watchWebhooks tells notifiers about the start of every session, then the
+// shells each one opens and the connections it loses and regains, until the
+// returned function is called.
+func watchWebhooks(notifiers []*webhook.Notifier, sessions []*sshx.Session, infos []sshx.Info) (stop func()) {
+	notify := func(p webhook.Payload) {
+		for _, notifier := range notifiers {
+			notifier.Send(p)
+		}
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		notify(webhook.Payload{
+			Event:    webhook.SessionStarted,
+			Session:  infos[i].Name,
+			URL:      infos[i].URL,
+			WriteURL: infos[i].WriteURL,
+		})
+
+		wg.Add(1)
+		go func(session *sshx.Session, name string) {
+			defer wg.Done()
+			// A channel failing repeatedly is reported once, until it is back
+			down := false
+			for {
+				select {
+				case event := <-session.Events():
+					switch {
+					case event.Type == sshx.EventShellCreated:
+						notify(webhook.Payload{Event: webhook.ShellCreated, Session: name, Shell: event.Shell})
+					case event.Type == sshx.EventDisconnected && !down:
+						down = true
+						notify(webhook.Payload{Event: webhook.Disconnected, Session: name, Error: event.Err.Error()})
+					case event.Type == sshx.EventConnected && down:
+						down = false
+						notify(webhook.Payload{Event: webhook.Reconnected, Session: name})
+					}
+				case <-done:
+					return
+				}
+			}
+		}(session, infos[i].Name)
+	}
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}